@@ -1,19 +1,88 @@
 package radius
 
+import (
+	"encoding/binary"
+	"net"
+)
+
 // Attribute is a RADIUS attribute, which is part of a RADIUS packet.
 type Attribute struct {
-	Type  byte
+	Type byte
+	// Tag of an RFC 2868 tagged attribute. Zero if the attribute is untagged.
+	Tag   byte
+	Value interface{}
+}
+
+// NewStringAttr returns an attribute of type t whose value is s.
+//
+// NewStringAttr, NewIntAttr, NewIPAttr, and NewBytesAttr build attributes
+// without a dictionary. The value of the attribute that they return is its
+// encoded value, as []byte, as for an attribute that is not registered (see
+// Parse): it is written as it is by a packet whose dictionary is nil or does
+// not register t, and by the codecs of the builtin attribute types, which
+// accept encoded values of the right size.
+func NewStringAttr(t byte, s string) *Attribute {
+	return NewBytesAttr(t, []byte(s))
+}
+
+// NewIntAttr returns an attribute of type t whose value is the 32-bit
+// integer v. See NewStringAttr.
+func NewIntAttr(t byte, v uint32) *Attribute {
+	value := make([]byte, 4)
+	binary.BigEndian.PutUint32(value, v)
+	return &Attribute{Type: t, Value: value}
+}
+
+// NewIPAttr returns an attribute of type t whose value is ip, in 4 bytes if it
+// is an IPv4 address, and in 16 bytes otherwise. See NewStringAttr.
+func NewIPAttr(t byte, ip net.IP) *Attribute {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	return NewBytesAttr(t, ip)
+}
+
+// NewBytesAttr returns an attribute of type t whose value is a copy of b. See
+// NewStringAttr.
+func NewBytesAttr(t byte, b []byte) *Attribute {
+	return &Attribute{Type: t, Value: append([]byte{}, b...)}
+}
+
+// TaggedValue is the value of a tagged attribute. It can be given to
+// Dictionary.Attr (and the Packet methods that use it) to set the Tag of the
+// created Attribute.
+type TaggedValue struct {
+	Tag   byte
 	Value interface{}
 }
 
 // AttributeCodec defines how an Attribute is encoded and decoded to and from
 // wire data.
+//
+// The packet that the attribute belongs to is passed to both methods, so that
+// codecs of encrypted attributes can use its Secret and Authenticator, as the
+// codecs of User-Password and Tunnel-Password do. When a response is decoded
+// or encoded, Authenticator is the authenticator of its request (see
+// Packet.Response), which is the one that RFC 2865 and RFC 2868 use to encrypt
+// attributes of responses. Any AttributeCodec that is registered in a
+// Dictionary is used in the same way by Parse and Packet.Encode; no
+// additional interface is needed for codecs that depend on the packet.
 type AttributeCodec interface {
 	// Note: do not store wire; make a copy of it.
 	Decode(packet *Packet, wire []byte) (interface{}, error)
 	Encode(packet *Packet, value interface{}) ([]byte, error)
 }
 
+// AttributeTaggedCodec defines an extension of AttributeCodec for attributes
+// that carry an RFC 2868 tag. Attributes registered using
+// Dictionary.RegisterTagged are encoded and decoded using its methods.
+type AttributeTaggedCodec interface {
+	AttributeCodec
+	// Note: do not store wire; make a copy of it.
+	DecodeTagged(packet *Packet, wire []byte) (tag byte, value interface{}, err error)
+	EncodeTagged(packet *Packet, tag byte, value interface{}) ([]byte, error)
+}
+
 // AttributeTransformer defines an extension of AttributeCodec. It provides a
 // method for converting attribute values to ones permitted by the attribute.
 type AttributeTransformer interface {
@@ -25,3 +94,17 @@ type AttributeTransformer interface {
 type AttributeStringer interface {
 	String(value interface{}) string
 }
+
+// AttributeEmptyChecker defines an extension of AttributeCodec. It declares
+// whether the attribute may have an empty (zero length) value, such as a flag
+// whose presence is its meaning.
+//
+// If AllowsEmpty returns false, Parse rejects packets in which the attribute
+// is empty, rather than decoding its empty value. If it returns true,
+// ParseStrict accepts such packets; Decode is then called with an empty value.
+// Attributes whose codec does not implement AttributeEmptyChecker are decoded
+// by Parse regardless of their length, and rejected by ParseStrict if they are
+// empty (except for EAP-Message).
+type AttributeEmptyChecker interface {
+	AllowsEmpty() bool
+}
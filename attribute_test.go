@@ -0,0 +1,594 @@
+package radius_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PromonLogicalis/radius"
+)
+
+func TestTaggedAttributes(t *testing.T) {
+	secret := []byte("xyzzy5461")
+
+	p := radius.New(radius.CodeAccessAccept, secret)
+	for _, tag := range []byte{1, 2} {
+		p.Add("Tunnel-Type", radius.TaggedValue{Tag: tag, Value: uint32(3)})
+		p.Add("Tunnel-Medium-Type", radius.TaggedValue{Tag: tag, Value: uint32(1)})
+		p.Add("Tunnel-Private-Group-ID", radius.TaggedValue{Tag: tag, Value: "vlan" + string('0'+tag)})
+		p.Add("Tunnel-Password", radius.TaggedValue{Tag: tag, Value: "password"})
+	}
+	// Untagged string values whose first byte could be mistaken for a tag
+	p.Add("Tunnel-Client-Endpoint", "\x01host")
+
+	wire, err := p.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Decoding the Tunnel-Password requires the request authenticator.
+	copy(wire[4:20], p.Authenticator[:])
+
+	q, err := radius.Parse(wire, secret, radius.Builtin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(q.Attributes) != len(p.Attributes) {
+		t.Fatalf("expecting %d attributes; got %d", len(p.Attributes), len(q.Attributes))
+	}
+	for i, attr := range q.Attributes {
+		expected := p.Attributes[i]
+		if attr.Type != expected.Type || attr.Tag != expected.Tag || attr.Value != expected.Value {
+			t.Fatalf("attribute %d: expecting %d:%d %v; got %d:%d %v", i, expected.Type, expected.Tag, expected.Value, attr.Type, attr.Tag, attr.Value)
+		}
+	}
+
+	if err := q.Set("Tunnel-Private-Group-ID", radius.TaggedValue{Tag: 2, Value: "vlan9"}); err != nil {
+		t.Fatal(err)
+	}
+	if q.Attributes[6].Value != "vlan9" || q.Attributes[2].Value != "vlan1" {
+		t.Fatal("expecting Set to only replace the attribute with the same tag")
+	}
+
+	if _, err := radius.Builtin.Attr("User-Name", radius.TaggedValue{Tag: 1, Value: "tim"}); err == nil {
+		t.Fatal("expecting tagged value of untagged attribute to fail")
+	}
+}
+
+func TestIPv6Prefix(t *testing.T) {
+	tests := []struct {
+		prefix string
+		wire   []byte
+	}{
+		{"2001:db8::/32", []byte{0x00, 0x20, 0x20, 0x01, 0x0d, 0xb8}},
+		{"2001:db8:ab80::/41", []byte{0x00, 0x29, 0x20, 0x01, 0x0d, 0xb8, 0xab, 0x80}},
+		{"::/0", []byte{0x00, 0x00}},
+	}
+	for _, test := range tests {
+		prefix := netip.MustParsePrefix(test.prefix)
+		attr, err := radius.Builtin.Attr("Framed-IPv6-Prefix", prefix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wire, err := radius.AttributeIPv6Prefix.Encode(nil, attr.Value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(wire, test.wire) {
+			t.Fatalf("%s: unexpected encoding %x", test.prefix, wire)
+		}
+		decoded, err := radius.AttributeIPv6Prefix.Decode(nil, wire)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decoded != prefix {
+			t.Fatalf("%s: unexpected decoded prefix %v", test.prefix, decoded)
+		}
+	}
+
+	// bits past the prefix length are masked
+	decoded, err := radius.AttributeIPv6Prefix.Decode(nil, []byte{0x00, 0x29, 0x20, 0x01, 0x0d, 0xb8, 0xab, 0xff})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := netip.MustParsePrefix("2001:db8:ab80::/41"); decoded != expected {
+		t.Fatalf("expecting %v; got %v", expected, decoded)
+	}
+
+	if _, err := radius.AttributeIPv6Prefix.Decode(nil, []byte{0x00, 0x40, 0x20, 0x01}); err == nil {
+		t.Fatal("expecting error for truncated prefix")
+	}
+}
+
+func TestIfid(t *testing.T) {
+	ifid := [8]byte{0x02, 0x11, 0x22, 0xff, 0xfe, 0x33, 0x44, 0x55}
+	for _, value := range []interface{}{ifid, ifid[:], net.HardwareAddr(ifid[:])} {
+		wire, err := radius.AttributeIfid.Encode(nil, value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(wire, ifid[:]) {
+			t.Fatalf("%T: unexpected encoding %x", value, wire)
+		}
+	}
+	decoded, err := radius.AttributeIfid.Decode(nil, ifid[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded != ifid {
+		t.Fatalf("unexpected decoded interface identifier %v", decoded)
+	}
+
+	if _, err := radius.AttributeIfid.Encode(nil, ifid[:6]); err == nil {
+		t.Fatal("expecting error for short interface identifier")
+	}
+	if _, err := radius.AttributeIfid.Decode(nil, make([]byte, 16)); err == nil {
+		t.Fatal("expecting error for long interface identifier")
+	}
+	if _, err := radius.AttributeIPv6Address.Decode(nil, make([]byte, 8)); err == nil {
+		t.Fatal("expecting error for short IPv6 address")
+	}
+}
+
+func TestOctets(t *testing.T) {
+	binary := []byte{0x00, 0xff, 0x00, 0xc3, 0x28}
+	p := radius.New(radius.CodeAccessRequest, []byte("secret"))
+	p.Add("State", binary)
+	p.Add("Class", string(binary))
+	if value, ok := p.Value("Class").([]byte); !ok || !bytes.Equal(value, binary) {
+		t.Fatalf("expecting Class to be stored as []byte; got %#v", p.Value("Class"))
+	}
+
+	wire, err := p.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, err := radius.Parse(wire, p.Secret, radius.Builtin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"State", "Class"} {
+		if value, ok := q.GetBytes(name); !ok || !bytes.Equal(value, binary) {
+			t.Fatalf("%s: expecting %x; got %x", name, binary, value)
+		}
+	}
+	if _, err := radius.Builtin.Attr("State", uint32(1)); err == nil {
+		t.Fatal("expecting error for non-binary State value")
+	}
+}
+
+func TestNASPort(t *testing.T) {
+	p := radius.New(radius.CodeAccessRequest, []byte("secret"))
+	if err := p.SetNASPort(4294967295); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SetNASPortID("eth0/1:100"); err != nil {
+		t.Fatal(err)
+	}
+	wire, err := p.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(wire, []byte{5, 6, 0xff, 0xff, 0xff, 0xff}) {
+		t.Fatal("expecting NAS-Port to be encoded as an unsigned 32 bit integer")
+	}
+	q, err := radius.Parse(wire, p.Secret, radius.Builtin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if port, ok := q.NASPort(); !ok || port != 4294967295 {
+		t.Fatalf("expecting NAS-Port = 4294967295; got %v", q.Value("NAS-Port"))
+	}
+	if id, ok := q.NASPortID(); !ok || id != "eth0/1:100" {
+		t.Fatalf("expecting NAS-Port-Id = eth0/1:100; got %v", q.Value("NAS-Port-Id"))
+	}
+
+	// other integer types are converted if they are in range
+	for _, value := range []interface{}{4294967295, int64(4294967295), uint64(4294967295)} {
+		attr, err := radius.Builtin.Attr("NAS-Port", value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if attr.Value != uint32(4294967295) {
+			t.Fatalf("%T: expecting uint32 value; got %#v", value, attr.Value)
+		}
+	}
+	for _, value := range []interface{}{-1, 4294967296, uint64(4294967296)} {
+		if _, err := radius.Builtin.Attr("NAS-Port", value); err == nil {
+			t.Fatalf("expecting %T %v to be rejected", value, value)
+		}
+	}
+}
+
+func TestInteger64(t *testing.T) {
+	wire, err := radius.AttributeInteger64.Encode(nil, uint64(0x0102030405060708))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(wire, []byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08}) {
+		t.Fatalf("unexpected encoding %x", wire)
+	}
+	value, err := radius.AttributeInteger64.Decode(nil, wire)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != uint64(0x0102030405060708) {
+		t.Fatalf("unexpected decoded value %v", value)
+	}
+	if _, err := radius.AttributeInteger64.Decode(nil, wire[:7]); err == nil {
+		t.Fatal("expecting error for invalid size")
+	}
+	if _, err := radius.AttributeInteger64.Encode(nil, uint32(1)); err == nil {
+		t.Fatal("expecting error for uint32 value")
+	}
+}
+
+func TestAcctOctets64(t *testing.T) {
+	p := radius.New(radius.CodeAccountingRequest, []byte("secret"))
+	if _, ok := p.AcctInputOctets(); ok {
+		t.Fatal("expecting no Acct-Input-Octets")
+	}
+	p.Add("Acct-Input-Octets", uint32(5))
+	p.Add("Acct-Input-Gigawords", uint32(2))
+	p.Add("Acct-Output-Octets", uint32(7))
+	if octets, ok := p.AcctInputOctets(); !ok || octets != 2<<32|5 {
+		t.Fatalf("unexpected input octets %d", octets)
+	}
+	if octets, ok := p.AcctOutputOctets(); !ok || octets != 7 {
+		t.Fatalf("unexpected output octets %d", octets)
+	}
+
+	q := radius.New(radius.CodeAccountingRequest, []byte("secret"))
+	if n := q.InputBytes(); n != 0 {
+		t.Fatalf("expecting no input bytes; got %d", n)
+	}
+	if err := q.SetInputBytes(5<<32 | 0xfffffffe); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.SetOutputBytes(7); err != nil {
+		t.Fatal(err)
+	}
+	wire, err := q.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := radius.Parse(wire, q.Secret, radius.Builtin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := r.InputBytes(); n != 5<<32|0xfffffffe {
+		t.Fatalf("unexpected input bytes %d", n)
+	}
+	if value, _ := r.Value("Acct-Input-Octets").(uint32); value != 0xfffffffe {
+		t.Fatalf("unexpected Acct-Input-Octets %d", value)
+	}
+	if n := r.OutputBytes(); n != 7 {
+		t.Fatalf("unexpected output bytes %d", n)
+	}
+	if r.Value("Acct-Output-Gigawords") != nil {
+		t.Fatal("expecting no Acct-Output-Gigawords")
+	}
+
+	if err := q.SetInputBytes(1); err != nil {
+		t.Fatal(err)
+	}
+	if q.Value("Acct-Input-Gigawords") != nil || q.InputBytes() != 1 {
+		t.Fatalf("expecting Acct-Input-Gigawords to be removed; got %d", q.InputBytes())
+	}
+}
+
+func TestSaltEncrypted(t *testing.T) {
+	secret := []byte("xyzzy5461")
+	authenticator := []byte("0123456789abcdef")
+
+	for _, plaintext := range []string{"", "password", "a password that is longer than one block"} {
+		ciphertext, err := radius.EncryptSalt([]byte(plaintext), secret, authenticator)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ciphertext[0]&0x80 == 0 {
+			t.Fatal("expecting most significant bit of salt to be set")
+		}
+		if (len(ciphertext)-2)%16 != 0 {
+			t.Fatalf("expecting ciphertext to be padded; got %d bytes", len(ciphertext))
+		}
+		decrypted, err := radius.DecryptSalt(ciphertext, secret, authenticator)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(decrypted) != plaintext {
+			t.Fatalf("expecting %q; got %q", plaintext, decrypted)
+		}
+	}
+
+	if _, err := radius.EncryptSalt(make([]byte, 240), secret, authenticator); err == nil {
+		t.Fatal("expecting long plaintext to fail")
+	}
+	if _, err := radius.DecryptSalt(make([]byte, 17), secret, authenticator); err == nil {
+		t.Fatal("expecting invalid ciphertext length to fail")
+	}
+
+	var d radius.Dictionary
+	d.MustRegister("Crypt", 1, radius.AttributeSaltEncrypted)
+	p := radius.New(radius.CodeAccessAccept, secret)
+	p.Dictionary = &d
+	p.Add("Crypt", "secret value")
+	wire, err := p.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(wire[4:20], p.Authenticator[:])
+	q, err := radius.Parse(wire, secret, &d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := q.Value("Crypt").([]byte); string(value) != "secret value" {
+		t.Fatalf("expecting decrypted value; got %v", q.Value("Crypt"))
+	}
+}
+
+func TestAttributeTime(t *testing.T) {
+	location := time.FixedZone("UTC-3", -3*60*60)
+	timestamp := time.Date(2017, 3, 14, 9, 26, 53, 0, location)
+
+	wire, err := radius.AttributeTime.Encode(nil, timestamp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []byte{0x58, 0xc7, 0xe1, 0x8d}; !bytes.Equal(wire, expected) {
+		t.Fatalf("expecting %x; got %x", expected, wire)
+	}
+	decoded, err := radius.AttributeTime.Decode(nil, wire)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded := decoded.(time.Time); !decoded.Equal(timestamp) || decoded.Location() != time.UTC {
+		t.Fatalf("expecting %s in UTC; got %s", timestamp, decoded)
+	}
+
+	max := time.Date(2106, 2, 7, 6, 28, 15, 0, time.UTC)
+	if _, err := radius.AttributeTime.Encode(nil, max); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := radius.AttributeTime.Encode(nil, max.Add(time.Second)); err == nil {
+		t.Fatal("expecting time past the 32 bit range to fail")
+	}
+	if _, err := radius.AttributeTime.Encode(nil, time.Unix(-1, 0)); err == nil {
+		t.Fatal("expecting time before the epoch to fail")
+	}
+	if _, err := radius.AttributeTime.Decode(nil, []byte{0x58, 0xc7, 0xe2}); err == nil {
+		t.Fatal("expecting invalid size to fail")
+	}
+}
+
+func TestExtendedAttributes(t *testing.T) {
+	var d radius.Dictionary
+	d.MustRegisterExtended("Frag-Status", 241, 1, radius.AttributeInteger)
+	d.MustRegisterExtended("Long-Thing", 245, 2, radius.AttributeString)
+
+	p := radius.New(radius.CodeAccessRequest, []byte("secret"))
+	p.Dictionary = &d
+	p.Add("Frag-Status", uint32(2))
+	long := bytes.Repeat([]byte{0x5a}, 600)
+	p.Add("Long-Thing", long)
+
+	wire, err := p.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []byte{0xf1, 0x07, 0x01, 0x00, 0x00, 0x00, 0x02}
+	if !bytes.Equal(wire[20:27], expected) {
+		t.Fatalf("unexpected extended attribute encoding: %x", wire[20:27])
+	}
+	// 600 bytes are split into fragments of 251, 251, and 98 bytes
+	if len(wire) != 27+3*4+600 {
+		t.Fatalf("unexpected encoded length %d", len(wire))
+	}
+	for i, offset := range []int{27, 27 + 255, 27 + 2*255} {
+		if wire[offset] != 245 || wire[offset+2] != 2 {
+			t.Fatalf("fragment %d: unexpected header %x", i, wire[offset:offset+4])
+		}
+		if more := wire[offset+3]&0x80 != 0; more != (i < 2) {
+			t.Fatalf("fragment %d: unexpected flags %x", i, wire[offset+3])
+		}
+	}
+
+	q, err := radius.Parse(wire, p.Secret, &d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(q.Attributes) != 2 {
+		t.Fatalf("expecting long extended attribute to be reassembled; got %d attributes", len(q.Attributes))
+	}
+	if status, _ := q.GetInt("Frag-Status"); status != 2 {
+		t.Fatalf("unexpected Frag-Status %v", q.Value("Frag-Status"))
+	}
+	if value, _ := q.GetBytes("Long-Thing"); !bytes.Equal(value, long) {
+		t.Fatalf("unexpected Long-Thing value %x", value)
+	}
+	if rewire, err := q.Encode(); err != nil || !bytes.Equal(rewire, wire) {
+		t.Fatal("expecting reassembled packet to encode as it was received")
+	}
+
+	if err := q.Set("Frag-Status", uint32(3)); err != nil {
+		t.Fatal(err)
+	}
+	if status, _ := q.GetInt("Frag-Status"); status != 3 || len(q.Attributes) != 2 {
+		t.Fatalf("expecting Frag-Status to be replaced; got %v", q.Value("Frag-Status"))
+	}
+
+	// the last fragment is missing
+	truncated := append([]byte(nil), wire[:27+2*255]...)
+	binary.BigEndian.PutUint16(truncated[2:4], uint16(len(truncated)))
+	if _, err := radius.Parse(truncated, p.Secret, &d); err == nil {
+		t.Fatal("expecting truncated long extended attribute to be rejected")
+	}
+
+	if err := d.RegisterExtended("Not-Extended", 26, 1, radius.AttributeString); err == nil {
+		t.Fatal("expecting error registering an extended attribute in type 26")
+	}
+
+	var loaded radius.Dictionary
+	if err := loaded.Load(strings.NewReader("ATTRIBUTE\tFrag-Status\t241.1\tinteger\n")); err != nil {
+		t.Fatal(err)
+	}
+	if name, ok := loaded.ExtendedAttributeName(241, 1); !ok || name != "Frag-Status" {
+		t.Fatalf("expecting Frag-Status to be loaded; got %q", name)
+	}
+}
+
+// flagCodec is the codec of an attribute whose presence is its meaning.
+type flagCodec struct{}
+
+func (flagCodec) Decode(packet *radius.Packet, wire []byte) (interface{}, error) {
+	return true, nil
+}
+
+func (flagCodec) Encode(packet *radius.Packet, value interface{}) ([]byte, error) {
+	return nil, nil
+}
+
+func (flagCodec) AllowsEmpty() bool {
+	return true
+}
+
+func TestAttributeAllowsEmpty(t *testing.T) {
+	secret := []byte("secret")
+	d := radius.Builtin.Clone()
+	d.MustRegister("Flag", 200, flagCodec{})
+
+	p := radius.New(radius.CodeAccessRequest, secret)
+	p.Dictionary = d
+	p.Add("User-Name", "tim")
+	p.Add("Flag", true)
+	wire, err := p.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, parse := range []func([]byte, []byte, *radius.Dictionary) (*radius.Packet, error){radius.Parse, radius.ParseStrict} {
+		q, err := parse(wire, secret, d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if q.Value("Flag") != true {
+			t.Fatalf("expecting Flag to be decoded; got %v", q.Value("Flag"))
+		}
+	}
+	// without the codec, the empty attribute is rejected by ParseStrict
+	if _, err := radius.ParseStrict(wire, secret, radius.Builtin); err == nil {
+		t.Fatal("expecting ParseStrict to reject an empty unknown attribute")
+	}
+
+	// an empty integer is rejected by Parse
+	empty := append(wire[:len(wire):len(wire)], 27, 2)
+	binary.BigEndian.PutUint16(empty[2:4], uint16(len(empty)))
+	_, err = radius.Parse(empty, secret, d)
+	if err == nil || !strings.Contains(err.Error(), "Session-Timeout attribute has no value") {
+		t.Fatalf("expecting an empty Session-Timeout to be rejected; got %v", err)
+	}
+}
+
+func TestAttributeBuilders(t *testing.T) {
+	secret := []byte("secret")
+	for _, dictionary := range []*radius.Dictionary{radius.Builtin, nil} {
+		p := radius.New(radius.CodeAccessRequest, secret)
+		p.Dictionary = dictionary
+		p.AddAttr(radius.NewStringAttr(1, "nemo"))
+		p.AddAttr(radius.NewIntAttr(5, 3))
+		p.AddAttr(radius.NewIPAttr(4, net.IPv4(192, 168, 0, 1)))
+		p.AddAttr(radius.NewIPAttr(95, net.ParseIP("2001:db8::1")))
+		p.AddAttr(radius.NewBytesAttr(25, []byte{1, 2, 3}))
+		p.AddAttr(radius.NewIntAttr(55, 1489483613))
+		wire, err := p.Encode()
+		if err != nil {
+			t.Fatalf("dictionary %p: %v", dictionary, err)
+		}
+		checkAttributeBuilders(t, wire, secret)
+	}
+
+	p := radius.New(radius.CodeAccessRequest, secret)
+	p.AddAttr(radius.NewBytesAttr(5, []byte{1, 2, 3}))
+	if _, err := p.Encode(); err == nil {
+		t.Fatal("expecting an encoded integer of invalid size to be rejected")
+	}
+}
+
+func checkAttributeBuilders(t *testing.T, wire, secret []byte) {
+	t.Helper()
+	parsed, err := radius.Parse(wire, secret, radius.Builtin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := parsed.String("User-Name"); name != "nemo" {
+		t.Fatalf("expecting User-Name nemo; got %q", name)
+	}
+	if port, ok := parsed.GetInt("NAS-Port"); !ok || port != 3 {
+		t.Fatalf("expecting NAS-Port 3; got %d", port)
+	}
+	if ip, ok := parsed.GetIP("NAS-IP-Address"); !ok || !ip.Equal(net.IPv4(192, 168, 0, 1)) {
+		t.Fatalf("expecting NAS-IP-Address 192.168.0.1; got %v", ip)
+	}
+	if ip, ok := parsed.GetIP("NAS-IPv6-Address"); !ok || !ip.Equal(net.ParseIP("2001:db8::1")) {
+		t.Fatalf("expecting NAS-IPv6-Address 2001:db8::1; got %v", ip)
+	}
+	if class, _ := parsed.GetBytes("Class"); !bytes.Equal(class, []byte{1, 2, 3}) {
+		t.Fatalf("expecting Class 010203; got %x", class)
+	}
+	if timestamp, _ := parsed.Value("Event-Timestamp").(time.Time); timestamp.Unix() != 1489483613 {
+		t.Fatalf("unexpected Event-Timestamp %v", parsed.Value("Event-Timestamp"))
+	}
+}
+
+func TestGroupTaggedAttributes(t *testing.T) {
+	secret := []byte("xyzzy5461")
+	p := radius.New(radius.CodeAccessAccept, secret)
+	p.Add("Class", []byte("c"))
+	for _, name := range []string{"Tunnel-Type", "Tunnel-Medium-Type", "Tunnel-Private-Group-ID"} {
+		for _, tag := range []byte{1, 2} {
+			var value interface{} = uint32(tag)
+			if name == "Tunnel-Private-Group-ID" {
+				value = "vlan" + string('0'+tag)
+			}
+			p.Add(name, radius.TaggedValue{Tag: tag, Value: value})
+		}
+	}
+	p.Add("Session-Timeout", uint32(60))
+
+	order := func() []string {
+		wire, err := p.Encode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		q, err := radius.Parse(wire, secret, radius.Builtin)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var order []string
+		for _, attr := range q.Attributes {
+			name, _ := radius.Builtin.Name(attr.Type)
+			if attr.Tag != 0 {
+				name += ":" + string('0'+attr.Tag)
+			}
+			order = append(order, name)
+		}
+		return order
+	}
+
+	// by default, the attributes are written in the order of Attributes
+	expected := "Class Tunnel-Type:1 Tunnel-Type:2 Tunnel-Medium-Type:1 Tunnel-Medium-Type:2 Tunnel-Private-Group-ID:1 Tunnel-Private-Group-ID:2 Session-Timeout"
+	if got := strings.Join(order(), " "); got != expected {
+		t.Fatalf("expecting %s; got %s", expected, got)
+	}
+
+	p.GroupTaggedAttributes = true
+	expected = "Class Tunnel-Type:1 Tunnel-Medium-Type:1 Tunnel-Private-Group-ID:1 Tunnel-Type:2 Tunnel-Medium-Type:2 Tunnel-Private-Group-ID:2 Session-Timeout"
+	if got := strings.Join(order(), " "); got != expected {
+		t.Fatalf("expecting %s; got %s", expected, got)
+	}
+	if p.Attributes[2].Tag != 2 {
+		t.Fatal("expecting Attributes not to be reordered")
+	}
+}
@@ -3,6 +3,7 @@ package radius
 import (
 	"encoding/binary"
 	"errors"
+	"math"
 	"net"
 	"time"
 	"unicode/utf8"
@@ -14,11 +15,16 @@ var (
 	AttributeText AttributeCodec
 	// []byte
 	AttributeString AttributeCodec
+	// []byte, for opaque binary data such as the State and Class attributes.
+	// Unlike AttributeString, a string given to Dictionary.Attr is stored as
+	// []byte, so values always have the same type.
+	AttributeOctets AttributeCodec
 	// net.IP
 	AttributeAddress AttributeCodec
-	// uint32
+	// uint32. Dictionary.Attr also accepts other Go integer types, if the
+	// value is between 0 and 4294967295.
 	AttributeInteger AttributeCodec
-	// time.Time
+	// time.Time (in UTC; from 1970-01-01T00:00:00Z to 2106-02-07T06:28:15Z)
 	AttributeTime AttributeCodec
 	// []byte
 	AttributeUnknown AttributeCodec
@@ -27,6 +33,7 @@ var (
 func init() {
 	AttributeText = attributeText{}
 	AttributeString = attributeString{}
+	AttributeOctets = attributeOctets{}
 	AttributeAddress = attributeAddress{}
 	AttributeInteger = attributeInteger{}
 	AttributeTime = attributeTime{}
@@ -57,9 +64,7 @@ func (attributeText) Encode(packet *Packet, value interface{}) ([]byte, error) {
 type attributeString struct{}
 
 func (attributeString) Decode(packet *Packet, value []byte) (interface{}, error) {
-	v := make([]byte, len(value))
-	copy(v, value)
-	return v, nil
+	return packet.wireValue(value), nil
 }
 
 func (attributeString) Encode(packet *Packet, value interface{}) ([]byte, error) {
@@ -74,18 +79,42 @@ func (attributeString) Encode(packet *Packet, value interface{}) ([]byte, error)
 	return nil, errors.New("radius: string attribute must be []byte or string")
 }
 
+type attributeOctets struct {
+	attributeString
+}
+
+func (attributeOctets) Transform(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case []byte:
+		return v, nil
+	case string:
+		return []byte(v), nil
+	}
+	return nil, errors.New("radius: octets attribute must be []byte or string")
+}
+
 type attributeAddress struct{}
 
+// AllowsEmpty implements AttributeEmptyChecker.
+func (attributeAddress) AllowsEmpty() bool {
+	return false
+}
+
 func (attributeAddress) Decode(packet *Packet, value []byte) (interface{}, error) {
 	if len(value) != net.IPv4len {
 		return nil, errors.New("radius: address attribute has invalid size")
 	}
-	v := make([]byte, len(value))
-	copy(v, value)
-	return net.IP(v), nil
+	return net.IP(packet.wireValue(value)), nil
 }
 
 func (attributeAddress) Encode(packet *Packet, value interface{}) ([]byte, error) {
+	if raw, ok := value.([]byte); ok {
+		// an encoded value, such as from NewIPAttr
+		if len(raw) != net.IPv4len {
+			return nil, errors.New("radius: address attribute has invalid size")
+		}
+		return raw, nil
+	}
 	ip, ok := value.(net.IP)
 	if !ok {
 		return nil, errors.New("radius: address attribute must be net.IP")
@@ -99,6 +128,11 @@ func (attributeAddress) Encode(packet *Packet, value interface{}) ([]byte, error
 
 type attributeInteger struct{}
 
+// AllowsEmpty implements AttributeEmptyChecker.
+func (attributeInteger) AllowsEmpty() bool {
+	return false
+}
+
 func (attributeInteger) Decode(packet *Packet, value []byte) (interface{}, error) {
 	if len(value) != 4 {
 		return nil, errors.New("radius: integer attribute has invalid size")
@@ -107,6 +141,13 @@ func (attributeInteger) Decode(packet *Packet, value []byte) (interface{}, error
 }
 
 func (attributeInteger) Encode(packet *Packet, value interface{}) ([]byte, error) {
+	if raw, ok := value.([]byte); ok {
+		// an encoded value, such as from NewIntAttr
+		if len(raw) != 4 {
+			return nil, errors.New("radius: integer attribute has invalid size")
+		}
+		return raw, nil
+	}
 	integer, ok := value.(uint32)
 	if !ok {
 		return nil, errors.New("radius: integer attribute must be uint32")
@@ -116,21 +157,78 @@ func (attributeInteger) Encode(packet *Packet, value interface{}) ([]byte, error
 	return raw, nil
 }
 
+// Transform converts Go integers of other types to uint32, so that, for
+// example, an untyped constant can be given to Dictionary.Attr. An error is
+// returned if the value is negative or does not fit in 32 bits, rather than
+// letting it wrap around.
+func (attributeInteger) Transform(value interface{}) (interface{}, error) {
+	var integer int64
+	switch v := value.(type) {
+	case uint32:
+		return v, nil
+	case uint8:
+		return uint32(v), nil
+	case uint16:
+		return uint32(v), nil
+	case uint:
+		if uint64(v) > math.MaxUint32 {
+			return nil, errors.New("radius: integer attribute value is out of range")
+		}
+		return uint32(v), nil
+	case uint64:
+		if v > math.MaxUint32 {
+			return nil, errors.New("radius: integer attribute value is out of range")
+		}
+		return uint32(v), nil
+	case int:
+		integer = int64(v)
+	case int32:
+		integer = int64(v)
+	case int64:
+		integer = v
+	default:
+		// not an integer; rejected by Encode
+		return value, nil
+	}
+	if integer < 0 || integer > math.MaxUint32 {
+		return nil, errors.New("radius: integer attribute value is out of range")
+	}
+	return uint32(integer), nil
+}
+
 type attributeTime struct{}
 
+// AllowsEmpty implements AttributeEmptyChecker.
+func (attributeTime) AllowsEmpty() bool {
+	return false
+}
+
 func (attributeTime) Decode(packet *Packet, value []byte) (interface{}, error) {
 	if len(value) != 4 {
 		return nil, errors.New("radius: time attribute has invalid size")
 	}
-	return time.Unix(int64(binary.BigEndian.Uint32(value)), 0), nil
+	return time.Unix(int64(binary.BigEndian.Uint32(value)), 0).UTC(), nil
 }
 
 func (attributeTime) Encode(packet *Packet, value interface{}) ([]byte, error) {
+	if raw, ok := value.([]byte); ok {
+		// an encoded value, such as from NewIntAttr
+		if len(raw) != 4 {
+			return nil, errors.New("radius: time attribute has invalid size")
+		}
+		return raw, nil
+	}
 	timestamp, ok := value.(time.Time)
 	if !ok {
 		return nil, errors.New("radius: time attribute must be time.Time")
 	}
+	// The value is the number of seconds since the Unix epoch, as an unsigned
+	// 32 bit integer; it does not depend on the location of timestamp.
+	seconds := timestamp.Unix()
+	if seconds < 0 || seconds > math.MaxUint32 {
+		return nil, errors.New("radius: time attribute value is out of range")
+	}
 	raw := make([]byte, 4)
-	binary.BigEndian.PutUint32(raw, uint32(timestamp.Unix()))
+	binary.BigEndian.PutUint32(raw, uint32(seconds))
 	return raw, nil
 }
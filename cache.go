@@ -0,0 +1,78 @@
+package radius
+
+import (
+	"sync"
+	"time"
+)
+
+// RequestKey identifies a request that was received by a Server. Requests that
+// have the same key are retransmissions of the same request.
+type RequestKey struct {
+	// Address of the client that sent the request (IP and port).
+	Addr string
+	// Identifier of the request.
+	Identifier byte
+	// Request authenticator of the request.
+	Authenticator [16]byte
+}
+
+// ResponseCache stores the responses sent by a Server, so that duplicate
+// requests can be answered without invoking the handler again.
+//
+// Implementations must be safe for concurrent use.
+type ResponseCache interface {
+	// Get returns the encoded response that was stored for the request, or nil
+	// if there is none.
+	Get(key RequestKey) []byte
+	// Put stores the encoded response to the request. The response should be
+	// discarded after ttl has elapsed.
+	Put(key RequestKey, response []byte, ttl time.Duration)
+}
+
+// NewMemoryCache returns a ResponseCache that stores responses in memory.
+func NewMemoryCache() ResponseCache {
+	return &memoryCache{
+		entries: make(map[RequestKey]memoryCacheEntry),
+	}
+}
+
+type memoryCacheEntry struct {
+	response []byte
+	expires  time.Time
+}
+
+type memoryCache struct {
+	mu        sync.Mutex
+	entries   map[RequestKey]memoryCacheEntry
+	lastPurge time.Time
+}
+
+func (c *memoryCache) Get(key RequestKey) []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok || time.Now().After(entry.expires) {
+		return nil
+	}
+	return entry.response
+}
+
+func (c *memoryCache) Put(key RequestKey, response []byte, ttl time.Duration) {
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// Remove expired entries, at most once per ttl.
+	if now.Sub(c.lastPurge) > ttl {
+		for k, entry := range c.entries {
+			if now.After(entry.expires) {
+				delete(c.entries, k)
+			}
+		}
+		c.lastPurge = now
+	}
+	c.entries[key] = memoryCacheEntry{
+		response: response,
+		expires:  now.Add(ttl),
+	}
+}
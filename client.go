@@ -1,35 +1,239 @@
 package radius
 
 import (
+	"context"
+	"fmt"
 	"net"
+	"sync"
 	"time"
 )
 
+// PacketRoundTripper makes a single exchange: it sends req to the server at
+// addr and returns its response, as with http.RoundTripper. Client and
+// ClientTLS implement it, so that they can be wrapped by round-trippers that
+// add behavior to their exchanges, such as metrics, circuit breaking, or
+// failover between servers; a wrapper is then used as the Transport of a
+// Client.
+//
+// RoundTrip must not modify req, and must be safe for concurrent use.
+type PacketRoundTripper interface {
+	RoundTrip(ctx context.Context, req *Packet, addr string) (*Packet, error)
+}
+
+// PacketRoundTripperFunc is a wrapper that allows ordinary functions to be
+// used as a PacketRoundTripper.
+type PacketRoundTripperFunc func(ctx context.Context, req *Packet, addr string) (*Packet, error)
+
+// RoundTrip calls f(ctx, req, addr).
+func (f PacketRoundTripperFunc) RoundTrip(ctx context.Context, req *Packet, addr string) (*Packet, error) {
+	return f(ctx, req, addr)
+}
+
 // Client is a RADIUS client that can send and receive packets to and from a
 // RADIUS server.
 type Client struct {
+	// If non-nil, makes the exchanges of the client in place of its own
+	// socket, in which case the fields that configure the connection and its
+	// retransmissions (Net to IdentifierWait) are not used; Timeout,
+	// RequestMiddleware, and OnExchange still apply. It must not be the client
+	// itself, or a round-tripper that calls it.
+	Transport PacketRoundTripper
+
 	// Network on which to make the connection. Defaults to "udp".
 	Net string
 
 	// Local address to use for outgoing connections (can be nil).
 	LocalAddr net.Addr
 
+	// If non-nil, opens the connection of each exchange in place of
+	// net.Dialer, in which case DialTimeout and LocalAddr are not used. It
+	// can be set to MemoryNetwork.DialContext to make exchanges without
+	// sockets.
+	DialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+	// If non-nil, opens the socket of a persistent client in place of
+	// net.ListenPacket, such as MemoryNetwork.ListenPacket.
+	ListenPacket func(network, addr string) (net.PacketConn, error)
+
 	// Timeouts for various operations. Default values for each field is 10
 	// seconds.
 	DialTimeout  time.Duration
 	ReadTimeout  time.Duration
 	WriteTimeout time.Duration
+
+	// If non-zero, the total time that an exchange may take, including all of
+	// its retransmissions. Once it has elapsed, the exchange is aborted (even
+	// while waiting for a response to a retransmission), and
+	// context.DeadlineExceeded is returned, as if the exchange's context had
+	// that deadline.
+	Timeout time.Duration
+
+	// Number of times the request is retransmitted if no response has been
+	// received. Defaults to 0 (the request is only sent once).
+	Retries int
+	// Time to wait for a response before the request is retransmitted.
+	// Defaults to Timeout divided by Retries+1 if Timeout is set, so that
+	// every attempt fits in the total time; otherwise, it defaults to
+	// ReadTimeout.
+	RetryInterval time.Duration
+
+	// If true, a single socket is used for all exchanges, rather than one
+	// socket per exchange. The socket is opened on first use and kept open
+	// until Close is called. Concurrent exchanges are multiplexed over it; to
+	// tell their responses apart, each request is sent with an Identifier that
+	// is not used by any other pending request to the same server. If all 256
+	// identifiers are in use, Exchange waits for one to be released (see
+	// IdentifierWait).
+	//
+	// A persistent Client must not be copied after first use.
+	Persistent bool
+	// How long a persistent client waits for a free identifier when all 256
+	// are in use by pending requests to the same server. If zero, it waits
+	// until one is released, or the exchange's context is done. If positive,
+	// ErrNoFreeIdentifier is returned after waiting for this duration; if
+	// negative, it is returned without waiting.
+	IdentifierWait time.Duration
+
+	// Maximum number of exchanges that ExchangeBatch makes at the same time.
+	// Defaults to 16.
+	BatchConcurrency int
+
+	// Called when each exchange completes, with the code of the response (or
+	// zero if err is non-nil), the time since the exchange started, and the
+	// error that is returned by it, if any. It is called from the goroutine
+	// that made the exchange. If nil, it is not called.
+	OnExchange func(code Code, duration time.Duration, err error)
+
+	// Called with each request before it is encoded and sent, so that its
+	// attributes can be changed for every exchange, such as to add a
+	// NAS-Identifier or remove internal attributes. It is given a copy of the
+	// packet that is passed to Exchange, which is not modified; the
+	// authenticators of the request are calculated after it returns, and
+	// responses are verified against the request that it produced. It may be
+	// called concurrently. If nil, requests are sent as they are.
+	RequestMiddleware func(packet *Packet)
+
+	// If true, a Message-Authenticator attribute is added to each request
+	// that has an EAP-Message attribute but no Message-Authenticator, as RFC
+	// 3579 requires, and as servers that harden EAP against forged requests
+	// insist on (see Server.RequireMessageAuthenticatorForEAP). It is added
+	// after RequestMiddleware is called. The packet passed to Exchange is not
+	// modified.
+	AddMessageAuthenticatorForEAP bool
+
+	// Receives messages about received packets that are ignored, because they
+	// are malformed or are not authentic responses. If nil, nothing is
+	// logged.
+	Logger Logger
+
+	mu     sync.Mutex
+	shared *clientConn
+	closed bool
+}
+
+// TimeoutError is returned by Client.Exchange when no response was received
+// after every attempt to send the request.
+type TimeoutError struct {
+	// Number of times the request was sent.
+	Attempts int
 }
 
-// TODO: add ability to resend packets every X seconds
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("radius: no response after %d attempt(s)", e.Attempts)
+}
+
+// Timeout returns true. It allows TimeoutError to be identified in the same
+// way as a net.Error timeout.
+func (e *TimeoutError) Timeout() bool {
+	return true
+}
 
 // Exchange sends the packet to the given server address and waits for a
 // response. nil and an error is returned upon failure.
+//
+// If no response is received within RetryInterval, the same packet (with the
+// same Identifier) is retransmitted, up to Retries times. A *TimeoutError is
+// returned if no response is received after the last attempt.
+//
+// Received packets that are not authentic responses to the request (see
+// Packet.VerifyResponse) are ignored, as they could be spoofed. However, if
+// no authentic response is received, but a response with the request's
+// Identifier was received whose authenticator did not match, ErrSecretMismatch
+// is returned rather than a *TimeoutError, as the server most likely has a
+// different secret.
 func (c *Client) Exchange(packet *Packet, addr string) (*Packet, error) {
+	return c.ExchangeContext(context.Background(), packet, addr)
+}
+
+// ExchangeContext is like Exchange, but the exchange is aborted when ctx is
+// cancelled or its deadline is reached. In that case, nil and ctx.Err() are
+// returned.
+func (c *Client) ExchangeContext(ctx context.Context, packet *Packet, addr string) (*Packet, error) {
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+	if c.OnExchange == nil {
+		return c.exchange(ctx, packet, addr)
+	}
+	start := time.Now()
+	response, err := c.exchange(ctx, packet, addr)
+	var code Code
+	if err == nil {
+		code = response.Code
+	}
+	c.OnExchange(code, time.Since(start), err)
+	return response, err
+}
+
+// RoundTrip makes an exchange with ExchangeContext. It implements
+// PacketRoundTripper.
+func (c *Client) RoundTrip(ctx context.Context, req *Packet, addr string) (*Packet, error) {
+	return c.ExchangeContext(ctx, req, addr)
+}
+
+// retryInterval returns the time to wait for a response before a request is
+// retransmitted.
+func (c *Client) retryInterval() time.Duration {
+	switch {
+	case c.RetryInterval > 0:
+		return c.RetryInterval
+	case c.Timeout > 0:
+		return c.Timeout / time.Duration(c.Retries+1)
+	case c.ReadTimeout > 0:
+		return c.ReadTimeout
+	}
+	return 10 * time.Second
+}
+
+// exchange makes an exchange for ExchangeContext.
+func (c *Client) exchange(ctx context.Context, packet *Packet, addr string) (*Packet, error) {
+	if c.RequestMiddleware != nil {
+		// a deep copy, as Set modifies attributes in place
+		packet = packet.Clone()
+		c.RequestMiddleware(packet)
+	}
+	if c.AddMessageAuthenticatorForEAP && packet.Len(attributeTypeEAPMessage) > 0 && packet.Len(attributeTypeMessageAuthenticator) == 0 {
+		request := *packet
+		request.Attributes = append([]*Attribute(nil), packet.Attributes...)
+		request.AddMessageAuthenticator()
+		packet = &request
+	}
+	if c.Transport != nil {
+		return c.Transport.RoundTrip(ctx, packet, addr)
+	}
+	if c.Persistent {
+		return c.exchangePersistent(ctx, packet, addr)
+	}
+
 	wire, err := packet.Encode()
 	if err != nil {
 		return nil, err
 	}
+	// Responses are authenticated against the authenticator that was sent,
+	// which is calculated when encoding an Accounting-Request.
+	sent := *packet
+	copy(sent.Authenticator[:], wire[4:20])
 
 	connNet := c.Net
 	if connNet == "" {
@@ -42,43 +246,107 @@ func (c *Client) Exchange(packet *Packet, addr string) (*Packet, error) {
 		dialTimeout = defaultTimeout
 	}
 
-	dialer := net.Dialer{
-		Timeout:   dialTimeout,
-		LocalAddr: c.LocalAddr,
+	dial := c.DialContext
+	if dial == nil {
+		dialer := net.Dialer{
+			Timeout:   dialTimeout,
+			LocalAddr: c.LocalAddr,
+		}
+		dial = dialer.DialContext
 	}
-	conn, err := dialer.Dial(connNet, addr)
+	conn, err := dial(ctx, connNet, addr)
 	if err != nil {
 		return nil, err
 	}
+	defer conn.Close()
+
+	if done := ctx.Done(); done != nil {
+		// Unblock any pending read or write once the context is done.
+		stop := make(chan struct{})
+		defer close(stop)
+		go func() {
+			select {
+			case <-done:
+				conn.SetDeadline(time.Unix(1, 0))
+			case <-stop:
+			}
+		}()
+	}
 
 	writeTimeout := c.WriteTimeout
 	if writeTimeout == 0 {
 		writeTimeout = defaultTimeout
 	}
-	conn.SetWriteDeadline(time.Now().Add(writeTimeout))
-	if _, err := conn.Write(wire); err != nil {
-		conn.Close()
-		return nil, err
-	}
+	retryInterval := c.retryInterval()
 
-	var incoming [maxPacketSize]byte
+	incoming := make([]byte, maxPacketSize())
+	// if a response was received whose authenticator did not match
+	mismatched := false
 
-	readTimeout := c.ReadTimeout
-	if readTimeout == 0 {
-		readTimeout = defaultTimeout
-	}
-	conn.SetReadDeadline(time.Now().Add(readTimeout))
+	for attempt := 1; ; attempt++ {
+		conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		// Checked after the deadline is set, so that a cancellation cannot be
+		// overwritten.
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if _, err := conn.Write(wire); err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return nil, ctxErr
+			}
+			return nil, err
+		}
 
-	for {
-		n, err := conn.Read(incoming[:])
-		if err != nil {
-			conn.Close()
+		readDeadline := time.Now().Add(retryInterval)
+		if deadline, ok := ctx.Deadline(); ok && deadline.Before(readDeadline) {
+			readDeadline = deadline
+		}
+		conn.SetReadDeadline(readDeadline)
+		if err := ctx.Err(); err != nil {
 			return nil, err
 		}
-		received, err := Parse(incoming[:n], packet.Secret, packet.Dictionary)
-		if err == nil && received.IsAuthentic(packet) {
-			conn.Close()
-			return received, nil
+
+		for {
+			n, err := conn.Read(incoming)
+			if err != nil {
+				if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+					break
+				}
+				if ctxErr := ctx.Err(); ctxErr != nil {
+					return nil, ctxErr
+				}
+				return nil, err
+			}
+			received, err := ParseResponse(incoming[:n], &sent)
+			if err == nil {
+				err = received.VerifyResponse(&sent)
+			}
+			if err == nil {
+				return received, nil
+			}
+			if err == ErrSecretMismatch {
+				mismatched = true
+			}
+			if c.Logger != nil {
+				c.Logger.Debugf("radius: ignoring packet from %s: %v", addr, err)
+			}
+		}
+
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		// The read deadline may be the deadline of ctx, which can be reached
+		// before ctx reports it.
+		if deadline, ok := ctx.Deadline(); ok && !time.Now().Before(deadline) {
+			return nil, context.DeadlineExceeded
+		}
+		if attempt > c.Retries {
+			if mismatched {
+				return nil, ErrSecretMismatch
+			}
+			return nil, &TimeoutError{
+				Attempts: attempt,
+			}
 		}
 	}
 }
@@ -0,0 +1,86 @@
+package radius
+
+import (
+	"context"
+	"sync"
+)
+
+// Request is a packet that is sent by Client.ExchangeBatch, along with the
+// address of the server that it is sent to.
+type Request struct {
+	Packet *Packet
+	Addr   string
+}
+
+// Result is the outcome of an asynchronous exchange. Exactly one of Packet
+// and Err is non-nil.
+type Result struct {
+	// The response to the request.
+	Packet *Packet
+	// The reason that the exchange failed.
+	Err error
+}
+
+// ExchangeAsync is like ExchangeContext, but the exchange is made in the
+// background. Its result is sent on the returned channel, which is then
+// closed.
+func (c *Client) ExchangeAsync(ctx context.Context, packet *Packet, addr string) <-chan Result {
+	results := make(chan Result, 1)
+	go func() {
+		defer close(results)
+		response, err := c.ExchangeContext(ctx, packet, addr)
+		results <- Result{
+			Packet: response,
+			Err:    err,
+		}
+	}()
+	return results
+}
+
+// ExchangeBatch makes an exchange for each of the given requests, with at
+// most BatchConcurrency of them in progress at the same time. It returns once
+// all of the exchanges are complete; the result of each request is at the
+// same index as the request.
+//
+// When the client is Persistent, the exchanges share its socket, and each
+// request is sent with an Identifier that is not used by any other pending
+// request to the same server. Otherwise, requests with the same Identifier
+// can be sent to the same server at once; make sure that their Identifiers
+// differ, or use a persistent client.
+//
+// If ctx is done, the exchanges that have not completed fail with ctx.Err().
+func (c *Client) ExchangeBatch(ctx context.Context, requests []Request) []Result {
+	concurrency := c.BatchConcurrency
+	if concurrency <= 0 {
+		concurrency = 16
+	}
+
+	results := make([]Result, len(requests))
+	slots := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, request := range requests {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			for j := i; j < len(requests); j++ {
+				results[j].Err = ctx.Err()
+			}
+			wg.Wait()
+			return results
+		}
+		wg.Add(1)
+		go func(i int, request Request) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			response, err := c.ExchangeContext(ctx, request.Packet, request.Addr)
+			results[i] = Result{
+				Packet: response,
+				Err:    err,
+			}
+		}(i, request)
+	}
+	wg.Wait()
+	return results
+}
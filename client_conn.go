@@ -0,0 +1,321 @@
+package radius
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+)
+
+// ErrClientClosed is returned by Client.Exchange when a persistent client is
+// used after it has been closed.
+var ErrClientClosed = errors.New("radius: client closed")
+
+// ErrNoFreeIdentifier is returned by Client.Exchange when a persistent client
+// has no free identifier for a request, and its IdentifierWait has elapsed.
+var ErrNoFreeIdentifier = errors.New("radius: no free identifier")
+
+// pendingKey identifies an exchange that is waiting for a response on a
+// persistent connection.
+type pendingKey struct {
+	// address of the server
+	addr string
+	// identifier of the request
+	identifier byte
+}
+
+// clientConn is the socket that is shared by the exchanges of a persistent
+// Client.
+type clientConn struct {
+	conn net.PacketConn
+
+	// closed once the read loop has stopped; err is then the reason
+	done chan struct{}
+	err  error
+
+	// protected by Client.mu
+	pending map[pendingKey]chan []byte
+	// number of pending exchanges with each server address
+	inFlight map[string]int
+	// closed and replaced each time an identifier is released
+	released chan struct{}
+}
+
+// persistentConn returns the client's shared connection, opening it if
+// needed.
+func (c *Client) persistentConn() (*clientConn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil, ErrClientClosed
+	}
+	if c.shared != nil {
+		select {
+		case <-c.shared.done:
+			// the previous socket failed; open a new one
+		default:
+			return c.shared, nil
+		}
+	}
+
+	connNet := c.Net
+	if connNet == "" {
+		connNet = "udp"
+	}
+	var localAddr string
+	if c.LocalAddr != nil {
+		localAddr = c.LocalAddr.String()
+	}
+	listen := c.ListenPacket
+	if listen == nil {
+		listen = net.ListenPacket
+	}
+	conn, err := listen(connNet, localAddr)
+	if err != nil {
+		return nil, err
+	}
+	shared := &clientConn{
+		conn:     conn,
+		done:     make(chan struct{}),
+		pending:  make(map[pendingKey]chan []byte),
+		inFlight: make(map[string]int),
+		released: make(chan struct{}),
+	}
+	go c.readLoop(shared)
+	c.shared = shared
+	return shared, nil
+}
+
+// readLoop passes the packets that are received on the shared connection to
+// the exchanges that are waiting for them.
+func (c *Client) readLoop(shared *clientConn) {
+	buff := make([]byte, maxPacketSize())
+	for {
+		n, addr, err := shared.conn.ReadFrom(buff)
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Temporary() {
+				if c.Logger != nil {
+					c.Logger.Warnf("radius: temporary error reading from %s: %v", shared.conn.LocalAddr(), err)
+				}
+				continue
+			}
+			shared.err = err
+			close(shared.done)
+			shared.conn.Close()
+			return
+		}
+		if n < 20 {
+			if c.Logger != nil {
+				c.Logger.Debugf("radius: ignoring packet from %s: packet is too short (%d bytes)", addr, n)
+			}
+			continue
+		}
+		key := pendingKey{
+			addr:       addr.String(),
+			identifier: buff[1],
+		}
+		c.mu.Lock()
+		ch := shared.pending[key]
+		c.mu.Unlock()
+		if ch == nil {
+			if c.Logger != nil {
+				c.Logger.Debugf("radius: ignoring packet from %s: no pending request with identifier %d", addr, buff[1])
+			}
+			continue
+		}
+		wire := make([]byte, n)
+		copy(wire, buff[:n])
+		select {
+		case ch <- wire:
+		default:
+			// a previous response has not been handled yet
+		}
+	}
+}
+
+// acquire allocates an identifier that is not used by any other pending
+// exchange with the server at addr. If none is free, it waits for one as
+// configured by IdentifierWait, or until ctx is done.
+func (c *Client) acquire(ctx context.Context, shared *clientConn, addr string, preferred byte) (byte, chan []byte, error) {
+	var expired <-chan time.Time
+	if c.IdentifierWait > 0 {
+		timer := time.NewTimer(c.IdentifierWait)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	for {
+		c.mu.Lock()
+		if shared.inFlight[addr] < 256 {
+			for i := 0; i < 256; i++ {
+				key := pendingKey{
+					addr:       addr,
+					identifier: preferred + byte(i),
+				}
+				if _, used := shared.pending[key]; !used {
+					ch := make(chan []byte, 1)
+					shared.pending[key] = ch
+					shared.inFlight[addr]++
+					c.mu.Unlock()
+					return key.identifier, ch, nil
+				}
+			}
+		}
+		released := shared.released
+		c.mu.Unlock()
+
+		if c.IdentifierWait < 0 {
+			return 0, nil, ErrNoFreeIdentifier
+		}
+		select {
+		case <-released:
+		case <-expired:
+			return 0, nil, ErrNoFreeIdentifier
+		case <-shared.done:
+			return 0, nil, c.connErr(shared)
+		case <-ctx.Done():
+			return 0, nil, ctx.Err()
+		}
+	}
+}
+
+// release frees an identifier that was allocated by acquire.
+func (c *Client) release(shared *clientConn, addr string, identifier byte) {
+	c.mu.Lock()
+	delete(shared.pending, pendingKey{
+		addr:       addr,
+		identifier: identifier,
+	})
+	if shared.inFlight[addr]--; shared.inFlight[addr] == 0 {
+		delete(shared.inFlight, addr)
+	}
+	close(shared.released)
+	shared.released = make(chan struct{})
+	c.mu.Unlock()
+}
+
+// InFlight returns the number of exchanges of a persistent client with the
+// server at addr that are waiting for a response, and so are using one of the
+// server's 256 identifiers. 0 is returned for a client that is not
+// persistent.
+func (c *Client) InFlight(addr string) int {
+	connNet := c.Net
+	if connNet == "" {
+		connNet = "udp"
+	}
+	raddr, err := net.ResolveUDPAddr(connNet, addr)
+	if err != nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.shared == nil {
+		return 0
+	}
+	return c.shared.inFlight[raddr.String()]
+}
+
+// connErr returns the reason that the shared connection stopped.
+func (c *Client) connErr(shared *clientConn) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return ErrClientClosed
+	}
+	return shared.err
+}
+
+func (c *Client) exchangePersistent(ctx context.Context, packet *Packet, addr string) (*Packet, error) {
+	connNet := c.Net
+	if connNet == "" {
+		connNet = "udp"
+	}
+	raddr, err := net.ResolveUDPAddr(connNet, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	shared, err := c.persistentConn()
+	if err != nil {
+		return nil, err
+	}
+
+	identifier, responses, err := c.acquire(ctx, shared, raddr.String(), packet.Identifier)
+	if err != nil {
+		return nil, err
+	}
+	defer c.release(shared, raddr.String(), identifier)
+
+	request := *packet
+	request.Identifier = identifier
+	wire, err := request.Encode()
+	if err != nil {
+		return nil, err
+	}
+	// Responses are authenticated against the authenticator that was sent.
+	copy(request.Authenticator[:], wire[4:20])
+
+	retryInterval := c.retryInterval()
+	// if a response was received whose authenticator did not match
+	mismatched := false
+
+	for attempt := 1; ; attempt++ {
+		if _, err := shared.conn.WriteTo(wire, raddr); err != nil {
+			return nil, err
+		}
+
+		timer := time.NewTimer(retryInterval)
+	wait:
+		for {
+			select {
+			case incoming := <-responses:
+				received, err := ParseResponse(incoming, &request)
+				if err == nil {
+					err = received.VerifyResponse(&request)
+				}
+				if err == nil {
+					timer.Stop()
+					return received, nil
+				}
+				if err == ErrSecretMismatch {
+					mismatched = true
+				}
+				if c.Logger != nil {
+					c.Logger.Debugf("radius: ignoring packet from %s: %v", raddr, err)
+				}
+			case <-timer.C:
+				break wait
+			case <-shared.done:
+				timer.Stop()
+				return nil, c.connErr(shared)
+			case <-ctx.Done():
+				timer.Stop()
+				return nil, ctx.Err()
+			}
+		}
+
+		if attempt > c.Retries {
+			if mismatched {
+				return nil, ErrSecretMismatch
+			}
+			return nil, &TimeoutError{
+				Attempts: attempt,
+			}
+		}
+	}
+}
+
+// Close closes the socket that is used by a persistent client. Any exchange
+// that is in progress returns ErrClientClosed. Close has no effect on a
+// client that is not persistent.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	if c.shared == nil {
+		return nil
+	}
+	return c.shared.conn.Close()
+}
@@ -0,0 +1,209 @@
+package radius
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ClientPool makes exchanges with one of several RADIUS servers, failing over
+// to the next server when one does not respond. It tracks the health of each
+// server: after MaxFailures consecutive timeouts, a server is unhealthy, and
+// is only tried once every healthy server has failed, until RecoverAfter has
+// elapsed since its last failure.
+//
+// The fields of a ClientPool must not be changed after first use.
+type ClientPool struct {
+	// Addresses of the servers, in the order of preference.
+	Servers []string
+
+	// Makes the exchange with each server, including its retransmissions:
+	// the pool tries the next server once the request has timed out on one
+	// (with a *TimeoutError, or another timeout error). It defaults to a
+	// Client with the default settings.
+	Transport PacketRoundTripper
+
+	// If true, each exchange starts at the server after the one that the
+	// previous exchange started at, so that requests are spread over the
+	// healthy servers. Otherwise, the servers are tried in order.
+	RoundRobin bool
+
+	// Number of consecutive timeouts after which a server is unhealthy.
+	// Defaults to 3.
+	MaxFailures int
+	// Time after the last failure of an unhealthy server that it is tried
+	// again as if it were healthy; a response makes it healthy, while another
+	// timeout extends the period. Defaults to 30 seconds.
+	RecoverAfter time.Duration
+
+	// Receives a warning when a server becomes unhealthy. If nil, nothing is
+	// logged.
+	Logger Logger
+
+	mu     sync.Mutex
+	health []ServerHealth
+	next   int
+
+	defaultTransport Client
+}
+
+// ServerHealth is the health of a server of a ClientPool.
+type ServerHealth struct {
+	Addr string
+	// Whether the server is tried before the unhealthy ones.
+	Healthy bool
+	// Number of timeouts since the server last responded.
+	ConsecutiveFailures int
+	// Time of the last timeout; zero if there has been none.
+	LastFailure time.Time
+	// Time of the last response; zero if there has been none.
+	LastSuccess time.Time
+}
+
+// Exchange is like ExchangeContext, with a background context.
+func (p *ClientPool) Exchange(packet *Packet) (*Packet, error) {
+	return p.ExchangeContext(context.Background(), packet)
+}
+
+// ExchangeContext sends the packet to the healthy servers in turn, until one
+// of them responds, and then to the unhealthy ones. It returns the first
+// response, or the error from the last server that was tried. The exchange
+// is aborted when ctx is done, in which case nil and ctx.Err() are returned;
+// a deadline of ctx applies to the exchange as a whole, across servers.
+//
+// Errors other than timeouts, such as ErrSecretMismatch, also cause the next
+// server to be tried, but do not count towards the health of the server.
+func (p *ClientPool) ExchangeContext(ctx context.Context, packet *Packet) (*Packet, error) {
+	order := p.order()
+	if len(order) == 0 {
+		return nil, errors.New("radius: client pool has no servers")
+	}
+	transport := p.transport()
+
+	var lastErr error
+	for _, i := range order {
+		response, err := transport.RoundTrip(ctx, packet, p.Servers[i])
+		if err == nil {
+			p.succeeded(i)
+			return response, nil
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		if isTimeout(err) {
+			p.failed(i)
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// Health returns the health of each server, in the order of Servers.
+func (p *ClientPool) Health() []ServerHealth {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.init()
+	health := make([]ServerHealth, len(p.health))
+	now := time.Now()
+	for i := range p.health {
+		health[i] = p.health[i]
+		health[i].Healthy = p.healthy(i, now)
+	}
+	return health
+}
+
+// transport returns the round-tripper that exchanges are made with.
+func (p *ClientPool) transport() PacketRoundTripper {
+	if p.Transport != nil {
+		return p.Transport
+	}
+	return &p.defaultTransport
+}
+
+// init creates the health of the servers on first use. p.mu must be held.
+func (p *ClientPool) init() {
+	if p.health != nil {
+		return
+	}
+	p.health = make([]ServerHealth, len(p.Servers))
+	for i, addr := range p.Servers {
+		p.health[i].Addr = addr
+	}
+}
+
+// healthy reports whether server i is healthy at now. p.mu must be held.
+func (p *ClientPool) healthy(i int, now time.Time) bool {
+	maxFailures := p.MaxFailures
+	if maxFailures <= 0 {
+		maxFailures = 3
+	}
+	recoverAfter := p.RecoverAfter
+	if recoverAfter <= 0 {
+		recoverAfter = 30 * time.Second
+	}
+	h := &p.health[i]
+	return h.ConsecutiveFailures < maxFailures || now.Sub(h.LastFailure) >= recoverAfter
+}
+
+// order returns the indexes of the servers in the order that they are tried:
+// the healthy ones, starting from the next one if RoundRobin is set, then the
+// unhealthy ones, the longest failed first.
+func (p *ClientPool) order() []int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.init()
+
+	start := 0
+	if p.RoundRobin && len(p.Servers) > 0 {
+		start = p.next
+		p.next = (p.next + 1) % len(p.Servers)
+	}
+	now := time.Now()
+	order := make([]int, 0, len(p.Servers))
+	var unhealthy []int
+	for n := 0; n < len(p.Servers); n++ {
+		i := (start + n) % len(p.Servers)
+		if p.healthy(i, now) {
+			order = append(order, i)
+		} else {
+			unhealthy = append(unhealthy, i)
+		}
+	}
+	for len(unhealthy) > 0 {
+		oldest := 0
+		for j := range unhealthy {
+			if p.health[unhealthy[j]].LastFailure.Before(p.health[unhealthy[oldest]].LastFailure) {
+				oldest = j
+			}
+		}
+		order = append(order, unhealthy[oldest])
+		unhealthy = append(unhealthy[:oldest], unhealthy[oldest+1:]...)
+	}
+	return order
+}
+
+func (p *ClientPool) succeeded(i int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.health[i].ConsecutiveFailures = 0
+	p.health[i].LastSuccess = time.Now()
+}
+
+func (p *ClientPool) failed(i int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	wasHealthy := p.healthy(i, now)
+	p.health[i].ConsecutiveFailures++
+	p.health[i].LastFailure = now
+	if wasHealthy && !p.healthy(i, now) && p.Logger != nil {
+		p.Logger.Warnf("radius: server %s is unhealthy after %d consecutive timeouts", p.Servers[i], p.health[i].ConsecutiveFailures)
+	}
+}
+
+// isTimeout reports whether err is a timeout, such as a *TimeoutError.
+func isTimeout(err error) bool {
+	var timeout interface{ Timeout() bool }
+	return errors.As(err, &timeout) && timeout.Timeout()
+}
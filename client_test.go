@@ -0,0 +1,614 @@
+package radius_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/PromonLogicalis/radius"
+)
+
+// udpServer starts a UDP server on the loopback interface. handle is called
+// for each received datagram; a non-nil return value is sent as the reply.
+func udpServer(t *testing.T, handle func(wire []byte) []byte) net.PacketConn {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		buff := make([]byte, 4096)
+		for {
+			n, addr, err := conn.ReadFrom(buff)
+			if err != nil {
+				return
+			}
+			wire := make([]byte, n)
+			copy(wire, buff[:n])
+			if reply := handle(wire); reply != nil {
+				conn.WriteTo(reply, addr)
+			}
+		}
+	}()
+	return conn
+}
+
+func TestClientRetries(t *testing.T) {
+	secret := []byte("secret")
+
+	received := make(chan []byte, 10)
+	server := udpServer(t, func(wire []byte) []byte {
+		received <- wire
+		if len(received) < 2 {
+			// drop the first attempt
+			return nil
+		}
+		request, err := radius.Parse(wire, secret, radius.Builtin)
+		if err != nil {
+			return nil
+		}
+		response := radius.Packet{
+			Code:          radius.CodeAccessAccept,
+			Identifier:    request.Identifier,
+			Authenticator: request.Authenticator,
+			Secret:        secret,
+			Dictionary:    radius.Builtin,
+		}
+		reply, _ := response.Encode()
+		return reply
+	})
+	defer server.Close()
+
+	client := radius.Client{
+		Retries:       2,
+		RetryInterval: 50 * time.Millisecond,
+	}
+	packet := radius.New(radius.CodeAccessRequest, secret)
+	packet.Add("User-Name", "tim")
+	response, err := client.Exchange(packet, server.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Code != radius.CodeAccessAccept {
+		t.Fatal("expecting Access-Accept")
+	}
+	if len(received) != 2 {
+		t.Fatalf("expecting 2 attempts; got %d", len(received))
+	}
+	if first, second := <-received, <-received; !bytes.Equal(first, second) {
+		t.Fatal("expecting retransmission to be identical to the first attempt")
+	}
+}
+
+func TestClientTimeout(t *testing.T) {
+	attempts := make(chan []byte, 10)
+	server := udpServer(t, func(wire []byte) []byte {
+		attempts <- wire
+		return nil
+	})
+	defer server.Close()
+
+	client := radius.Client{
+		Retries:       2,
+		RetryInterval: 20 * time.Millisecond,
+	}
+	packet := radius.New(radius.CodeAccessRequest, []byte("secret"))
+	_, err := client.Exchange(packet, server.LocalAddr().String())
+	timeoutErr, ok := err.(*radius.TimeoutError)
+	if !ok {
+		t.Fatalf("expecting *TimeoutError; got %v", err)
+	}
+	if timeoutErr.Attempts != 3 {
+		t.Fatalf("expecting 3 attempts; got %d", timeoutErr.Attempts)
+	}
+}
+
+func TestClientTotalTimeout(t *testing.T) {
+	attempts := make(chan []byte, 10)
+	server := udpServer(t, func(wire []byte) []byte {
+		attempts <- wire
+		return nil
+	})
+	defer server.Close()
+	addr := server.LocalAddr().String()
+
+	// the total timeout expires while waiting for the first response
+	client := radius.Client{
+		Timeout:       50 * time.Millisecond,
+		Retries:       3,
+		RetryInterval: 10 * time.Second,
+	}
+	start := time.Now()
+	_, err := client.Exchange(radius.New(radius.CodeAccessRequest, []byte("secret")), addr)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("expecting context.DeadlineExceeded; got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expecting exchange to be aborted after 50ms; took %v", elapsed)
+	}
+	if n := len(attempts); n != 1 {
+		t.Fatalf("expecting 1 attempt; got %d", n)
+	}
+	<-attempts
+
+	// the retry interval is derived from the total timeout
+	client = radius.Client{
+		Timeout: 200 * time.Millisecond,
+		Retries: 3,
+	}
+	start = time.Now()
+	if _, err := client.Exchange(radius.New(radius.CodeAccessRequest, []byte("secret")), addr); err == nil {
+		t.Fatal("expecting exchange to time out")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expecting exchange to take at most 200ms; took %v", elapsed)
+	}
+	if n := len(attempts); n < 3 {
+		t.Fatalf("expecting the retries to fit in the total timeout; got %d attempts", n)
+	}
+}
+
+func TestClientExchangeContext(t *testing.T) {
+	server := udpServer(t, func(wire []byte) []byte {
+		return nil
+	})
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	client := radius.Client{
+		ReadTimeout: 10 * time.Second,
+	}
+	packet := radius.New(radius.CodeAccessRequest, []byte("secret"))
+	start := time.Now()
+	_, err := client.ExchangeContext(ctx, packet, server.LocalAddr().String())
+	if err != context.Canceled {
+		t.Fatalf("expecting context.Canceled; got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("exchange was not aborted (took %s)", elapsed)
+	}
+}
+
+func TestClientChangeOfAuthorization(t *testing.T) {
+	secret := []byte("secret")
+
+	server := udpServer(t, func(wire []byte) []byte {
+		request, err := radius.Parse(wire, secret, radius.Builtin)
+		if err != nil || request.Code != radius.CodeCoARequest || !request.IsAuthenticRequest() {
+			return nil
+		}
+		response, err := request.Response(radius.CodeCoANAK)
+		if err != nil {
+			return nil
+		}
+		response.Add("Error-Cause", radius.ErrorCauseSessionContextNotFound)
+		reply, _ := response.Encode()
+		return reply
+	})
+	defer server.Close()
+
+	var client radius.Client
+	packet := radius.New(radius.CodeAccessRequest, secret)
+	packet.Add("User-Name", "tim")
+	response, err := client.ChangeOfAuthorization(context.Background(), packet, server.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Code != radius.CodeCoANAK {
+		t.Fatal("expecting CoA-NAK")
+	}
+	if cause, _ := response.Value("Error-Cause").(uint32); cause != radius.ErrorCauseSessionContextNotFound {
+		t.Fatalf("expecting Error-Cause = 503; got %v", response.Value("Error-Cause"))
+	}
+	if packet.Code != radius.CodeAccessRequest {
+		t.Fatal("expecting packet to be unmodified")
+	}
+}
+
+func TestClientPersistent(t *testing.T) {
+	secret := []byte("secret")
+
+	sources := make(chan string, 100)
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	go func() {
+		buff := make([]byte, 4096)
+		for {
+			n, addr, err := server.ReadFrom(buff)
+			if err != nil {
+				return
+			}
+			sources <- addr.String()
+			request, err := radius.Parse(buff[:n], secret, radius.Builtin)
+			if err != nil {
+				continue
+			}
+			response, _ := request.Response(radius.CodeAccessAccept)
+			response.Add("Reply-Message", request.String("User-Name"))
+			reply, _ := response.Encode()
+			// reply out of order
+			time.AfterFunc(time.Duration(n%7)*time.Millisecond, func() {
+				server.WriteTo(reply, addr)
+			})
+		}
+	}()
+
+	client := radius.Client{
+		Persistent: true,
+	}
+	defer client.Close()
+
+	const count = 50
+	errs := make(chan error, count)
+	for i := 0; i < count; i++ {
+		go func(i int) {
+			packet := radius.New(radius.CodeAccessRequest, secret)
+			packet.Identifier = 1
+			name := strings.Repeat("x", i+1)
+			packet.Add("User-Name", name)
+			response, err := client.Exchange(packet, server.LocalAddr().String())
+			if err == nil && response.String("Reply-Message") != name {
+				err = errors.New("response does not match request")
+			}
+			errs <- err
+		}(i)
+	}
+	for i := 0; i < count; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	first := <-sources
+	for i := 1; i < count; i++ {
+		if source := <-sources; source != first {
+			t.Fatalf("expecting all requests from %s; got %s", first, source)
+		}
+	}
+
+	client.Close()
+	packet := radius.New(radius.CodeAccessRequest, secret)
+	if _, err := client.Exchange(packet, server.LocalAddr().String()); err != radius.ErrClientClosed {
+		t.Fatalf("expecting ErrClientClosed; got %v", err)
+	}
+}
+
+func TestClientIdentifierWait(t *testing.T) {
+	secret := []byte("secret")
+
+	// a server that never responds
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	addr := server.LocalAddr().String()
+
+	client := radius.Client{
+		Persistent:     true,
+		ReadTimeout:    10 * time.Second,
+		IdentifierWait: 20 * time.Millisecond,
+	}
+	defer client.Close()
+
+	errs := make(chan error, 256)
+	for i := 0; i < 256; i++ {
+		go func() {
+			_, err := client.Exchange(radius.New(radius.CodeAccessRequest, secret), addr)
+			errs <- err
+		}()
+	}
+	for i := 0; client.InFlight(addr) != 256; i++ {
+		if i == 100 {
+			t.Fatalf("expecting 256 exchanges in flight; got %d", client.InFlight(addr))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	start := time.Now()
+	if _, err := client.Exchange(radius.New(radius.CodeAccessRequest, secret), addr); err != radius.ErrNoFreeIdentifier {
+		t.Fatalf("expecting ErrNoFreeIdentifier; got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < client.IdentifierWait {
+		t.Fatalf("expecting to wait for a free identifier; waited %v", elapsed)
+	}
+
+	client.Close()
+	for i := 0; i < 256; i++ {
+		if err := <-errs; err != radius.ErrClientClosed {
+			t.Fatalf("expecting ErrClientClosed; got %v", err)
+		}
+	}
+	if n := client.InFlight(addr); n != 0 {
+		t.Fatalf("expecting no exchanges in flight; got %d", n)
+	}
+}
+
+func TestClientPing(t *testing.T) {
+	secret := []byte("secret")
+	addr := freeAddr(t)
+
+	server := radius.Server{
+		Addr:                 addr,
+		Secret:               secret,
+		Dictionary:           radius.Builtin,
+		StatusServerResponse: radius.CodeAccessAccept,
+		Handler: radius.HandlerFunc(func(w radius.ResponseWriter, p *radius.Packet) {
+			t.Error("expecting Status-Server to be answered without calling the handler")
+		}),
+	}
+	go server.ListenAndServe()
+	defer server.Close()
+
+	client := radius.Client{
+		ReadTimeout: 50 * time.Millisecond,
+	}
+	var err error
+	for i := 0; i < 50; i++ {
+		// the server may not be listening yet
+		if err = client.Ping(context.Background(), secret, addr); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.Ping(context.Background(), []byte("wrong"), addr); err == nil {
+		t.Fatal("expecting Ping with the wrong secret to fail")
+	}
+}
+
+func TestClientExchangeBatch(t *testing.T) {
+	secret := []byte("secret")
+
+	server := udpServer(t, func(wire []byte) []byte {
+		request, err := radius.Parse(wire, secret, radius.Builtin)
+		if err != nil {
+			return nil
+		}
+		response, _ := request.Response(radius.CodeAccessAccept)
+		response.Add("Reply-Message", request.String("User-Name"))
+		reply, _ := response.Encode()
+		return reply
+	})
+	defer server.Close()
+	addr := server.LocalAddr().String()
+
+	client := radius.Client{
+		Persistent:       true,
+		BatchConcurrency: 4,
+	}
+	defer client.Close()
+
+	var requests []radius.Request
+	for i := 0; i < 20; i++ {
+		packet := radius.New(radius.CodeAccessRequest, secret)
+		packet.Identifier = 1
+		packet.Add("User-Name", strings.Repeat("x", i+1))
+		requests = append(requests, radius.Request{
+			Packet: packet,
+			Addr:   addr,
+		})
+	}
+	results := client.ExchangeBatch(context.Background(), requests)
+	if len(results) != len(requests) {
+		t.Fatalf("expecting %d results; got %d", len(requests), len(results))
+	}
+	for i, result := range results {
+		if result.Err != nil {
+			t.Fatal(result.Err)
+		}
+		if name := requests[i].Packet.String("User-Name"); result.Packet.String("Reply-Message") != name {
+			t.Fatalf("result %d does not match its request", i)
+		}
+	}
+
+	result := <-client.ExchangeAsync(context.Background(), requests[0].Packet, addr)
+	if result.Err != nil || result.Packet.Code != radius.CodeAccessAccept {
+		t.Fatalf("unexpected async result %v", result)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, result := range client.ExchangeBatch(ctx, requests) {
+		if result.Err == nil {
+			t.Fatal("expecting cancelled batch to fail")
+		}
+	}
+}
+
+func TestClientRequestMiddleware(t *testing.T) {
+	secret := []byte("secret")
+
+	received := make(chan *radius.Packet, 1)
+	server := udpServer(t, func(wire []byte) []byte {
+		request, err := radius.Parse(wire, secret, radius.Builtin)
+		if err != nil || !request.IsAuthentic(request) {
+			return nil
+		}
+		received <- request
+		response, err := request.Response(radius.CodeAccountingResponse)
+		if err != nil {
+			return nil
+		}
+		reply, _ := response.Encode()
+		return reply
+	})
+	defer server.Close()
+
+	client := radius.Client{
+		ReadTimeout: time.Second,
+		RequestMiddleware: func(p *radius.Packet) {
+			p.Remove(33)
+			p.Set("NAS-Identifier", "nas1")
+		},
+	}
+	packet := radius.New(radius.CodeAccountingRequest, secret)
+	packet.Add("Acct-Status-Type", radius.AcctStatusTypeStart)
+	packet.Add("Acct-Session-Id", "a")
+	packet.Add("Proxy-State", []byte("internal"))
+	packet.Add("NAS-Identifier", "caller")
+	if _, err := client.Exchange(packet, server.LocalAddr().String()); err != nil {
+		t.Fatal(err)
+	}
+
+	// the authenticator of the request covers the changed attributes
+	request := <-received
+	if request.String("NAS-Identifier") != "nas1" || request.Len(33) != 0 {
+		t.Fatalf("expecting the middleware's changes to be sent; got %v", request.Attributes)
+	}
+	if packet.Len(33) != 1 || packet.String("NAS-Identifier") != "caller" {
+		t.Fatal("expecting the caller's packet not to be modified")
+	}
+}
+
+func TestClientSecretMismatch(t *testing.T) {
+	server := udpServer(t, func(wire []byte) []byte {
+		request, err := radius.Parse(wire, []byte("other"), radius.Builtin)
+		if err != nil {
+			return nil
+		}
+		response, _ := request.Response(radius.CodeAccessAccept)
+		reply, _ := response.Encode()
+		return reply
+	})
+	defer server.Close()
+
+	for _, persistent := range []bool{false, true} {
+		client := radius.Client{
+			Retries:       1,
+			RetryInterval: 50 * time.Millisecond,
+			Persistent:    persistent,
+		}
+		packet := radius.New(radius.CodeAccessRequest, []byte("secret"))
+		if _, err := client.Exchange(packet, server.LocalAddr().String()); err != radius.ErrSecretMismatch {
+			t.Fatalf("expecting ErrSecretMismatch (persistent: %v); got %v", persistent, err)
+		}
+		client.Close()
+	}
+}
+
+func TestClientTransport(t *testing.T) {
+	secret := []byte("secret")
+	server := udpServer(t, func(wire []byte) []byte {
+		request, err := radius.Parse(wire, secret, radius.Builtin)
+		if err != nil || request.String("NAS-Identifier") != "nas1" {
+			// the request was not changed by the middleware
+			return nil
+		}
+		response, err := request.Response(radius.CodeAccessAccept)
+		if err != nil {
+			return nil
+		}
+		reply, _ := response.Encode()
+		return reply
+	})
+	defer server.Close()
+
+	// a round-tripper that fails over to the next server when one does not
+	// respond
+	servers := []string{freeAddr(t), server.LocalAddr().String()}
+	var tried []string
+	next := &radius.Client{ReadTimeout: 50 * time.Millisecond}
+	failover := radius.PacketRoundTripperFunc(func(ctx context.Context, req *radius.Packet, addr string) (*radius.Packet, error) {
+		var err error
+		for _, server := range servers {
+			tried = append(tried, server)
+			var response *radius.Packet
+			if response, err = next.RoundTrip(ctx, req, server); err == nil {
+				return response, nil
+			}
+		}
+		return nil, err
+	})
+
+	var sent string
+	client := radius.Client{
+		Transport: failover,
+		RequestMiddleware: func(p *radius.Packet) {
+			p.Set("NAS-Identifier", "nas1")
+		},
+		OnExchange: func(code radius.Code, duration time.Duration, err error) {
+			sent = code.String()
+		},
+	}
+	packet := radius.New(radius.CodeAccessRequest, secret)
+	packet.Add("User-Name", "nemo")
+	response, err := client.Exchange(packet, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Code != radius.CodeAccessAccept || sent != "Access-Accept" {
+		t.Fatalf("expecting Access-Accept; got %v (reported %s)", response.Code, sent)
+	}
+	if len(tried) != 2 || tried[1] != servers[1] {
+		t.Fatalf("expecting both servers to be tried; got %v", tried)
+	}
+}
+
+func TestClientPool(t *testing.T) {
+	secret := []byte("secret")
+
+	var dropped int32
+	down := udpServer(t, func(wire []byte) []byte {
+		atomic.AddInt32(&dropped, 1)
+		return nil
+	})
+	defer down.Close()
+	up := udpServer(t, func(wire []byte) []byte {
+		request, err := radius.Parse(wire, secret, radius.Builtin)
+		if err != nil {
+			return nil
+		}
+		response, err := request.Response(radius.CodeAccessAccept)
+		if err != nil {
+			return nil
+		}
+		reply, _ := response.Encode()
+		return reply
+	})
+	defer up.Close()
+
+	pool := radius.ClientPool{
+		Servers: []string{down.LocalAddr().String(), up.LocalAddr().String()},
+		Transport: &radius.Client{
+			ReadTimeout: 50 * time.Millisecond,
+			Retries:     1,
+		},
+		MaxFailures:  2,
+		RecoverAfter: time.Hour,
+	}
+	for i := 0; i < 3; i++ {
+		response, err := pool.Exchange(radius.New(radius.CodeAccessRequest, secret))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if response.Code != radius.CodeAccessAccept {
+			t.Fatalf("expecting Access-Accept; got %v", response.Code)
+		}
+	}
+
+	// the first two exchanges were retransmitted to the server that is down
+	// before failing over; the third skipped it
+	if n := atomic.LoadInt32(&dropped); n != 4 {
+		t.Fatalf("expecting 4 requests to the server that is down; got %d", n)
+	}
+	health := pool.Health()
+	if health[0].Healthy || health[0].ConsecutiveFailures != 2 || health[0].LastFailure.IsZero() {
+		t.Fatalf("expecting first server to be unhealthy; got %+v", health[0])
+	}
+	if !health[1].Healthy || health[1].ConsecutiveFailures != 0 || health[1].LastSuccess.IsZero() {
+		t.Fatalf("expecting second server to be healthy; got %+v", health[1])
+	}
+}
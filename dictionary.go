@@ -1,10 +1,28 @@
 package radius
 
 import (
+	"encoding/binary"
 	"errors"
+	"fmt"
+	"sort"
+	"strings"
 	"sync"
 )
 
+// Errors that are returned when looking up attributes. They may be wrapped
+// with additional context, so they should be tested using errors.Is.
+var (
+	// ErrAttributeAlreadyRegistered is returned when registering an attribute
+	// whose type is already registered.
+	ErrAttributeAlreadyRegistered = errors.New("radius: attribute already registered")
+	// ErrAttributeNotRegistered is returned when an attribute name or type is
+	// not registered in a dictionary.
+	ErrAttributeNotRegistered = errors.New("radius: attribute is not registered")
+	// ErrAttributeNotFound is returned when a packet does not contain a
+	// required attribute.
+	ErrAttributeNotFound = errors.New("radius: attribute not found")
+)
+
 var builtinOnce sync.Once
 
 // Builtin is the built-in dictionary. It is initially loaded with the
@@ -18,38 +36,168 @@ func initDictionary() {
 // DictionaryEntry stores a single mapping between an attribute name, type and
 // AttributeCodec.
 type DictionaryEntry struct {
-	Type  byte
-	Name  string
-	Codec AttributeCodec
+	// Vendor ID of a vendor-specific attribute; zero otherwise.
+	Vendor uint32
+	Type   byte
+	// Extended type of an RFC 6929 extended attribute, whose Type is that of
+	// the attribute that carries it (241 to 246); zero otherwise.
+	ExtendedType byte
+	Name         string
+	Codec        AttributeCodec
+	// Tagged is true if the attribute carries an RFC 2868 tag, in which case
+	// Codec implements AttributeTaggedCodec.
+	Tagged bool
+	// Range of lengths, in bytes, of the attribute's value (including its tag,
+	// if any) that are accepted when a packet is parsed. Zero means that the
+	// length is not constrained. They are set using Dictionary.SetLength.
+	MinLength int
+	MaxLength int
+	// Concat is true if a value that is longer than 253 bytes is split over
+	// consecutive attributes of the type, which are concatenated back when a
+	// packet is parsed (RFC 2865, section 2). It is set using
+	// Dictionary.SetConcat.
+	Concat bool
+	// Sensitive is true if the attribute carries a credential or a key, such
+	// as User-Password, whose value is redacted by Packet.Dump and
+	// Packet.Summary. It is set using Dictionary.SetSensitive.
+	Sensitive bool
 }
 
 // Dictionary stores mappings between attribute names and types and
 // AttributeCodecs.
+//
+// A nil *Dictionary is empty: nothing is registered in it, so its lookups
+// find nothing, and packets that are parsed with it have the undecoded values
+// of all of their attributes (see Parse). It cannot be registered into.
 type Dictionary struct {
 	mu               sync.RWMutex
 	attributesByType [256]*DictionaryEntry
 	attributesByName map[string]*DictionaryEntry
+	vendors          map[uint32]*VendorDictionary
+	// RFC 6929 extended attributes, by extendedKey
+	extended map[uint16]*DictionaryEntry
+	// named values of enumerated attributes, by attribute name
+	values map[string]*valueNames
+	// names of the attributes that are registered under each alias
+	aliases map[string]string
+	// set using SetUnknownPolicy
+	unknownPolicy UnknownPolicy
+}
+
+// used in place of a nil *Dictionary
+var emptyDictionary Dictionary
+
+// valueNames stores the named values of an enumerated attribute.
+type valueNames struct {
+	byName  map[string]uint32
+	byValue map[uint32]string
 }
 
 // Register registers the AttributeCodec for the given attribute name and type.
 func (d *Dictionary) Register(name string, t byte, codec AttributeCodec) error {
+	return d.register(&DictionaryEntry{
+		Type:  t,
+		Name:  name,
+		Codec: codec,
+	})
+}
+
+// RegisterTagged registers the AttributeTaggedCodec for the given RFC 2868
+// tagged attribute name and type.
+func (d *Dictionary) RegisterTagged(name string, t byte, codec AttributeTaggedCodec) error {
+	return d.register(&DictionaryEntry{
+		Type:   t,
+		Name:   name,
+		Codec:  codec,
+		Tagged: true,
+	})
+}
+
+func (d *Dictionary) register(entry *DictionaryEntry) error {
 	d.mu.Lock()
-	if d.attributesByType[t] != nil {
+	if d.attributesByType[entry.Type] != nil {
 		d.mu.Unlock()
-		return errors.New("radius: attribute already registered")
+		return ErrAttributeAlreadyRegistered
 	}
+	d.attributesByType[entry.Type] = entry
+	if d.attributesByName == nil {
+		d.attributesByName = make(map[string]*DictionaryEntry)
+	}
+	d.attributesByName[entry.Name] = entry
+	d.mu.Unlock()
+	return nil
+}
+
+// ReplaceRegister registers the AttributeCodec for the given attribute name
+// and type, replacing any attribute that is already registered with the same
+// type or name. The replacement is atomic; concurrent lookups see either the
+// old or the new attribute.
+func (d *Dictionary) ReplaceRegister(name string, t byte, codec AttributeCodec) {
 	entry := &DictionaryEntry{
 		Type:  t,
 		Name:  name,
 		Codec: codec,
 	}
-	d.attributesByType[t] = entry
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if existing := d.attributesByType[t]; existing != nil {
+		d.unnameLocked(existing.Name)
+	}
 	if d.attributesByName == nil {
 		d.attributesByName = make(map[string]*DictionaryEntry)
 	}
+	if named := d.attributesByName[name]; named != nil {
+		d.unstoreLocked(named)
+	}
+	d.attributesByType[t] = entry
 	d.attributesByName[name] = entry
-	d.mu.Unlock()
-	return nil
+}
+
+// storedLocked returns the entry that is registered with the same type (and
+// vendor ID or extended type) as entry, or nil if there is none. d.mu must be
+// held.
+func (d *Dictionary) storedLocked(entry *DictionaryEntry) *DictionaryEntry {
+	switch {
+	case entry.Vendor != 0:
+		if vendor := d.vendors[entry.Vendor]; vendor != nil {
+			return vendor.attributesByType[entry.Type]
+		}
+		return nil
+	case entry.ExtendedType != 0:
+		return d.extended[extendedKey(entry.Type, entry.ExtendedType)]
+	}
+	return d.attributesByType[entry.Type]
+}
+
+// storeLocked registers entry by its type (and vendor ID or extended type),
+// but not by its name. d.mu must be held for writing.
+func (d *Dictionary) storeLocked(entry *DictionaryEntry) {
+	switch {
+	case entry.Vendor != 0:
+		d.vendorLocked(entry.Vendor).attributesByType[entry.Type] = entry
+	case entry.ExtendedType != 0:
+		if d.extended == nil {
+			d.extended = make(map[uint16]*DictionaryEntry)
+		}
+		d.extended[extendedKey(entry.Type, entry.ExtendedType)] = entry
+	default:
+		d.attributesByType[entry.Type] = entry
+	}
+}
+
+// unstoreLocked removes the registration of entry by its type, but not by its
+// name. d.mu must be held for writing.
+func (d *Dictionary) unstoreLocked(entry *DictionaryEntry) {
+	switch {
+	case entry.Vendor != 0:
+		d.vendors[entry.Vendor].attributesByType[entry.Type] = nil
+	case entry.ExtendedType != 0:
+		delete(d.extended, extendedKey(entry.Type, entry.ExtendedType))
+	default:
+		d.attributesByType[entry.Type] = nil
+	}
 }
 
 // MustRegister is a helper for Register that panics if it returns an error.
@@ -59,16 +207,299 @@ func (d *Dictionary) MustRegister(name string, t byte, codec AttributeCodec) {
 	}
 }
 
-func (d *Dictionary) get(name string) (t byte, codec AttributeCodec, ok bool) {
+// MustRegisterTagged is a helper for RegisterTagged that panics if it returns
+// an error.
+func (d *Dictionary) MustRegisterTagged(name string, t byte, codec AttributeTaggedCodec) {
+	if err := d.RegisterTagged(name, t, codec); err != nil {
+		panic(err)
+	}
+}
+
+// RegisterAlias registers aliasName as another name of the attribute that is
+// registered as existingName (which may itself be an alias), such as a name
+// that the attribute was known by before it was renamed. The alias can be
+// given wherever an attribute name is accepted, such as to Attr, Type, and
+// Packet.Get; Name returns the name that the attribute was registered with.
+// The aliases of an attribute are removed along with it.
+//
+// An error is returned if existingName is not registered, or if aliasName is
+// already the name or alias of an attribute.
+func (d *Dictionary) RegisterAlias(existingName, aliasName string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	name := d.canonicalLocked(existingName)
+	if d.attributesByName[name] == nil {
+		return fmt.Errorf("%w: %s", ErrAttributeNotRegistered, existingName)
+	}
+	if _, exists := d.aliases[aliasName]; exists || d.attributesByName[aliasName] != nil {
+		return fmt.Errorf("%w: %s", ErrAttributeAlreadyRegistered, aliasName)
+	}
+	d.aliasLocked(aliasName, name)
+	return nil
+}
+
+// MustRegisterAlias is a helper for RegisterAlias that panics if it returns an
+// error.
+func (d *Dictionary) MustRegisterAlias(existingName, aliasName string) {
+	if err := d.RegisterAlias(existingName, aliasName); err != nil {
+		panic(err)
+	}
+}
+
+// aliasLocked registers alias as an alias of name. d.mu must be held for
+// writing.
+func (d *Dictionary) aliasLocked(alias, name string) {
+	if d.aliases == nil {
+		d.aliases = make(map[string]string)
+	}
+	d.aliases[alias] = name
+}
+
+// canonicalLocked returns the name that the attribute known as name was
+// registered with, which is name itself unless it is an alias. d.mu must be
+// held.
+func (d *Dictionary) canonicalLocked(name string) string {
+	if _, ok := d.attributesByName[name]; ok {
+		return name
+	}
+	if canonical, ok := d.aliases[name]; ok {
+		return canonical
+	}
+	return name
+}
+
+// unnameLocked removes the registration of an attribute by its name, and its
+// aliases. d.mu must be held for writing.
+func (d *Dictionary) unnameLocked(name string) {
+	delete(d.attributesByName, name)
+	for alias, canonical := range d.aliases {
+		if canonical == name {
+			delete(d.aliases, alias)
+		}
+	}
+}
+
+// RegisterValue registers a name for a value of the enumerated integer
+// attribute attrName, such as "Login-User" for the Service-Type value 1. Once
+// registered, the name can be given to Attr in place of the value, and is
+// returned by Packet.GetEnum and Packet.String.
+//
+// A value may have several names; the first one that is registered is used
+// when looking up the name of the value.
+func (d *Dictionary) RegisterValue(attrName string, valueName string, value uint32) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	attrName = d.canonicalLocked(attrName)
+	if d.attributesByName[attrName] == nil {
+		return fmt.Errorf("%w: %s", ErrAttributeNotRegistered, attrName)
+	}
+	d.registerValueLocked(attrName, valueName, value)
+	return nil
+}
+
+// MustRegisterValue is a helper for RegisterValue that panics if it returns an
+// error.
+func (d *Dictionary) MustRegisterValue(attrName string, valueName string, value uint32) {
+	if err := d.RegisterValue(attrName, valueName, value); err != nil {
+		panic(err)
+	}
+}
+
+// registerValueLocked registers a value name. d.mu must be held for writing.
+func (d *Dictionary) registerValueLocked(attrName string, valueName string, value uint32) {
+	names := d.values[attrName]
+	if names == nil {
+		names = &valueNames{
+			byName:  make(map[string]uint32),
+			byValue: make(map[uint32]string),
+		}
+		if d.values == nil {
+			d.values = make(map[string]*valueNames)
+		}
+		d.values[attrName] = names
+	}
+	if previous, ok := names.byName[valueName]; ok && names.byValue[previous] == valueName {
+		delete(names.byValue, previous)
+	}
+	names.byName[valueName] = value
+	if _, ok := names.byValue[value]; !ok {
+		names.byValue[value] = valueName
+	}
+}
+
+// ValueName returns the registered name of the given value of the attribute
+// attrName. ok is false if the value has no name.
+func (d *Dictionary) ValueName(attrName string, value uint32) (name string, ok bool) {
+	if d == nil {
+		d = &emptyDictionary
+	}
 	d.mu.RLock()
-	entry := d.attributesByName[name]
+	defer d.mu.RUnlock()
+	if names := d.values[d.canonicalLocked(attrName)]; names != nil {
+		name, ok = names.byValue[value]
+	}
+	return
+}
+
+// namedValue returns the value that is registered under valueName for the
+// attribute attrName. ok is false if attrName has no named values; err is
+// non-nil if it has, but valueName is not one of them.
+func (d *Dictionary) namedValue(attrName string, valueName string) (value uint32, ok bool, err error) {
+	if d == nil {
+		d = &emptyDictionary
+	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	names := d.values[d.canonicalLocked(attrName)]
+	if names == nil {
+		return 0, false, nil
+	}
+	value, ok = names.byName[valueName]
+	if !ok {
+		return 0, true, fmt.Errorf("radius: %s has no value named %q", attrName, valueName)
+	}
+	return value, true, nil
+}
+
+// entry returns the entry registered under the given name, which may be a
+// vendor-specific attribute. nil is returned if the name is not registered.
+func (d *Dictionary) entry(name string) *DictionaryEntry {
+	if d == nil {
+		d = &emptyDictionary
+	}
+	d.mu.RLock()
+	entry := d.attributesByName[d.canonicalLocked(name)]
 	d.mu.RUnlock()
+	return entry
+}
+
+// SetLength sets the range of lengths of the value of the registered attribute
+// name, which may be a vendor-specific or extended attribute. Parse returns an
+// error if a packet contains the attribute with a value that is shorter than
+// min bytes, or longer than max bytes. Zero means that the length is not
+// constrained; for example, SetLength("NAS-IP-Address", 4, 4) requires a 4
+// byte value, and SetLength("CHAP-Challenge", 5, 0) one of at least 5 bytes.
+func (d *Dictionary) SetLength(name string, min, max int) error {
+	if min < 0 || max < 0 || (max > 0 && max < min) {
+		return errors.New("radius: invalid attribute length range")
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	name = d.canonicalLocked(name)
+	entry := d.attributesByName[name]
+	if entry == nil {
+		return fmt.Errorf("%w: %s", ErrAttributeNotRegistered, name)
+	}
+	// Entries are replaced rather than modified, as they are read without
+	// holding d.mu.
+	updated := *entry
+	updated.MinLength = min
+	updated.MaxLength = max
+	d.storeLocked(&updated)
+	d.attributesByName[name] = &updated
+	return nil
+}
+
+// SetConcat sets whether values of the registered attribute name that are
+// longer than 253 bytes are split over consecutive attributes when a packet is
+// encoded, and whether consecutive attributes of its type are concatenated
+// into a single value (which is then decoded as one) when a packet is parsed.
+//
+// Only some attributes are concatenated: Reply-Message, for example, can be,
+// but Proxy-State and Class, which also repeat, carry separate values. Only
+// standard attributes that are not tagged can be concatenated; vendor
+// attributes and extended attributes have their own ways of continuing values
+// (see VendorFormatWiMAX and RFC 6929 long extended attributes).
+func (d *Dictionary) SetConcat(name string, concat bool) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	name = d.canonicalLocked(name)
+	entry := d.attributesByName[name]
 	if entry == nil {
+		return fmt.Errorf("%w: %s", ErrAttributeNotRegistered, name)
+	}
+	if entry.Vendor != 0 || entry.ExtendedType != 0 || entry.Tagged {
+		return fmt.Errorf("radius: %s attribute cannot be concatenated", name)
+	}
+	updated := *entry
+	updated.Concat = concat
+	d.storeLocked(&updated)
+	d.attributesByName[name] = &updated
+	return nil
+}
+
+// SetSensitive sets whether the value of the registered attribute name, which
+// may be a vendor-specific or extended attribute, is redacted when a packet is
+// written for logging by Packet.Dump and Packet.Summary. In Builtin,
+// User-Password, CHAP-Password, and Tunnel-Password are sensitive, as are the
+// MPPE keys of MicrosoftDictionary.
+func (d *Dictionary) SetSensitive(name string, sensitive bool) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	name = d.canonicalLocked(name)
+	entry := d.attributesByName[name]
+	if entry == nil {
+		return fmt.Errorf("%w: %s", ErrAttributeNotRegistered, name)
+	}
+	updated := *entry
+	updated.Sensitive = sensitive
+	d.storeLocked(&updated)
+	d.attributesByName[name] = &updated
+	return nil
+}
+
+// checkLength returns an error if length is outside of the entry's range of
+// value lengths.
+func (e *DictionaryEntry) checkLength(length int) error {
+	if length < e.MinLength || (e.MaxLength > 0 && length > e.MaxLength) {
+		if e.MaxLength == 0 {
+			return fmt.Errorf("radius: %s attribute is too short (%d bytes; the minimum is %d)", e.Name, length, e.MinLength)
+		}
+		return fmt.Errorf("radius: %s attribute has invalid length (%d bytes; expecting %d to %d)", e.Name, length, e.MinLength, e.MaxLength)
+	}
+	return nil
+}
+
+// allowsEmpty reports whether the codec of e declares that the attribute may
+// have an empty value (see AttributeEmptyChecker). declared is false if e is
+// nil or its codec does not declare it.
+func (e *DictionaryEntry) allowsEmpty() (allowed, declared bool) {
+	if e == nil {
+		return false, false
+	}
+	checker, ok := e.Codec.(AttributeEmptyChecker)
+	if !ok {
+		return false, false
+	}
+	return checker.AllowsEmpty(), true
+}
+
+// decode decodes a value of the attribute that is described by e, after
+// checking its length. e may be nil, in which case the value is decoded with
+// AttributeUnknown.
+func (e *DictionaryEntry) decode(packet *Packet, value []byte) (tag byte, decoded interface{}, err error) {
+	if e == nil {
+		decoded, err = AttributeUnknown.Decode(packet, value)
 		return
 	}
-	t = entry.Type
-	codec = entry.Codec
-	ok = true
+	if err = e.checkLength(len(value)); err != nil {
+		return
+	}
+	if len(value) == 0 {
+		if allowed, declared := e.allowsEmpty(); declared && !allowed {
+			err = fmt.Errorf("radius: %s attribute has no value", e.Name)
+			return
+		}
+	}
+	if e.Tagged {
+		return e.Codec.(AttributeTaggedCodec).DecodeTagged(packet, value)
+	}
+	decoded, err = e.Codec.Decode(packet, value)
 	return
 }
 
@@ -80,10 +511,25 @@ func (d *Dictionary) Remove(t byte) error {
 
 	entry := d.attributesByType[t]
 	if entry == nil {
-		return errors.New("radius: attribute is not registered")
+		return ErrAttributeNotRegistered
 	}
 	d.attributesByType[t] = nil
-	delete(d.attributesByName, entry.Name)
+	d.unnameLocked(entry.Name)
+	return nil
+}
+
+// RemoveVendor removes a vendor attribute from the dictionary by type. It
+// returns an error only if the vendor attribute type does not exist.
+func (d *Dictionary) RemoveVendor(vendorID uint32, t byte) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	vendor := d.vendors[vendorID]
+	if vendor == nil || vendor.attributesByType[t] == nil {
+		return ErrAttributeNotRegistered
+	}
+	d.unnameLocked(vendor.attributesByType[t].Name)
+	vendor.attributesByType[t] = nil
 	return nil
 }
 
@@ -93,27 +539,207 @@ func (d *Dictionary) RemoveByName(name string) error {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
-	entry, ok := d.attributesByName[name]
+	entry, ok := d.attributesByName[d.canonicalLocked(name)]
 	if !ok {
-		return errors.New("radius: attribute is not registered")
+		return fmt.Errorf("%w: %s", ErrAttributeNotRegistered, name)
 	}
-	d.attributesByType[entry.Type] = nil
-	delete(d.attributesByName, name)
+	d.unstoreLocked(entry)
+	d.unnameLocked(entry.Name)
 	return nil
 }
 
 // Entries returns a new slice with a copy of each registered attribute in the
-// dictionary.
+// dictionary, sorted by type. Vendor-specific attributes follow the standard
+// attributes, sorted by vendor ID and then by type. Extended attributes come
+// last, sorted by type and then by extended type.
 func (d *Dictionary) Entries() []DictionaryEntry {
+	if d == nil {
+		d = &emptyDictionary
+	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
 	var attrs []DictionaryEntry
 	for _, attr := range d.attributesByType {
 		if attr != nil {
 			attrs = append(attrs, *attr)
 		}
 	}
+	vendorIDs := make([]uint32, 0, len(d.vendors))
+	for id := range d.vendors {
+		vendorIDs = append(vendorIDs, id)
+	}
+	sort.Slice(vendorIDs, func(i, j int) bool {
+		return vendorIDs[i] < vendorIDs[j]
+	})
+	for _, id := range vendorIDs {
+		for _, attr := range d.vendors[id].attributesByType {
+			if attr != nil {
+				attrs = append(attrs, *attr)
+			}
+		}
+	}
+	extendedKeys := make([]uint16, 0, len(d.extended))
+	for key := range d.extended {
+		extendedKeys = append(extendedKeys, key)
+	}
+	sort.Slice(extendedKeys, func(i, j int) bool {
+		return extendedKeys[i] < extendedKeys[j]
+	})
+	for _, key := range extendedKeys {
+		attrs = append(attrs, *d.extended[key])
+	}
 	return attrs
 }
 
+// Each calls fn with a copy of each registered attribute in the dictionary, in
+// the same order as Entries. The entries are copied before fn is first called,
+// so fn may use the dictionary, including registering or removing attributes;
+// such changes are not seen by the rest of the iteration.
+func (d *Dictionary) Each(fn func(entry *DictionaryEntry)) {
+	entries := d.Entries()
+	for i := range entries {
+		fn(&entries[i])
+	}
+}
+
+// Clone returns a copy of the dictionary. Registering or removing attributes
+// in the copy does not affect the original, and vice versa.
+func (d *Dictionary) Clone() *Dictionary {
+	clone := &Dictionary{
+		unknownPolicy: d.UnknownPolicy(),
+	}
+	clone.Merge(d, false)
+	return clone
+}
+
+// Merge registers the attributes and vendors of other in d. If an attribute
+// type (or vendor attribute type) is already registered in d, the attribute
+// from other replaces it if overwrite is true, and is skipped otherwise. The
+// same applies to the format and name of a vendor that is present in both,
+// and to the value names of enumerated attributes.
+func (d *Dictionary) Merge(other *Dictionary, overwrite bool) error {
+	if other == nil {
+		return errors.New("radius: nil Dictionary")
+	}
+	if other == d {
+		return nil
+	}
+
+	// other is copied first, so that the dictionaries are never locked at the
+	// same time.
+	other.mu.RLock()
+	var entries []DictionaryEntry
+	for _, entry := range other.attributesByType {
+		if entry != nil {
+			entries = append(entries, *entry)
+		}
+	}
+	type namedValue struct {
+		attrName, valueName string
+		value               uint32
+		// if the name is the one that is used for the value
+		primary bool
+	}
+	var values []namedValue
+	for attrName, names := range other.values {
+		for valueName, value := range names.byName {
+			values = append(values, namedValue{
+				attrName:  attrName,
+				valueName: valueName,
+				value:     value,
+				primary:   names.byValue[value] == valueName,
+			})
+		}
+	}
+	// The first name that is registered for a value is the one that is used.
+	sort.Slice(values, func(i, j int) bool {
+		return values[i].primary && !values[j].primary
+	})
+	formats := make(map[uint32]VendorFormat, len(other.vendors))
+	vendorNames := make(map[uint32]string, len(other.vendors))
+	for id, vendor := range other.vendors {
+		formats[id] = vendor.Format
+		if vendor.Name != "" {
+			vendorNames[id] = vendor.Name
+		}
+		for _, entry := range vendor.attributesByType {
+			if entry != nil {
+				entries = append(entries, *entry)
+			}
+		}
+	}
+	for _, entry := range other.extended {
+		entries = append(entries, *entry)
+	}
+	aliases := make(map[string]string, len(other.aliases))
+	for alias, name := range other.aliases {
+		aliases[alias] = name
+	}
+	other.mu.RUnlock()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for id, format := range formats {
+		if _, exists := d.vendors[id]; !exists || overwrite {
+			d.vendorLocked(id).Format = format
+		}
+	}
+	for id, name := range vendorNames {
+		if named, ok := d.vendorIDLocked(name); ok && named != id {
+			// the name is used by another vendor of d
+			continue
+		}
+		if vendor := d.vendorLocked(id); vendor.Name == "" || overwrite {
+			vendor.Name = name
+		}
+	}
+	if d.attributesByName == nil {
+		d.attributesByName = make(map[string]*DictionaryEntry)
+	}
+	for i := range entries {
+		entry := &entries[i]
+		if entry.Vendor != 0 {
+			d.vendorLocked(entry.Vendor)
+		}
+		existing := d.storedLocked(entry)
+		if existing != nil {
+			if !overwrite {
+				continue
+			}
+			d.unnameLocked(existing.Name)
+		}
+		if named := d.attributesByName[entry.Name]; named != nil {
+			if !overwrite {
+				continue
+			}
+			// The name is used by an attribute of another type.
+			d.unstoreLocked(named)
+		}
+		d.storeLocked(entry)
+		d.attributesByName[entry.Name] = entry
+	}
+	for _, v := range values {
+		exists := false
+		if names := d.values[v.attrName]; names != nil {
+			_, exists = names.byName[v.valueName]
+		}
+		if !exists || overwrite {
+			d.registerValueLocked(v.attrName, v.valueName, v.value)
+		}
+	}
+	for alias, name := range aliases {
+		if d.attributesByName[name] == nil || d.attributesByName[alias] != nil {
+			continue
+		}
+		if _, exists := d.aliases[alias]; !exists || overwrite {
+			d.aliasLocked(alias, name)
+		}
+	}
+	return nil
+}
+
 // Attr returns a new *Attribute whose type is registered under the given
 // name.
 //
@@ -122,20 +748,101 @@ func (d *Dictionary) Entries() []DictionaryEntry {
 // If the attribute's codec implements AttributeTransformer, the value is
 // first transformed before being stored in *Attribute. If the transform
 // function returns an error, nil and the error is returned.
+//
+// If name is a tagged attribute, value can be a TaggedValue, whose Tag is
+// stored in *Attribute.
+//
+// If name is an enumerated attribute (see RegisterValue), value can be the
+// name of one of its values.
+//
+// An error is returned if value is a string or []byte that is too long to be
+// carried by a single attribute, unless it belongs to a vendor that uses
+// VendorFormatWiMAX, or is a long extended attribute.
+//
+// If name is a vendor-specific attribute, a Vendor-Specific attribute whose
+// value is a *VendorAttribute is returned. If name is an extended attribute,
+// an attribute of the type that carries it, whose value is an
+// *ExtendedAttribute, is returned.
 func (d *Dictionary) Attr(name string, value interface{}) (*Attribute, error) {
-	t, codec, ok := d.get(name)
-	if !ok {
-		return nil, errors.New("radius: attribute name not registered")
+	entry := d.entry(name)
+	if entry == nil {
+		return nil, fmt.Errorf("%w: %s", ErrAttributeNotRegistered, name)
+	}
+	var tag byte
+	if tagged, ok := value.(TaggedValue); ok {
+		if !entry.Tagged {
+			return nil, errors.New("radius: attribute is not tagged")
+		}
+		tag = tagged.Tag
+		value = tagged.Value
+	}
+	maxLength := maxAttributeValueLength
+	if entry.Vendor != 0 {
+		// vendor ID, type, and length
+		maxLength -= 6
+	}
+	if entry.ExtendedType != 0 {
+		// extended type
+		maxLength--
+	}
+	// WiMAX vendor attributes and long extended attributes are continued over
+	// as many attributes as needed
+	format, _ := d.vendorFormat(entry.Vendor)
+	continued := entry.Vendor != 0 && format == VendorFormatWiMAX
+	if entry.ExtendedType != 0 && isLongExtendedType(entry.Type) {
+		continued = true
+	}
+	if entry.Concat {
+		continued = true
+	}
+	var length int
+	switch v := value.(type) {
+	case string:
+		length = len(v)
+	case []byte:
+		length = len(v)
+	}
+	if length > maxLength && !continued {
+		return nil, fmt.Errorf("radius: %s attribute value is too long (%d bytes; the maximum is %d)", name, length, maxLength)
+	}
+	if valueName, ok := value.(string); ok {
+		named, enumerated, err := d.namedValue(name, valueName)
+		if err != nil {
+			return nil, err
+		}
+		if enumerated {
+			value = named
+		}
 	}
-	if transformer, ok := codec.(AttributeTransformer); ok {
+	if transformer, ok := entry.Codec.(AttributeTransformer); ok {
 		transformed, err := transformer.Transform(value)
 		if err != nil {
 			return nil, err
 		}
 		value = transformed
 	}
+	if entry.Vendor != 0 {
+		return &Attribute{
+			Type: attributeTypeVendorSpecific,
+			Value: &VendorAttribute{
+				VendorID: entry.Vendor,
+				Type:     entry.Type,
+				Value:    value,
+			},
+		}, nil
+	}
+	if entry.ExtendedType != 0 {
+		return &Attribute{
+			Type: entry.Type,
+			Value: &ExtendedAttribute{
+				ExtendedType: entry.ExtendedType,
+				Value:        value,
+			},
+		}, nil
+	}
 	return &Attribute{
-		Type:  t,
+		Type:  entry.Type,
+		Tag:   tag,
 		Value: value,
 	}, nil
 }
@@ -149,9 +856,76 @@ func (d *Dictionary) MustAttr(name string, value interface{}) *Attribute {
 	return attr
 }
 
+// Attrs creates an attribute for each of the given values, by attribute name,
+// as with Attr. Every value is attempted: if any of them fail, the attributes
+// of the others are returned along with an *AttrsError that lists every
+// failure. The attributes are sorted by name.
+func (d *Dictionary) Attrs(values map[string]interface{}) ([]*Attribute, error) {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	attrs := make([]*Attribute, 0, len(names))
+	var failed *AttrsError
+	for _, name := range names {
+		attr, err := d.Attr(name, values[name])
+		if err != nil {
+			if failed == nil {
+				failed = &AttrsError{}
+			}
+			failed.Names = append(failed.Names, name)
+			failed.Errors = append(failed.Errors, err)
+			continue
+		}
+		attrs = append(attrs, attr)
+	}
+	if failed != nil {
+		return attrs, failed
+	}
+	return attrs, nil
+}
+
+// AttrsError is returned by Dictionary.Attrs when some of the values could not
+// be converted to attributes.
+type AttrsError struct {
+	// Names of the attributes that failed, sorted, and the error of each.
+	Names  []string
+	Errors []error
+}
+
+func (e *AttrsError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "radius: %d invalid attribute(s)", len(e.Errors))
+	for i, err := range e.Errors {
+		fmt.Fprintf(&b, "; %s: %s", e.Names[i], strings.TrimPrefix(err.Error(), "radius: "))
+	}
+	return b.String()
+}
+
+// Unwrap returns the error of each attribute that failed, so that errors.Is
+// and errors.As can be used to test for them, such as for
+// ErrAttributeNotRegistered.
+func (e *AttrsError) Unwrap() []error {
+	return e.Errors
+}
+
+// typeName returns the registered name for the given attribute type, or a
+// description of the type if it is not registered.
+func (d *Dictionary) typeName(t byte) string {
+	if name, ok := d.Name(t); ok {
+		return name
+	}
+	return fmt.Sprintf("type %d", t)
+}
+
 // Name returns the registered name for the given attribute type. ok is false
 // if the given type is not registered.
 func (d *Dictionary) Name(t byte) (name string, ok bool) {
+	if d == nil {
+		d = &emptyDictionary
+	}
 	d.mu.RLock()
 	entry := d.attributesByType[t]
 	d.mu.RUnlock()
@@ -164,12 +938,16 @@ func (d *Dictionary) Name(t byte) (name string, ok bool) {
 }
 
 // Type returns the registered type for the given attribute name. ok is false
-// if the given name is not registered.
+// if the given name is not registered, or is a vendor-specific or extended
+// attribute.
 func (d *Dictionary) Type(name string) (t byte, ok bool) {
+	if d == nil {
+		d = &emptyDictionary
+	}
 	d.mu.RLock()
-	entry := d.attributesByName[name]
+	entry := d.attributesByName[d.canonicalLocked(name)]
 	d.mu.RUnlock()
-	if entry == nil {
+	if entry == nil || entry.Vendor != 0 || entry.ExtendedType != 0 {
 		return
 	}
 	t = entry.Type
@@ -177,9 +955,62 @@ func (d *Dictionary) Type(name string) (t byte, ok bool) {
 	return
 }
 
+// Lookup returns a copy of the entry registered under the given name, which
+// may be a vendor-specific or extended attribute, so that its type, codec,
+// and other properties can be read at once. ok is false if the name is not
+// registered. Modifying the returned entry does not affect the dictionary.
+func (d *Dictionary) Lookup(name string) (entry *DictionaryEntry, ok bool) {
+	return copyEntry(d.entry(name))
+}
+
+// LookupType is like Lookup, but returns the entry registered for the given
+// standard attribute type.
+func (d *Dictionary) LookupType(t byte) (entry *DictionaryEntry, ok bool) {
+	return copyEntry(d.typeEntry(t))
+}
+
+// copyEntry returns a copy of entry, if it is non-nil.
+func copyEntry(entry *DictionaryEntry) (*DictionaryEntry, bool) {
+	if entry == nil {
+		return nil, false
+	}
+	copied := *entry
+	return &copied, true
+}
+
+// typeEntry returns the entry registered for the given standard attribute
+// type, or nil if there is none.
+func (d *Dictionary) typeEntry(t byte) *DictionaryEntry {
+	if d == nil {
+		d = &emptyDictionary
+	}
+	d.mu.RLock()
+	entry := d.attributesByType[t]
+	d.mu.RUnlock()
+	return entry
+}
+
+// taggedCodec returns the AttributeTaggedCodec for the given registered type.
+// nil is returned if the given type is not registered as a tagged attribute.
+func (d *Dictionary) taggedCodec(t byte) AttributeTaggedCodec {
+	if d == nil {
+		d = &emptyDictionary
+	}
+	d.mu.RLock()
+	entry := d.attributesByType[t]
+	d.mu.RUnlock()
+	if entry == nil || !entry.Tagged {
+		return nil
+	}
+	return entry.Codec.(AttributeTaggedCodec)
+}
+
 // Codec returns the AttributeCodec for the given registered type. nil is
 // returned if the given type is not registered.
 func (d *Dictionary) Codec(t byte) AttributeCodec {
+	if d == nil {
+		d = &emptyDictionary
+	}
 	d.mu.RLock()
 	entry := d.attributesByType[t]
 	d.mu.RUnlock()
@@ -188,3 +1019,106 @@ func (d *Dictionary) Codec(t byte) AttributeCodec {
 	}
 	return entry.Codec
 }
+
+// UnknownPolicy specifies what Parse does with attributes that are not
+// registered in the dictionary.
+type UnknownPolicy int
+
+// Policies for unknown attributes.
+const (
+	// UnknownKeep decodes unknown attributes with AttributeUnknown, so that
+	// their values are kept as []byte. This is the default.
+	UnknownKeep UnknownPolicy = iota
+	// UnknownDrop removes unknown attributes from parsed packets.
+	UnknownDrop
+	// UnknownError makes Parse fail with an error that wraps
+	// ErrUnknownAttribute and names the unknown attribute.
+	UnknownError
+)
+
+// ErrUnknownAttribute is returned by Parse when a packet contains an attribute
+// that is not registered in a dictionary whose policy is UnknownError. It is
+// wrapped with the type of the attribute, so it should be tested using
+// errors.Is.
+var ErrUnknownAttribute = errors.New("radius: unknown attribute")
+
+// SetUnknownPolicy sets what Parse does with attributes that are not
+// registered in the dictionary. These are attributes whose type is not
+// registered, extended attributes whose extended type is not registered, and
+// vendor attributes of vendors, or with vendor types, that are not registered.
+//
+// The policy applies to every packet that uses the dictionary, so it should
+// not be changed on Builtin by a program in which other packages parse
+// packets; set it on a clone of Builtin instead.
+func (d *Dictionary) SetUnknownPolicy(policy UnknownPolicy) {
+	d.mu.Lock()
+	d.unknownPolicy = policy
+	d.mu.Unlock()
+}
+
+// UnknownPolicy returns the policy that was set using SetUnknownPolicy.
+func (d *Dictionary) UnknownPolicy() UnknownPolicy {
+	if d == nil {
+		d = &emptyDictionary
+	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.unknownPolicy
+}
+
+// applyUnknownPolicy applies policy to a decoded attribute of type t. It
+// returns the value to keep, which has the unknown vendor attributes removed
+// if the policy is UnknownDrop; keep is false if the whole attribute is
+// dropped.
+func (d *Dictionary) applyUnknownPolicy(policy UnknownPolicy, t byte, value interface{}) (kept interface{}, keep bool, err error) {
+	unknown := func(format string, args ...interface{}) (interface{}, bool, error) {
+		if policy == UnknownError {
+			return nil, false, fmt.Errorf("%w: "+format, append([]interface{}{ErrUnknownAttribute}, args...)...)
+		}
+		return nil, false, nil
+	}
+
+	if d.typeEntry(t) == nil {
+		return unknown("type %d", t)
+	}
+	switch v := value.(type) {
+	case *ExtendedAttribute:
+		if isExtendedType(t) && d.extendedEntry(t, v.ExtendedType) == nil {
+			return unknown("type %d.%d", t, v.ExtendedType)
+		}
+	case *VendorAttribute:
+		if t == attributeTypeVendorSpecific && d.vendorEntry(v.VendorID, v.Type) == nil {
+			return unknown("vendor %d type %d", v.VendorID, v.Type)
+		}
+	case []*VendorAttribute:
+		if t != attributeTypeVendorSpecific {
+			break
+		}
+		var known []*VendorAttribute
+		for _, attr := range v {
+			if d.vendorEntry(attr.VendorID, attr.Type) == nil {
+				if policy == UnknownError {
+					return unknown("vendor %d type %d", attr.VendorID, attr.Type)
+				}
+				continue
+			}
+			known = append(known, attr)
+		}
+		switch len(known) {
+		case len(v):
+		case 0:
+			return nil, false, nil
+		case 1:
+			return known[0], true, nil
+		default:
+			return known, true, nil
+		}
+	case []byte:
+		// the value of a Vendor-Specific attribute whose vendor is not
+		// registered
+		if t == attributeTypeVendorSpecific && len(v) >= 4 {
+			return unknown("vendor %d", binary.BigEndian.Uint32(v))
+		}
+	}
+	return value, true, nil
+}
@@ -0,0 +1,391 @@
+package radius
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// maximum depth of nested $INCLUDE directives
+const maxIncludeDepth = 16
+
+// LoadFile parses the FreeRADIUS-format dictionary file at the given path and
+// registers each of its attributes in the dictionary. $INCLUDE directives are
+// resolved relative to the directory of the file that contains them.
+func (d *Dictionary) LoadFile(path string) error {
+	return d.loadFile(path, 0)
+}
+
+// Load parses FreeRADIUS-format dictionary data from r and registers each of
+// its attributes in the dictionary. $INCLUDE directives are resolved relative
+// to the current working directory.
+//
+// The following keywords are understood:
+//
+//	ATTRIBUTE <name> <number> <type> [flags]
+//	ATTRIBUTE <name> <number> <type> <vendor name>
+//	VALUE <attribute name> <value name> <number>
+//	VENDOR <name> <number> [format=<type size>,<length size>[,c]]
+//	BEGIN-VENDOR <name>
+//	END-VENDOR <name>
+//	$INCLUDE <path>
+//
+// Extended attributes (RFC 6929) are numbered as <type>.<extended type>, such
+// as 241.1, and are registered using RegisterExtended. Attributes with nested
+// numbers (such as vendor attributes in the extended space) are skipped.
+//
+// VENDOR entries register the name (see RegisterVendorName) and the format of
+// a vendor: format=1,1 is VendorFormatStandard, format=1,0 is
+// VendorFormatContinuous, and format=1,1,c is VendorFormatWiMAX. Vendors with
+// other formats are ignored. Attributes between BEGIN-VENDOR and END-VENDOR,
+// and those followed by a vendor name, are registered using RegisterVendor;
+// those of vendors that have not been declared with VENDOR, and tagged ones,
+// are skipped.
+//
+// Everything following a # character is a comment. The attribute types
+// string, integer, ipaddr, octets, and date are mapped to AttributeText,
+// AttributeInteger, AttributeAddress, AttributeString, and AttributeTime,
+// respectively. Any other type is registered with AttributeUnknown. Attributes
+// with the has_tag flag are registered using RegisterTagged. Attributes with
+// the encrypt=2 flag are encrypted in the same way as Tunnel-Password, and
+// those with the concat flag are concatenated (see SetConcat). Attributes
+// with an encrypt flag, or the secret flag, are sensitive (see SetSensitive).
+// VALUE entries are registered using RegisterValue; those of attributes that
+// are not registered are ignored.
+//
+// An error that includes the offending line number is returned if an entry is
+// malformed. Attributes registered before the error are not removed.
+func (d *Dictionary) Load(r io.Reader) error {
+	return d.load(r, "", ".", 0)
+}
+
+func (d *Dictionary) loadFile(path string, depth int) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return d.load(f, path, filepath.Dir(path), depth)
+}
+
+func (d *Dictionary) load(r io.Reader, name, dir string, depth int) error {
+	if depth > maxIncludeDepth {
+		return fmt.Errorf("radius: %s: too many nested $INCLUDE directives", name)
+	}
+
+	parser := dictionaryParser{
+		dictionary: d,
+		name:       name,
+		dir:        dir,
+		depth:      depth,
+	}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		parser.line++
+		if err := parser.parseLine(scanner.Text()); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+type dictionaryParser struct {
+	dictionary *Dictionary
+	// name of the file being parsed; empty when parsing from an io.Reader
+	name string
+	// directory that $INCLUDE paths are relative to
+	dir   string
+	depth int
+	// current line number
+	line int
+	// non-empty while inside of a BEGIN-VENDOR block
+	vendor string
+	// ID of the vendor of the BEGIN-VENDOR block; zero if its attributes
+	// are skipped
+	vendorID uint32
+}
+
+func (p *dictionaryParser) errorf(format string, args ...interface{}) error {
+	location := "line " + strconv.Itoa(p.line)
+	if p.name != "" {
+		location = p.name + ":" + strconv.Itoa(p.line)
+	}
+	return fmt.Errorf("radius: dictionary %s: %s", location, fmt.Sprintf(format, args...))
+}
+
+func (p *dictionaryParser) parseLine(line string) error {
+	if i := strings.IndexByte(line, '#'); i > -1 {
+		line = line[:i]
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil
+	}
+
+	switch fields[0] {
+	case "ATTRIBUTE":
+		return p.parseAttribute(fields[1:])
+	case "VALUE":
+		return p.parseValue(fields[1:])
+	case "$INCLUDE":
+		if len(fields) != 2 {
+			return p.errorf("$INCLUDE expects a single path")
+		}
+		path := fields[1]
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(p.dir, path)
+		}
+		return p.dictionary.loadFile(path, p.depth+1)
+	case "VENDOR":
+		return p.parseVendor(fields[1:])
+	case "BEGIN-VENDOR":
+		if len(fields) < 2 {
+			return p.errorf("BEGIN-VENDOR expects a vendor name")
+		}
+		p.vendor = fields[1]
+		p.vendorID = 0
+		if len(fields) == 2 {
+			// Blocks of vendors that are not declared (or whose format
+			// is not supported), and of extended vendor attributes, are
+			// skipped.
+			p.vendorID, _ = p.dictionary.VendorID(fields[1])
+		}
+	case "END-VENDOR":
+		p.vendor = ""
+		p.vendorID = 0
+	}
+	// Other keywords are not used by Dictionary.
+	return nil
+}
+
+// parseVendor registers the name and format of a vendor.
+func (p *dictionaryParser) parseVendor(fields []string) error {
+	if len(fields) < 2 || len(fields) > 3 {
+		return p.errorf("VENDOR expects a name, number, and optional format")
+	}
+	id, err := strconv.ParseUint(fields[1], 0, 32)
+	if err != nil || id == 0 {
+		return p.errorf("invalid vendor number %q", fields[1])
+	}
+	format := VendorFormatStandard
+	if len(fields) == 3 {
+		switch fields[2] {
+		case "format=1,1":
+		case "format=1,0":
+			format = VendorFormatContinuous
+		case "format=1,1,c":
+			format = VendorFormatWiMAX
+		default:
+			// Other formats are not supported; the vendor's attributes
+			// are skipped.
+			return nil
+		}
+	}
+	if err := p.dictionary.RegisterVendorName(uint32(id), fields[0]); err != nil {
+		return p.errorf("%s", strings.TrimPrefix(err.Error(), "radius: "))
+	}
+	p.dictionary.SetVendorFormat(uint32(id), format)
+	return nil
+}
+
+func (p *dictionaryParser) parseAttribute(fields []string) error {
+	if len(fields) < 3 {
+		return p.errorf("ATTRIBUTE expects a name, number, and type")
+	}
+	if len(fields) > 4 {
+		return p.errorf("too many ATTRIBUTE fields")
+	}
+	if p.vendor != "" {
+		return p.parseVendorAttribute(p.vendorID, fields)
+	}
+	if len(fields) == 4 && !isDictionaryFlags(fields[3]) {
+		// the vendor name of older dictionary files
+		vendorID, _ := p.dictionary.VendorID(fields[3])
+		return p.parseVendorAttribute(vendorID, fields[:3])
+	}
+	name := fields[0]
+	if i := strings.IndexByte(fields[1], '.'); i > -1 {
+		return p.parseExtendedAttribute(name, fields[1][:i], fields[1][i+1:], fields[2])
+	}
+	t, err := strconv.ParseUint(fields[1], 0, 8)
+	if err != nil || t == 0 {
+		return p.errorf("invalid attribute number %q", fields[1])
+	}
+	var flags []string
+	if len(fields) == 4 {
+		flags = strings.Split(fields[3], ",")
+	}
+
+	if hasFlag(flags, "encrypt=2") {
+		// encrypted in the same way as Tunnel-Password
+		if hasFlag(flags, "has_tag") {
+			err = p.dictionary.RegisterTagged(name, byte(t), rfc2868TunnelPassword{})
+		} else {
+			err = p.dictionary.Register(name, byte(t), AttributeSaltEncrypted)
+		}
+	} else if hasFlag(flags, "has_tag") {
+		if codec := dictionaryTaggedCodec(fields[2]); codec != nil {
+			err = p.dictionary.RegisterTagged(name, byte(t), codec)
+		} else {
+			err = p.dictionary.Register(name, byte(t), dictionaryCodec(fields[2]))
+		}
+	} else if (fields[2] == "extended" || fields[2] == "long-extended") && isExtendedType(byte(t)) {
+		err = p.dictionary.Register(name, byte(t), attributeExtended{byte(t)})
+	} else {
+		err = p.dictionary.Register(name, byte(t), dictionaryCodec(fields[2]))
+	}
+	if err == nil && hasFlag(flags, "concat") {
+		err = p.dictionary.SetConcat(name, true)
+	}
+	if err == nil && isSensitiveFlags(flags) {
+		err = p.dictionary.SetSensitive(name, true)
+	}
+	if err != nil {
+		return p.errorf("%s: %s", name, strings.TrimPrefix(err.Error(), "radius: "))
+	}
+	return nil
+}
+
+// parseVendorAttribute registers an attribute of the vendor with the given
+// ID. Attributes are skipped if vendorID is zero, or if they are tagged or
+// have nested numbers, which are not supported for vendor attributes.
+func (p *dictionaryParser) parseVendorAttribute(vendorID uint32, fields []string) error {
+	var flags []string
+	if len(fields) == 4 {
+		flags = strings.Split(fields[3], ",")
+	}
+	if vendorID == 0 || strings.Contains(fields[1], ".") || hasFlag(flags, "has_tag") {
+		return nil
+	}
+	name := fields[0]
+	t, err := strconv.ParseUint(fields[1], 0, 8)
+	if err != nil {
+		return p.errorf("invalid attribute number %q", fields[1])
+	}
+	codec := dictionaryCodec(fields[2])
+	if hasFlag(flags, "encrypt=2") {
+		codec = AttributeSaltEncrypted
+	}
+	err = p.dictionary.RegisterVendor(vendorID, name, byte(t), codec)
+	if err == nil && isSensitiveFlags(flags) {
+		err = p.dictionary.SetSensitive(name, true)
+	}
+	if err != nil {
+		return p.errorf("%s: %s", name, strings.TrimPrefix(err.Error(), "radius: "))
+	}
+	return nil
+}
+
+// parseExtendedAttribute registers an RFC 6929 extended attribute, whose
+// number is written as "<type>.<extended type>" (such as 241.1).
+func (p *dictionaryParser) parseExtendedAttribute(name, number, extendedNumber, typ string) error {
+	if strings.Contains(extendedNumber, ".") {
+		// Nested attributes are not supported.
+		return nil
+	}
+	t, err := strconv.ParseUint(number, 0, 8)
+	if err != nil {
+		return p.errorf("invalid attribute number %q", number+"."+extendedNumber)
+	}
+	extendedType, err := strconv.ParseUint(extendedNumber, 0, 8)
+	if err != nil {
+		return p.errorf("invalid attribute number %q", number+"."+extendedNumber)
+	}
+	if err := p.dictionary.RegisterExtended(name, byte(t), byte(extendedType), dictionaryCodec(typ)); err != nil {
+		return p.errorf("%s: %s", name, strings.TrimPrefix(err.Error(), "radius: "))
+	}
+	return nil
+}
+
+func (p *dictionaryParser) parseValue(fields []string) error {
+	if len(fields) != 3 {
+		return p.errorf("VALUE expects an attribute name, value name, and number")
+	}
+	value, err := strconv.ParseUint(fields[2], 0, 32)
+	if err != nil {
+		return p.errorf("invalid value number %q", fields[2])
+	}
+	err = p.dictionary.RegisterValue(fields[0], fields[1], uint32(value))
+	if errors.Is(err, ErrAttributeNotRegistered) {
+		// The attribute was skipped, or is defined elsewhere.
+		return nil
+	}
+	return err
+}
+
+func hasFlag(flags []string, flag string) bool {
+	for _, f := range flags {
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// isSensitiveFlags returns if the given ATTRIBUTE flags mark an attribute
+// as sensitive: if it is encrypted, or has the secret flag.
+func isSensitiveFlags(flags []string) bool {
+	return hasFlag(flags, "encrypt=1") || hasFlag(flags, "encrypt=2") || hasFlag(flags, "encrypt=3") || hasFlag(flags, "secret")
+}
+
+// isDictionaryFlags returns if the given ATTRIBUTE field is a list of flags,
+// rather than the vendor name used by older dictionary files.
+func isDictionaryFlags(field string) bool {
+	for _, flag := range strings.Split(field, ",") {
+		switch {
+		case strings.Contains(flag, "="):
+		case flag == "has_tag", flag == "array", flag == "concat", flag == "virtual":
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// dictionaryTaggedCodec returns the AttributeTaggedCodec for the given
+// dictionary file attribute type. nil is returned if the type cannot be
+// tagged.
+func dictionaryTaggedCodec(typ string) AttributeTaggedCodec {
+	switch typ {
+	case "string":
+		return AttributeTaggedText
+	case "integer":
+		return AttributeTaggedInteger
+	case "octets":
+		return AttributeTaggedString
+	}
+	return nil
+}
+
+// dictionaryCodec returns the AttributeCodec for the given dictionary file
+// attribute type.
+func dictionaryCodec(typ string) AttributeCodec {
+	switch typ {
+	case "string":
+		return AttributeText
+	case "integer":
+		return AttributeInteger
+	case "integer64":
+		return AttributeInteger64
+	case "ipaddr":
+		return AttributeAddress
+	case "octets":
+		return AttributeString
+	case "date":
+		return AttributeTime
+	case "ipv6addr":
+		return AttributeIPv6Address
+	case "ipv6prefix":
+		return AttributeIPv6Prefix
+	case "ifid":
+		return AttributeIfid
+	case "vsa":
+		return AttributeVendorSpecific
+	}
+	return AttributeUnknown
+}
@@ -0,0 +1,609 @@
+package radius_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/PromonLogicalis/radius"
+)
+
+func TestDictionaryLoadFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "radius")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	main := `
+# Example dictionary
+ATTRIBUTE	User-Name	1	string
+ATTRIBUTE	NAS-IP-Address	4	ipaddr	# trailing comment
+$INCLUDE	dictionary.extra
+`
+	extra := `
+ATTRIBUTE	Class		25	octets
+ATTRIBUTE	Event-Timestamp	55	date
+ATTRIBUTE	Framed-MTU	12	integer
+ATTRIBUTE	Some-Thing	200	ifid
+`
+	if err := ioutil.WriteFile(filepath.Join(dir, "dictionary"), []byte(main), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "dictionary.extra"), []byte(extra), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var d radius.Dictionary
+	if err := d.LoadFile(filepath.Join(dir, "dictionary")); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]struct {
+		Type  byte
+		Codec radius.AttributeCodec
+	}{
+		"User-Name":       {1, radius.AttributeText},
+		"NAS-IP-Address":  {4, radius.AttributeAddress},
+		"Class":           {25, radius.AttributeString},
+		"Event-Timestamp": {55, radius.AttributeTime},
+		"Framed-MTU":      {12, radius.AttributeInteger},
+		"Some-Thing":      {200, radius.AttributeIfid},
+	}
+	for name, e := range expected {
+		typ, ok := d.Type(name)
+		if !ok {
+			t.Fatalf("expecting %s to be registered", name)
+		}
+		if typ != e.Type {
+			t.Fatalf("expecting %s type = %d; got %d", name, e.Type, typ)
+		}
+		if d.Codec(typ) != e.Codec {
+			t.Fatalf("unexpected codec for %s", name)
+		}
+	}
+}
+
+func TestDictionaryLoadMalformed(t *testing.T) {
+	data := "ATTRIBUTE User-Name 1 string\n\nATTRIBUTE Broken abc string\n"
+
+	var d radius.Dictionary
+	err := d.Load(strings.NewReader(data))
+	if err == nil {
+		t.Fatal("expecting Load to fail")
+	}
+	if !strings.Contains(err.Error(), "line 3") {
+		t.Fatalf("expecting error to contain line number; got %q", err)
+	}
+}
+
+func TestDictionaryLoadVendor(t *testing.T) {
+	data := `
+VENDOR		Cisco		9
+VENDOR		Acme		1234
+VENDOR		Lucent		4846	format=2,1
+
+BEGIN-VENDOR	Cisco
+ATTRIBUTE	Cisco-AVPair		1	string
+ATTRIBUTE	Cisco-Multilink-ID	187	integer
+VALUE		Cisco-Multilink-ID	Default	1
+END-VENDOR	Cisco
+
+BEGIN-VENDOR	Lucent
+ATTRIBUTE	Lucent-Max-Shared-Users	2	integer
+END-VENDOR	Lucent
+
+BEGIN-VENDOR	Unknown
+ATTRIBUTE	Unknown-Attr		1	string
+END-VENDOR	Unknown
+
+ATTRIBUTE	Acme-Thing		5	string	Acme
+`
+	var d radius.Dictionary
+	if err := d.Load(strings.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	if id, ok := d.VendorID("Cisco"); !ok || id != 9 {
+		t.Fatalf("expecting Cisco = 9; got %d, %t", id, ok)
+	}
+	if name, ok := d.VendorName(1234); !ok || name != "Acme" {
+		t.Fatalf("expecting 1234 = Acme; got %q, %t", name, ok)
+	}
+	if _, ok := d.VendorID("Lucent"); ok {
+		t.Fatal("expecting vendor with unsupported format to be ignored")
+	}
+	for _, name := range []string{"Lucent-Max-Shared-Users", "Unknown-Attr"} {
+		if _, err := d.Attr(name, "x"); err == nil {
+			t.Fatalf("expecting %s to be skipped", name)
+		}
+	}
+	if name, ok := d.ValueName("Cisco-Multilink-ID", 1); !ok || name != "Default" {
+		t.Fatalf("expecting value name Default; got %q, %t", name, ok)
+	}
+
+	p := radius.New(radius.CodeAccessRequest, []byte("secret"))
+	p.Dictionary = &d
+	if err := p.Add("Cisco-AVPair", "shell:priv-lvl=15"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Add("Acme-Thing", "thing"); err != nil {
+		t.Fatal(err)
+	}
+	wire, err := p.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, err := radius.Parse(wire, p.Secret, &d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value := q.String("Cisco-AVPair"); value != "shell:priv-lvl=15" {
+		t.Fatalf("unexpected Cisco-AVPair %q", value)
+	}
+	if value := q.String("Acme-Thing"); value != "thing" {
+		t.Fatalf("unexpected Acme-Thing %q", value)
+	}
+
+	var other radius.Dictionary
+	if err := other.Load(strings.NewReader("VENDOR Cisco 9\nVENDOR Cisco 10\n")); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Fatalf("expecting vendor name conflict on line 2; got %v", err)
+	}
+}
+
+func TestDictionaryCloneMerge(t *testing.T) {
+	clone := radius.Builtin.Clone()
+	clone.MustRegisterVendor(9, "Cisco-AVPair", 1, radius.AttributeText)
+	if _, err := clone.Attr("Cisco-AVPair", "a=b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := radius.Builtin.Attr("Cisco-AVPair", "a=b"); err == nil {
+		t.Fatal("expecting Builtin to be unmodified by its clone")
+	}
+	if _, ok := clone.Type("User-Name"); !ok {
+		t.Fatal("expecting clone to have User-Name")
+	}
+
+	var d radius.Dictionary
+	d.MustRegister("Custom-Name", 1, radius.AttributeString)
+	d.MustRegister("Custom-Other", 200, radius.AttributeInteger)
+
+	skipped := radius.Builtin.Clone()
+	if err := skipped.Merge(&d, false); err != nil {
+		t.Fatal(err)
+	}
+	if name, _ := skipped.Name(1); name != "User-Name" {
+		t.Fatalf("expecting type 1 = User-Name; got %s", name)
+	}
+	if name, _ := skipped.Name(200); name != "Custom-Other" {
+		t.Fatalf("expecting type 200 = Custom-Other; got %s", name)
+	}
+
+	overwritten := radius.Builtin.Clone()
+	if err := overwritten.Merge(&d, true); err != nil {
+		t.Fatal(err)
+	}
+	if name, _ := overwritten.Name(1); name != "Custom-Name" {
+		t.Fatalf("expecting type 1 = Custom-Name; got %s", name)
+	}
+	if _, ok := overwritten.Type("User-Name"); ok {
+		t.Fatal("expecting User-Name to be replaced")
+	}
+}
+
+func TestDictionaryEntries(t *testing.T) {
+	var d radius.Dictionary
+	d.MustRegister("Second", 2, radius.AttributeText)
+	d.MustRegister("First", 1, radius.AttributeText)
+	d.MustRegisterVendor(20, "Twenty-One", 1, radius.AttributeText)
+	d.MustRegisterVendor(10, "Ten-Two", 2, radius.AttributeText)
+	d.MustRegisterVendor(10, "Ten-One", 1, radius.AttributeText)
+
+	var names []string
+	for _, entry := range d.Entries() {
+		names = append(names, entry.Name)
+	}
+	expected := "First Second Vendor-Specific Ten-One Ten-Two Twenty-One"
+	if joined := strings.Join(names, " "); joined != expected {
+		t.Fatalf("expecting entries %q; got %q", expected, joined)
+	}
+
+	d.Each(func(entry *radius.DictionaryEntry) {
+		entry.Name = "Modified"
+	})
+	if name, _ := d.Name(1); name != "First" {
+		t.Fatal("expecting Each to pass copies of the entries")
+	}
+
+	// fn may read and modify the dictionary
+	d.Each(func(entry *radius.DictionaryEntry) {
+		if entry.Vendor == 0 && entry.Type == 1 {
+			d.MustRegister("Third", 3, radius.AttributeText)
+		}
+		if _, ok := d.Type(entry.Name); !ok && entry.Vendor == 0 {
+			t.Errorf("expecting %s to be registered", entry.Name)
+		}
+	})
+	if _, ok := d.Type("Third"); !ok {
+		t.Fatal("expecting Third to be registered from Each")
+	}
+}
+
+func TestDictionaryErrors(t *testing.T) {
+	var d radius.Dictionary
+	d.MustRegister("User-Name", 1, radius.AttributeText)
+
+	if err := d.Register("Other", 1, radius.AttributeText); !errors.Is(err, radius.ErrAttributeAlreadyRegistered) {
+		t.Fatalf("expecting ErrAttributeAlreadyRegistered; got %v", err)
+	}
+	if _, err := d.Attr("Unknown", "x"); !errors.Is(err, radius.ErrAttributeNotRegistered) {
+		t.Fatalf("expecting ErrAttributeNotRegistered; got %v", err)
+	}
+	if err := d.Remove(2); !errors.Is(err, radius.ErrAttributeNotRegistered) {
+		t.Fatalf("expecting ErrAttributeNotRegistered; got %v", err)
+	}
+
+	p := radius.New(radius.CodeAccessRequest, []byte("secret"))
+	if _, err := p.EAPMessage(); !errors.Is(err, radius.ErrAttributeNotFound) {
+		t.Fatalf("expecting ErrAttributeNotFound; got %v", err)
+	}
+}
+
+func TestDictionaryReplaceRegister(t *testing.T) {
+	var d radius.Dictionary
+	d.MustRegister("Class", 25, radius.AttributeString)
+	d.MustRegister("Other", 26, radius.AttributeString)
+
+	d.ReplaceRegister("Class-Text", 25, radius.AttributeText)
+	if name, _ := d.Name(25); name != "Class-Text" {
+		t.Fatalf("expecting type 25 = Class-Text; got %s", name)
+	}
+	if _, ok := d.Type("Class"); ok {
+		t.Fatal("expecting old name to be removed")
+	}
+	if d.Codec(25) != radius.AttributeText {
+		t.Fatal("expecting codec to be replaced")
+	}
+
+	// the name moves to the new type
+	d.ReplaceRegister("Other", 27, radius.AttributeString)
+	if _, ok := d.Name(26); ok {
+		t.Fatal("expecting type 26 to be removed")
+	}
+	if typ, _ := d.Type("Other"); typ != 27 {
+		t.Fatalf("expecting Other = 27; got %d", typ)
+	}
+}
+
+func TestDictionaryValues(t *testing.T) {
+	file := `
+ATTRIBUTE	Service-Type	6	integer
+VALUE	Service-Type	Login-User	1
+VALUE	Service-Type	Framed-User	2
+VALUE	Service-Type	Framed		2
+VALUE	Unknown-Attribute	Something	1
+`
+	var d radius.Dictionary
+	if err := d.Load(strings.NewReader(file)); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.RegisterValue("Unknown-Attribute", "Something", 1); !errors.Is(err, radius.ErrAttributeNotRegistered) {
+		t.Fatalf("expecting ErrAttributeNotRegistered; got %v", err)
+	}
+	if name, ok := d.ValueName("Service-Type", 2); !ok || name != "Framed-User" {
+		t.Fatalf("expecting the first name of a value to be used; got %q", name)
+	}
+
+	clone := d.Clone()
+	attr, err := clone.Attr("Service-Type", "Framed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attr.Value != uint32(2) {
+		t.Fatalf("expecting Service-Type = 2; got %v", attr.Value)
+	}
+	if _, err := clone.Attr("Service-Type", "No-Such-Value"); err == nil {
+		t.Fatal("expecting unknown value name to fail")
+	}
+
+	p := radius.New(radius.CodeAccessRequest, []byte("secret"))
+	p.Dictionary = clone
+	p.AddAttr(attr)
+	if name, value, ok := p.GetEnum("Service-Type"); !ok || name != "Framed-User" || value != 2 {
+		t.Fatalf("expecting Service-Type = Framed-User (2); got %q (%d)", name, value)
+	}
+	if s := p.String("Service-Type"); s != "Framed-User" {
+		t.Fatalf("expecting String to return the value name; got %q", s)
+	}
+
+	// builtin values
+	p = radius.New(radius.CodeAccountingRequest, []byte("secret"))
+	if err := p.Add("Acct-Status-Type", "Interim-Update"); err != nil {
+		t.Fatal(err)
+	}
+	if name, value, ok := p.GetEnum("Acct-Status-Type"); !ok || name != "Interim-Update" || value != radius.AcctStatusTypeInterimUpdate {
+		t.Fatalf("unexpected Acct-Status-Type %q (%d)", name, value)
+	}
+}
+
+func TestDictionarySetLength(t *testing.T) {
+	d := radius.Builtin.Clone()
+	if err := d.SetLength("Class", 2, 4); err != nil {
+		t.Fatal(err)
+	}
+	d.MustRegisterVendor(9, "Cisco-AVPair", 1, radius.AttributeText)
+	if err := d.SetLength("Cisco-AVPair", 3, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetLength("No-Such-Attribute", 1, 1); !errors.Is(err, radius.ErrAttributeNotRegistered) {
+		t.Fatalf("expecting ErrAttributeNotRegistered; got %v", err)
+	}
+	if err := d.SetLength("Class", 4, 2); err == nil {
+		t.Fatal("expecting error for an invalid range")
+	}
+
+	tests := []struct {
+		name  string
+		value interface{}
+		valid bool
+	}{
+		{"Class", []byte{1, 2}, true},
+		{"Class", []byte{1, 2, 3, 4}, true},
+		{"Class", []byte{1}, false},
+		{"Class", []byte{1, 2, 3, 4, 5}, false},
+		{"Cisco-AVPair", "a=b", true},
+		{"Cisco-AVPair", "ab", false},
+		// unconstrained
+		{"State", []byte{1}, true},
+	}
+	for _, test := range tests {
+		p := radius.New(radius.CodeAccessRequest, []byte("secret"))
+		p.Dictionary = d
+		if err := p.Add(test.name, test.value); err != nil {
+			t.Fatal(err)
+		}
+		wire, err := p.Encode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := radius.Parse(wire, p.Secret, d); (err == nil) != test.valid {
+			t.Fatalf("%s %v: expecting valid = %v; got %v", test.name, test.value, test.valid, err)
+		}
+		// the original dictionary is not constrained
+		if _, err := radius.Parse(wire, p.Secret, radius.Builtin); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDictionaryAttrs(t *testing.T) {
+	attrs, err := radius.Builtin.Attrs(map[string]interface{}{
+		"User-Name":      "tim",
+		"NAS-Port":       uint32(1),
+		"User-Nmae":      "typo",
+		"Session-Timout": uint32(60),
+		"Class":          uint32(2),
+	})
+	var attrsErr *radius.AttrsError
+	if !errors.As(err, &attrsErr) {
+		t.Fatalf("expecting *AttrsError; got %v", err)
+	}
+	if names := strings.Join(attrsErr.Names, ","); names != "Class,Session-Timout,User-Nmae" {
+		t.Fatalf("unexpected failed attributes %s", names)
+	}
+	if !errors.Is(err, radius.ErrAttributeNotRegistered) {
+		t.Fatal("expecting error to wrap ErrAttributeNotRegistered")
+	}
+	if len(attrs) != 2 || attrs[0].Type != 5 || attrs[1].Type != 1 {
+		t.Fatalf("expecting the valid attributes, sorted by name; got %v", attrs)
+	}
+
+	if _, err := radius.Builtin.Attrs(map[string]interface{}{"User-Name": "tim"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDictionaryUnknownPolicy(t *testing.T) {
+	d := radius.Builtin.Clone()
+	d.MustRegisterVendor(311, "MS-Known", 1, radius.AttributeText)
+
+	p := radius.New(radius.CodeAccessRequest, []byte("secret"))
+	p.Dictionary = d
+	p.Add("User-Name", "tim")
+	p.AddAttr(&radius.Attribute{Type: 200, Value: []byte{1}})
+	p.AddAttr(&radius.Attribute{
+		Type: 26,
+		Value: []*radius.VendorAttribute{
+			{VendorID: 311, Type: 1, Value: "a"},
+			{VendorID: 311, Type: 2, Value: []byte{2}},
+		},
+	})
+	// vendor that is not registered
+	p.Add("Vendor-Specific", []byte{0, 0, 0, 9, 1, 3, 'x'})
+	wire, err := p.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q, err := radius.Parse(wire, p.Secret, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(q.Attributes) != 4 {
+		t.Fatalf("expecting UnknownKeep to keep all attributes; got %d", len(q.Attributes))
+	}
+
+	d.SetUnknownPolicy(radius.UnknownDrop)
+	if d.Clone().UnknownPolicy() != radius.UnknownDrop {
+		t.Fatal("expecting Clone to copy the policy")
+	}
+	q, err = radius.Parse(wire, p.Secret, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(q.Attributes) != 2 || q.String("User-Name") != "tim" {
+		t.Fatalf("expecting unknown attributes to be dropped; got %d attributes", len(q.Attributes))
+	}
+	if attr, ok := q.Attributes[1].Value.(*radius.VendorAttribute); !ok || attr.Type != 1 {
+		t.Fatalf("expecting the known vendor attribute to be kept; got %#v", q.Attributes[1].Value)
+	}
+
+	d.SetUnknownPolicy(radius.UnknownError)
+	_, err = radius.Parse(wire, p.Secret, d)
+	if !errors.Is(err, radius.ErrUnknownAttribute) || !strings.Contains(err.Error(), "type 200") {
+		t.Fatalf("expecting ErrUnknownAttribute for type 200; got %v", err)
+	}
+	if _, err := radius.Parse(wire, p.Secret, radius.Builtin); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDictionarySetConcat(t *testing.T) {
+	secret := []byte("secret")
+	d := radius.Builtin.Clone()
+	if err := d.SetConcat("Reply-Message", true); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.SetConcat("Tunnel-Password", true); err == nil {
+		t.Fatal("expecting a tagged attribute not to be concatenated")
+	}
+	long := strings.Repeat("0123456789", 60)
+	if _, err := radius.Builtin.Attr("Reply-Message", long); err == nil {
+		t.Fatal("expecting a long value to be rejected without SetConcat")
+	}
+
+	p := radius.New(radius.CodeAccessAccept, secret)
+	p.Dictionary = d
+	if err := p.Add("Reply-Message", long); err != nil {
+		t.Fatal(err)
+	}
+	p.Add("Proxy-State", []byte("a"))
+	p.Add("Proxy-State", []byte("b"))
+	wire, err := p.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lengths []int
+	for rest := wire[20:]; len(rest) > 0; rest = rest[rest[1]:] {
+		if rest[0] == 18 {
+			lengths = append(lengths, int(rest[1])-2)
+		}
+	}
+	if len(lengths) != 3 || lengths[0] != 253 || lengths[1] != 253 || lengths[2] != 94 {
+		t.Fatalf("expecting the value to be split into 253, 253 and 94 bytes; got %v", lengths)
+	}
+
+	q, err := radius.Parse(wire, secret, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Len(18) != 1 || q.String("Reply-Message") != long {
+		t.Fatalf("expecting a single concatenated Reply-Message; got %d", q.Len(18))
+	}
+	if q.Len(33) != 2 {
+		t.Fatal("expecting Proxy-State attributes not to be concatenated")
+	}
+
+	// without SetConcat, the attributes are parsed separately
+	q, err = radius.Parse(wire, secret, radius.Builtin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Len(18) != 3 {
+		t.Fatalf("expecting 3 Reply-Message attributes; got %d", q.Len(18))
+	}
+	loaded := &radius.Dictionary{}
+	if err := loaded.Load(strings.NewReader("ATTRIBUTE Long-Data 200 octets concat\n")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loaded.Attr("Long-Data", long); err != nil {
+		t.Fatalf("expecting the concat flag to be loaded; got %v", err)
+	}
+}
+
+func TestDictionaryLookup(t *testing.T) {
+	entry, ok := radius.Builtin.Lookup("NAS-Port")
+	if !ok || entry.Type != 5 || entry.Name != "NAS-Port" || entry.Codec != radius.AttributeInteger {
+		t.Fatalf("unexpected NAS-Port entry %+v", entry)
+	}
+	entry.Name = "Modified"
+	if name, _ := radius.Builtin.Name(5); name != "NAS-Port" {
+		t.Fatal("expecting the dictionary to be unaffected by the copy")
+	}
+
+	entry, ok = radius.Builtin.LookupType(1)
+	if !ok || entry.Name != "User-Name" || entry.Codec != radius.AttributeText {
+		t.Fatalf("unexpected entry for type 1 %+v", entry)
+	}
+	if entry, ok := radius.Builtin.Lookup("Tunnel-Password"); !ok || !entry.Tagged {
+		t.Fatalf("expecting Tunnel-Password to be tagged; got %+v", entry)
+	}
+
+	if _, ok := radius.Builtin.Lookup("User-Nmae"); ok {
+		t.Fatal("expecting an unregistered name not to be found")
+	}
+	if _, ok := radius.Builtin.LookupType(250); ok {
+		t.Fatal("expecting an unregistered type not to be found")
+	}
+}
+
+func TestDictionaryRegisterAlias(t *testing.T) {
+	d := radius.Builtin.Clone()
+	if err := d.RegisterAlias("Service-Type", "User-Service-Type"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.RegisterAlias("User-Service-Type", "Service"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.RegisterAlias("Service-Type", "User-Name"); !errors.Is(err, radius.ErrAttributeAlreadyRegistered) {
+		t.Fatalf("expecting ErrAttributeAlreadyRegistered for a registered name; got %v", err)
+	}
+	if err := d.RegisterAlias("User-Service-Type", "Service"); !errors.Is(err, radius.ErrAttributeAlreadyRegistered) {
+		t.Fatalf("expecting ErrAttributeAlreadyRegistered for a registered alias; got %v", err)
+	}
+	if err := d.RegisterAlias("Unknown", "Other"); !errors.Is(err, radius.ErrAttributeNotRegistered) {
+		t.Fatalf("expecting ErrAttributeNotRegistered; got %v", err)
+	}
+
+	if typ, ok := d.Type("Service"); !ok || typ != 6 {
+		t.Fatalf("expecting the alias to resolve to type 6; got %d, %v", typ, ok)
+	}
+	if name, _ := d.Name(6); name != "Service-Type" {
+		t.Fatalf("expecting the registered name; got %q", name)
+	}
+	if err := d.RegisterValue("Service", "Framed-User", 2); err != nil {
+		t.Fatal(err)
+	}
+	if name, ok := d.ValueName("Service-Type", 2); !ok || name != "Framed-User" {
+		t.Fatalf("expecting the value to be registered for Service-Type; got %q", name)
+	}
+	attr, err := d.Attr("User-Service-Type", "Framed-User")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attr.Type != 6 || attr.Value != uint32(2) {
+		t.Fatalf("unexpected attribute %+v", attr)
+	}
+	if _, ok := radius.Builtin.Type("Service"); ok {
+		t.Fatal("expecting the original dictionary to be unaffected")
+	}
+	if typ, ok := d.Clone().Type("Service"); !ok || typ != 6 {
+		t.Fatal("expecting the clone to have the alias")
+	}
+
+	if err := d.RemoveByName("Service"); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"Service-Type", "User-Service-Type", "Service"} {
+		if _, ok := d.Type(name); ok {
+			t.Fatalf("expecting %s to be removed", name)
+		}
+	}
+	d.MustRegister("Service-Type", 6, radius.AttributeInteger)
+	if _, ok := d.Type("Service"); ok {
+		t.Fatal("expecting the alias not to return with the attribute")
+	}
+}
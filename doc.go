@@ -31,7 +31,7 @@
 //  Framed-IPX-Network        23  net.IP
 //  State                     24  []byte
 //  Class                     25  []byte
-//  Vendor-Specific           26  []byte
+//  Vendor-Specific           26  *VendorAttribute
 //  Session-Timeout           27  uint32
 //  Idle-Timeout              28  uint32
 //  Termination-Action        29  uint32
@@ -64,4 +64,76 @@
 //  Acct-Terminate-Cause   49  uint32
 //  Acct-Multi-Session-Id  50  string
 //  Acct-Link-Count        51  uint32
+//
+// The following tagged attributes are defined by RFC 2868:
+//
+//  Tunnel-Type              64  uint32
+//  Tunnel-Medium-Type       65  uint32
+//  Tunnel-Client-Endpoint   66  string
+//  Tunnel-Server-Endpoint   67  string
+//  Tunnel-Password          69  string
+//  Tunnel-Private-Group-ID  81  string
+//  Tunnel-Assignment-ID     82  string
+//  Tunnel-Preference        83  uint32
+//  Tunnel-Client-Auth-ID    90  string
+//  Tunnel-Server-Auth-ID    91  string
+//
+// The following attributes are defined by RFC 2869:
+//
+//  Acct-Input-Gigawords     52  uint32
+//  Acct-Output-Gigawords    53  uint32
+//  Event-Timestamp          55  time.Time
+//  ARAP-Password            70  []byte
+//  ARAP-Features            71  []byte
+//  ARAP-Zone-Access         72  uint32
+//  ARAP-Security            73  uint32
+//  ARAP-Security-Data       74  string
+//  Password-Retry           75  uint32
+//  Prompt                   76  uint32
+//  Connect-Info             77  string
+//  Configuration-Token      78  string
+//  ARAP-Challenge-Response  84  []byte
+//  Acct-Interim-Interval    85  uint32
+//  NAS-Port-Id              87  string
+//  Framed-Pool              88  string
+//
+// The following attributes are defined by RFC 3162:
+//
+//  NAS-IPv6-Address     95   net.IP
+//  Framed-Interface-Id  96   [8]byte
+//  Framed-IPv6-Prefix   97   netip.Prefix
+//  Login-IPv6-Host      98   net.IP
+//  Framed-IPv6-Route    99   string
+//  Framed-IPv6-Pool     100  string
+//
+// The following attributes are defined by RFC 3579:
+//
+//  EAP-Message            79  []byte
+//  Message-Authenticator  80  []byte
+//
+// The following attributes are defined by RFC 4005:
+//
+//  Originating-Line-Info  94  []byte
+//
+// The following attributes are defined by RFC 5176:
+//
+//  Error-Cause  101  uint32
+//
+// The following attributes are defined by RFC 6911:
+//
+//  Framed-IPv6-Address         168  net.IP
+//  DNS-Server-IPv6-Address     169  net.IP
+//  Route-IPv6-Information      170  netip.Prefix
+//  Delegated-IPv6-Prefix-Pool  171  string
+//  Stateful-IPv6-Address-Pool  172  string
+//
+// The following attributes are defined by RFC 6929. They carry extended
+// attributes, which are registered using Dictionary.RegisterExtended:
+//
+//  Extended-Type-1       241  *ExtendedAttribute
+//  Extended-Type-2       242  *ExtendedAttribute
+//  Extended-Type-3       243  *ExtendedAttribute
+//  Extended-Type-4       244  *ExtendedAttribute
+//  Long-Extended-Type-1  245  *ExtendedAttribute
+//  Long-Extended-Type-2  246  *ExtendedAttribute
 package radius
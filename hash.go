@@ -0,0 +1,43 @@
+package radius
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"hash"
+)
+
+// Functions that return the hashes used by the package. They are replaced
+// with SetMD5Provider.
+var (
+	newMD5     = md5.New
+	newHMACMD5 = defaultHMACMD5
+)
+
+func defaultHMACMD5(key []byte) hash.Hash {
+	return hmac.New(newMD5, key)
+}
+
+// SetMD5Provider replaces the implementation of MD5 that is used by the
+// package, such as by an approved cryptographic module in a FIPS deployment.
+// RADIUS mandates MD5 for the authenticators of packets, for the
+// Message-Authenticator attribute (as HMAC-MD5), and for the encryption of
+// attributes such as User-Password; this only changes which implementation
+// computes it, not the algorithm.
+//
+// newMD5Hash returns a new MD5 hash, and newHMAC a new HMAC-MD5 hash with the
+// given key. If newHMAC is nil, HMAC-MD5 is computed by crypto/hmac with
+// newMD5Hash; if newMD5Hash is nil, crypto/md5 is used again.
+//
+// SetMD5Provider must be called before the package is used, such as from an
+// init function, as it is not safe to call while packets are being encoded or
+// decoded.
+func SetMD5Provider(newMD5Hash func() hash.Hash, newHMAC func(key []byte) hash.Hash) {
+	if newMD5Hash == nil {
+		newMD5Hash = md5.New
+	}
+	if newHMAC == nil {
+		newHMAC = defaultHMACMD5
+	}
+	newMD5 = newMD5Hash
+	newHMACMD5 = newHMAC
+}
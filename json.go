@@ -0,0 +1,273 @@
+package radius
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"time"
+)
+
+// jsonPacket is the JSON representation of a Packet.
+type jsonPacket struct {
+	Code          string          `json:"code"`
+	Identifier    byte            `json:"identifier"`
+	Authenticator string          `json:"authenticator"`
+	Attributes    []jsonAttribute `json:"attributes"`
+}
+
+// jsonAttribute is the JSON representation of an Attribute. Attributes whose
+// values can be represented in JSON have a Name and Value; the others have the
+// hex encoding of their wire value.
+type jsonAttribute struct {
+	Type byte `json:"type"`
+	Tag  byte `json:"tag,omitempty"`
+	// dictionary name of the attribute, or of the vendor or extended
+	// attribute that it carries
+	Name  string      `json:"name,omitempty"`
+	Value interface{} `json:"value,omitempty"`
+	Hex   string      `json:"hex,omitempty"`
+}
+
+// MarshalJSON returns the JSON representation of the packet. It is an object
+// with the packet's code name, identifier, authenticator (in hex), and an
+// array of its attributes, in order. Each attribute has its type and, if
+// non-zero, its tag. Attributes whose dictionary name is known and whose value
+// is a string, integer, IP address or prefix, or time have their name and
+// value; vendor-specific and extended attributes are named after the vendor
+// or extended attribute that they carry. Other attributes, such as those
+// with []byte values or that are not in the dictionary, have the hex encoding
+// of their wire value instead.
+//
+// Sensitive attributes (those that Dump redacts, such as User-Password) also
+// have the hex encoding of their wire value, which is encrypted with the
+// packet's secret, so that their plaintext is not included. The packet's
+// secret is not included either.
+func (p *Packet) MarshalJSON() ([]byte, error) {
+	if err := p.Decode(); err != nil {
+		return nil, err
+	}
+	j := jsonPacket{
+		Code:          p.Code.String(),
+		Identifier:    p.Identifier,
+		Authenticator: hex.EncodeToString(p.Authenticator[:]),
+		Attributes:    make([]jsonAttribute, 0, len(p.Attributes)),
+	}
+	for _, attr := range p.Attributes {
+		ja, err := p.jsonAttribute(attr)
+		if err != nil {
+			return nil, err
+		}
+		j.Attributes = append(j.Attributes, ja)
+	}
+	return json.Marshal(j)
+}
+
+func (p *Packet) jsonAttribute(attr *Attribute) (jsonAttribute, error) {
+	ja := jsonAttribute{
+		Type: attr.Type,
+		Tag:  attr.Tag,
+	}
+	var name string
+	var nameOK, redacted bool
+	value := attr.Value
+	switch v := attr.Value.(type) {
+	case *VendorAttribute:
+		if attr.Type == attributeTypeVendorSpecific {
+			name, nameOK = p.Dictionary.VendorAttributeName(v.VendorID, v.Type)
+			redacted = isRedacted(p.Dictionary.vendorEntry(v.VendorID, v.Type), 0)
+			value = v.Value
+		}
+	case *ExtendedAttribute:
+		if isExtendedType(attr.Type) {
+			name, nameOK = p.Dictionary.ExtendedAttributeName(attr.Type, v.ExtendedType)
+			redacted = isRedacted(p.Dictionary.extendedEntry(attr.Type, v.ExtendedType), 0)
+			value = v.Value
+		}
+	default:
+		name, nameOK = p.Dictionary.Name(attr.Type)
+		redacted = isRedacted(p.Dictionary.typeEntry(attr.Type), attr.Type)
+	}
+	if nameOK && !redacted {
+		if jsonValue, ok := toJSONValue(value); ok {
+			ja.Name = name
+			ja.Value = jsonValue
+			return ja, nil
+		}
+	}
+
+	var wire []byte
+	var err error
+	if codec := p.Dictionary.taggedCodec(attr.Type); codec != nil {
+		wire, err = codec.EncodeTagged(p, attr.Tag, attr.Value)
+	} else {
+		wire, err = p.Dictionary.Codec(attr.Type).Encode(p, attr.Value)
+	}
+	if err != nil {
+		return ja, err
+	}
+	ja.Name, _ = p.Dictionary.Name(attr.Type)
+	ja.Hex = hex.EncodeToString(wire)
+	return ja, nil
+}
+
+// toJSONValue returns the JSON representation of an attribute value. ok is
+// false if the value has no representation other than its wire format.
+func toJSONValue(value interface{}) (jsonValue interface{}, ok bool) {
+	switch v := value.(type) {
+	case string, uint32, uint64:
+		return v, true
+	case net.IP:
+		return v.String(), true
+	case netip.Addr:
+		return v.String(), true
+	case netip.Prefix:
+		return v.String(), true
+	case time.Time:
+		return v.Format(time.RFC3339), true
+	}
+	return nil, false
+}
+
+// UnmarshalJSON rebuilds a packet from the representation that is returned by
+// MarshalJSON. Attribute values are decoded using the packet's dictionary, or
+// Builtin if it is nil, and the packet's secret is left unchanged; both should
+// be set before UnmarshalJSON is called if the attributes use a different
+// dictionary, or are encrypted with the secret.
+//
+// Attributes that have a hex value are decoded from it, so attributes that are
+// not in the dictionary are preserved byte for byte.
+func (p *Packet) UnmarshalJSON(data []byte) error {
+	var j jsonPacket
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&j); err != nil {
+		return err
+	}
+
+	code, err := parseCode(j.Code)
+	if err != nil {
+		return err
+	}
+	authenticator, err := hex.DecodeString(j.Authenticator)
+	if err != nil || len(authenticator) != len(p.Authenticator) {
+		return errors.New("radius: invalid JSON packet authenticator")
+	}
+	p.Code = code
+	p.Identifier = j.Identifier
+	copy(p.Authenticator[:], authenticator)
+	if p.Dictionary == nil {
+		p.Dictionary = Builtin
+	}
+	p.ClearAttributes()
+	p.Raw = nil
+
+	for _, ja := range j.Attributes {
+		attr, err := p.attributeFromJSON(ja)
+		if err != nil {
+			return err
+		}
+		p.AddAttr(attr)
+	}
+	return nil
+}
+
+// parseCode returns the code with the given name or number.
+func parseCode(s string) (Code, error) {
+	for code, name := range codeNames {
+		if name == s {
+			return code, nil
+		}
+	}
+	n, err := strconv.ParseUint(s, 10, 8)
+	if err != nil {
+		return 0, fmt.Errorf("radius: unknown packet code %q", s)
+	}
+	return Code(n), nil
+}
+
+func (p *Packet) attributeFromJSON(ja jsonAttribute) (*Attribute, error) {
+	if ja.Value == nil {
+		wire, err := hex.DecodeString(ja.Hex)
+		if err != nil {
+			return nil, fmt.Errorf("radius: invalid JSON hex value of attribute type %d", ja.Type)
+		}
+		attr := &Attribute{
+			Type: ja.Type,
+		}
+		attr.Tag, attr.Value, err = p.Dictionary.typeEntry(ja.Type).decode(p, wire)
+		if err != nil {
+			return nil, err
+		}
+		return attr, nil
+	}
+
+	entry := p.Dictionary.entry(ja.Name)
+	if entry == nil {
+		return nil, fmt.Errorf("%w: %s", ErrAttributeNotRegistered, ja.Name)
+	}
+	// The JSON value is converted to the first Go value that the attribute's
+	// codec can encode; the value is then decoded from the encoding, so that
+	// it has the same form as one that was parsed.
+	for _, candidate := range jsonCandidates(ja.Value) {
+		var decoded interface{}
+		if entry.Tagged {
+			codec := entry.Codec.(AttributeTaggedCodec)
+			wire, err := codec.EncodeTagged(p, ja.Tag, candidate)
+			if err != nil {
+				continue
+			}
+			_, decoded, err = codec.DecodeTagged(p, wire)
+			if err != nil {
+				continue
+			}
+			decoded = TaggedValue{
+				Tag:   ja.Tag,
+				Value: decoded,
+			}
+		} else {
+			wire, err := entry.Codec.Encode(p, candidate)
+			if err != nil {
+				continue
+			}
+			if decoded, err = entry.Codec.Decode(p, wire); err != nil {
+				continue
+			}
+		}
+		return p.Dictionary.Attr(ja.Name, decoded)
+	}
+	return nil, fmt.Errorf("radius: invalid JSON value for %s", ja.Name)
+}
+
+// jsonCandidates returns the Go values that a JSON attribute value may
+// represent, in the order in which they are tried.
+func jsonCandidates(value interface{}) []interface{} {
+	switch v := value.(type) {
+	case string:
+		candidates := []interface{}{v}
+		if ip := net.ParseIP(v); ip != nil {
+			candidates = append(candidates, ip)
+		}
+		if prefix, err := netip.ParsePrefix(v); err == nil {
+			candidates = append(candidates, prefix)
+		}
+		if t, err := time.Parse(time.RFC3339, v); err == nil {
+			candidates = append(candidates, t)
+		}
+		return candidates
+	case json.Number:
+		var candidates []interface{}
+		if n, err := strconv.ParseUint(v.String(), 10, 32); err == nil {
+			candidates = append(candidates, uint32(n))
+		}
+		if n, err := strconv.ParseUint(v.String(), 10, 64); err == nil {
+			candidates = append(candidates, n)
+		}
+		return candidates
+	}
+	return nil
+}
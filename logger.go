@@ -0,0 +1,17 @@
+package radius
+
+// Logger receives messages about events that are not otherwise reported, such
+// as packets that are dropped because they are malformed or are not
+// authentic. Messages are formatted as with fmt.Sprintf, and include the
+// address of the packet's sender and the reason it was dropped.
+//
+// Implementations must be safe for concurrent use.
+type Logger interface {
+	// Debugf logs an event that is expected during normal operation, such as
+	// a retransmitted request, or a response that arrives too late.
+	Debugf(format string, args ...interface{})
+	// Warnf logs an event that may indicate a misconfiguration or an attack,
+	// such as a packet from an unknown client, or with an invalid
+	// authenticator.
+	Warnf(format string, args ...interface{})
+}
@@ -0,0 +1,336 @@
+package radius
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// number of datagrams that are queued for a connection of a MemoryNetwork
+// before further ones are dropped
+const memoryQueueSize = 1024
+
+// first port that is assigned to connections that do not ask for one
+const memoryEphemeralPort = 49152
+
+// MemoryNetwork is an in-memory datagram network, which connects clients and
+// servers in the same process without using sockets, such as in tests:
+//
+//  network := radius.NewMemoryNetwork()
+//  conn, _ := network.ListenPacket("udp", "127.0.0.1:1812")
+//  go server.Serve(conn)
+//
+//  client := radius.Client{
+//  	DialContext:  network.DialContext,
+//  	ListenPacket: network.ListenPacket,
+//  }
+//  response, err := client.Exchange(packet, "127.0.0.1:1812")
+//
+// It behaves like UDP: each write is delivered as a single datagram, and
+// datagrams that are sent to an address that nothing listens on, or to a
+// connection whose queue is full, are dropped. Addresses are UDP addresses;
+// a connection that listens on an unspecified address (such as ":1812")
+// receives the datagrams sent to its port at any address.
+type MemoryNetwork struct {
+	mu sync.Mutex
+	// listening connections, by local address
+	conns map[string]*memoryConn
+	// next port to try for connections that do not ask for one
+	nextPort int
+}
+
+// NewMemoryNetwork returns a new, empty in-memory network.
+func NewMemoryNetwork() *MemoryNetwork {
+	return &MemoryNetwork{
+		conns:    make(map[string]*memoryConn),
+		nextPort: memoryEphemeralPort,
+	}
+}
+
+// ListenPacket returns a connection that receives the datagrams that are sent
+// to addr on the network, as with net.ListenPacket. network must be "udp",
+// "udp4", or "udp6". If the port of addr is zero, a free port is chosen.
+func (n *MemoryNetwork) ListenPacket(network, addr string) (net.PacketConn, error) {
+	local, err := net.ResolveUDPAddr(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return n.listen(local, nil)
+}
+
+// DialContext returns a connection whose writes are sent to addr on the
+// network, and which only reads the datagrams that are received from addr, as
+// with net.Dialer.DialContext. The local address is on the loopback
+// interface, with a free port.
+func (n *MemoryNetwork) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	remote, err := net.ResolveUDPAddr(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	local := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}
+	if remote.IP != nil && remote.IP.To4() == nil {
+		local.IP = net.IPv6loopback
+	}
+	return n.listen(local, remote)
+}
+
+// listen registers a connection on local, choosing a port if it has none.
+func (n *MemoryNetwork) listen(local, remote *net.UDPAddr) (*memoryConn, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	local = &net.UDPAddr{IP: local.IP, Port: local.Port, Zone: local.Zone}
+	if local.Port == 0 {
+		for i := 0; ; i++ {
+			if i == 65536-memoryEphemeralPort {
+				return nil, errors.New("radius: no free port in memory network")
+			}
+			local.Port = n.nextPort
+			if n.nextPort++; n.nextPort > 65535 {
+				n.nextPort = memoryEphemeralPort
+			}
+			if n.conns[local.String()] == nil {
+				break
+			}
+		}
+	} else if n.conns[local.String()] != nil {
+		return nil, &net.OpError{Op: "listen", Net: "udp", Addr: local, Err: errors.New("address already in use")}
+	}
+
+	conn := &memoryConn{
+		network: n,
+		local:   local,
+		remote:  remote,
+		queue:   make(chan memoryDatagram, memoryQueueSize),
+		closed:  make(chan struct{}),
+		changed: make(chan struct{}),
+	}
+	n.conns[local.String()] = conn
+	return conn, nil
+}
+
+// deliver queues a datagram for the connection that listens on to, if any.
+func (n *MemoryNetwork) deliver(from, to *net.UDPAddr, data []byte) {
+	n.mu.Lock()
+	conn := n.conns[to.String()]
+	if conn == nil {
+		conn = n.conns[(&net.UDPAddr{Port: to.Port}).String()]
+	}
+	n.mu.Unlock()
+	if conn == nil {
+		return
+	}
+	if conn.local.IP == nil || conn.local.IP.IsUnspecified() {
+		conn.addPeer(from, to.IP)
+	}
+	datagram := memoryDatagram{
+		from: from,
+		data: append([]byte(nil), data...),
+	}
+	select {
+	case conn.queue <- datagram:
+	default:
+		// the receiver is not keeping up
+	}
+}
+
+// memoryDatagram is a datagram queued for a connection of a MemoryNetwork.
+type memoryDatagram struct {
+	from *net.UDPAddr
+	data []byte
+}
+
+// memoryConn is a connection of a MemoryNetwork. It is a net.PacketConn, and,
+// if it was dialed, a net.Conn.
+type memoryConn struct {
+	network *MemoryNetwork
+	local   *net.UDPAddr
+	// address that the connection was dialed to; nil if it listens
+	remote *net.UDPAddr
+
+	queue     chan memoryDatagram
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	mu            sync.Mutex
+	readDeadline  time.Time
+	writeDeadline time.Time
+	// closed and replaced each time the read deadline changes
+	changed chan struct{}
+	// for a connection on an unspecified address, the address that each
+	// peer sent datagrams to, which is the source of the datagrams that are
+	// sent back to it, as a socket would choose
+	peers map[string]net.IP
+}
+
+// maximum number of peers that a connection remembers the addresses of
+const maxMemoryPeers = 4096
+
+func (c *memoryConn) addPeer(peer *net.UDPAddr, ip net.IP) {
+	c.mu.Lock()
+	if c.peers == nil || len(c.peers) >= maxMemoryPeers {
+		c.peers = make(map[string]net.IP)
+	}
+	c.peers[peer.String()] = ip
+	c.mu.Unlock()
+}
+
+// source returns the address that datagrams to addr are sent from.
+func (c *memoryConn) source(addr *net.UDPAddr) *net.UDPAddr {
+	if c.local.IP != nil && !c.local.IP.IsUnspecified() {
+		return c.local
+	}
+	c.mu.Lock()
+	ip := c.peers[addr.String()]
+	c.mu.Unlock()
+	if ip == nil {
+		ip = net.IPv4(127, 0, 0, 1)
+		if addr.IP != nil && addr.IP.To4() == nil {
+			ip = net.IPv6loopback
+		}
+	}
+	return &net.UDPAddr{IP: ip, Port: c.local.Port}
+}
+
+func (c *memoryConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		c.mu.Lock()
+		deadline, changed := c.readDeadline, c.changed
+		c.mu.Unlock()
+
+		var expired <-chan time.Time
+		var timer *time.Timer
+		if !deadline.IsZero() {
+			wait := time.Until(deadline)
+			if wait <= 0 {
+				return 0, nil, c.opError("read", os.ErrDeadlineExceeded)
+			}
+			timer = time.NewTimer(wait)
+			expired = timer.C
+		}
+		select {
+		case datagram := <-c.queue:
+			if timer != nil {
+				timer.Stop()
+			}
+			if c.remote != nil && (datagram.from.Port != c.remote.Port || !datagram.from.IP.Equal(c.remote.IP)) {
+				// not from the address that the connection was dialed to
+				continue
+			}
+			return copy(b, datagram.data), datagram.from, nil
+		case <-expired:
+		case <-changed:
+			if timer != nil {
+				timer.Stop()
+			}
+		case <-c.closed:
+			if timer != nil {
+				timer.Stop()
+			}
+			return 0, nil, c.opError("read", net.ErrClosed)
+		}
+	}
+}
+
+func (c *memoryConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	select {
+	case <-c.closed:
+		return 0, c.opError("write", net.ErrClosed)
+	default:
+	}
+	c.mu.Lock()
+	deadline := c.writeDeadline
+	c.mu.Unlock()
+	if !deadline.IsZero() && !time.Now().Before(deadline) {
+		return 0, c.opError("write", os.ErrDeadlineExceeded)
+	}
+	to, ok := addr.(*net.UDPAddr)
+	if !ok {
+		var err error
+		if to, err = net.ResolveUDPAddr("udp", addr.String()); err != nil {
+			return 0, c.opError("write", err)
+		}
+	}
+	c.network.deliver(c.source(to), to, b)
+	return len(b), nil
+}
+
+func (c *memoryConn) Read(b []byte) (int, error) {
+	n, _, err := c.ReadFrom(b)
+	return n, err
+}
+
+func (c *memoryConn) Write(b []byte) (int, error) {
+	if c.remote == nil {
+		return 0, c.opError("write", errors.New("not connected"))
+	}
+	return c.WriteTo(b, c.remote)
+}
+
+func (c *memoryConn) Close() error {
+	closed := false
+	c.closeOnce.Do(func() {
+		closed = true
+		c.network.mu.Lock()
+		delete(c.network.conns, c.local.String())
+		c.network.mu.Unlock()
+		close(c.closed)
+	})
+	if !closed {
+		return c.opError("close", net.ErrClosed)
+	}
+	return nil
+}
+
+func (c *memoryConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+// RemoteAddr returns the address that the connection was dialed to, or nil.
+func (c *memoryConn) RemoteAddr() net.Addr {
+	if c.remote == nil {
+		return nil
+	}
+	return c.remote
+}
+
+func (c *memoryConn) SetDeadline(t time.Time) error {
+	c.SetReadDeadline(t)
+	return c.SetWriteDeadline(t)
+}
+
+func (c *memoryConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.readDeadline = t
+	close(c.changed)
+	c.changed = make(chan struct{})
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *memoryConn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.writeDeadline = t
+	c.mu.Unlock()
+	return nil
+}
+
+// opError wraps err in a *net.OpError, as the errors of sockets are.
+func (c *memoryConn) opError(op string, err error) error {
+	opErr := &net.OpError{
+		Op:     op,
+		Net:    "udp",
+		Source: c.local,
+		Err:    err,
+	}
+	if c.remote != nil {
+		opErr.Addr = c.remote
+	}
+	return opErr
+}
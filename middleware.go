@@ -0,0 +1,260 @@
+package radius
+
+import (
+	"log"
+	"net"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// Middleware wraps a Handler, adding behavior before or after the packets are
+// handled by it.
+type Middleware func(Handler) Handler
+
+// Chain returns a Middleware that applies the given middlewares in order; the
+// first middleware is the outermost, and sees each packet first.
+//
+//  server.Handler = radius.Chain(radius.Recover(nil), radius.Logging(nil))(handler)
+func Chain(middlewares ...Middleware) Middleware {
+	return func(h Handler) Handler {
+		for i := len(middlewares) - 1; i >= 0; i-- {
+			h = middlewares[i](h)
+		}
+		return h
+	}
+}
+
+// recordingWriter is a ResponseWriter that records if a response was sent.
+type recordingWriter struct {
+	ResponseWriter
+
+	mu      sync.Mutex
+	written bool
+	code    Code
+}
+
+func (w *recordingWriter) record(code Code) {
+	w.mu.Lock()
+	w.written = true
+	w.code = code
+	w.mu.Unlock()
+}
+
+// response returns the code of the response that was sent, and if one was.
+func (w *recordingWriter) response() (Code, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.code, w.written
+}
+
+func (w *recordingWriter) Write(packet *Packet) error {
+	err := w.ResponseWriter.Write(packet)
+	if err == nil {
+		w.record(packet.Code)
+	}
+	return err
+}
+
+func (w *recordingWriter) AccessAccept(attributes ...*Attribute) error {
+	err := w.ResponseWriter.AccessAccept(attributes...)
+	if err == nil {
+		w.record(CodeAccessAccept)
+	}
+	return err
+}
+
+func (w *recordingWriter) AccessReject(attributes ...*Attribute) error {
+	err := w.ResponseWriter.AccessReject(attributes...)
+	if err == nil {
+		w.record(CodeAccessReject)
+	}
+	return err
+}
+
+func (w *recordingWriter) AccessChallenge(attributes ...*Attribute) error {
+	err := w.ResponseWriter.AccessChallenge(attributes...)
+	if err == nil {
+		w.record(CodeAccessChallenge)
+	}
+	return err
+}
+
+// loggerOrDefault returns logger, or a logger that writes to the standard
+// logger if it is nil.
+func loggerOrDefault(logger *log.Logger) *log.Logger {
+	if logger == nil {
+		return log.Default()
+	}
+	return logger
+}
+
+// Logging returns a Middleware that logs each packet that is handled, along
+// with the response that was sent and how long handling took. If logger is
+// nil, the standard logger is used.
+func Logging(logger *log.Logger) Middleware {
+	logger = loggerOrDefault(logger)
+	return func(h Handler) Handler {
+		return HandlerFunc(func(w ResponseWriter, p *Packet) {
+			start := time.Now()
+			recorder := &recordingWriter{
+				ResponseWriter: w,
+			}
+			h.ServeRadius(recorder, p)
+			elapsed := time.Since(start)
+
+			if code, ok := recorder.response(); ok {
+				logger.Printf("radius: %s code %d #%d: responded with code %d (%s)", w.RemoteAddr(), p.Code, p.Identifier, code, elapsed)
+			} else {
+				logger.Printf("radius: %s code %d #%d: no response (%s)", w.RemoteAddr(), p.Code, p.Identifier, elapsed)
+			}
+		})
+	}
+}
+
+// Recover returns a Middleware that recovers from panics in the wrapped
+// Handler. The panic is logged along with its stack trace, and an
+// Access-Request that was not yet answered is answered with an Access-Reject.
+// Other packets are left unanswered. If logger is nil, the standard logger is
+// used.
+func Recover(logger *log.Logger) Middleware {
+	logger = loggerOrDefault(logger)
+	return func(h Handler) Handler {
+		return HandlerFunc(func(w ResponseWriter, p *Packet) {
+			recorder := &recordingWriter{
+				ResponseWriter: w,
+			}
+			defer func() {
+				r := recover()
+				if r == nil {
+					return
+				}
+				logger.Printf("radius: panic handling packet from %s: %v\n%s", w.RemoteAddr(), r, debug.Stack())
+				if _, ok := recorder.response(); !ok && p.Code == CodeAccessRequest {
+					w.AccessReject()
+				}
+			}()
+			h.ServeRadius(recorder, p)
+		})
+	}
+}
+
+// RateLimit returns a Middleware that limits the rate of the packets that are
+// handled from each client IP address. It is a shortcut for a RateLimiter
+// with the given Rate and Burst.
+func RateLimit(rate float64, burst int) Middleware {
+	limiter := &RateLimiter{
+		Rate:  rate,
+		Burst: burst,
+	}
+	return limiter.Wrap
+}
+
+// RateLimiter limits the rate of the packets that are handled from each
+// client IP address, using a token bucket per client. Each client may send
+// Burst packets at once, which are replenished at Rate packets per second.
+//
+// The state of a client is discarded once its bucket has been full for a
+// while, so clients that are only seen briefly do not use memory
+// indefinitely.
+//
+// A RateLimiter must not be copied after first use, and its fields must not
+// be modified after first use.
+type RateLimiter struct {
+	// Number of packets per second that are allowed from each client.
+	Rate float64
+	// Number of packets that a client may send at once.
+	Burst int
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastPurge time.Time
+}
+
+// Wrap returns a Handler that passes packets to h unless their sender is over
+// the limit. Packets over the limit are dropped without a response, as RADIUS
+// has no way to indicate that a client should slow down. Wrap can be used as
+// a Middleware.
+func (l *RateLimiter) Wrap(h Handler) Handler {
+	return HandlerFunc(func(w ResponseWriter, p *Packet) {
+		if !l.Allow(w.RemoteAddr()) {
+			return
+		}
+		h.ServeRadius(w, p)
+	})
+}
+
+// Allow returns if a packet from addr can be handled now, taking a token from
+// the bucket of its IP address if so.
+func (l *RateLimiter) Allow(addr net.Addr) bool {
+	return l.allow(hostOf(addr), time.Now())
+}
+
+// hostOf returns the IP address of addr, without its port.
+func hostOf(addr net.Addr) string {
+	switch addr := addr.(type) {
+	case *net.UDPAddr:
+		return addr.IP.String()
+	case *net.TCPAddr:
+		return addr.IP.String()
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// fillTime returns how long it takes for an empty bucket to be full.
+func (l *RateLimiter) fillTime() time.Duration {
+	if l.Rate <= 0 {
+		return 0
+	}
+	return time.Duration(float64(l.Burst) / l.Rate * float64(time.Second))
+}
+
+func (l *RateLimiter) allow(key string, now time.Time) bool {
+	burst := float64(l.Burst)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.buckets == nil {
+		l.buckets = make(map[string]*tokenBucket)
+	}
+	// A bucket that has had time to fill up is the same as a new one; remove
+	// such buckets, at most once per fill time.
+	if fill := l.fillTime(); fill > 0 && now.Sub(l.lastPurge) > fill {
+		for k, bucket := range l.buckets {
+			if now.Sub(bucket.last) > fill {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastPurge = now
+	}
+
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{
+			tokens: burst,
+			last:   now,
+		}
+		l.buckets[key] = bucket
+	}
+	if elapsed := now.Sub(bucket.last); elapsed > 0 {
+		bucket.tokens += elapsed.Seconds() * l.Rate
+		if bucket.tokens > burst {
+			bucket.tokens = burst
+		}
+		bucket.last = now
+	}
+	if bucket.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+	return true
+}
@@ -0,0 +1,208 @@
+package radius_test
+
+import (
+	"bytes"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/PromonLogicalis/radius"
+)
+
+// testResponseWriter is a ResponseWriter that records the responses that are
+// written to it.
+type testResponseWriter struct {
+	remoteAddr net.Addr
+	responses  []radius.Code
+}
+
+func (w *testResponseWriter) LocalAddr() net.Addr  { return &net.UDPAddr{} }
+func (w *testResponseWriter) RemoteAddr() net.Addr { return w.remoteAddr }
+
+func (w *testResponseWriter) Write(packet *radius.Packet) error {
+	w.responses = append(w.responses, packet.Code)
+	return nil
+}
+
+func (w *testResponseWriter) AccessAccept(attributes ...*radius.Attribute) error {
+	return w.Write(&radius.Packet{Code: radius.CodeAccessAccept})
+}
+
+func (w *testResponseWriter) AccessReject(attributes ...*radius.Attribute) error {
+	return w.Write(&radius.Packet{Code: radius.CodeAccessReject})
+}
+
+func (w *testResponseWriter) AccessChallenge(attributes ...*radius.Attribute) error {
+	return w.Write(&radius.Packet{Code: radius.CodeAccessChallenge})
+}
+
+// syncBuffer is a bytes.Buffer that is safe for concurrent use.
+type syncBuffer struct {
+	mu   sync.Mutex
+	buff bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buff.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buff.String()
+}
+
+func TestChain(t *testing.T) {
+	var order []string
+	middleware := func(name string) radius.Middleware {
+		return func(h radius.Handler) radius.Handler {
+			return radius.HandlerFunc(func(w radius.ResponseWriter, p *radius.Packet) {
+				order = append(order, name)
+				h.ServeRadius(w, p)
+			})
+		}
+	}
+	handler := radius.Chain(middleware("a"), middleware("b"))(radius.HandlerFunc(func(w radius.ResponseWriter, p *radius.Packet) {
+		order = append(order, "handler")
+	}))
+	handler.ServeRadius(&testResponseWriter{}, radius.New(radius.CodeAccessRequest, nil))
+	if s := strings.Join(order, ","); s != "a,b,handler" {
+		t.Fatalf("expecting a,b,handler; got %s", s)
+	}
+}
+
+func TestLogging(t *testing.T) {
+	var buff bytes.Buffer
+	handler := radius.Logging(log.New(&buff, "", 0))(radius.HandlerFunc(func(w radius.ResponseWriter, p *radius.Packet) {
+		w.AccessAccept()
+	}))
+	w := &testResponseWriter{
+		remoteAddr: &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 1645},
+	}
+	handler.ServeRadius(w, radius.New(radius.CodeAccessRequest, nil))
+	if len(w.responses) != 1 {
+		t.Fatalf("expecting one response; got %d", len(w.responses))
+	}
+	if line := buff.String(); !strings.Contains(line, "192.0.2.1:1645") || !strings.Contains(line, "responded with code 2") {
+		t.Fatalf("unexpected log line %q", line)
+	}
+}
+
+func TestRecover(t *testing.T) {
+	secret := []byte("secret")
+	addr := freeAddr(t)
+
+	var logged syncBuffer
+	server := radius.Server{
+		Addr:       addr,
+		Secret:     secret,
+		Dictionary: radius.Builtin,
+		Handler: radius.Recover(log.New(&logged, "", 0))(radius.HandlerFunc(func(w radius.ResponseWriter, p *radius.Packet) {
+			if p.String("User-Name") == "panic" {
+				panic("handler failure")
+			}
+			w.AccessAccept()
+		})),
+	}
+	go server.ListenAndServe()
+	defer server.Close()
+
+	// wait for the server to start listening
+	probe := radius.New(radius.CodeAccessRequest, secret)
+	for i := 0; ; i++ {
+		client := radius.Client{
+			ReadTimeout: 50 * time.Millisecond,
+		}
+		if _, err := client.Exchange(probe, addr); err == nil {
+			break
+		} else if i == 50 {
+			t.Fatal(err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	var client radius.Client
+	packet := radius.New(radius.CodeAccessRequest, secret)
+	packet.Add("User-Name", "panic")
+	response, err := client.Exchange(packet, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Code != radius.CodeAccessReject {
+		t.Fatalf("expecting Access-Reject; got code %d", response.Code)
+	}
+	if !strings.Contains(logged.String(), "handler failure") {
+		t.Fatalf("expecting panic to be logged; got %q", logged.String())
+	}
+
+	// the server keeps handling packets
+	response, err = client.Exchange(radius.New(radius.CodeAccessRequest, secret), addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Code != radius.CodeAccessAccept {
+		t.Fatalf("expecting Access-Accept; got code %d", response.Code)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	var handled int
+	handler := radius.RateLimit(0.001, 2)(radius.HandlerFunc(func(w radius.ResponseWriter, p *radius.Packet) {
+		handled++
+	}))
+	first := &testResponseWriter{
+		remoteAddr: &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 1000},
+	}
+	// the port does not identify the client
+	second := &testResponseWriter{
+		remoteAddr: &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 2000},
+	}
+	other := &testResponseWriter{
+		remoteAddr: &net.UDPAddr{IP: net.IPv4(192, 0, 2, 2), Port: 1000},
+	}
+	packet := radius.New(radius.CodeAccessRequest, nil)
+
+	handler.ServeRadius(first, packet)
+	handler.ServeRadius(second, packet)
+	handler.ServeRadius(first, packet)
+	if handled != 2 {
+		t.Fatalf("expecting 2 packets to be handled; got %d", handled)
+	}
+	handler.ServeRadius(other, packet)
+	if handled != 3 {
+		t.Fatal("expecting packet from another client to be handled")
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	limiter := radius.RateLimiter{
+		Rate:  20,
+		Burst: 3,
+	}
+	addr := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 1000}
+	for i := 0; i < 3; i++ {
+		if !limiter.Allow(addr) {
+			t.Fatalf("expecting packet %d to be allowed", i)
+		}
+	}
+	if limiter.Allow(addr) {
+		t.Fatal("expecting packet over burst to be dropped")
+	}
+
+	// tokens are replenished at Rate, and the state of idle clients expires
+	// without changing that behavior
+	time.Sleep(200 * time.Millisecond)
+	for i := 0; i < 3; i++ {
+		if !limiter.Allow(addr) {
+			t.Fatalf("expecting packet %d to be allowed after waiting", i)
+		}
+	}
+	if limiter.Allow(addr) {
+		t.Fatal("expecting packet over burst to be dropped after waiting")
+	}
+}
@@ -6,10 +6,36 @@ import (
 	"crypto/rand"
 	"encoding/binary"
 	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
 )
 
-// maximum RADIUS packet size
-const maxPacketSize = 4095
+// maximum length of the value of an attribute
+const maxAttributeValueLength = 253
+
+// MaxPacketSize is the maximum size of the RADIUS packets that are encoded,
+// parsed, and received. It defaults to 4096 bytes, the limit of RFC 2865;
+// some deployments, such as those with large EAP-TLS exchanges, allow larger
+// packets. Values greater than 65535 (the largest value of the Length field)
+// are treated as 65535.
+//
+// MaxPacketSize should only be changed before any packet is sent or
+// received.
+var MaxPacketSize = 4096
+
+// maxPacketSize returns the effective value of MaxPacketSize.
+func maxPacketSize() int {
+	switch {
+	case MaxPacketSize > 65535:
+		return 65535
+	case MaxPacketSize < 20:
+		return 20
+	}
+	return MaxPacketSize
+}
 
 // Code specifies the kind of RADIUS packet.
 type Code byte
@@ -27,7 +53,45 @@ const (
 	CodeReserved           Code = 255
 )
 
+// names of the codes, as used by Code.String
+var codeNames = map[Code]string{
+	CodeAccessRequest:      "Access-Request",
+	CodeAccessAccept:       "Access-Accept",
+	CodeAccessReject:       "Access-Reject",
+	CodeAccountingRequest:  "Accounting-Request",
+	CodeAccountingResponse: "Accounting-Response",
+	CodeAccessChallenge:    "Access-Challenge",
+	CodeStatusServer:       "Status-Server",
+	CodeStatusClient:       "Status-Client",
+	CodeDisconnectRequest:  "Disconnect-Request",
+	CodeDisconnectACK:      "Disconnect-ACK",
+	CodeDisconnectNAK:      "Disconnect-NAK",
+	CodeCoARequest:         "CoA-Request",
+	CodeCoAACK:             "CoA-ACK",
+	CodeCoANAK:             "CoA-NAK",
+	CodeReserved:           "Reserved",
+}
+
+// String returns the name of the code, such as "Access-Request", or its
+// number if it has no name.
+func (c Code) String() string {
+	if name, ok := codeNames[c]; ok {
+		return name
+	}
+	return strconv.Itoa(int(c))
+}
+
 // Packet defines a RADIUS packet.
+//
+// A Packet is not safe for concurrent use: it has no internal locking, and
+// methods that modify it, such as Add, Set, Remove, MoveToFront, and
+// SetResponseAuthenticator, must not be called while other goroutines use
+// the packet. Methods that only read it, such as Value, String, Attrs,
+// Encode, IsAuthentic, and VerifyResponse, may be called concurrently, as
+// long as no goroutine modifies the packet (or its attribute values) at the
+// same time. To hand a packet to several goroutines that modify it, give each
+// of them its own copy, made with Clone. A packet returned by ParseLazy is
+// only safe for concurrent use once Decode has been called.
 type Packet struct {
 	Code          Code
 	Identifier    byte
@@ -38,16 +102,81 @@ type Packet struct {
 	Dictionary *Dictionary
 
 	Attributes []*Attribute
+
+	// If true, Encode writes the attributes that have a tag (see
+	// Attribute.Tag) grouped by tag, for NASes that expect the attributes of
+	// each tunnel (RFC 2868) to be together: the groups are written where the
+	// first tagged attribute is, in the order in which their tags first
+	// appear, and the attributes of each group in the order in which they
+	// appear in Attributes. Untagged attributes keep their positions. If
+	// false, attributes are written in the order of Attributes.
+	GroupTaggedAttributes bool
+
+	// Copy of the wire data that the packet was parsed from, for packets that
+	// were returned by Parse or ParseStrict. It can be used to audit or verify
+	// the packet exactly as it was received, as encoding the packet again may
+	// not reproduce it byte for byte. It is not used by Encode, and is not
+	// updated when the packet is modified.
+	Raw []byte
+
+	// request authenticator that the attributes of a response were decoded
+	// with by ParseResponse; nil if they were decoded with Authenticator
+	decodedWith *[16]byte
+
+	// true while the packet is decoded by ParseInto, in which case codecs may
+	// return values that refer to the wire data rather than copies of it
+	aliased bool
+
+	// attributes of a packet returned by ParseLazy that have not been decoded
+	// yet, and the error that decoding them resulted in
+	lazy    *lazyAttributes
+	lazyErr error
 }
 
+// Rand is the source of the random data that is used for new packets (their
+// identifiers and request authenticators) and for the salts of
+// salt-encrypted attributes (see EncryptSalt). It defaults to
+// crypto/rand.Reader, and can be replaced to make tests deterministic, such
+// as to compare encrypted attributes with known ciphertext. It must be safe
+// for concurrent use.
+var Rand io.Reader = rand.Reader
+
 // New returns a new packet with the given code and secret. The identifier and
 // authenticator are filled with random data, and the dictionary is set to
 // Builtin. nil is returned if not enough random data could be generated.
+//
+// New is like NewPacket, but does not report why the packet could not be
+// created.
 func New(code Code, secret []byte) *Packet {
-	var buff [17]byte
-	if _, err := rand.Read(buff[:]); err != nil {
+	packet, err := NewPacket(code, secret)
+	if err != nil {
 		return nil
 	}
+	return packet
+}
+
+// NewPacket returns a new packet with the given code and secret, and the
+// dictionary set to Builtin. The identifier is read from Rand.
+//
+// The request authenticator of an Access-Request (or of any code other than
+// the ones below) is read from Rand as well. That of the following codes is
+// left as zeros, as it is calculated from the packet when it is encoded:
+//  CodeAccountingRequest
+//  CodeDisconnectRequest
+//  CodeCoARequest
+//
+// An error is returned if Rand fails, rather than creating a packet with a
+// predictable authenticator.
+func NewPacket(code Code, secret []byte) (*Packet, error) {
+	var buff [17]byte
+	random := buff[:]
+	switch code {
+	case CodeAccountingRequest, CodeDisconnectRequest, CodeCoARequest:
+		random = buff[:1]
+	}
+	if _, err := io.ReadFull(Rand, random); err != nil {
+		return nil, fmt.Errorf("radius: could not generate random data: %w", err)
+	}
 
 	packet := &Packet{
 		Code:       code,
@@ -56,65 +185,365 @@ func New(code Code, secret []byte) *Packet {
 		Dictionary: Builtin,
 	}
 	copy(packet.Authenticator[:], buff[1:])
-	return packet
+	return packet, nil
 }
 
 // Parse parses a RADIUS packet from wire data, using the given shared secret
 // and dictionary. nil and an error is returned if there is a problem parsing
 // the packet.
 //
+// Parse is lenient: the packet's Length field is not required to match the
+// length of data, and all of data is parsed as attributes. ParseStrict can be
+// used to reject such packets.
+//
+// Attributes that are not registered in the dictionary are kept, dropped, or
+// rejected, depending on its UnknownPolicy. If dictionary is nil, no
+// attribute is registered, and all are kept; see Packet.Reparse. Those that
+// are kept have their undecoded value, as []byte (or, for an unregistered
+// vendor attribute, a *VendorAttribute whose value is []byte), which Encode
+// writes back unchanged, so that a packet that is parsed and encoded again
+// (by a proxy, for example) does not lose them.
+//
 // Note: this function does not validate the authenticity of a packet.
 // Ensuring a packet's authenticity should be done using the IsAuthentic
 // method.
 func Parse(data, secret []byte, dictionary *Dictionary) (*Packet, error) {
-	if len(data) < 20 {
-		return nil, errors.New("radius: packet must be at least 20 bytes long")
+	if err := checkPacket(data); err != nil {
+		return nil, err
+	}
+	return parse(data, secret, dictionary)
+}
+
+// ParseInto is like Parse, but the packet is parsed into p, using p.Secret and
+// p.Dictionary, and the other fields of p are replaced. The backing array of
+// p.Attributes is reused if it is large enough.
+//
+// Unlike Parse, ParseInto does not copy buf: p.Raw is buf, and the []byte and
+// net.IP values that are decoded by the builtin codecs (such as those of
+// Class, Proxy-State and NAS-IP-Address) refer to buf. The caller must
+// therefore not modify buf, or reuse it for another packet, while p or any of
+// its values are in use. Parse should be used when that cannot be guaranteed.
+//
+// If an error is returned, the contents of p are unspecified.
+func ParseInto(buf []byte, p *Packet) error {
+	if err := checkPacket(buf); err != nil {
+		return err
 	}
+	p.aliased = true
+	err := p.decode(buf)
+	p.aliased = false
+	if err != nil {
+		return err
+	}
+	p.Raw = buf
+	return nil
+}
 
+// ParseResponse is like Parse, but data is parsed as a response to request,
+// with the request's secret and dictionary. Encrypted attributes of responses,
+// such as Tunnel-Password and MS-MPPE-Send-Key, are encrypted with the request
+// authenticator rather than the response authenticator, so it is used to
+// decode the attributes; p.Authenticator is the response authenticator that
+// was received, so that the response can then be verified with
+// VerifyResponse.
+func ParseResponse(data []byte, request *Packet) (*Packet, error) {
+	if err := checkPacket(data); err != nil {
+		return nil, err
+	}
 	packet := &Packet{
-		Code:       Code(data[0]),
-		Identifier: data[1],
-		Secret:     secret,
+		Secret:     request.Secret,
+		Dictionary: request.Dictionary,
+	}
+	// The attributes are decoded from a copy of data that has the request
+	// authenticator in place of the response authenticator, which is then
+	// restored in the copy that is kept as Raw.
+	decoded := append([]byte(nil), data...)
+	copy(decoded[4:20], request.Authenticator[:])
+	if err := packet.decode(decoded); err != nil {
+		return nil, err
+	}
+	copy(decoded[4:20], data[4:20])
+	copy(packet.Authenticator[:], data[4:20])
+	packet.Raw = decoded
+	packet.decodedWith = &request.Authenticator
+	return packet, nil
+}
+
+// Reparse decodes the attributes of p again with dictionary, which then
+// becomes p's dictionary. It allows a packet to be parsed in two phases, when
+// the dictionary that applies is only known after some of its attributes are
+// read: the packet is first parsed with a nil dictionary, which keeps the
+// undecoded value of every attribute, then reparsed once the dictionary is
+// chosen:
+//
+//  packet, err := radius.Parse(wire, secret, nil)
+//  ...
+//  var nasID []byte
+//  if attrs := packet.Attrs(32); len(attrs) > 0 { // NAS-Identifier
+//  	nasID = attrs[0].Value.([]byte)
+//  }
+//  err = packet.Reparse(tenants[string(nasID)])
+//
+// The attributes are encoded with the current dictionary of p, and decoded
+// from that encoding; p.Raw is not used, so it is not required, and changes
+// that were made to p.Attributes since it was parsed are kept. Encrypted
+// attributes are decoded with the same authenticator as when p was parsed
+// (the request authenticator, for a response that was parsed by
+// ParseResponse). If an error is returned, p is not modified.
+func (p *Packet) Reparse(dictionary *Dictionary) error {
+	if err := p.Decode(); err != nil {
+		return err
+	}
+	encoder := *p
+	if p.decodedWith != nil {
+		encoder.Authenticator = *p.decodedWith
+	}
+	data := make([]byte, 20, 20+len(p.Attributes)*8)
+	data[0] = byte(p.Code)
+	data[1] = p.Identifier
+	copy(data[4:20], encoder.Authenticator[:])
+	data, _, err := encoder.appendAttributes(data)
+	if err != nil {
+		return err
+	}
+	binary.BigEndian.PutUint16(data[2:4], uint16(len(data)))
+
+	decoded := &Packet{
+		Secret:     p.Secret,
 		Dictionary: dictionary,
 	}
+	if err := decoded.decode(data); err != nil {
+		return err
+	}
+	p.Dictionary = dictionary
+	p.Attributes = decoded.Attributes
+	return nil
+}
 
-	length := binary.BigEndian.Uint16(data[2:4])
-	if length < 20 || length > maxPacketSize {
-		return nil, errors.New("radius: invalid packet length")
+// checkPacket returns an error if data is too short to be a packet, its
+// Length field is invalid, or its attributes are truncated.
+func checkPacket(data []byte) error {
+	if len(data) < 20 {
+		return errors.New("radius: packet must be at least 20 bytes long")
 	}
 
-	copy(packet.Authenticator[:], data[4:20])
+	length := int(binary.BigEndian.Uint16(data[2:4]))
+	if length < 20 || length > maxPacketSize() {
+		return errors.New("radius: invalid packet length")
+	}
 
-	// Attributes
 	attributes := data[20:]
 	for len(attributes) > 0 {
 		if len(attributes) < 2 {
-			return nil, errors.New("radius: attribute must be at least 2 bytes long")
+			return errors.New("radius: attribute must be at least 2 bytes long")
 		}
-
 		attrLength := attributes[1]
-		if attrLength < 1 || attrLength > 253 || len(attributes) < int(attrLength) {
-			return nil, errors.New("radius: invalid attribute length")
+		if attrLength < 2 || len(attributes) < int(attrLength) {
+			return errors.New("radius: invalid attribute length")
+		}
+		attributes = attributes[attrLength:]
+	}
+	return nil
+}
+
+// ParseError is returned by ParseStrict when a packet is malformed.
+type ParseError struct {
+	// Offset, from the start of the packet, of the field that is invalid.
+	Offset int
+	// Description of the problem.
+	Reason string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("radius: %s (offset %d)", e.Reason, e.Offset)
+}
+
+// ParseStrict is like Parse, but the structure of the packet is validated
+// before any attribute is decoded. A *ParseError is returned if:
+//  - the Length field is not between 20 and MaxPacketSize, or does not match
+//    the length of data
+//  - an attribute's Length field is less than 2, or the attribute runs past
+//    the end of the packet
+//  - an attribute has no value (except EAP-Message, whose empty value is used
+//    to signal EAP-Start, and attributes whose codec allows empty values; see
+//    AttributeEmptyChecker)
+func ParseStrict(data, secret []byte, dictionary *Dictionary) (*Packet, error) {
+	if len(data) < 20 {
+		return nil, &ParseError{Offset: 0, Reason: "packet must be at least 20 bytes long"}
+	}
+
+	length := int(binary.BigEndian.Uint16(data[2:4]))
+	if length < 20 || length > maxPacketSize() {
+		return nil, &ParseError{Offset: 2, Reason: "invalid packet length"}
+	}
+	if length != len(data) {
+		return nil, &ParseError{Offset: 2, Reason: "packet length does not match the received data"}
+	}
+
+	for offset := 20; offset < length; {
+		if length-offset < 2 {
+			return nil, &ParseError{Offset: offset, Reason: "attribute must be at least 2 bytes long"}
 		}
+		attrLength := int(data[offset+1])
+		if attrLength < 2 || offset+attrLength > length {
+			return nil, &ParseError{Offset: offset + 1, Reason: "invalid attribute length"}
+		}
+		if attrLength == 2 && !allowsEmpty(dictionary, data[offset]) {
+			return nil, &ParseError{Offset: offset, Reason: "attribute has no value"}
+		}
+		offset += attrLength
+	}
+
+	return parse(data, secret, dictionary)
+}
+
+// allowsEmpty reports whether ParseStrict accepts an empty attribute of type
+// t: if its codec in dictionary allows it, or, if the codec does not declare
+// it, if the attribute is an EAP-Message, whose empty value signals EAP-Start.
+func allowsEmpty(dictionary *Dictionary, t byte) bool {
+	if dictionary != nil {
+		if allowed, declared := dictionary.typeEntry(t).allowsEmpty(); declared {
+			return allowed
+		}
+	}
+	return t == attributeTypeEAPMessage
+}
+
+// parse decodes a packet whose attributes have already been validated.
+func parse(data, secret []byte, dictionary *Dictionary) (*Packet, error) {
+	packet := &Packet{
+		Secret:     secret,
+		Dictionary: dictionary,
+	}
+	if err := packet.decode(data); err != nil {
+		return nil, err
+	}
+	packet.Raw = append([]byte(nil), data...)
+	return packet, nil
+}
+
+// decode sets the header and attributes of the packet to those of data, which
+// have already been validated, using the packet's secret and dictionary.
+func (p *Packet) decode(data []byte) error {
+	p.Code = Code(data[0])
+	p.Identifier = data[1]
+	copy(p.Authenticator[:], data[4:20])
+	p.decodedWith = nil
+	p.lazy = nil
+	p.lazyErr = nil
+	return p.decodeAttributes(data[20:], nil)
+}
+
+// decodeAttributes sets the attributes of the packet to those of attributes,
+// the wire data of attributes that have already been validated. The
+// attributes of decoded, keyed by their offset in attributes, are used
+// instead of decoding the attributes at those offsets again.
+func (p *Packet) decodeAttributes(attributes []byte, decoded map[int]*Attribute) error {
+	all := attributes
+	dictionary := p.Dictionary
+	policy := dictionary.UnknownPolicy()
+
+	// The attributes are allocated in a single block, rather than one at a
+	// time, to reduce the cost of parsing large packets.
+	count := 0
+	for rest := attributes; len(rest) > 0; rest = rest[rest[1]:] {
+		count++
+	}
+	var block []Attribute
+	p.Attributes = p.Attributes[:0]
+	if count > 0 {
+		block = make([]Attribute, 0, count)
+		if cap(p.Attributes) < count {
+			p.Attributes = make([]*Attribute, 0, count)
+		}
+	}
+	for len(attributes) > 0 {
+		attrLength := attributes[1]
 		attrType := attributes[0]
 		attrValue := attributes[2:attrLength]
+		if attr := decoded[len(all)-len(attributes)]; attr != nil {
+			p.Attributes = append(p.Attributes, attr)
+			attributes = attributes[attrLength:]
+			continue
+		}
 
-		codec := dictionary.Codec(attrType)
-		decoded, err := codec.Decode(packet, attrValue)
+		if attrType == attributeTypeVendorSpecific || isLongExtendedType(attrType) {
+			attr, n, ok, err := parseContinuedAttribute(p, attributes)
+			if err != nil {
+				return err
+			}
+			if ok {
+				keep := true
+				if policy != UnknownKeep {
+					if attr.Value, keep, err = dictionary.applyUnknownPolicy(policy, attrType, attr.Value); err != nil {
+						return err
+					}
+				}
+				if keep {
+					p.Attributes = append(p.Attributes, attr)
+				}
+				attributes = attributes[n:]
+				continue
+			}
+		}
+
+		entry := dictionary.typeEntry(attrType)
+		attributes = attributes[attrLength:]
+		if entry != nil && entry.Concat {
+			// the value continues in the consecutive attributes of the type
+			for len(attributes) > 0 && attributes[0] == attrType {
+				// The full slice expression makes the first append copy
+				// the value, rather than overwrite the data that follows.
+				attrValue = append(attrValue[:len(attrValue):len(attrValue)], attributes[2:attributes[1]]...)
+				attributes = attributes[attributes[1]:]
+			}
+		}
+		tag, decoded, err := entry.decode(p, attrValue)
 		if err != nil {
-			return nil, err
+			return err
+		}
+		if policy != UnknownKeep {
+			var keep bool
+			if decoded, keep, err = dictionary.applyUnknownPolicy(policy, attrType, decoded); err != nil {
+				return err
+			} else if !keep {
+				continue
+			}
 		}
-		attr := &Attribute{
+		block = append(block, Attribute{
 			Type:  attrType,
+			Tag:   tag,
 			Value: decoded,
-		}
-		packet.Attributes = append(packet.Attributes, attr)
-		attributes = attributes[attrLength:]
+		})
+		p.Attributes = append(p.Attributes, &block[len(block)-1])
 	}
 
 	// TODO: validate that the given packet (by code) has all the required attributes, etc.
 
-	return packet, nil
+	return nil
+}
+
+// wireValue returns a copy of value, which is part of the wire data that the
+// packet is decoded from, or value itself if the packet is being parsed by
+// ParseInto.
+func (p *Packet) wireValue(value []byte) []byte {
+	if p != nil && p.aliased {
+		return value
+	}
+	v := make([]byte, len(value))
+	copy(v, value)
+	return v
+}
+
+// parseContinuedAttribute parses an attribute whose value is continued over
+// consecutive attributes, at the start of attributes: a WiMAX vendor attribute,
+// or a long extended attribute. ok is false if the first attribute does not
+// start such an attribute.
+func parseContinuedAttribute(packet *Packet, attributes []byte) (attr *Attribute, n int, ok bool, err error) {
+	if attributes[0] == attributeTypeVendorSpecific {
+		return parseContinuedVendorAttribute(packet, attributes)
+	}
+	return parseLongExtendedAttribute(packet, attributes)
 }
 
 // IsAuthentic returns if the packet is an authenticate response to the given
@@ -125,24 +554,44 @@ func Parse(data, secret []byte, dictionary *Dictionary) (*Packet, error) {
 //      CodeAccountingRequest
 //      CodeAccountingResponse
 //      CodeAccessChallenge
+//      CodeDisconnectRequest
+//      CodeDisconnectACK
+//      CodeDisconnectNAK
+//      CodeCoARequest
+//      CodeCoAACK
+//      CodeCoANAK
 //  - p.Authenticator contains the calculated authenticator
+//
+// The authenticator of request must be the one that was sent on the wire. For
+// an Accounting-Request, this is the calculated authenticator (see
+// AccountingRequestAuthenticator) rather than the one stored in the packet
+// before encoding.
+//
+// If p was parsed, the authenticator is calculated over the attributes as
+// they were received (see Raw), rather than over p.Attributes.
 func (p *Packet) IsAuthentic(request *Packet) bool {
 	switch p.Code {
-	case CodeAccessAccept, CodeAccessReject, CodeAccountingRequest, CodeAccessChallenge:
-		wire, err := p.Encode()
+	case CodeAccessAccept, CodeAccessReject, CodeAccountingRequest, CodeAccountingResponse, CodeAccessChallenge,
+		CodeDisconnectRequest, CodeDisconnectACK, CodeDisconnectNAK, CodeCoARequest, CodeCoAACK, CodeCoANAK:
+		attrs, _, err := p.authenticatedAttributes()
 		if err != nil {
 			return false
 		}
 
-		hash := md5.New()
-		hash.Write(wire[0:4])
-		if p.Code == CodeAccountingRequest {
+		var header [4]byte
+		header[0] = byte(p.Code)
+		header[1] = p.Identifier
+		binary.BigEndian.PutUint16(header[2:4], uint16(20+len(attrs)))
+
+		hash := newMD5()
+		hash.Write(header[:])
+		if p.Code == CodeAccountingRequest || p.Code == CodeDisconnectRequest || p.Code == CodeCoARequest {
 			var nul [16]byte
 			hash.Write(nul[:])
 		} else {
 			hash.Write(request.Authenticator[:])
 		}
-		hash.Write(wire[20:])
+		hash.Write(attrs)
 		hash.Write(request.Secret)
 
 		var sum [md5.Size]byte
@@ -151,29 +600,315 @@ func (p *Packet) IsAuthentic(request *Packet) bool {
 	return false
 }
 
+// ErrSecretMismatch is returned by Packet.VerifyResponse when the Identifier
+// of a response matches its request, but its authenticator does not. This
+// usually means that the client and server have different secrets. It is also
+// returned by Client.Exchange when only such responses were received.
+var ErrSecretMismatch = errors.New("radius: invalid response authenticator (secret mismatch?)")
+
+// VerifyResponse verifies that p is an authentic response to request, as
+// described in RFC 2865 section 3. nil is returned if the Identifier of p
+// matches request, and its response authenticator is the MD5 hash of the
+// response, the request authenticator, and the request's secret. If p has a
+// Message-Authenticator attribute, it is verified too.
+//
+// As with IsAuthentic, the authenticator of request must be the one that was
+// sent on the wire.
+func (p *Packet) VerifyResponse(request *Packet) error {
+	switch p.Code {
+	case CodeAccessAccept, CodeAccessReject, CodeAccountingResponse, CodeAccessChallenge,
+		CodeDisconnectACK, CodeDisconnectNAK, CodeCoAACK, CodeCoANAK:
+	default:
+		return errors.New("radius: packet is not a response")
+	}
+	if p.Identifier != request.Identifier {
+		return errors.New("radius: response Identifier does not match request")
+	}
+	if !p.IsAuthentic(request) {
+		return ErrSecretMismatch
+	}
+	if p.Len(attributeTypeMessageAuthenticator) > 0 {
+		// The HMAC of a response is calculated with the request authenticator.
+		if err := p.verifyMessageAuthenticator(request.Authenticator); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Response returns a new response packet to the request p, with the given
+// code. The response has the request's Identifier, Secret, and Dictionary,
+// and the request's authenticator, from which the response authenticator is
+// calculated when it is encoded.
+//
+// An error is returned if code is not a valid response to the request. An
+// Accounting-Response can only be created for an Accounting-Request that has
+// an Acct-Status-Type attribute, or for a Status-Server.
+func (p *Packet) Response(code Code) (*Packet, error) {
+	switch code {
+	case CodeAccessAccept:
+		if p.Code != CodeAccessRequest && p.Code != CodeStatusServer {
+			return nil, errors.New("radius: packet is not an Access-Request or Status-Server")
+		}
+	case CodeAccessReject, CodeAccessChallenge:
+		if p.Code != CodeAccessRequest {
+			return nil, errors.New("radius: packet is not an Access-Request")
+		}
+	case CodeAccountingResponse:
+		if p.Code == CodeStatusServer {
+			break
+		}
+		if err := checkAccountingRequest(p); err != nil {
+			return nil, err
+		}
+	case CodeDisconnectACK, CodeDisconnectNAK:
+		if p.Code != CodeDisconnectRequest {
+			return nil, errors.New("radius: packet is not a Disconnect-Request")
+		}
+	case CodeCoAACK, CodeCoANAK:
+		if p.Code != CodeCoARequest {
+			return nil, errors.New("radius: packet is not a CoA-Request")
+		}
+	default:
+		return nil, errors.New("radius: invalid response code")
+	}
+	return &Packet{
+		Code:          code,
+		Identifier:    p.Identifier,
+		Authenticator: p.Authenticator,
+		Secret:        p.Secret,
+		Dictionary:    p.Dictionary,
+	}, nil
+}
+
+// SetResponseAuthenticator prepares the response p to be encoded as a
+// response to request, which may be a different request from the one that p
+// answered, such as when a proxy relays a response that it received from
+// another server: p is given the request's Identifier, authenticator, and
+// Secret, so that Encode calculates the response authenticator (and the
+// Message-Authenticator, and encrypts attributes such as Tunnel-Password) for
+// that request. Raw is cleared, as it is no longer what p encodes to.
+//
+//  upstream, err := radius.ParseResponse(data, forwarded)
+//  // check upstream.VerifyResponse(forwarded), modify upstream
+//  upstream.SetResponseAuthenticator(request)
+//  wire, err := upstream.Encode()
+func (p *Packet) SetResponseAuthenticator(request *Packet) {
+	p.Identifier = request.Identifier
+	p.Authenticator = request.Authenticator
+	p.Secret = request.Secret
+	p.Raw = nil
+}
+
+// Clone returns a deep copy of the packet. The attributes, and their []byte,
+// net.IP, and vendor attribute values, are copied, so that the copy can be
+// modified without affecting the original. The Secret and Dictionary are
+// shared with the original.
+func (p *Packet) Clone() *Packet {
+	p.decodeLazy()
+	clone := *p
+	if p.Raw != nil {
+		clone.Raw = append([]byte(nil), p.Raw...)
+	}
+	if p.Attributes != nil {
+		clone.Attributes = make([]*Attribute, len(p.Attributes))
+		for i, attr := range p.Attributes {
+			clone.Attributes[i] = &Attribute{
+				Type:  attr.Type,
+				Tag:   attr.Tag,
+				Value: cloneValue(attr.Value),
+			}
+		}
+	}
+	return &clone
+}
+
+// cloneValue returns a deep copy of an attribute value, if it is one of the
+// mutable value types that are used by the builtin codecs.
+func cloneValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case []byte:
+		return append([]byte(nil), v...)
+	case net.IP:
+		return append(net.IP(nil), v...)
+	case *ExtendedAttribute:
+		return &ExtendedAttribute{
+			ExtendedType: v.ExtendedType,
+			Value:        cloneValue(v.Value),
+		}
+	case *VendorAttribute:
+		return &VendorAttribute{
+			VendorID: v.VendorID,
+			Type:     v.Type,
+			Value:    cloneValue(v.Value),
+		}
+	case []*VendorAttribute:
+		clone := make([]*VendorAttribute, len(v))
+		for i, vendorAttr := range v {
+			clone[i] = cloneValue(vendorAttr).(*VendorAttribute)
+		}
+		return clone
+	}
+	return value
+}
+
 // ClearAttributes removes all of the packet's attributes.
 func (p *Packet) ClearAttributes() {
 	p.Attributes = nil
+	p.lazy = nil
+	p.lazyErr = nil
 }
 
 // Value returns the value of the first attribute whose dictionary name matches
 // the given name. nil is returned if no such attribute exists.
 func (p *Packet) Value(name string) interface{} {
-	if attr := p.Attr(name); attr != nil {
-		return attr.Value
+	if _, value, _ := p.lookup(name); value != nil {
+		return *value
 	}
 	return nil
 }
 
 // Attr returns the first attribute whose dictionary name matches the given
 // name. nil is returned if no such attribute exists.
+//
+// If name is a vendor-specific or extended attribute, the attribute that
+// carries it is returned.
 func (p *Packet) Attr(name string) *Attribute {
+	attr, _, _ := p.lookup(name)
+	return attr
+}
+
+// Attrs returns all of the packet's attributes with the given type, in the
+// order in which they appear in the packet. (The method cannot be named
+// Attributes, as that is the name of the field that holds them.)
+func (p *Packet) Attrs(t byte) []*Attribute {
+	p.decodeLazy()
+	var attrs []*Attribute
 	for _, attr := range p.Attributes {
-		if attrName, ok := p.Dictionary.Name(attr.Type); ok && attrName == name {
-			return attr
+		if attr.Type == t {
+			attrs = append(attrs, attr)
+		}
+	}
+	return attrs
+}
+
+// MoveToFront moves the packet's attributes with the given type before all of
+// its other attributes. The order of the moved attributes, and of the other
+// attributes, is preserved.
+func (p *Packet) MoveToFront(t byte) {
+	p.moveAttributes(t, true)
+}
+
+// MoveToEnd moves the packet's attributes with the given type after all of
+// its other attributes. The order of the moved attributes, and of the other
+// attributes, is preserved. The Message-Authenticator stays last when the
+// packet is encoded.
+func (p *Packet) MoveToEnd(t byte) {
+	p.moveAttributes(t, false)
+}
+
+func (p *Packet) moveAttributes(t byte, front bool) {
+	p.decodeLazy()
+	moved := make([]*Attribute, 0, len(p.Attributes))
+	others := make([]*Attribute, 0, len(p.Attributes))
+	for _, attr := range p.Attributes {
+		if attr.Type == t {
+			moved = append(moved, attr)
+		} else {
+			others = append(others, attr)
+		}
+	}
+	if front {
+		p.Attributes = append(moved, others...)
+	} else {
+		p.Attributes = append(others, moved...)
+	}
+}
+
+// Len returns the number of the packet's attributes with the given type.
+func (p *Packet) Len(t byte) int {
+	p.decodeLazy()
+	var n int
+	for _, attr := range p.Attributes {
+		if attr.Type == t {
+			n++
+		}
+	}
+	return n
+}
+
+// Gets returns the values of all of the attributes whose dictionary name
+// matches the given name, in the order in which they appear in the packet. An
+// error is returned if name is not in the packet's dictionary.
+func (p *Packet) Gets(name string) ([]interface{}, error) {
+	entry := p.Dictionary.entry(name)
+	if entry == nil {
+		return nil, fmt.Errorf("%w: %s", ErrAttributeNotRegistered, name)
+	}
+	var values []interface{}
+	p.eachValue(entry, func(attr *Attribute, value *interface{}) bool {
+		values = append(values, *value)
+		return true
+	})
+	return values, nil
+}
+
+// lookup returns the first attribute whose dictionary name matches the given
+// name, along with a pointer to its value and its dictionary entry. If name is
+// a vendor-specific or extended attribute, the attribute that carries it is
+// returned, and value points to the value of the vendor or extended
+// attribute.
+func (p *Packet) lookup(name string) (attr *Attribute, value *interface{}, entry *DictionaryEntry) {
+	entry = p.Dictionary.entry(name)
+	if entry == nil {
+		return nil, nil, nil
+	}
+	p.eachValue(entry, func(a *Attribute, v *interface{}) bool {
+		attr, value = a, v
+		return false
+	})
+	return attr, value, entry
+}
+
+// eachValue calls fn for each of the packet's attributes that matches entry,
+// in order, with a pointer to its value, until fn returns false. For a
+// vendor-specific or extended entry, attr is the attribute that carries the
+// matching vendor or extended attribute, whose value is passed to fn.
+func (p *Packet) eachValue(entry *DictionaryEntry, fn func(attr *Attribute, value *interface{}) bool) {
+	if p.lazy != nil {
+		if p.lazy.eachValue(p.Dictionary, entry, fn) {
+			return
+		}
+		p.Decode()
+	}
+	for _, attr := range p.Attributes {
+		switch {
+		case entry.Vendor != 0:
+			if attr.Type != attributeTypeVendorSpecific {
+				continue
+			}
+			for _, vendorAttr := range vendorAttributes(attr.Value) {
+				if vendorAttr.VendorID == entry.Vendor && vendorAttr.Type == entry.Type {
+					if !fn(attr, &vendorAttr.Value) {
+						return
+					}
+				}
+			}
+		case entry.ExtendedType != 0:
+			extended, ok := attr.Value.(*ExtendedAttribute)
+			if ok && attr.Type == entry.Type && extended.ExtendedType == entry.ExtendedType {
+				if !fn(attr, &extended.Value) {
+					return
+				}
+			}
+		default:
+			if attr.Type == entry.Type {
+				if !fn(attr, &attr.Value) {
+					return
+				}
+			}
 		}
 	}
-	return nil
 }
 
 // String returns the string representation of the value of the first attribute
@@ -184,23 +919,32 @@ func (p *Packet) Attr(name string) *Attribute {
 //    returned
 //  - If the attribute's Codec implements AttributeStringer,
 //    AttributeStringer.String(value) is returned
+//  - If the value is a uint32 that has a registered name (see
+//    Dictionary.RegisterValue), the name is returned
 //  - If the value implements fmt.Stringer, value.String() is returned
 //  - If the value is string, itself is returned
 //  - If the value is []byte, string(value) is returned
 //  - Otherwise, "" is returned
 func (p *Packet) String(name string) string {
-	attr := p.Attr(name)
+	attr, ref, entry := p.lookup(name)
 	if attr == nil {
 		return ""
 	}
-	value := attr.Value
+	value := *ref
+	codec := entry.Codec
 
-	if codec := p.Dictionary.Codec(attr.Type); codec != nil {
+	if codec != nil {
 		if stringer, ok := codec.(AttributeStringer); ok {
 			return stringer.String(value)
 		}
 	}
 
+	if integer, ok := value.(uint32); ok {
+		if valueName, ok := p.Dictionary.ValueName(name, integer); ok {
+			return valueName
+		}
+	}
+
 	if stringer, ok := value.(interface {
 		String() string
 	}); ok {
@@ -216,8 +960,58 @@ func (p *Packet) String(name string) string {
 	return ""
 }
 
+// GetString returns the value of the first attribute whose dictionary name
+// matches the given name, if it was decoded to a string (as with
+// AttributeText). ok is false if there is no such attribute, or its value is
+// of another type.
+func (p *Packet) GetString(name string) (value string, ok bool) {
+	value, ok = p.Value(name).(string)
+	return
+}
+
+// GetInt is like GetString, but for uint32 values (as with AttributeInteger).
+func (p *Packet) GetInt(name string) (value uint32, ok bool) {
+	value, ok = p.Value(name).(uint32)
+	return
+}
+
+// GetIP is like GetString, but for net.IP values (as with AttributeAddress).
+func (p *Packet) GetIP(name string) (value net.IP, ok bool) {
+	value, ok = p.Value(name).(net.IP)
+	return
+}
+
+// GetEnum returns the value of the first attribute whose dictionary name
+// matches the given name, if it was decoded to a uint32, along with the name
+// of the value (see Dictionary.RegisterValue). valueName is empty if the
+// value has no registered name. ok is false if there is no such attribute, or
+// its value is not a uint32.
+func (p *Packet) GetEnum(name string) (valueName string, value uint32, ok bool) {
+	value, ok = p.Value(name).(uint32)
+	if ok {
+		valueName, _ = p.Dictionary.ValueName(name, value)
+	}
+	return
+}
+
+// GetBytes is like GetString, but for []byte values (as with
+// AttributeString).
+func (p *Packet) GetBytes(name string) (value []byte, ok bool) {
+	value, ok = p.Value(name).([]byte)
+	return
+}
+
 // Add adds an attribute whose dictionary name matches the given name.
+//
+// An EAP-Message that is longer than 253 bytes is split over several
+// EAP-Message attributes, as with SetEAPMessage.
 func (p *Packet) Add(name string, value interface{}) error {
+	if data, ok := value.([]byte); ok && len(data) > maxAttributeValueLength {
+		if entry := p.Dictionary.entry(name); entry != nil && entry.Vendor == 0 && entry.Type == attributeTypeEAPMessage {
+			p.addEAPMessage(data)
+			return nil
+		}
+	}
 	attr, err := p.Dictionary.Attr(name, value)
 	if err != nil {
 		return err
@@ -228,28 +1022,106 @@ func (p *Packet) Add(name string, value interface{}) error {
 
 // AddAttr adds the given attribute to the packet.
 func (p *Packet) AddAttr(attribute *Attribute) {
+	p.decodeLazy()
 	p.Attributes = append(p.Attributes, attribute)
 }
 
-// Set sets the value of the first attribute whose dictionary name matches the
-// given name. If no such attribute exists, a new attribute is added
+// Set replaces all of the attributes whose dictionary name matches the given
+// name with a single attribute that has the given value. The new value takes
+// the place of the first matching attribute, and the others are removed; if
+// there are none, a new attribute is added.
+//
+// If value is a TaggedValue, only attributes with the same tag are replaced.
 func (p *Packet) Set(name string, value interface{}) error {
-	for _, attr := range p.Attributes {
-		if attrName, ok := p.Dictionary.Name(attr.Type); ok && attrName == name {
-			codec := p.Dictionary.Codec(attr.Type)
-			if transformer, ok := codec.(AttributeTransformer); ok {
-				transformed, err := transformer.Transform(value)
-				if err != nil {
-					return err
+	p.decodeLazy()
+	attr, err := p.Dictionary.Attr(name, value)
+	if err != nil {
+		return err
+	}
+	entry := p.Dictionary.entry(name)
+	var replaced bool
+	attrs := p.Attributes[:0]
+	for _, existing := range p.Attributes {
+		switch {
+		case entry.Vendor != 0:
+			if existing.Type == attributeTypeVendorSpecific && !setVendorValue(existing, entry, attr.Value.(*VendorAttribute).Value, &replaced) {
+				continue
+			}
+		case entry.ExtendedType != 0:
+			if extended, ok := existing.Value.(*ExtendedAttribute); ok && existing.Type == entry.Type && extended.ExtendedType == entry.ExtendedType {
+				if replaced {
+					continue
 				}
-				attr.Value = transformed
-				return nil
+				existing.Value = attr.Value
+				replaced = true
 			}
-			attr.Value = value
-			return nil
+		default:
+			if existing.Type == attr.Type && existing.Tag == attr.Tag {
+				if replaced {
+					continue
+				}
+				existing.Value = attr.Value
+				replaced = true
+			}
+		}
+		attrs = append(attrs, existing)
+	}
+	p.Attributes = attrs
+	if !replaced {
+		p.AddAttr(attr)
+	}
+	return nil
+}
+
+// setVendorValue sets the value of the first vendor attribute carried by attr
+// that matches entry, unless replaced is already true, and removes the other
+// matching vendor attributes. false is returned if attr no longer carries any
+// vendor attributes, and should be removed.
+func setVendorValue(attr *Attribute, entry *DictionaryEntry, value interface{}, replaced *bool) bool {
+	vendorAttrs := vendorAttributes(attr.Value)
+	kept := make([]*VendorAttribute, 0, len(vendorAttrs))
+	for _, vendorAttr := range vendorAttrs {
+		if vendorAttr.VendorID == entry.Vendor && vendorAttr.Type == entry.Type {
+			if *replaced {
+				continue
+			}
+			vendorAttr.Value = value
+			*replaced = true
+		}
+		kept = append(kept, vendorAttr)
+	}
+	switch {
+	case len(kept) == len(vendorAttrs):
+		return true
+	case len(kept) == 0:
+		return false
+	}
+	attr.Value = kept
+	return true
+}
+
+// Has returns if the packet has an attribute with the given type.
+func (p *Packet) Has(t byte) bool {
+	p.decodeLazy()
+	for _, attr := range p.Attributes {
+		if attr.Type == t {
+			return true
+		}
+	}
+	return false
+}
+
+// Remove removes all of the packet's attributes with the given type. The order
+// of the other attributes is preserved.
+func (p *Packet) Remove(t byte) {
+	p.decodeLazy()
+	attrs := p.Attributes[:0]
+	for _, attr := range p.Attributes {
+		if attr.Type != t {
+			attrs = append(attrs, attr)
 		}
 	}
-	return p.Add(name, value)
+	p.Attributes = attrs
 }
 
 // PAP returns the User-Name and User-Password attributes of an Access-Request
@@ -284,57 +1156,323 @@ func (p *Packet) PAP() (username, password string, ok bool) {
 	return
 }
 
+// encodeAttributes encodes the packet's attributes to wire format. The value
+// of a Message-Authenticator attribute is written as-is if it is a 16 byte
+// []byte, and as zeros otherwise. The offset of its value in the returned
+// bytes is also returned, or -1 if the packet has no Message-Authenticator.
+func (p *Packet) encodeAttributes() ([]byte, int, error) {
+	return p.appendAttributes(nil)
+}
+
+// authenticatedAttributes is like encodeAttributes, but if the packet was
+// parsed, the attributes are those of p.Raw, as they were received: encrypted
+// attributes, such as those with a random salt, are not encoded again to the
+// same bytes. The returned slice can be modified.
+func (p *Packet) authenticatedAttributes() ([]byte, int, error) {
+	if len(p.Raw) < 20 {
+		return p.encodeAttributes()
+	}
+	attrs := append([]byte(nil), p.Raw[20:]...)
+	messageAuthenticator := -1
+	for i := 0; i+2 <= len(attrs) && attrs[i+1] >= 2; i += int(attrs[i+1]) {
+		if attrs[i] == attributeTypeMessageAuthenticator && attrs[i+1] == 2+md5.Size && messageAuthenticator < 0 {
+			messageAuthenticator = i + 2
+		}
+	}
+	return attrs, messageAuthenticator, nil
+}
+
+// appendAttributes is like encodeAttributes, but appends the attributes to
+// dst. The returned offset is from the start of dst.
+func (p *Packet) appendAttributes(dst []byte) ([]byte, int, error) {
+	messageAuthenticator := -1
+	for _, attr := range p.encodeOrder() {
+		var wire []byte
+		values, continued, err := p.encodeContinuedAttribute(attr)
+		if err != nil {
+			return nil, -1, err
+		}
+		if continued {
+			for _, value := range values {
+				dst = append(dst, attr.Type, byte(len(value)+2))
+				dst = append(dst, value...)
+			}
+			continue
+		}
+		if attr.Type == attributeTypeMessageAuthenticator {
+			wire, _ = attr.Value.([]byte)
+			if len(wire) != md5.Size {
+				var zeros [md5.Size]byte
+				wire = zeros[:]
+			}
+			messageAuthenticator = len(dst) + 2
+		} else if codec := p.Dictionary.taggedCodec(attr.Type); codec != nil {
+			wire, err = codec.EncodeTagged(p, attr.Tag, attr.Value)
+		} else {
+			wire, err = p.Dictionary.Codec(attr.Type).Encode(p, attr.Value)
+		}
+		if err != nil {
+			return nil, -1, err
+		}
+		if len(wire) > maxAttributeValueLength {
+			if entry := p.Dictionary.typeEntry(attr.Type); entry != nil && entry.Concat {
+				for len(wire) > maxAttributeValueLength {
+					dst = append(dst, attr.Type, maxAttributeValueLength+2)
+					dst = append(dst, wire[:maxAttributeValueLength]...)
+					wire = wire[maxAttributeValueLength:]
+				}
+				dst = append(dst, attr.Type, byte(len(wire)+2))
+				dst = append(dst, wire...)
+				continue
+			}
+			err := fmt.Errorf("radius: encoded %s attribute is too long (%d bytes; the maximum is %d)", p.Dictionary.typeName(attr.Type), len(wire), maxAttributeValueLength)
+			if attr.Type == attributeTypeEAPMessage {
+				err = fmt.Errorf("%w; use SetEAPMessage to split it", err)
+			}
+			return nil, -1, err
+		}
+		dst = append(dst, attr.Type, byte(len(wire)+2))
+		dst = append(dst, wire...)
+	}
+	return dst, messageAuthenticator, nil
+}
+
+// encodeOrder returns the attributes of p in the order that they are encoded
+// (see GroupTaggedAttributes).
+func (p *Packet) encodeOrder() []*Attribute {
+	if !p.GroupTaggedAttributes {
+		return p.Attributes
+	}
+	var tags []byte
+	for _, attr := range p.Attributes {
+		if attr.Tag != 0 && bytes.IndexByte(tags, attr.Tag) < 0 {
+			tags = append(tags, attr.Tag)
+		}
+	}
+	if len(tags) < 2 {
+		return p.Attributes
+	}
+	ordered := make([]*Attribute, 0, len(p.Attributes))
+	grouped := false
+	for _, attr := range p.Attributes {
+		if attr.Tag == 0 {
+			ordered = append(ordered, attr)
+			continue
+		}
+		if grouped {
+			continue
+		}
+		grouped = true
+		for _, tag := range tags {
+			for _, tagged := range p.Attributes {
+				if tagged.Tag == tag {
+					ordered = append(ordered, tagged)
+				}
+			}
+		}
+	}
+	return ordered
+}
+
+// encodeContinuedAttribute encodes an attribute whose value may be continued
+// over several attributes (a WiMAX vendor attribute, or a long extended
+// attribute) into the values of the attributes that carry it. ok is false if
+// attr is not such an attribute.
+func (p *Packet) encodeContinuedAttribute(attr *Attribute) (values [][]byte, ok bool, err error) {
+	if vendorAttr, isVendor := attr.Value.(*VendorAttribute); isVendor && attr.Type == attributeTypeVendorSpecific {
+		return p.encodeContinuedVendorAttribute(vendorAttr)
+	}
+	if isLongExtendedType(attr.Type) {
+		return p.encodeLongExtendedAttribute(attr)
+	}
+	return nil, false, nil
+}
+
 // Encode encodes the packet to wire format. If there is an error encoding the
 // packet, nil and an error is returned.
+//
+// If the packet contains a Message-Authenticator attribute, its value is
+// calculated after all of the other attributes have been encoded, and before
+// the packet's response authenticator is calculated. A Status-Server packet
+// must contain a Message-Authenticator attribute; one is included in the
+// encoded packet if p does not have one.
+//
+// Attributes are encoded in the order in which they appear in p.Attributes
+// (see MoveToFront and MoveToEnd), or grouped by tag if
+// GroupTaggedAttributes is set, except for the Message-Authenticator, which
+// is always encoded last.
+//
+// The request authenticator of a response is p.Authenticator (as set by
+// Response, or by SetResponseAuthenticator), from which its response
+// authenticator is calculated. Request packets are encoded with
+// p.Authenticator, except for Accounting-Request, Disconnect-Request, and
+// CoA-Request packets, whose authenticator is calculated.
 func (p *Packet) Encode() ([]byte, error) {
-	var bufferAttrs bytes.Buffer
-	for _, attr := range p.Attributes {
-		codec := p.Dictionary.Codec(attr.Type)
-		wire, err := codec.Encode(p, attr.Value)
-		if err != nil {
+	wire, buf, err := p.encodePooled()
+	if err != nil {
+		return nil, err
+	}
+	// The returned wire data must not refer to the pooled buffer.
+	encoded := make([]byte, len(wire))
+	copy(encoded, wire)
+	releaseEncodeBuffer(buf)
+	return encoded, nil
+}
+
+// encodeBuffers holds the scratch buffers that packets are encoded into, so
+// that high-rate senders do not allocate a buffer for each packet.
+var encodeBuffers = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, 0, 4096)
+		return &buf
+	},
+}
+
+// encodePooled encodes the packet into a buffer from encodeBuffers. The
+// buffer must be given back with releaseEncodeBuffer once wire, which refers
+// to it, is no longer used.
+func (p *Packet) encodePooled() (wire []byte, buf *[]byte, err error) {
+	buf = encodeBuffers.Get().(*[]byte)
+	wire, err = p.appendEncoded((*buf)[:0])
+	if err != nil {
+		releaseEncodeBuffer(buf)
+		return nil, nil, err
+	}
+	// keep the capacity that the buffer has grown to
+	*buf = wire
+	return wire, buf, nil
+}
+
+// releaseEncodeBuffer gives a buffer back to encodeBuffers. Buffers that have
+// grown much larger than the largest possible packet are not kept, so that the
+// pool does not hold on to more memory than it needs.
+func releaseEncodeBuffer(buf *[]byte) {
+	if cap(*buf) > 2*65536 {
+		return
+	}
+	encodeBuffers.Put(buf)
+}
+
+// appendEncoded appends the packet's wire format to dst.
+func (p *Packet) appendEncoded(dst []byte) ([]byte, error) {
+	// The attributes of a packet returned by ParseLazy are written as they
+	// were received, if they can be.
+	var raw []byte
+	rawMessageAuthenticator, lazy := -1, false
+	if p.lazy != nil {
+		raw, rawMessageAuthenticator, lazy = p.lazy.encoded(p)
+	}
+	if !lazy {
+		if err := p.Decode(); err != nil {
 			return nil, err
 		}
-		if len(wire) > 253 {
-			return nil, errors.New("radius: encoded attribute is too long")
+		if p.Code == CodeStatusServer && p.Len(attributeTypeMessageAuthenticator) == 0 {
+			withMessageAuthenticator := *p
+			withMessageAuthenticator.Attributes = append(p.Attributes[:len(p.Attributes):len(p.Attributes)], &Attribute{
+				Type:  attributeTypeMessageAuthenticator,
+				Value: make([]byte, md5.Size),
+			})
+			return withMessageAuthenticator.appendEncoded(dst)
+		}
+		if n := len(p.Attributes); n > 0 && p.Attributes[n-1].Type != attributeTypeMessageAuthenticator && p.Len(attributeTypeMessageAuthenticator) > 0 {
+			reordered := *p
+			reordered.Attributes = append([]*Attribute(nil), p.Attributes...)
+			reordered.MoveToEnd(attributeTypeMessageAuthenticator)
+			return reordered.appendEncoded(dst)
+		}
+	}
+
+	start := len(dst)
+	var header [20]byte
+	dst = append(dst, header[:]...)
+	messageAuthenticator := -1
+	if lazy {
+		if rawMessageAuthenticator >= 0 {
+			messageAuthenticator = len(dst) + rawMessageAuthenticator
+		}
+		dst = append(dst, raw...)
+	} else {
+		var err error
+		if dst, messageAuthenticator, err = p.appendAttributes(dst); err != nil {
+			return nil, err
 		}
-		bufferAttrs.WriteByte(attr.Type)
-		bufferAttrs.WriteByte(byte(len(wire) + 2))
-		bufferAttrs.Write(wire)
 	}
+	wire := dst[start:]
 
-	length := 1 + 1 + 2 + 16 + bufferAttrs.Len()
-	if length > maxPacketSize {
-		return nil, errors.New("radius: encoded packet is too long")
+	length := len(wire)
+	if max := maxPacketSize(); length > max {
+		return nil, fmt.Errorf("radius: encoded packet is too long (%d bytes; MaxPacketSize is %d)", length, max)
 	}
 
-	var buffer bytes.Buffer
-	buffer.Grow(length)
-	buffer.WriteByte(byte(p.Code))
-	buffer.WriteByte(p.Identifier)
-	binary.Write(&buffer, binary.BigEndian, uint16(length))
+	wire[0] = byte(p.Code)
+	wire[1] = p.Identifier
+	binary.BigEndian.PutUint16(wire[2:4], uint16(length))
 
 	switch p.Code {
-	case CodeAccessRequest:
-		buffer.Write(p.Authenticator[:])
-	case CodeAccessAccept, CodeAccessReject, CodeAccountingRequest, CodeAccountingResponse, CodeAccessChallenge:
-		hash := md5.New()
-		hash.Write(buffer.Bytes())
-		if p.Code == CodeAccountingRequest {
-			var nul [16]byte
-			hash.Write(nul[:])
-		} else {
-			hash.Write(p.Authenticator[:])
+	case CodeAccessRequest, CodeAccessAccept, CodeAccessReject, CodeAccountingResponse, CodeAccessChallenge,
+		CodeStatusServer, CodeDisconnectACK, CodeDisconnectNAK, CodeCoAACK, CodeCoANAK:
+		copy(wire[4:20], p.Authenticator[:])
+	case CodeAccountingRequest, CodeDisconnectRequest, CodeCoARequest:
+		// The authenticator is calculated with the field set to zeros.
+	default:
+		return nil, errors.New("radius: unknown Packet code")
+	}
+
+	if messageAuthenticator > -1 {
+		messageAuthenticator -= start
+		value := wire[messageAuthenticator : messageAuthenticator+md5.Size]
+		for i := range value {
+			value[i] = 0
 		}
-		hash.Write(bufferAttrs.Bytes())
+		hash := newHMACMD5(p.Secret)
+		hash.Write(wire)
+		hash.Sum(value[0:0])
+	}
+
+	switch p.Code {
+	case CodeAccessAccept, CodeAccessReject, CodeAccountingResponse, CodeAccessChallenge,
+		CodeDisconnectACK, CodeDisconnectNAK, CodeCoAACK, CodeCoANAK:
+		hash := newMD5()
+		hash.Write(wire)
 		hash.Write(p.Secret)
 
 		var sum [md5.Size]byte
-		buffer.Write(hash.Sum(sum[0:0]))
-	default:
-		return nil, errors.New("radius: unknown Packet code")
+		copy(wire[4:20], hash.Sum(sum[0:0]))
+	case CodeAccountingRequest, CodeDisconnectRequest, CodeCoARequest:
+		authenticator, _ := AccountingRequestAuthenticator(wire, p.Secret)
+		copy(wire[4:20], authenticator[:])
 	}
 
-	buffer.ReadFrom(&bufferAttrs)
+	return dst, nil
+}
 
-	return buffer.Bytes(), nil
+// WriteTo writes the encoded packet to w. It implements io.WriterTo.
+//
+// No framing is added: as over RADIUS over TLS (RFC 6614), each packet is
+// delimited by the Length field of its header, so a stream of packets that is
+// written with WriteTo can be read back with ReadPacketFrom.
+//
+// The packet is encoded into a pooled buffer, which is reused once w.Write has
+// returned, so w must not retain the slice that it is given (as required by
+// io.Writer).
+func (p *Packet) WriteTo(w io.Writer) (int64, error) {
+	wire, buf, err := p.encodePooled()
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(wire)
+	releaseEncodeBuffer(buf)
+	return int64(n), err
+}
+
+// ReadPacketFrom reads a packet from a stream of packets that are delimited by
+// the Length fields of their headers, as written by Packet.WriteTo or sent
+// over RADIUS over TLS, and parses it as with Parse. io.EOF is returned if r
+// has no more data; io.ErrUnexpectedEOF if it ends in the middle of a packet.
+func ReadPacketFrom(r io.Reader, secret []byte, dictionary *Dictionary) (*Packet, error) {
+	wire, err := readStreamPacket(r)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(wire, secret, dictionary)
 }
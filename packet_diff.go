@@ -0,0 +1,293 @@
+package radius
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"time"
+)
+
+// Equal reports whether p and other have the same code, identifier, and
+// attributes. Attributes of different types may be in any order, but those of
+// the same type must be in the same order, as their order is significant
+// (RFC 2865, section 5). Values are compared by content, so, for example, a
+// net.IP in its 16 byte form equals the same address in its 4 byte form.
+//
+// The authenticators are not compared, as they differ each time a request is
+// sent; they can be compared with p.Authenticator == other.Authenticator. The
+// secrets and dictionaries are not compared either.
+func (p *Packet) Equal(other *Packet) bool {
+	return len(p.Diff(other)) == 0
+}
+
+// Diff returns a human-readable description of each difference between p and
+// other, in the sense of Equal, or nil if there is none. Each difference is
+// written as "name: value in p != value in other", such as
+// `User-Name: "tim" != "bob"`. An attribute that is missing from one of the
+// packets is described as "missing".
+func (p *Packet) Diff(other *Packet) []string {
+	p.decodeLazy()
+	other.decodeLazy()
+	var diffs []string
+	if p.Code != other.Code {
+		diffs = append(diffs, fmt.Sprintf("Code: %v != %v", p.Code, other.Code))
+	}
+	if p.Identifier != other.Identifier {
+		diffs = append(diffs, fmt.Sprintf("Identifier: %d != %d", p.Identifier, other.Identifier))
+	}
+
+	// attribute types in the order in which they first appear in p, and then
+	// in other
+	var types []byte
+	var seen [256]bool
+	for _, attrs := range [][]*Attribute{p.Attributes, other.Attributes} {
+		for _, attr := range attrs {
+			if !seen[attr.Type] {
+				seen[attr.Type] = true
+				types = append(types, attr.Type)
+			}
+		}
+	}
+
+	for _, t := range types {
+		attrs, otherAttrs := p.Attrs(t), other.Attrs(t)
+		name := p.diffName(t)
+		n := len(attrs)
+		if len(otherAttrs) > n {
+			n = len(otherAttrs)
+		}
+		for i := 0; i < n; i++ {
+			var attr, otherAttr *Attribute
+			if i < len(attrs) {
+				attr = attrs[i]
+			}
+			if i < len(otherAttrs) {
+				otherAttr = otherAttrs[i]
+			}
+			if attr != nil && otherAttr != nil && attr.Tag == otherAttr.Tag && valueEqual(attr.Value, otherAttr.Value) {
+				continue
+			}
+			label := name
+			if n > 1 {
+				label = fmt.Sprintf("%s[%d]", name, i)
+			}
+			diffs = append(diffs, fmt.Sprintf("%s: %s != %s", label, diffAttribute(attr), diffAttribute(otherAttr)))
+		}
+	}
+	return diffs
+}
+
+// Types of the attributes that are included in Summary, in order.
+var summaryAttributes = []byte{
+	1,  // User-Name
+	2,  // User-Password
+	3,  // CHAP-Password
+	4,  // NAS-IP-Address
+	32, // NAS-Identifier
+	31, // Calling-Station-Id
+	40, // Acct-Status-Type
+	44, // Acct-Session-Id
+}
+
+// Types of the attributes whose values are not written by Summary and Dump,
+// as they carry credentials, even if they are not sensitive in the
+// packet's dictionary (see DictionaryEntry.Sensitive).
+var redactedAttributes = []byte{
+	2,  // User-Password
+	3,  // CHAP-Password
+	69, // Tunnel-Password
+}
+
+// Summary returns a one-line description of the packet for logging, with its
+// code, identifier, and the attributes that identify the exchange, such as:
+//
+//  Access-Request id=42 User-Name="bob" User-Password=<redacted> NAS-IP-Address=10.0.0.1
+//
+// The attributes are User-Name, User-Password, CHAP-Password, NAS-IP-Address,
+// NAS-Identifier, Calling-Station-Id, Acct-Status-Type, and Acct-Session-Id,
+// if present, named by the packet's dictionary (or as Attr-N if they are not
+// registered in it). The values of sensitive attributes, such as
+// User-Password, are never written, only their presence (see Dump).
+func (p *Packet) Summary() string {
+	p.decodeLazy()
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%v id=%d", p.Code, p.Identifier)
+	for _, t := range summaryAttributes {
+		for _, attr := range p.Attributes {
+			if attr.Type != t {
+				continue
+			}
+			name, ok := p.Dictionary.Name(t)
+			if !ok {
+				name = fmt.Sprintf("Attr-%d", t)
+			}
+			value := "<redacted>"
+			if !isRedacted(p.Dictionary.typeEntry(t), t) {
+				value = p.dumpValue(name, attr.Value)
+			}
+			fmt.Fprintf(&b, " %s=%s", name, value)
+		}
+	}
+	return b.String()
+}
+
+// Dump returns a description of the packet for logging, with its code,
+// identifier, and authenticator on the first line, and each of its attributes
+// on the following lines:
+//
+//  Access-Request id=42 authenticator=0x0f1e...
+//  	User-Name = "bob"
+//  	User-Password = <redacted>
+//  	Tunnel-Type:1 = 13
+//
+// The values of sensitive attributes (see Dictionary.SetSensitive), and of
+// User-Password, CHAP-Password, and Tunnel-Password whatever the dictionary,
+// are written as <redacted>; DumpUnsafe writes them. The secret is never
+// written. Printing a packet with the fmt package (such as with %v) writes
+// its Dump.
+func (p *Packet) Dump() string {
+	return p.dump(false)
+}
+
+// DumpUnsafe is like Dump, but the values of sensitive attributes are written
+// in clear, such as the plaintext of User-Password. It is meant for
+// debugging; its output must not be logged where credentials must not be.
+func (p *Packet) DumpUnsafe() string {
+	return p.dump(true)
+}
+
+// Format implements fmt.Formatter, so that printing a packet with any verb
+// writes its Dump, rather than its fields (which include its Secret).
+func (p *Packet) Format(f fmt.State, verb rune) {
+	io.WriteString(f, p.Dump())
+}
+
+func (p *Packet) dump(unsafe bool) string {
+	p.decodeLazy()
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%v id=%d authenticator=0x%x", p.Code, p.Identifier, p.Authenticator[:])
+	line := func(name string, tag byte, redacted bool, value interface{}) {
+		label := name
+		if tag != 0 {
+			label = fmt.Sprintf("%s:%d", name, tag)
+		}
+		if redacted && !unsafe {
+			fmt.Fprintf(&b, "\n\t%s = <redacted>", label)
+			return
+		}
+		fmt.Fprintf(&b, "\n\t%s = %s", label, p.dumpValue(name, value))
+	}
+	for _, attr := range p.Attributes {
+		if vendorAttrs := vendorAttributes(attr.Value); vendorAttrs != nil && attr.Type == attributeTypeVendorSpecific {
+			for _, v := range vendorAttrs {
+				name, ok := p.Dictionary.VendorAttributeName(v.VendorID, v.Type)
+				if !ok {
+					name = fmt.Sprintf("Vendor-%d-Attr-%d", v.VendorID, v.Type)
+				}
+				line(name, 0, isRedacted(p.Dictionary.vendorEntry(v.VendorID, v.Type), 0), v.Value)
+			}
+			continue
+		}
+		if extended, ok := attr.Value.(*ExtendedAttribute); ok {
+			name, ok := p.Dictionary.ExtendedAttributeName(attr.Type, extended.ExtendedType)
+			if !ok {
+				name = fmt.Sprintf("Attr-%d.%d", attr.Type, extended.ExtendedType)
+			}
+			line(name, 0, isRedacted(p.Dictionary.extendedEntry(attr.Type, extended.ExtendedType), 0), extended.Value)
+			continue
+		}
+		name, ok := p.Dictionary.Name(attr.Type)
+		if !ok {
+			name = fmt.Sprintf("Attr-%d", attr.Type)
+		}
+		line(name, attr.Tag, isRedacted(p.Dictionary.typeEntry(attr.Type), attr.Type), attr.Value)
+	}
+	return b.String()
+}
+
+// isRedacted reports whether the value of the attribute whose dictionary
+// entry is entry (which may be nil) is redacted by Summary and Dump. t is the
+// type of a standard attribute, or zero.
+func isRedacted(entry *DictionaryEntry, t byte) bool {
+	return (entry != nil && entry.Sensitive) || (t != 0 && bytes.IndexByte(redactedAttributes, t) >= 0)
+}
+
+// dumpValue formats the value of the attribute name for Summary and Dump.
+// Integers are written as the names of their values, if they have one.
+func (p *Packet) dumpValue(name string, value interface{}) string {
+	if integer, ok := value.(uint32); ok {
+		if valueName, ok := p.Dictionary.ValueName(name, integer); ok {
+			return valueName
+		}
+	}
+	return diffValue(value)
+}
+
+// diffName returns the name of the attribute type t, as used by Diff.
+func (p *Packet) diffName(t byte) string {
+	if p.Dictionary == nil {
+		return fmt.Sprintf("type %d", t)
+	}
+	return p.Dictionary.typeName(t)
+}
+
+// diffAttribute formats an attribute for Diff.
+func diffAttribute(attr *Attribute) string {
+	if attr == nil {
+		return "missing"
+	}
+	if attr.Tag != 0 {
+		return fmt.Sprintf("%s (tag %d)", diffValue(attr.Value), attr.Tag)
+	}
+	return diffValue(attr.Value)
+}
+
+// diffValue formats an attribute value for Diff and Summary.
+func diffValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return fmt.Sprintf("%q", v)
+	case []byte:
+		return fmt.Sprintf("0x%x", v)
+	case *VendorAttribute:
+		return fmt.Sprintf("vendor %d type %d %s", v.VendorID, v.Type, diffValue(v.Value))
+	case *ExtendedAttribute:
+		return fmt.Sprintf("extended type %d %s", v.ExtendedType, diffValue(v.Value))
+	}
+	return fmt.Sprintf("%v", value)
+}
+
+// valueEqual reports whether two attribute values have the same content.
+func valueEqual(a, b interface{}) bool {
+	switch a := a.(type) {
+	case []byte:
+		b, ok := b.([]byte)
+		return ok && bytes.Equal(a, b)
+	case net.IP:
+		b, ok := b.(net.IP)
+		return ok && a.Equal(b)
+	case time.Time:
+		b, ok := b.(time.Time)
+		return ok && a.Equal(b)
+	case *VendorAttribute:
+		b, ok := b.(*VendorAttribute)
+		return ok && a.VendorID == b.VendorID && a.Type == b.Type && valueEqual(a.Value, b.Value)
+	case []*VendorAttribute:
+		b, ok := b.([]*VendorAttribute)
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !valueEqual(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	case *ExtendedAttribute:
+		b, ok := b.(*ExtendedAttribute)
+		return ok && a.ExtendedType == b.ExtendedType && valueEqual(a.Value, b.Value)
+	}
+	return reflect.DeepEqual(a, b)
+}
@@ -0,0 +1,177 @@
+package radius
+
+import (
+	"bytes"
+	"crypto/md5"
+)
+
+// lazyAttributes holds the attributes of a packet returned by ParseLazy that
+// have not been decoded yet.
+type lazyAttributes struct {
+	// wire data of the attributes
+	data []byte
+	// packet with the code, identifier, authenticator, secret, and dictionary
+	// that the attributes are decoded with
+	decoder Packet
+	// attributes that were decoded by lookups, by their offset in data
+	decoded map[int]*Attribute
+}
+
+// ParseLazy is like Parse, but the attributes of the packet are decoded when
+// they are first used, rather than by ParseLazy. It saves the cost of decoding
+// the attributes that are never used, such as when a proxy forwards packets
+// after reading few of their attributes, if any.
+//
+// Looking up attributes by name, with Value, Attr, Gets, String, and the Get
+// methods such as GetString, only decodes the attributes of the type that is
+// looked up (unless they are vendor-specific, extended, or concatenated), and
+// the decoded attributes are kept. Any other use of the attributes, such as
+// with Attrs, Add, Set, Remove, or Diff, decodes all of them. Until then, the
+// Attributes field is nil: Decode must be called before it is used directly.
+//
+// Attributes are decoded with the given secret and dictionary, and the
+// authenticator that was received, even if the fields of the packet are
+// changed before they are decoded. If the packet's secret, authenticator, and
+// dictionary are unchanged, and its attributes have not been decoded, or were
+// not modified since, Encode writes the attributes as they were received.
+//
+// An attribute that cannot be decoded results in an error from Decode, and
+// from Encode, rather than from ParseLazy; the attributes that precede it are
+// kept. If the UnknownPolicy of dictionary is not UnknownKeep, the attributes
+// are decoded by ParseLazy, as by Parse.
+//
+// The packet must not be used concurrently, or copied other than with Clone,
+// until Decode has been called.
+func ParseLazy(data, secret []byte, dictionary *Dictionary) (*Packet, error) {
+	if err := checkPacket(data); err != nil {
+		return nil, err
+	}
+	if dictionary.UnknownPolicy() != UnknownKeep {
+		return parse(data, secret, dictionary)
+	}
+	raw := append([]byte(nil), data...)
+	packet := &Packet{
+		Code:       Code(raw[0]),
+		Identifier: raw[1],
+		Secret:     secret,
+		Dictionary: dictionary,
+		Raw:        raw,
+	}
+	copy(packet.Authenticator[:], raw[4:20])
+	packet.lazy = &lazyAttributes{
+		data: raw[20:],
+		decoder: Packet{
+			Code:          packet.Code,
+			Identifier:    packet.Identifier,
+			Authenticator: packet.Authenticator,
+			Secret:        secret,
+			Dictionary:    dictionary,
+		},
+	}
+	return packet, nil
+}
+
+// Decode decodes the attributes of a packet that was returned by ParseLazy, if
+// they have not been decoded yet. The error that decoding them resulted in,
+// if any, is returned. Decode does nothing, and returns nil, for other
+// packets.
+func (p *Packet) Decode() error {
+	if p.lazy != nil {
+		l := p.lazy
+		p.lazy = nil
+		decoder := l.decoder
+		p.lazyErr = decoder.decodeAttributes(l.data, l.decoded)
+		p.Attributes = decoder.Attributes
+	}
+	return p.lazyErr
+}
+
+// decodeLazy is like Decode, for the methods that cannot return its error,
+// which is then returned by Encode.
+func (p *Packet) decodeLazy() {
+	if p.lazy != nil {
+		p.Decode()
+	}
+}
+
+// eachValue is like Packet.eachValue, for the attributes that have not been
+// decoded yet. Only the attributes that match entry are decoded. It returns
+// false, without calling fn, if entry cannot be looked up in this way, or if
+// an attribute cannot be decoded, in which case all of the attributes must be
+// decoded instead.
+func (l *lazyAttributes) eachValue(dictionary *Dictionary, entry *DictionaryEntry, fn func(attr *Attribute, value *interface{}) bool) bool {
+	t := entry.Type
+	if dictionary != l.decoder.Dictionary || entry.Vendor != 0 || entry.ExtendedType != 0 || entry.Concat ||
+		t == attributeTypeVendorSpecific || isExtendedType(t) {
+		return false
+	}
+	typeEntry := dictionary.typeEntry(t)
+	var attrs []*Attribute
+	for offset := 0; offset < len(l.data); offset += int(l.data[offset+1]) {
+		if l.data[offset] != t {
+			continue
+		}
+		attr := l.decoded[offset]
+		if attr == nil {
+			tag, value, err := typeEntry.decode(&l.decoder, l.data[offset+2:offset+int(l.data[offset+1])])
+			if err != nil {
+				return false
+			}
+			attr = &Attribute{
+				Type:  t,
+				Tag:   tag,
+				Value: value,
+			}
+			if l.decoded == nil {
+				l.decoded = make(map[int]*Attribute)
+			}
+			l.decoded[offset] = attr
+		}
+		attrs = append(attrs, attr)
+	}
+	for _, attr := range attrs {
+		if !fn(attr, &attr.Value) {
+			break
+		}
+	}
+	return true
+}
+
+// encoded returns the wire data of the attributes, as they were received, if
+// they are what p would encode them to: the secret, authenticator, and
+// dictionary of p are those that the attributes are decoded with, an
+// attribute that was decoded by a lookup encodes to the same bytes, and the
+// attributes need not be reordered. The offset of the value of the
+// Message-Authenticator attribute is also returned, or -1 if there is none.
+func (l *lazyAttributes) encoded(p *Packet) (attrs []byte, messageAuthenticator int, ok bool) {
+	d := &l.decoder
+	if p.Dictionary != d.Dictionary || !bytes.Equal(p.Secret, d.Secret) || p.Authenticator != d.Authenticator || p.GroupTaggedAttributes {
+		return nil, -1, false
+	}
+	messageAuthenticator = -1
+	for offset := 0; offset < len(l.data); offset += int(l.data[offset+1]) {
+		if messageAuthenticator >= 0 {
+			// the Message-Authenticator would be moved to the end
+			return nil, -1, false
+		}
+		if l.data[offset] == attributeTypeMessageAuthenticator {
+			if l.data[offset+1] != 2+md5.Size {
+				return nil, -1, false
+			}
+			messageAuthenticator = offset + 2
+		}
+	}
+	if p.Code == CodeStatusServer && messageAuthenticator < 0 {
+		return nil, -1, false
+	}
+	for offset, attr := range l.decoded {
+		encoder := *d
+		encoder.Code = p.Code
+		encoder.Attributes = []*Attribute{attr}
+		wire, _, err := encoder.encodeAttributes()
+		if err != nil || !bytes.Equal(wire, l.data[offset:offset+int(l.data[offset+1])]) {
+			return nil, -1, false
+		}
+	}
+	return l.data, messageAuthenticator, true
+}
@@ -0,0 +1,144 @@
+package radius
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+)
+
+// Framing specifies how the packets that are read by a PacketReader are
+// delimited.
+type Framing int
+
+// Framings of a PacketReader.
+const (
+	// FramingAuto selects FramingDatagram if the reader is a net.Conn of a
+	// datagram network (such as one returned by net.DialUDP), and
+	// FramingStream otherwise.
+	FramingAuto Framing = iota
+	// FramingDatagram reads a single packet with each call to Read, as
+	// packets are carried over UDP.
+	FramingDatagram
+	// FramingStream reads packets that follow each other in a stream, each
+	// delimited by the Length field of its header, as packets are carried
+	// over TCP and TLS (RFC 6614), and as they are written by Packet.WriteTo.
+	FramingStream
+)
+
+// PacketReader reads and parses packets from an io.Reader, such as a net.Conn,
+// a file, or a capture of RADIUS traffic.
+type PacketReader struct {
+	// Secret and dictionary that the packets are parsed with.
+	Secret     []byte
+	Dictionary *Dictionary
+
+	r       io.Reader
+	framing Framing
+	// buffer that datagrams are read into, and that the packet that is being
+	// read from a stream is kept in
+	buff []byte
+	// number of bytes of buff that have been read of the packet that is being
+	// read from a stream
+	pending int
+}
+
+// NewPacketReader returns a PacketReader that reads packets from r, which are
+// delimited as specified by framing, and parses them with the given secret and
+// dictionary.
+func NewPacketReader(r io.Reader, framing Framing, secret []byte, dictionary *Dictionary) *PacketReader {
+	if framing == FramingAuto {
+		framing = FramingStream
+		if conn, ok := r.(net.Conn); ok && isDatagramNetwork(conn.LocalAddr().Network()) {
+			framing = FramingDatagram
+		}
+	}
+	return &PacketReader{
+		Secret:     secret,
+		Dictionary: dictionary,
+		r:          r,
+		framing:    framing,
+	}
+}
+
+// isDatagramNetwork reports whether network, as returned by net.Addr.Network,
+// delimits the messages that are sent over it.
+func isDatagramNetwork(network string) bool {
+	switch network {
+	case "udp", "udp4", "udp6", "ip", "ip4", "ip6", "unixgram", "unixpacket":
+		return true
+	}
+	return false
+}
+
+// Framing returns the framing of the reader; FramingAuto is resolved to the
+// framing that was selected.
+func (r *PacketReader) Framing() Framing {
+	return r.framing
+}
+
+// Next reads and parses the next packet, as with Parse. io.EOF is returned
+// once the reader has no more data.
+//
+// If a datagram cannot be parsed, its error is returned, and Next can be
+// called again to read the next datagram.
+//
+// Over a stream, partial reads are retried until the whole packet, as given
+// by the Length field of its header, has been read. If the stream ends (with
+// io.EOF) in the middle of a packet, io.ErrUnexpectedEOF is returned, and the
+// bytes that were read are kept: Next can be called again once more data is
+// available, such as on a reader that is fed with the segments of a TCP
+// connection as they arrive, and it resumes the packet. The same applies to
+// other read errors, such as timeouts, which are returned as they are. A
+// *ParseError is returned if the Length field is invalid, which cannot be
+// recovered from, as the start of the next packet is not known; the
+// connection should then be closed.
+func (r *PacketReader) Next() (*Packet, error) {
+	if len(r.buff) < maxPacketSize() {
+		buff := make([]byte, maxPacketSize())
+		copy(buff, r.buff[:r.pending])
+		r.buff = buff
+	}
+
+	if r.framing == FramingStream {
+		wire, err := r.readStream()
+		if err != nil {
+			return nil, err
+		}
+		return Parse(wire, r.Secret, r.Dictionary)
+	}
+
+	n, err := r.r.Read(r.buff)
+	if n == 0 && err != nil {
+		return nil, err
+	}
+	// Parse copies all that it keeps of the datagram, so the buffer can be
+	// reused.
+	return Parse(r.buff[:n], r.Secret, r.Dictionary)
+}
+
+// readStream reads the rest of the packet that is being read from a stream
+// into r.buff, and returns it once it is complete. The returned slice is only
+// valid until the next read.
+func (r *PacketReader) readStream() ([]byte, error) {
+	for {
+		need := 4
+		if r.pending >= 4 {
+			need = int(binary.BigEndian.Uint16(r.buff[2:4]))
+			if need < 20 || need > maxPacketSize() {
+				return nil, &ParseError{Offset: 2, Reason: "invalid packet length"}
+			}
+			if r.pending == need {
+				r.pending = 0
+				return r.buff[:need], nil
+			}
+		}
+		n, err := r.r.Read(r.buff[r.pending:need])
+		r.pending += n
+		if err != nil && r.pending < need {
+			if err == io.EOF && r.pending > 0 {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+	}
+}
@@ -2,8 +2,22 @@ package radius_test
 
 import (
 	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
 	"net"
+	"strings"
+	"sync"
 	"testing"
+	"testing/iotest"
+	"time"
 
 	"github.com/PromonLogicalis/radius"
 )
@@ -130,3 +144,1837 @@ func Test_RFC2865_7_2(t *testing.T) {
 		t.Fatal("expecting Framed-Protocol = 1")
 	}
 }
+
+func TestUserPassword(t *testing.T) {
+	secret := []byte("xyzzy5461")
+	authenticator := []byte("0123456789abcdef")
+
+	for _, password := range []string{"", "short", "exactly16bytes!!", strings.Repeat("long password ", 9)} {
+		ciphertext, err := radius.EncryptUserPassword([]byte(password), secret, authenticator)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ciphertext) == 0 || len(ciphertext)%16 != 0 {
+			t.Fatalf("unexpected ciphertext length %d", len(ciphertext))
+		}
+		plaintext, err := radius.DecryptUserPassword(ciphertext, secret, authenticator)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(plaintext) != password {
+			t.Fatalf("expecting %q; got %q", password, plaintext)
+		}
+	}
+
+	if _, err := radius.EncryptUserPassword(make([]byte, 129), secret, authenticator); err == nil {
+		t.Fatal("expecting passwords longer than 128 bytes to fail")
+	}
+
+	p := radius.New(radius.CodeAccessRequest, secret)
+	long := strings.Repeat("x", 100)
+	p.Add("User-Password", long)
+	wire, err := p.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, err := radius.Parse(wire, secret, radius.Builtin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.String("User-Password") != long {
+		t.Fatal("expecting User-Password to round-trip")
+	}
+}
+
+func TestMessageAuthenticator(t *testing.T) {
+	secret := []byte("xyzzy5461")
+
+	p := radius.New(radius.CodeAccessRequest, secret)
+	p.AddMessageAuthenticator()
+	p.Add("User-Name", "tim")
+	wire, err := p.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q, err := radius.Parse(wire, secret, radius.Builtin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := q.VerifyMessageAuthenticator(); err != nil {
+		t.Fatal(err)
+	}
+	if value := q.Value("Message-Authenticator").([]byte); bytes.Equal(value, make([]byte, 16)) {
+		t.Fatal("expecting Message-Authenticator to be calculated")
+	}
+
+	wire[len(wire)-1] ^= 0xff
+	q, err = radius.Parse(wire, secret, radius.Builtin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := q.VerifyMessageAuthenticator(); err == nil {
+		t.Fatal("expecting modified packet to fail verification")
+	}
+
+	// A response's Message-Authenticator must not break IsAuthentic
+	response := radius.Packet{
+		Code:          radius.CodeAccessAccept,
+		Identifier:    p.Identifier,
+		Authenticator: p.Authenticator,
+		Secret:        secret,
+		Dictionary:    radius.Builtin,
+	}
+	response.AddMessageAuthenticator()
+	wire, err = response.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	received, err := radius.Parse(wire, secret, radius.Builtin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !received.IsAuthentic(p) {
+		t.Fatal("expecting response to be authentic")
+	}
+}
+
+func TestAccounting(t *testing.T) {
+	secret := []byte("secret")
+
+	request := radius.New(radius.CodeAccountingRequest, secret)
+	request.Add("Acct-Status-Type", radius.AcctStatusTypeStop)
+	request.Add("Acct-Session-Id", "abc123")
+	request.Add("Acct-Input-Octets", uint32(1024))
+	request.Add("Acct-Session-Time", uint32(60))
+
+	wire, err := request.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	authenticator, err := radius.AccountingRequestAuthenticator(wire, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(wire[4:20], authenticator[:]) {
+		t.Fatal("unexpected Accounting-Request authenticator")
+	}
+
+	q, err := radius.Parse(wire, secret, radius.Builtin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !q.IsAuthentic(q) {
+		t.Fatal("expecting Accounting-Request to be authentic")
+	}
+	if value, _ := q.Value("Acct-Input-Octets").(uint32); value != 1024 {
+		t.Fatalf("expecting Acct-Input-Octets = 1024; got %v", q.Value("Acct-Input-Octets"))
+	}
+	if value, _ := q.Value("Acct-Session-Time").(uint32); value != 60 {
+		t.Fatalf("expecting Acct-Session-Time = 60; got %v", q.Value("Acct-Session-Time"))
+	}
+
+	response, err := q.Response(radius.CodeAccountingResponse)
+	if err != nil {
+		t.Fatal(err)
+	}
+	responseWire, err := response.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := radius.Parse(responseWire, secret, radius.Builtin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.IsAuthentic(q) {
+		t.Fatal("expecting Accounting-Response to be authentic")
+	}
+
+	if _, err := q.Response(radius.CodeAccessAccept); err == nil {
+		t.Fatal("expecting Access-Accept response to Accounting-Request to fail")
+	}
+	missing := radius.New(radius.CodeAccountingRequest, secret)
+	if _, err := missing.Response(radius.CodeAccountingResponse); err == nil {
+		t.Fatal("expecting error for missing Acct-Status-Type")
+	}
+}
+
+func TestSetAccountingAuthenticator(t *testing.T) {
+	secret := []byte("secret")
+
+	request := radius.New(radius.CodeAccountingRequest, secret)
+	request.Add("Acct-Status-Type", radius.AcctStatusTypeStart)
+	request.Add("Acct-Session-Id", "abc123")
+	if err := request.SetAccountingAuthenticator(); err != nil {
+		t.Fatal(err)
+	}
+	wire, err := request.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(wire[4:20], request.Authenticator[:]) {
+		t.Fatal("expecting Authenticator to be the encoded authenticator")
+	}
+	if err := request.VerifyAccountingAuthenticator(); err != nil {
+		t.Fatal(err)
+	}
+
+	q, err := radius.Parse(wire, []byte("wrong"), radius.Builtin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := q.VerifyAccountingAuthenticator(); err == nil {
+		t.Fatal("expecting authenticator to be invalid for the wrong secret")
+	}
+
+	access := radius.New(radius.CodeAccessRequest, secret)
+	if err := access.SetAccountingAuthenticator(); err == nil {
+		t.Fatal("expecting error for Access-Request")
+	}
+	if err := access.VerifyAccountingAuthenticator(); err == nil {
+		t.Fatal("expecting error for Access-Request")
+	}
+}
+
+func TestPacketCopyClass(t *testing.T) {
+	secret := []byte("secret")
+	request := radius.New(radius.CodeAccessRequest, secret)
+	accept, _ := request.Response(radius.CodeAccessAccept)
+	accept.Add("Class", []byte{0x00, 0xff})
+	accept.Add("Session-Timeout", uint32(60))
+	accept.Add("Class", "second")
+	wire, err := accept.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	received, err := radius.Parse(wire, secret, radius.Builtin)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	accounting := radius.New(radius.CodeAccountingRequest, secret)
+	accounting.Add("Acct-Status-Type", radius.AcctStatusTypeStart)
+	accounting.CopyClass(received)
+	classes := accounting.Attrs(25)
+	if len(classes) != 2 || len(accounting.Attributes) != 3 {
+		t.Fatalf("expecting the two Class attributes to be copied; got %d", len(classes))
+	}
+	if !bytes.Equal(classes[0].Value.([]byte), []byte{0x00, 0xff}) || !bytes.Equal(classes[1].Value.([]byte), []byte("second")) {
+		t.Fatalf("expecting Class values in order; got %#v %#v", classes[0].Value, classes[1].Value)
+	}
+	// the values are copies
+	classes[0].Value.([]byte)[0] = 1
+	if value, _ := received.GetBytes("Class"); value[0] != 0 {
+		t.Fatal("expecting the original Class to be unmodified")
+	}
+}
+
+func TestAccountingSessions(t *testing.T) {
+	request := func(status uint32, id string) *radius.Packet {
+		p := radius.New(radius.CodeAccountingRequest, []byte("secret"))
+		p.Add("NAS-IP-Address", net.IPv4(10, 0, 0, 1))
+		p.Add("Acct-Status-Type", status)
+		if id != "" {
+			p.Add("Acct-Session-Id", id)
+		}
+		return p
+	}
+
+	var sessions radius.AccountingSessions
+	tests := []struct {
+		status  uint32
+		id      string
+		warning string
+	}{
+		{radius.AcctStatusTypeAccountingOn, "", ""},
+		{radius.AcctStatusTypeStart, "a", ""},
+		{radius.AcctStatusTypeInterimUpdate, "a", ""},
+		{radius.AcctStatusTypeStart, "a", `session "a": duplicate Start`},
+		{radius.AcctStatusTypeStop, "a", ""},
+		{radius.AcctStatusTypeInterimUpdate, "a", `session "a": Interim-Update after Stop`},
+		{radius.AcctStatusTypeStop, "a", `session "a": duplicate Stop`},
+		{radius.AcctStatusTypeStop, "b", `session "b": Stop before Start`},
+		{radius.AcctStatusTypeInterimUpdate, "c", `session "c": Interim-Update before Start`},
+		{radius.AcctStatusTypeStart, "", "Accounting-Request has no Acct-Session-Id"},
+		{radius.AcctStatusTypeAccountingOn, "", "Accounting-On while 1 session(s) of the NAS were not stopped"},
+		{radius.AcctStatusTypeStop, "c", `session "c": Stop before Start`},
+	}
+	for i, test := range tests {
+		warnings := sessions.Observe(request(test.status, test.id))
+		if warning := strings.Join(warnings, "; "); warning != test.warning {
+			t.Fatalf("%d: expecting warning %q; got %q", i, test.warning, warning)
+		}
+	}
+	if n := sessions.Open(); n != 0 {
+		t.Fatalf("expecting no open sessions; got %d", n)
+	}
+
+	// sessions of other NASes are independent
+	other := request(radius.AcctStatusTypeStart, "a")
+	other.Set("NAS-IP-Address", net.IPv4(10, 0, 0, 2))
+	sessions.Observe(request(radius.AcctStatusTypeStart, "a"))
+	if warnings := sessions.Observe(other); warnings != nil {
+		t.Fatalf("expecting no warnings; got %q", warnings)
+	}
+	if n := sessions.Open(); n != 2 {
+		t.Fatalf("expecting 2 open sessions; got %d", n)
+	}
+
+	// stopped sessions are forgotten after the StopRetention
+	sessions = radius.AccountingSessions{StopRetention: time.Nanosecond}
+	sessions.Observe(request(radius.AcctStatusTypeStart, "a"))
+	sessions.Observe(request(radius.AcctStatusTypeStop, "a"))
+	time.Sleep(time.Millisecond)
+	if warnings := sessions.Observe(request(radius.AcctStatusTypeStart, "a")); warnings != nil {
+		t.Fatalf("expecting the stopped session to be forgotten; got %q", warnings)
+	}
+}
+
+func TestPacketRepeatedAttributes(t *testing.T) {
+	secret := []byte("secret")
+
+	p := radius.New(radius.CodeAccessRequest, secret)
+	p.Add("Proxy-State", []byte("a"))
+	p.Add("User-Name", "tim")
+	p.Add("Proxy-State", []byte("b"))
+	p.Add("Proxy-State", []byte("c"))
+
+	wire, err := p.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, err := radius.Parse(wire, secret, radius.Builtin)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := q.Len(33); n != 3 {
+		t.Fatalf("expecting 3 Proxy-State attributes; got %d", n)
+	}
+	attrs := q.Attrs(33)
+	if len(attrs) != 3 {
+		t.Fatalf("expecting 3 Proxy-State attributes; got %d", len(attrs))
+	}
+	values, err := q.Gets("Proxy-State")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, expected := range []string{"a", "b", "c"} {
+		if value := attrs[i].Value.([]byte); string(value) != expected {
+			t.Fatalf("expecting Attrs()[%d] = %s; got %s", i, expected, value)
+		}
+		if value := values[i].([]byte); string(value) != expected {
+			t.Fatalf("expecting Gets()[%d] = %s; got %s", i, expected, value)
+		}
+	}
+
+	if _, err := q.Gets("Unknown-Attribute"); err == nil {
+		t.Fatal("expecting error for unknown attribute name")
+	}
+}
+
+func TestPacketSetRemove(t *testing.T) {
+	p := radius.New(radius.CodeAccessRequest, []byte("secret"))
+	p.Add("Class", []byte("a"))
+	p.Add("User-Name", "tim")
+	p.Add("Class", []byte("b"))
+	p.Add("Reply-Message", "hello")
+
+	if err := p.Set("Class", []byte("c")); err != nil {
+		t.Fatal(err)
+	}
+	if n := p.Len(25); n != 1 {
+		t.Fatalf("expecting 1 Class attribute; got %d", n)
+	}
+	if p.Attributes[0].Type != 25 || string(p.Attributes[0].Value.([]byte)) != "c" {
+		t.Fatal("expecting Set to replace the first Class attribute")
+	}
+
+	if err := p.Set("NAS-Port", uint32(3)); err != nil {
+		t.Fatal(err)
+	}
+	if port, _ := p.GetInt("NAS-Port"); port != 3 || len(p.Attributes) != 4 {
+		t.Fatal("expecting Set to add a missing attribute")
+	}
+
+	if !p.Has(18) {
+		t.Fatal("expecting packet to have Reply-Message")
+	}
+	p.Add("Reply-Message", "world")
+	p.Remove(18)
+	if p.Has(18) {
+		t.Fatal("expecting all Reply-Message attributes to be removed")
+	}
+	if len(p.Attributes) != 3 || p.String("User-Name") != "tim" {
+		t.Fatal("expecting other attributes to be kept")
+	}
+}
+
+func TestEAPMessage(t *testing.T) {
+	secret := []byte("secret")
+
+	payload := make([]byte, 600)
+	for i := range payload {
+		payload[i] = byte(i)
+	}
+
+	p := radius.New(radius.CodeAccessRequest, secret)
+	p.Add("User-Name", "tim")
+	p.SetEAPMessage([]byte{0x01})
+	p.SetEAPMessage(payload)
+
+	if n := p.Len(79); n != 3 {
+		t.Fatalf("expecting 3 EAP-Message attributes; got %d", n)
+	}
+	if n := p.Len(80); n != 1 {
+		t.Fatalf("expecting 1 Message-Authenticator attribute; got %d", n)
+	}
+
+	wire, err := p.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, err := radius.Parse(wire, secret, radius.Builtin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := q.VerifyMessageAuthenticator(); err != nil {
+		t.Fatal(err)
+	}
+	message, err := q.EAPMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(message, payload) {
+		t.Fatal("unexpected EAP-Message payload")
+	}
+
+	// EAP-Start
+	q.SetEAPMessage(nil)
+	if n := q.Len(79); n != 1 {
+		t.Fatalf("expecting 1 EAP-Message attribute; got %d", n)
+	}
+	if message, err := q.EAPMessage(); err != nil || message == nil || len(message) != 0 {
+		t.Fatalf("expecting empty EAP-Message; got %v, %v", message, err)
+	}
+
+	r := radius.New(radius.CodeAccessRequest, secret)
+	if _, err := r.EAPMessage(); err == nil {
+		t.Fatal("expecting error for missing EAP-Message")
+	}
+}
+
+func TestPacketClone(t *testing.T) {
+	p := radius.New(radius.CodeAccessRequest, []byte("secret"))
+	p.Add("User-Name", "tim")
+	p.Add("State", []byte{0x01, 0x02})
+
+	q := p.Clone()
+	if q.Identifier != p.Identifier || q.Authenticator != p.Authenticator {
+		t.Fatal("expecting clone to have the same header")
+	}
+	q.Authenticator[0]++
+	q.Attributes[1].Value.([]byte)[0] = 0xff
+	q.Set("User-Name", "bob")
+	q.Add("Class", []byte{0x03})
+
+	if p.Authenticator == q.Authenticator {
+		t.Fatal("expecting Authenticator to be copied")
+	}
+	if len(p.Attributes) != 2 {
+		t.Fatal("expecting original attributes to be unmodified")
+	}
+	if p.String("User-Name") != "tim" {
+		t.Fatal("expecting original User-Name = tim")
+	}
+	if value := p.Value("State").([]byte); value[0] != 0x01 {
+		t.Fatal("expecting original State value to be unmodified")
+	}
+}
+
+func TestParseStrict(t *testing.T) {
+	secret := []byte("secret")
+
+	header := func(length int) []byte {
+		return []byte{
+			0x01, 0x01, byte(length >> 8), byte(length), 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+			0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		}
+	}
+
+	tests := []struct {
+		wire   []byte
+		offset int
+	}{
+		// Length field does not match the data
+		{append(header(30), 0x01, 0x05, 't', 'i', 'm'), 2},
+		// attribute length of 1
+		{append(header(23), 0x01, 0x01, 't'), 21},
+		// attribute runs past the end of the packet
+		{append(header(25), 0x01, 0x06, 't', 'i', 'm'), 21},
+		// attribute with no value
+		{append(header(22), 0x01, 0x02), 20},
+		// truncated attribute header
+		{append(header(21), 0x01), 20},
+	}
+	for i, test := range tests {
+		_, err := radius.ParseStrict(test.wire, secret, radius.Builtin)
+		parseErr, ok := err.(*radius.ParseError)
+		if !ok {
+			t.Fatalf("%d: expecting *ParseError; got %v", i, err)
+		}
+		if parseErr.Offset != test.offset {
+			t.Fatalf("%d: expecting offset %d; got %d", i, test.offset, parseErr.Offset)
+		}
+	}
+
+	// lenient parsing ignores the Length field
+	if _, err := radius.Parse(tests[0].wire, secret, radius.Builtin); err != nil {
+		t.Fatal(err)
+	}
+	// an attribute length of 1 is always invalid
+	if _, err := radius.Parse(tests[1].wire, secret, radius.Builtin); err == nil {
+		t.Fatal("expecting error for attribute length of 1")
+	}
+
+	// EAP-Start
+	if _, err := radius.ParseStrict(append(header(22), 0x4f, 0x02), secret, radius.Builtin); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPacketVerifyResponse(t *testing.T) {
+	secret := []byte("secret")
+
+	request := radius.New(radius.CodeAccessRequest, secret)
+	request.Add("User-Name", "tim")
+	response, err := request.Response(radius.CodeAccessAccept)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.AddMessageAuthenticator()
+	wire, err := response.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	received, err := radius.Parse(wire, secret, radius.Builtin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := received.VerifyResponse(request); err != nil {
+		t.Fatal(err)
+	}
+
+	// a response from a server with another secret
+	response.Secret = []byte("wrong")
+	wire, err = response.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	received, err = radius.Parse(wire, secret, radius.Builtin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := received.VerifyResponse(request); err != radius.ErrSecretMismatch {
+		t.Fatalf("expecting ErrSecretMismatch; got %v", err)
+	}
+
+	other := *request
+	other.Identifier++
+	if err := received.VerifyResponse(&other); err == nil || err == radius.ErrSecretMismatch {
+		t.Fatalf("expecting Identifier mismatch; got %v", err)
+	}
+
+	if err := request.VerifyResponse(request); err == nil {
+		t.Fatal("expecting request to fail verification")
+	}
+}
+
+func TestMaxPacketSize(t *testing.T) {
+	p := radius.New(radius.CodeAccessRequest, []byte("secret"))
+	for i := 0; i < 20; i++ {
+		p.Add("Class", bytes.Repeat([]byte{byte(i)}, 253))
+	}
+	if _, err := p.Encode(); err == nil || !strings.Contains(err.Error(), "MaxPacketSize") {
+		t.Fatalf("expecting packet over 4096 bytes to fail; got %v", err)
+	}
+
+	defer func(size int) {
+		radius.MaxPacketSize = size
+	}(radius.MaxPacketSize)
+	radius.MaxPacketSize = 8192
+
+	wire, err := p.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, err := radius.ParseStrict(wire, p.Secret, radius.Builtin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Len(25) != 20 {
+		t.Fatalf("expecting 20 Class attributes; got %d", q.Len(25))
+	}
+}
+
+func TestPacketTypedGetters(t *testing.T) {
+	p := radius.New(radius.CodeAccessRequest, []byte("secret"))
+	p.Add("User-Name", "tim")
+	p.Add("NAS-Port", uint32(7))
+	p.Add("NAS-IP-Address", net.IPv4(192, 0, 2, 1))
+	p.Add("Class", []byte{0x01, 0x02})
+
+	if value, ok := p.GetString("User-Name"); !ok || value != "tim" {
+		t.Fatalf("expecting User-Name = tim; got %q, %t", value, ok)
+	}
+	if value, ok := p.GetInt("NAS-Port"); !ok || value != 7 {
+		t.Fatalf("expecting NAS-Port = 7; got %d, %t", value, ok)
+	}
+	if value, ok := p.GetIP("NAS-IP-Address"); !ok || !value.Equal(net.IPv4(192, 0, 2, 1)) {
+		t.Fatalf("expecting NAS-IP-Address = 192.0.2.1; got %s, %t", value, ok)
+	}
+	if value, ok := p.GetBytes("Class"); !ok || !bytes.Equal(value, []byte{0x01, 0x02}) {
+		t.Fatalf("expecting Class = 0102; got %x, %t", value, ok)
+	}
+
+	// mismatched types and missing attributes
+	if _, ok := p.GetInt("User-Name"); ok {
+		t.Fatal("expecting GetInt of a string attribute to fail")
+	}
+	if _, ok := p.GetString("Reply-Message"); ok {
+		t.Fatal("expecting GetString of a missing attribute to fail")
+	}
+	if _, ok := p.GetBytes("No-Such-Attribute"); ok {
+		t.Fatal("expecting GetBytes of an unknown attribute to fail")
+	}
+}
+
+type failingReader struct{}
+
+func (failingReader) Read(p []byte) (int, error) {
+	return 0, errors.New("no entropy")
+}
+
+func TestNewPacket(t *testing.T) {
+	defer func(r io.Reader) {
+		radius.Rand = r
+	}(radius.Rand)
+
+	random := make([]byte, 17)
+	for i := range random {
+		random[i] = byte(i + 1)
+	}
+	radius.Rand = bytes.NewReader(random)
+	p, err := radius.NewPacket(radius.CodeAccessRequest, []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Identifier != 1 || !bytes.Equal(p.Authenticator[:], random[1:]) {
+		t.Fatalf("expecting identifier and authenticator from Rand; got %d %x", p.Identifier, p.Authenticator)
+	}
+
+	radius.Rand = bytes.NewReader(random)
+	p, err = radius.NewPacket(radius.CodeAccountingRequest, []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Identifier != 1 || p.Authenticator != [16]byte{} {
+		t.Fatalf("expecting zero Accounting-Request authenticator; got %x", p.Authenticator)
+	}
+
+	radius.Rand = failingReader{}
+	if _, err := radius.NewPacket(radius.CodeAccessRequest, []byte("secret")); err == nil {
+		t.Fatal("expecting NewPacket to fail when Rand fails")
+	}
+	if radius.New(radius.CodeAccessRequest, []byte("secret")) != nil {
+		t.Fatal("expecting New to return nil when Rand fails")
+	}
+}
+
+func TestEncryptSaltRand(t *testing.T) {
+	defer func(r io.Reader) {
+		radius.Rand = r
+	}(radius.Rand)
+
+	secret := []byte("secret")
+	authenticator := make([]byte, 16)
+	var ciphertexts [][]byte
+	for i := 0; i < 2; i++ {
+		radius.Rand = bytes.NewReader([]byte{0x12, 0x34})
+		ciphertext, err := radius.EncryptSalt([]byte("password"), secret, authenticator)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ciphertexts = append(ciphertexts, ciphertext)
+	}
+	if ciphertexts[0][0] != 0x92 || ciphertexts[0][1] != 0x34 {
+		t.Fatalf("expecting salt from Rand, with its most significant bit set; got %x", ciphertexts[0][:2])
+	}
+	if !bytes.Equal(ciphertexts[0], ciphertexts[1]) {
+		t.Fatal("expecting the same ciphertext for the same salt")
+	}
+
+	radius.Rand = failingReader{}
+	if _, err := radius.EncryptSalt([]byte("password"), secret, authenticator); err == nil {
+		t.Fatal("expecting EncryptSalt to fail when Rand fails")
+	}
+}
+
+func TestPacketAttributeOrder(t *testing.T) {
+	p := radius.New(radius.CodeAccessRequest, []byte("secret"))
+	p.AddMessageAuthenticator()
+	p.Add("User-Name", "tim")
+	p.Add("Class", []byte("1"))
+	p.Add("Reply-Message", "a")
+	p.Add("Class", []byte("2"))
+	p.Add("EAP-Message", []byte{0x02, 0x00, 0x00, 0x04})
+
+	p.MoveToFront(25)
+	p.MoveToEnd(1)
+	var types []byte
+	for _, attr := range p.Attributes {
+		types = append(types, attr.Type)
+	}
+	if expected := []byte{25, 25, 80, 18, 79, 1}; !bytes.Equal(types, expected) {
+		t.Fatalf("expecting attribute types %v; got %v", expected, types)
+	}
+	if string(p.Attributes[0].Value.([]byte)) != "1" {
+		t.Fatal("expecting the order of moved attributes to be preserved")
+	}
+
+	wire, err := p.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, err := radius.Parse(wire, p.Secret, radius.Builtin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	types = types[:0]
+	for _, attr := range q.Attributes {
+		types = append(types, attr.Type)
+	}
+	if expected := []byte{25, 25, 18, 79, 1, 80}; !bytes.Equal(types, expected) {
+		t.Fatalf("expecting Message-Authenticator to be encoded last; got %v", types)
+	}
+	if err := q.VerifyMessageAuthenticator(); err != nil {
+		t.Fatal(err)
+	}
+	if p.Attributes[2].Type != 80 {
+		t.Fatal("expecting Encode not to modify the packet")
+	}
+}
+
+func TestAttributeTooLong(t *testing.T) {
+	p := radius.New(radius.CodeAccessAccept, []byte("secret"))
+	err := p.Add("Reply-Message", strings.Repeat("x", 300))
+	if err == nil || !strings.Contains(err.Error(), "Reply-Message") || !strings.Contains(err.Error(), "300 bytes") {
+		t.Fatalf("expecting error naming the attribute and its length; got %v", err)
+	}
+
+	p.AddAttr(&radius.Attribute{
+		Type:  25,
+		Value: make([]byte, 254),
+	})
+	_, err = p.Encode()
+	if err == nil || !strings.Contains(err.Error(), "Class") || !strings.Contains(err.Error(), "254 bytes") {
+		t.Fatalf("expecting error naming the attribute and its length; got %v", err)
+	}
+
+	// EAP-Message is split instead
+	q := radius.New(radius.CodeAccessRequest, []byte("secret"))
+	message := bytes.Repeat([]byte{0x01}, 600)
+	if err := q.Add("EAP-Message", message); err != nil {
+		t.Fatal(err)
+	}
+	if n := q.Len(79); n != 3 {
+		t.Fatalf("expecting 3 EAP-Message attributes; got %d", n)
+	}
+	if data, err := q.EAPMessage(); err != nil || !bytes.Equal(data, message) {
+		t.Fatalf("expecting EAP-Message to be reassembled; got %v", err)
+	}
+}
+
+func TestPacketReject(t *testing.T) {
+	secret := []byte("secret")
+	request := radius.New(radius.CodeAccessRequest, secret)
+	request.Add("User-Name", "tim")
+
+	// 300 bytes, where byte 253 is in the middle of a character
+	reason := strings.Repeat("a", 252) + strings.Repeat("\u00e9", 24)
+	response, err := request.Reject(reason, radius.ErrorCauseAdministrativelyProhibited)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wire, err := response.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	received, err := radius.Parse(wire, secret, radius.Builtin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if received.Code != radius.CodeAccessReject || !received.IsAuthentic(request) {
+		t.Fatal("expecting an authentic Access-Reject")
+	}
+	messages, err := received.Gets("Reply-Message")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 2 || messages[0] != strings.Repeat("a", 252) || messages[0].(string)+messages[1].(string) != reason {
+		t.Fatalf("unexpected Reply-Message values %q", messages)
+	}
+	if cause, _ := received.Value("Error-Cause").(uint32); cause != radius.ErrorCauseAdministrativelyProhibited {
+		t.Fatalf("unexpected Error-Cause %v", received.Value("Error-Cause"))
+	}
+
+	if response, _ := request.Reject("", 0); len(response.Attributes) != 0 {
+		t.Fatalf("expecting no attributes; got %d", len(response.Attributes))
+	}
+	accounting := radius.New(radius.CodeAccountingRequest, secret)
+	if _, err := accounting.Reject("denied", 0); err == nil {
+		t.Fatal("expecting Reject of an Accounting-Request to fail")
+	}
+}
+
+func TestPacketReplyMessages(t *testing.T) {
+	secret := []byte("secret")
+	p := radius.New(radius.CodeAccessAccept, secret)
+	p.AddReplyMessage("Welcome")
+	long := strings.Repeat("x", 300)
+	p.AddReplyMessage(long)
+	if err := p.AddFilterID("std.ingress"); err != nil {
+		t.Fatal(err)
+	}
+	p.AddFilterID("std.egress")
+	if err := p.AddFilterID(strings.Repeat("f", 254)); err == nil {
+		t.Fatal("expecting a Filter-Id longer than 253 bytes to be rejected")
+	}
+	if err := p.AddFilterID(""); err == nil {
+		t.Fatal("expecting an empty Filter-Id to be rejected")
+	}
+
+	wire, err := p.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	received, err := radius.Parse(wire, secret, radius.Builtin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages := received.ReplyMessages()
+	if len(messages) != 3 || messages[0] != "Welcome" || messages[1]+messages[2] != long {
+		t.Fatalf("unexpected Reply-Message values %q", messages)
+	}
+	if ids := received.FilterIDs(); len(ids) != 2 || ids[0] != "std.ingress" || ids[1] != "std.egress" {
+		t.Fatalf("unexpected Filter-Id values %q", ids)
+	}
+	if messages := radius.New(radius.CodeAccessReject, secret).ReplyMessages(); messages != nil {
+		t.Fatalf("expecting no Reply-Message values; got %q", messages)
+	}
+}
+
+func TestCHAP(t *testing.T) {
+	challenge := make([]byte, 16)
+	for i := range challenge {
+		challenge[i] = byte(i)
+	}
+	chapPassword := radius.CHAPPassword(0x2a, "password", challenge)
+	expected, _ := hex.DecodeString("2a3e0949a5572c2d338c182b7f6377471d")
+	if !bytes.Equal(chapPassword, expected) {
+		t.Fatalf("expecting CHAP-Password %x; got %x", expected, chapPassword)
+	}
+	if !radius.VerifyCHAP(chapPassword, challenge, "password") {
+		t.Fatal("expecting CHAP-Password to be valid")
+	}
+	if radius.VerifyCHAP(chapPassword, challenge, "wrong") || radius.VerifyCHAP(chapPassword[:16], challenge, "password") {
+		t.Fatal("expecting CHAP-Password to be invalid")
+	}
+
+	secret := []byte("secret")
+	for _, withChallenge := range []bool{false, true} {
+		request := radius.New(radius.CodeAccessRequest, secret)
+		request.Add("User-Name", "tim")
+		if withChallenge {
+			request.Add("CHAP-Challenge", []byte("0123456789abcdefghij"))
+		}
+		request.SetCHAPPassword(7, "password")
+		wire, err := request.Encode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		received, err := radius.Parse(wire, secret, radius.Builtin)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if withChallenge != bytes.Equal(received.CHAPChallenge(), []byte("0123456789abcdefghij")) {
+			t.Fatalf("unexpected challenge %x", received.CHAPChallenge())
+		}
+		if !received.VerifyCHAPPassword("password") {
+			t.Fatalf("challenge %v: expecting CHAP-Password to be valid", withChallenge)
+		}
+		if received.VerifyCHAPPassword("wrong") {
+			t.Fatalf("challenge %v: expecting CHAP-Password to be invalid", withChallenge)
+		}
+	}
+	if radius.New(radius.CodeAccessRequest, secret).VerifyCHAPPassword("password") {
+		t.Fatal("expecting a packet without CHAP-Password to be invalid")
+	}
+}
+
+func TestPacketChallenge(t *testing.T) {
+	secret := []byte("secret")
+	state := []byte{0x00, 0x01, 0xfe, 0xff}
+
+	request := radius.New(radius.CodeAccessRequest, secret)
+	request.Add("User-Name", "tim")
+	if _, ok := request.State(); ok {
+		t.Fatal("expecting request to have no State")
+	}
+
+	challenge, err := request.Challenge("Enter your one-time password", state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wire, err := challenge.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	received, err := radius.Parse(wire, secret, radius.Builtin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if received.Code != radius.CodeAccessChallenge || !received.IsAuthentic(request) {
+		t.Fatal("expecting an authentic Access-Challenge")
+	}
+	if message := received.String("Reply-Message"); message != "Enter your one-time password" {
+		t.Fatalf("unexpected Reply-Message %q", message)
+	}
+
+	// the State is echoed in the next request
+	next := radius.New(radius.CodeAccessRequest, secret)
+	next.Add("User-Name", "tim")
+	next.Add("State", []byte("old"))
+	receivedState, ok := received.State()
+	if !ok || !bytes.Equal(receivedState, state) {
+		t.Fatalf("expecting State %x; got %x", state, receivedState)
+	}
+	next.SetState(receivedState)
+	receivedState[0] = 0xff
+	if echoed, _ := next.State(); next.Len(24) != 1 || !bytes.Equal(echoed, state) {
+		t.Fatalf("expecting a copy of the State to replace the old one; got %x", echoed)
+	}
+
+	if _, err := challenge.Challenge("", nil); err == nil {
+		t.Fatal("expecting error for a challenge to an Access-Challenge")
+	}
+}
+
+func TestParseInto(t *testing.T) {
+	secret := []byte("secret")
+	p := radius.New(radius.CodeAccountingRequest, secret)
+	p.Add("Acct-Status-Type", radius.AcctStatusTypeStart)
+	p.Add("NAS-IP-Address", net.IPv4(10, 0, 0, 1))
+	p.Add("Class", []byte("class"))
+	wire, err := p.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q := &radius.Packet{
+		Secret:     secret,
+		Dictionary: radius.Builtin,
+		Attributes: make([]*radius.Attribute, 0, 8),
+	}
+	if err := radius.ParseInto(wire, q); err != nil {
+		t.Fatal(err)
+	}
+	if q.Code != radius.CodeAccountingRequest || q.Identifier != p.Identifier || !q.IsAuthenticRequest() {
+		t.Fatal("expecting an authentic Accounting-Request")
+	}
+	if len(q.Attributes) != 3 || cap(q.Attributes) != 8 {
+		t.Fatal("expecting the Attributes slice to be reused")
+	}
+	if ip, _ := q.GetIP("NAS-IP-Address"); !ip.Equal(net.IPv4(10, 0, 0, 1)) {
+		t.Fatalf("unexpected NAS-IP-Address %v", ip)
+	}
+
+	// values refer to the buffer
+	class, _ := q.GetBytes("Class")
+	copy(wire[len(wire)-5:], "CLASS")
+	if string(class) != "CLASS" {
+		t.Fatalf("expecting Class to refer to the buffer; got %q", class)
+	}
+	if &q.Raw[0] != &wire[0] {
+		t.Fatal("expecting Raw to be the buffer")
+	}
+
+	// Parse still copies
+	r, err := radius.Parse(wire, secret, radius.Builtin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(wire[len(wire)-5:], "class")
+	if class, _ := r.GetBytes("Class"); string(class) != "CLASS" {
+		t.Fatalf("expecting Parse to copy Class; got %q", class)
+	}
+
+	if err := radius.ParseInto(wire[:19], q); err == nil {
+		t.Fatal("expecting error for a short packet")
+	}
+}
+
+func TestPacketEncodeNotAliased(t *testing.T) {
+	p := radius.New(radius.CodeAccessAccept, []byte("secret"))
+	p.Add("User-Name", "tim")
+	wire, err := p.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	original := append([]byte(nil), wire...)
+
+	// encoding other packets, which reuses the pooled buffers, does not
+	// modify previously encoded packets
+	q := radius.New(radius.CodeAccessReject, []byte("other"))
+	q.Add("Reply-Message", "denied")
+	for i := 0; i < 100; i++ {
+		if _, err := q.Encode(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := q.WriteTo(ioutil.Discard); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !bytes.Equal(wire, original) {
+		t.Fatal("expecting encoded packet to be unchanged")
+	}
+	if cap(wire) != len(wire) {
+		t.Fatalf("expecting encoded packet to have no spare capacity; got %d > %d", cap(wire), len(wire))
+	}
+}
+
+func TestPacketReader(t *testing.T) {
+	secret := []byte("secret")
+	var stream bytes.Buffer
+	for _, name := range []string{"tim", "bob"} {
+		p := radius.New(radius.CodeAccessRequest, secret)
+		p.Add("User-Name", name)
+		if _, err := p.WriteTo(&stream); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wire := append([]byte(nil), stream.Bytes()...)
+
+	// the stream is read one byte at a time
+	r := radius.NewPacketReader(iotest.OneByteReader(&stream), radius.FramingAuto, secret, radius.Builtin)
+	if r.Framing() != radius.FramingStream {
+		t.Fatalf("expecting FramingStream; got %v", r.Framing())
+	}
+	for _, name := range []string{"tim", "bob"} {
+		p, err := r.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.String("User-Name") != name {
+			t.Fatalf("expecting User-Name %q; got %q", name, p.String("User-Name"))
+		}
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Fatalf("expecting io.EOF; got %v", err)
+	}
+
+	// each datagram is a packet
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	conn, err := net.Dial("udp", listener.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	first := int(wire[2])<<8 | int(wire[3])
+	if _, err := listener.WriteTo(wire[:first], conn.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := listener.WriteTo([]byte{1, 2}, conn.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := listener.WriteTo(wire[first:], conn.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	r = radius.NewPacketReader(conn, radius.FramingAuto, secret, radius.Builtin)
+	if r.Framing() != radius.FramingDatagram {
+		t.Fatalf("expecting FramingDatagram; got %v", r.Framing())
+	}
+	if p, err := r.Next(); err != nil || p.String("User-Name") != "tim" {
+		t.Fatalf("expecting the first packet; got %v", err)
+	}
+	if _, err := r.Next(); err == nil {
+		t.Fatal("expecting a short datagram to be an error")
+	}
+	if p, err := r.Next(); err != nil || p.String("User-Name") != "bob" {
+		t.Fatalf("expecting the next datagram after an error; got %v", err)
+	}
+}
+
+func TestPacketWriteTo(t *testing.T) {
+	secret := []byte("secret")
+	var stream bytes.Buffer
+	var sent []*radius.Packet
+	for _, name := range []string{"tim", "bob"} {
+		p := radius.New(radius.CodeAccessRequest, secret)
+		p.Add("User-Name", name)
+		n, err := p.WriteTo(&stream)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if wire, _ := p.Encode(); n != int64(len(wire)) {
+			t.Fatalf("expecting %d bytes to be written; got %d", len(wire), n)
+		}
+		sent = append(sent, p)
+	}
+	truncated := append([]byte(nil), stream.Bytes()[:stream.Len()-1]...)
+
+	for _, p := range sent {
+		q, err := radius.ReadPacketFrom(&stream, secret, radius.Builtin)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if q.Identifier != p.Identifier || q.String("User-Name") != p.String("User-Name") {
+			t.Fatal("expecting packets to be read in the order they were written")
+		}
+	}
+	if _, err := radius.ReadPacketFrom(&stream, secret, radius.Builtin); err != io.EOF {
+		t.Fatalf("expecting io.EOF; got %v", err)
+	}
+
+	r := bytes.NewReader(truncated)
+	radius.ReadPacketFrom(r, secret, radius.Builtin)
+	if _, err := radius.ReadPacketFrom(r, secret, radius.Builtin); err != io.ErrUnexpectedEOF {
+		t.Fatalf("expecting io.ErrUnexpectedEOF; got %v", err)
+	}
+}
+
+func TestPacketRaw(t *testing.T) {
+	secret := []byte("secret")
+	p := radius.New(radius.CodeAccessRequest, secret)
+	p.Add("User-Name", "tim")
+	p.AddMessageAuthenticator()
+	if p.Raw != nil {
+		t.Fatal("expecting new packet to have no raw data")
+	}
+	wire, err := p.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := append([]byte(nil), wire...)
+
+	q, err := radius.Parse(wire, secret, radius.Builtin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the buffer is reused
+	for i := range wire {
+		wire[i] = 0
+	}
+	if !bytes.Equal(q.Raw, expected) {
+		t.Fatalf("unexpected raw data %x", q.Raw)
+	}
+	if response, _ := q.Response(radius.CodeAccessAccept); response.Raw != nil {
+		t.Fatal("expecting response to have no raw data")
+	}
+}
+
+func TestPacketJSON(t *testing.T) {
+	secret := []byte("secret")
+	p := radius.New(radius.CodeAccessRequest, secret)
+	p.Add("User-Name", "tim")
+	p.Add("User-Password", "12345")
+	p.Add("NAS-IP-Address", net.IPv4(10, 0, 0, 1))
+	p.Add("Service-Type", "Framed-User")
+	p.Add("Class", []byte{0x00, 0x01, 0xff})
+	p.Add("Event-Timestamp", time.Unix(1489494413, 0))
+	p.Add("Tunnel-Type", radius.TaggedValue{Tag: 1, Value: uint32(3)})
+	p.AddAttr(&radius.Attribute{Type: 200, Value: []byte("unknown")})
+	p.AddMessageAuthenticator()
+	wire, err := p.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := json.Marshal(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		`"code":"Access-Request"`,
+		`{"type":1,"name":"User-Name","value":"tim"}`,
+		`{"type":4,"name":"NAS-IP-Address","value":"10.0.0.1"}`,
+		`{"type":25,"name":"Class","hex":"0001ff"}`,
+		`{"type":200,"hex":"756e6b6e6f776e"}`,
+		`{"type":2,"name":"User-Password","hex":"`,
+	} {
+		if !strings.Contains(string(data), expected) {
+			t.Fatalf("expecting %s in %s", expected, data)
+		}
+	}
+	if strings.Contains(string(data), "12345") {
+		t.Fatalf("expecting User-Password to be redacted in %s", data)
+	}
+
+	q := radius.Packet{
+		Secret: secret,
+	}
+	if err := json.Unmarshal(data, &q); err != nil {
+		t.Fatal(err)
+	}
+	rewire, err := q.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rewire, wire) {
+		t.Fatalf("expecting unmarshaled packet to encode as the original\n%x\n%x", wire, rewire)
+	}
+
+	if err := json.Unmarshal([]byte(`{"code":"Access-Request","authenticator":"00","attributes":[]}`), &q); err == nil {
+		t.Fatal("expecting error for short authenticator")
+	}
+}
+
+func TestPacketJSONSensitive(t *testing.T) {
+	secret := []byte("secret")
+	p := radius.New(radius.CodeAccessAccept, secret)
+	p.Add("Tunnel-Password", radius.TaggedValue{Tag: 1, Value: "hunter2"})
+	d := radius.MicrosoftDictionary()
+	d.Merge(radius.Builtin, false)
+	p.Dictionary = d
+	p.Add("MS-MPPE-Send-Key", []byte("0123456789abcdef"))
+
+	data, err := json.Marshal(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"hunter2", "0123456789abcdef", `"value"`} {
+		if strings.Contains(string(data), secret) {
+			t.Fatalf("expecting %s not to be in %s", secret, data)
+		}
+	}
+
+	q := radius.Packet{
+		Secret:     secret,
+		Dictionary: d,
+	}
+	if err := json.Unmarshal(data, &q); err != nil {
+		t.Fatal(err)
+	}
+	if attrs := q.Attrs(69); len(attrs) != 1 || attrs[0].Tag != 1 {
+		t.Fatalf("expecting a Tunnel-Password with tag 1; got %v", attrs)
+	}
+	if value := q.String("Tunnel-Password"); value != "hunter2" {
+		t.Fatalf("unexpected Tunnel-Password %v", q.Value("Tunnel-Password"))
+	}
+	if value, _ := q.Value("MS-MPPE-Send-Key").([]byte); !bytes.Equal(value, []byte("0123456789abcdef")) {
+		t.Fatalf("unexpected MS-MPPE-Send-Key %v", q.Value("MS-MPPE-Send-Key"))
+	}
+}
+
+// accountingWire returns an encoded Accounting-Request, with the secret
+// "secret", for the parsing benchmarks.
+func accountingWire(b *testing.B) []byte {
+	p := radius.New(radius.CodeAccountingRequest, []byte("secret"))
+	p.Add("User-Name", "tim")
+	p.Add("NAS-IP-Address", net.IPv4(10, 0, 0, 1))
+	p.Add("NAS-Port", uint32(1))
+	p.Add("Acct-Status-Type", "Interim-Update")
+	p.Add("Acct-Session-Id", "0123456789abcdef")
+	for i := 0; i < 20; i++ {
+		p.Add("Class", []byte("class"))
+		p.Add("Proxy-State", []byte{byte(i)})
+	}
+	wire, err := p.Encode()
+	if err != nil {
+		b.Fatal(err)
+	}
+	return wire
+}
+
+func BenchmarkParseAccounting(b *testing.B) {
+	secret := []byte("secret")
+	wire := accountingWire(b)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := radius.Parse(wire, secret, radius.Builtin); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestParseLazy(t *testing.T) {
+	secret := []byte("secret")
+	p := radius.New(radius.CodeAccessRequest, secret)
+	p.Add("User-Name", "tim")
+	p.Add("User-Password", "12345")
+	p.Add("NAS-Port", uint32(7))
+	p.Add("Class", []byte("one"))
+	p.Add("Class", []byte("two"))
+	p.AddMessageAuthenticator()
+	wire, err := p.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q, err := radius.ParseLazy(wire, secret, radius.Builtin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Attributes != nil {
+		t.Fatal("expecting the attributes not to be decoded")
+	}
+	if username, password, ok := q.PAP(); !ok || username != "tim" || password != "12345" {
+		t.Fatalf("unexpected PAP credentials %q, %q", username, password)
+	}
+	if classes, _ := q.Gets("Class"); len(classes) != 2 || string(classes[1].([]byte)) != "two" {
+		t.Fatalf("unexpected classes %v", classes)
+	}
+	if q.Attributes != nil {
+		t.Fatal("expecting lookups not to decode all of the attributes")
+	}
+	rewire, err := q.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rewire, wire) {
+		t.Fatalf("expecting the packet to encode as it was received\n%x\n%x", wire, rewire)
+	}
+
+	// a change to a looked-up attribute is encoded, and kept once all of the
+	// attributes are decoded
+	q.Attr("User-Name").Value = "bob"
+	if rewire, err = q.Encode(); err != nil {
+		t.Fatal(err)
+	}
+	r, err := radius.Parse(rewire, secret, radius.Builtin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := r.String("User-Name"); name != "bob" {
+		t.Fatalf("expecting the changed User-Name; got %q", name)
+	}
+	if err := q.Decode(); err != nil {
+		t.Fatal(err)
+	}
+	if len(q.Attributes) != 6 || q.String("User-Name") != "bob" || q.Attributes[0] != q.Attr("User-Name") {
+		t.Fatalf("unexpected decoded attributes %v", q.Attributes)
+	}
+
+	// forwarded with another secret, the password is encrypted again
+	q, _ = radius.ParseLazy(wire, secret, radius.Builtin)
+	q.Secret = []byte("upstream")
+	if rewire, err = q.Encode(); err != nil {
+		t.Fatal(err)
+	}
+	if r, err = radius.Parse(rewire, q.Secret, radius.Builtin); err != nil {
+		t.Fatal(err)
+	}
+	if password := r.String("User-Password"); password != "12345" {
+		t.Fatalf("expecting the password to be encrypted with the new secret; got %q", password)
+	}
+	if err := r.VerifyMessageAuthenticator(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a malformed attribute is reported when the attributes are decoded
+	malformed := append(wire[:len(wire):len(wire)], 5, 3, 0)
+	binary.BigEndian.PutUint16(malformed[2:4], uint16(len(malformed)))
+	if _, err := radius.Parse(malformed, secret, radius.Builtin); err == nil {
+		t.Fatal("expecting Parse to fail")
+	}
+	q, err = radius.ParseLazy(malformed, secret, radius.Builtin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := q.String("User-Name"); name != "tim" {
+		t.Fatalf("expecting User-Name to be decoded; got %q", name)
+	}
+	// the attributes that precede the malformed NAS-Port are kept
+	if port, _ := q.GetInt("NAS-Port"); port != 7 || len(q.Attributes) != 6 {
+		t.Fatalf("expecting the first NAS-Port; got %d", port)
+	}
+	if err := q.Decode(); err == nil {
+		t.Fatal("expecting Decode to fail")
+	}
+	if _, err := q.Encode(); err == nil {
+		t.Fatal("expecting Encode to fail")
+	}
+}
+
+func BenchmarkForwardAccounting(b *testing.B) {
+	secret := []byte("secret")
+	wire := accountingWire(b)
+	for _, bench := range []struct {
+		name  string
+		parse func(data, secret []byte, dictionary *radius.Dictionary) (*radius.Packet, error)
+	}{
+		{"Parse", radius.Parse},
+		{"ParseLazy", radius.ParseLazy},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				p, err := bench.parse(wire, secret, radius.Builtin)
+				if err != nil {
+					b.Fatal(err)
+				}
+				p.Identifier++
+				if _, err := p.Encode(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestPacketEqual(t *testing.T) {
+	secret := []byte("secret")
+	p := radius.New(radius.CodeAccessAccept, secret)
+	p.Identifier = 1
+	p.Add("User-Name", "tim")
+	p.Add("Framed-IP-Address", net.IPv4(10, 0, 0, 1))
+	p.Add("Reply-Message", "one")
+	p.Add("Reply-Message", "two")
+
+	// attributes of different types are reordered, and the address is in its
+	// 4 byte form
+	q := radius.New(radius.CodeAccessAccept, secret)
+	q.Identifier = 1
+	q.Add("Reply-Message", "one")
+	q.Add("Framed-IP-Address", net.IP{10, 0, 0, 1})
+	q.Add("Reply-Message", "two")
+	q.Add("User-Name", "tim")
+
+	if !p.Equal(q) {
+		t.Fatalf("expecting packets to be equal; got %q", p.Diff(q))
+	}
+	if p.Authenticator == q.Authenticator {
+		t.Fatal("expecting random authenticators to differ")
+	}
+
+	q.Code = radius.CodeAccessReject
+	q.Set("User-Name", "bob")
+	q.Remove(18)
+	q.Add("Reply-Message", "two")
+	q.Add("Reply-Message", "one")
+	q.Add("Class", []byte{1, 2})
+	if p.Equal(q) {
+		t.Fatal("expecting packets to differ")
+	}
+	expected := []string{
+		`Code: Access-Accept != Access-Reject`,
+		`User-Name: "tim" != "bob"`,
+		`Reply-Message[0]: "one" != "two"`,
+		`Reply-Message[1]: "two" != "one"`,
+		`Class: missing != 0x0102`,
+	}
+	if diff := p.Diff(q); strings.Join(diff, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("got diff:\n%s\nexpecting:\n%s", strings.Join(diff, "\n"), strings.Join(expected, "\n"))
+	}
+}
+
+func BenchmarkParseIntoAccounting(b *testing.B) {
+	secret := []byte("secret")
+	wire := accountingWire(b)
+
+	q := &radius.Packet{
+		Secret:     secret,
+		Dictionary: radius.Builtin,
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := radius.ParseInto(wire, q); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkWriteToAccept(b *testing.B) {
+	p := radius.New(radius.CodeAccessAccept, []byte("secret"))
+	p.Add("Framed-IP-Address", net.IPv4(10, 0, 0, 1))
+	p.Add("Session-Timeout", uint32(3600))
+	for i := 0; i < 10; i++ {
+		p.Add("Class", []byte("class"))
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := p.WriteTo(ioutil.Discard); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestCodeString(t *testing.T) {
+	tests := []struct {
+		code radius.Code
+		name string
+	}{
+		{radius.CodeAccessRequest, "Access-Request"},
+		{radius.CodeAccountingResponse, "Accounting-Response"},
+		{radius.CodeStatusServer, "Status-Server"},
+		{radius.CodeDisconnectNAK, "Disconnect-NAK"},
+		{radius.CodeCoARequest, "CoA-Request"},
+		{radius.Code(99), "99"},
+	}
+	for _, test := range tests {
+		if name := test.code.String(); name != test.name {
+			t.Errorf("expecting %q for code %d; got %q", test.name, byte(test.code), name)
+		}
+	}
+}
+
+func TestPacketSetResponseAuthenticator(t *testing.T) {
+	// a request that a proxy received, and the one that it forwarded
+	request := radius.New(radius.CodeAccessRequest, []byte("client"))
+	forwarded := radius.New(radius.CodeAccessRequest, []byte("upstream"))
+	forwarded.Identifier = request.Identifier + 1
+
+	upstream, err := forwarded.Response(radius.CodeAccessAccept)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upstream.Add("Tunnel-Password", "pass")
+	upstream.AddAttr(&radius.Attribute{Type: 200, Value: []byte{1, 2, 3}})
+	upstream.AddAttr(&radius.Attribute{Type: 26, Value: &radius.VendorAttribute{VendorID: 9999, Type: 1, Value: []byte{4, 5}}})
+	upstream.AddMessageAuthenticator()
+	data, err := upstream.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	relayed, err := radius.ParseResponse(data, forwarded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := relayed.VerifyResponse(forwarded); err != nil {
+		t.Fatal(err)
+	}
+	relayed.SetResponseAuthenticator(request)
+	relayed.AddReplyMessage("relayed")
+	wire, err := relayed.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	received, err := radius.ParseResponse(wire, request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := received.VerifyResponse(request); err != nil {
+		t.Fatal(err)
+	}
+	if password := received.String("Tunnel-Password"); password != "pass" {
+		t.Fatalf("unexpected Tunnel-Password %q", password)
+	}
+	if value, _ := received.Attrs(200)[0].Value.([]byte); !bytes.Equal(value, []byte{1, 2, 3}) {
+		t.Fatalf("expecting the unknown attribute to be kept; got %v", received.Attrs(200)[0].Value)
+	}
+	// Builtin does not register Vendor-Specific
+	if value, _ := received.Attrs(26)[0].Value.([]byte); !bytes.Equal(value, []byte{0, 0, 0x27, 0x0f, 1, 4, 4, 5}) {
+		t.Fatalf("expecting the vendor attribute to be kept; got %v", received.Attrs(26)[0].Value)
+	}
+}
+
+func TestStationIDs(t *testing.T) {
+	p := radius.New(radius.CodeAccessRequest, []byte("secret"))
+	p.Add("Calling-Station-Id", "+1 (555) 123-4567")
+	p.Add("Called-Station-Id", "00-10-A4-23-19-C0:corp")
+	p.Add("Originating-Line-Info", "00")
+	if name, _ := radius.Builtin.Name(94); name != "Originating-Line-Info" {
+		t.Fatalf("expecting Originating-Line-Info to be registered; got %q", name)
+	}
+
+	if number, ok := radius.E164Number(p.String("Calling-Station-Id")); !ok || number != "+15551234567" {
+		t.Fatalf("unexpected number %q", number)
+	}
+	for _, id := range []string{"", "+", "00-10-A4-23-19-C0", "1234567890123456", "12+3"} {
+		if number, ok := radius.E164Number(id); ok {
+			t.Fatalf("expecting %q not to be a number; got %q", id, number)
+		}
+	}
+
+	mac, ssid, ok := radius.StationMAC(p.String("Called-Station-Id"))
+	if !ok || mac.String() != "00:10:a4:23:19:c0" || ssid != "corp" {
+		t.Fatalf("unexpected station %v %q", mac, ssid)
+	}
+	tests := []struct {
+		id   string
+		mac  string
+		ssid string
+	}{
+		{"00:10:A4:23:19:C0", "00:10:a4:23:19:c0", ""},
+		{"0010.a423.19c0:guest", "00:10:a4:23:19:c0", "guest"},
+		{"00-10-A4-23-19-C0", "00:10:a4:23:19:c0", ""},
+		{"+15551234567", "", ""},
+		{"00-10-A4-23-19-C0corp", "", ""},
+		{"0010a42319c0", "", ""},
+	}
+	for _, test := range tests {
+		mac, ssid, ok := radius.StationMAC(test.id)
+		if test.mac == "" {
+			if ok {
+				t.Fatalf("expecting %q not to be a MAC address; got %v", test.id, mac)
+			}
+			continue
+		}
+		if !ok || mac.String() != test.mac || ssid != test.ssid {
+			t.Fatalf("%q: unexpected station %v %q", test.id, mac, ssid)
+		}
+	}
+}
+
+// TestPacketCloneFanOut is meant to be run with the race detector: the
+// original packet is only read concurrently, and each goroutine modifies its
+// own clone.
+func TestPacketCloneFanOut(t *testing.T) {
+	p := radius.New(radius.CodeAccessRequest, []byte("secret"))
+	p.Add("User-Name", "tim")
+	p.Add("Class", []byte{1, 2, 3})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := p.Encode(); err != nil {
+				t.Error(err)
+			}
+			if p.String("User-Name") != "tim" {
+				t.Error("unexpected User-Name")
+			}
+			q := p.Clone()
+			q.Set("User-Name", fmt.Sprint(i))
+			q.Value("Class").([]byte)[0] = byte(i)
+			q.Remove(25)
+		}(i)
+	}
+	wg.Wait()
+	if p.String("User-Name") != "tim" || !bytes.Equal(p.Value("Class").([]byte), []byte{1, 2, 3}) {
+		t.Fatal("expecting the original packet not to be modified")
+	}
+}
+
+type countingHash struct {
+	hash.Hash
+	count *int
+}
+
+func (h countingHash) Sum(b []byte) []byte {
+	*h.count++
+	return h.Hash.Sum(b)
+}
+
+func TestSetMD5Provider(t *testing.T) {
+	var md5Sums, hmacSums int
+	radius.SetMD5Provider(func() hash.Hash {
+		return countingHash{md5.New(), &md5Sums}
+	}, func(key []byte) hash.Hash {
+		return countingHash{hmac.New(md5.New, key), &hmacSums}
+	})
+	defer radius.SetMD5Provider(nil, nil)
+
+	secret := []byte("xyzzy5461")
+	request := radius.New(radius.CodeAccessRequest, secret)
+	request.Add("User-Name", "nemo")
+	request.Add("User-Password", "arctangent")
+	request.AddMessageAuthenticator()
+	wire, err := request.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if md5Sums == 0 || hmacSums != 1 {
+		t.Fatalf("expecting provider to be used; got %d MD5 and %d HMAC-MD5 sums", md5Sums, hmacSums)
+	}
+
+	// the default provider computes the same packet
+	radius.SetMD5Provider(nil, nil)
+	parsed, err := radius.Parse(wire, secret, radius.Builtin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if password := parsed.String("User-Password"); password != "arctangent" {
+		t.Fatalf("expecting User-Password arctangent; got %q", password)
+	}
+	if err := parsed.VerifyMessageAuthenticator(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPacketReparse(t *testing.T) {
+	secret := []byte("xyzzy5461")
+	request := radius.New(radius.CodeAccessRequest, secret)
+	request.Add("User-Name", "nemo")
+	request.Add("User-Password", "arctangent")
+	request.Add("NAS-Identifier", "tenant1")
+	request.Add("NAS-Port", 3)
+	wire, err := request.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	packet, err := radius.Parse(wire, secret, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, attr := range packet.Attributes {
+		if _, ok := attr.Value.([]byte); !ok {
+			t.Fatalf("expecting raw value of attribute %d; got %T", attr.Type, attr.Value)
+		}
+	}
+	if nasID := packet.Attrs(32)[0].Value.([]byte); string(nasID) != "tenant1" {
+		t.Fatalf("expecting NAS-Identifier tenant1; got %q", nasID)
+	}
+
+	// the original bytes are not needed
+	packet.Raw = nil
+	if err := packet.Reparse(radius.Builtin); err != nil {
+		t.Fatal(err)
+	}
+	if packet.Dictionary != radius.Builtin {
+		t.Fatal("expecting Builtin to be the packet's dictionary")
+	}
+	if password := packet.String("User-Password"); password != "arctangent" {
+		t.Fatalf("expecting User-Password arctangent; got %q", password)
+	}
+	if port, ok := packet.GetInt("NAS-Port"); !ok || port != 3 {
+		t.Fatalf("expecting NAS-Port 3; got %d", port)
+	}
+}
+
+func TestPacketReaderResume(t *testing.T) {
+	secret := []byte("secret")
+	p := radius.New(radius.CodeAccessRequest, secret)
+	p.Add("User-Name", "tim")
+	wire, err := p.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the bytes arrive one at a time, and the reader reaches the end of the
+	// data after each of them
+	var segments bytes.Buffer
+	r := radius.NewPacketReader(&segments, radius.FramingStream, secret, radius.Builtin)
+	if _, err := r.Next(); err != io.EOF {
+		t.Fatalf("expecting io.EOF before any data; got %v", err)
+	}
+	var packets []*radius.Packet
+	for i, b := range wire {
+		segments.WriteByte(b)
+		packet, err := r.Next()
+		if i < len(wire)-1 {
+			if err != io.ErrUnexpectedEOF {
+				t.Fatalf("byte %d: expecting io.ErrUnexpectedEOF; got %v", i, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		packets = append(packets, packet)
+	}
+	if len(packets) != 1 || packets[0].String("User-Name") != "tim" {
+		t.Fatalf("expecting a single packet; got %v", packets)
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Fatalf("expecting io.EOF; got %v", err)
+	}
+
+	// an invalid Length field cannot be recovered from
+	segments.Write([]byte{1, 2, 0, 5, 0, 0})
+	var parseErr *radius.ParseError
+	if _, err := r.Next(); !errors.As(err, &parseErr) || errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("expecting *ParseError; got %v", err)
+	}
+	if _, err := r.Next(); !errors.As(err, &parseErr) {
+		t.Fatalf("expecting *ParseError again; got %v", err)
+	}
+}
+
+func TestPacketSummary(t *testing.T) {
+	secret := []byte("secret")
+	request := radius.New(radius.CodeAccessRequest, secret)
+	request.Identifier = 42
+	request.Add("NAS-IP-Address", net.IPv4(10, 0, 0, 1))
+	request.Add("User-Name", "bob")
+	request.Add("User-Password", "arctangent")
+	request.Add("Reply-Message", "not summarized")
+	expected := `Access-Request id=42 User-Name="bob" User-Password=<redacted> NAS-IP-Address=10.0.0.1`
+	if summary := request.Summary(); summary != expected {
+		t.Fatalf("expecting %s; got %s", expected, summary)
+	}
+
+	accounting := radius.New(radius.CodeAccountingRequest, secret)
+	accounting.Identifier = 7
+	accounting.Add("Acct-Status-Type", radius.AcctStatusTypeStart)
+	accounting.Add("Acct-Session-Id", "s1")
+	expected = `Accounting-Request id=7 Acct-Status-Type=Start Acct-Session-Id="s1"`
+	if summary := accounting.Summary(); summary != expected {
+		t.Fatalf("expecting %s; got %s", expected, summary)
+	}
+
+	// without a dictionary
+	accounting.Dictionary = nil
+	expected = `Accounting-Request id=7 Attr-40=1 Attr-44="s1"`
+	if summary := accounting.Summary(); summary != expected {
+		t.Fatalf("expecting %s; got %s", expected, summary)
+	}
+}
+
+func TestPacketDump(t *testing.T) {
+	secret := []byte("secret")
+	dictionary := radius.Builtin.Clone()
+	if err := dictionary.Merge(radius.MicrosoftDictionary(), false); err != nil {
+		t.Fatal(err)
+	}
+	if err := dictionary.SetSensitive("Class", true); err != nil {
+		t.Fatal(err)
+	}
+	p := radius.New(radius.CodeAccessAccept, secret)
+	p.Dictionary = dictionary
+	p.Identifier = 3
+	p.Authenticator = [16]byte{}
+	p.Add("User-Name", "bob")
+	p.Add("User-Password", "arctangent")
+	p.Add("Tunnel-Type", radius.TaggedValue{Tag: 1, Value: uint32(13)})
+	p.Add("Tunnel-Password", radius.TaggedValue{Tag: 1, Value: "tunnel"})
+	p.Add("Class", []byte("c"))
+	p.Add("Acct-Status-Type", radius.AcctStatusTypeStop)
+	p.Add("MS-MPPE-Send-Key", []byte("key"))
+
+	expected := "Access-Accept id=3 authenticator=0x00000000000000000000000000000000" +
+		"\n\tUser-Name = \"bob\"" +
+		"\n\tUser-Password = <redacted>" +
+		"\n\tTunnel-Type:1 = 13" +
+		"\n\tTunnel-Password:1 = <redacted>" +
+		"\n\tClass = <redacted>" +
+		"\n\tAcct-Status-Type = Stop" +
+		"\n\tMS-MPPE-Send-Key = <redacted>"
+	if dump := p.Dump(); dump != expected {
+		t.Fatalf("expecting:\n%s\ngot:\n%s", expected, dump)
+	}
+	if printed := fmt.Sprintf("%v", p); printed != expected || strings.Contains(fmt.Sprint(p), "secret") {
+		t.Fatalf("expecting %%v to print the dump; got:\n%s", printed)
+	}
+
+	unsafe := p.DumpUnsafe()
+	for _, value := range []string{`User-Password = "arctangent"`, `Tunnel-Password:1 = "tunnel"`, "Class = 0x63", "MS-MPPE-Send-Key = 0x6b6579"} {
+		if !strings.Contains(unsafe, value) {
+			t.Fatalf("expecting DumpUnsafe to contain %s; got:\n%s", value, unsafe)
+		}
+	}
+}
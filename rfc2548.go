@@ -0,0 +1,77 @@
+package radius
+
+// VendorMicrosoft is the vendor ID of Microsoft, whose vendor attributes are
+// defined in RFC 2548.
+const VendorMicrosoft uint32 = 311
+
+// Values of the MS-MPPE-Encryption-Policy attribute that are defined in RFC
+// 2548.
+const (
+	MSMPPEEncryptionPolicyAllowed  uint32 = 1
+	MSMPPEEncryptionPolicyRequired uint32 = 2
+)
+
+// MicrosoftDictionary returns a new dictionary with the Microsoft vendor
+// attributes that are defined in RFC 2548, such as MS-CHAP-Challenge,
+// MS-CHAP2-Response, and the MS-MPPE-Send-Key and MS-MPPE-Recv-Key attributes
+// that carry the MPPE keys. They are not registered in Builtin; they can be
+// added to a dictionary using Merge:
+//
+//  dictionary := radius.Builtin.Clone()
+//  dictionary.Merge(radius.MicrosoftDictionary(), false)
+//
+// MS-MPPE-Send-Key and MS-MPPE-Recv-Key are encrypted with the shared secret
+// and the request authenticator as described in RFC 2548 section 2.4.2 (as
+// with AttributeSaltEncrypted, of which it is the origin); their values are
+// the plaintext keys, as []byte. The value of MS-CHAP-MPPE-Keys, which is
+// encrypted differently, is not decrypted.
+func MicrosoftDictionary() *Dictionary {
+	d := &Dictionary{}
+	d.RegisterVendorName(VendorMicrosoft, "Microsoft")
+	for _, attr := range []struct {
+		name  string
+		t     byte
+		codec AttributeCodec
+	}{
+		{"MS-CHAP-Response", 1, AttributeString},
+		{"MS-CHAP-Error", 2, AttributeText},
+		{"MS-CHAP-CPW-1", 3, AttributeString},
+		{"MS-CHAP-CPW-2", 4, AttributeString},
+		{"MS-CHAP-LM-Enc-PW", 5, AttributeString},
+		{"MS-CHAP-NT-Enc-PW", 6, AttributeString},
+		{"MS-MPPE-Encryption-Policy", 7, AttributeInteger},
+		{"MS-MPPE-Encryption-Types", 8, AttributeInteger},
+		{"MS-RAS-Vendor", 9, AttributeInteger},
+		{"MS-CHAP-Domain", 10, AttributeText},
+		{"MS-CHAP-Challenge", 11, AttributeString},
+		{"MS-CHAP-MPPE-Keys", 12, AttributeString},
+		{"MS-BAP-Usage", 13, AttributeInteger},
+		{"MS-Link-Utilization-Threshold", 14, AttributeInteger},
+		{"MS-Link-Drop-Time-Limit", 15, AttributeInteger},
+		{"MS-MPPE-Send-Key", 16, AttributeSaltEncrypted},
+		{"MS-MPPE-Recv-Key", 17, AttributeSaltEncrypted},
+		{"MS-RAS-Version", 18, AttributeText},
+		{"MS-Old-ARAP-Password", 19, AttributeString},
+		{"MS-New-ARAP-Password", 20, AttributeString},
+		{"MS-ARAP-PW-Change-Reason", 21, AttributeInteger},
+		{"MS-Filter", 22, AttributeString},
+		{"MS-Acct-Auth-Type", 23, AttributeInteger},
+		{"MS-Acct-EAP-Type", 24, AttributeInteger},
+		{"MS-CHAP2-Response", 25, AttributeString},
+		{"MS-CHAP2-Success", 26, AttributeString},
+		{"MS-CHAP2-CPW", 27, AttributeString},
+		{"MS-Primary-DNS-Server", 28, AttributeAddress},
+		{"MS-Secondary-DNS-Server", 29, AttributeAddress},
+		{"MS-Primary-NBNS-Server", 30, AttributeAddress},
+		{"MS-Secondary-NBNS-Server", 31, AttributeAddress},
+		{"MS-ARAP-Challenge", 33, AttributeString},
+	} {
+		d.MustRegisterVendor(VendorMicrosoft, attr.name, attr.t, attr.codec)
+	}
+	for _, name := range []string{"MS-CHAP-MPPE-Keys", "MS-MPPE-Send-Key", "MS-MPPE-Recv-Key"} {
+		d.SetSensitive(name, true)
+	}
+	d.MustRegisterValue("MS-MPPE-Encryption-Policy", "Encryption-Allowed", MSMPPEEncryptionPolicyAllowed)
+	d.MustRegisterValue("MS-MPPE-Encryption-Policy", "Encryption-Required", MSMPPEEncryptionPolicyRequired)
+	return d
+}
@@ -3,7 +3,9 @@ package radius
 import (
 	"bytes"
 	"crypto/md5"
+	"crypto/subtle"
 	"errors"
+	"unicode/utf8"
 )
 
 func init() {
@@ -11,7 +13,9 @@ func init() {
 	// TODO: Attribute* should be initialized before
 	Builtin.MustRegister("User-Name", 1, AttributeText)
 	Builtin.MustRegister("User-Password", 2, rfc2865UserPassword{})
-	Builtin.MustRegister("CHAP-Password", 3, AttributeString)
+	Builtin.MustRegister("CHAP-Password", attributeTypeCHAPPassword, AttributeString)
+	Builtin.SetSensitive("User-Password", true)
+	Builtin.SetSensitive("CHAP-Password", true)
 	Builtin.MustRegister("NAS-IP-Address", 4, AttributeAddress)
 	Builtin.MustRegister("NAS-Port", 5, AttributeInteger)
 	Builtin.MustRegister("Service-Type", 6, AttributeInteger)
@@ -19,66 +23,161 @@ func init() {
 	Builtin.MustRegister("Framed-IP-Address", 8, AttributeAddress)
 	Builtin.MustRegister("Framed-IP-Netmask", 9, AttributeAddress)
 	Builtin.MustRegister("Framed-Routing", 10, AttributeInteger)
-	Builtin.MustRegister("Filter-Id", 11, AttributeText)
+	Builtin.MustRegister("Filter-Id", attributeTypeFilterID, AttributeText)
 	Builtin.MustRegister("Framed-MTU", 12, AttributeInteger)
 	Builtin.MustRegister("Framed-Compression", 13, AttributeInteger)
 	Builtin.MustRegister("Login-IP-Host", 14, AttributeAddress)
 	Builtin.MustRegister("Login-Service", 15, AttributeInteger)
 	Builtin.MustRegister("Login-TCP-Port", 16, AttributeInteger)
-	Builtin.MustRegister("Reply-Message", 18, AttributeText)
+	Builtin.MustRegister("Reply-Message", attributeTypeReplyMessage, AttributeText)
 	Builtin.MustRegister("Callback-Number", 19, AttributeString)
 	Builtin.MustRegister("Callback-Id", 20, AttributeString)
 	Builtin.MustRegister("Framed-Route", 22, AttributeText)
 	Builtin.MustRegister("Framed-IPX-Network", 23, AttributeAddress)
-	Builtin.MustRegister("State", 24, AttributeString)
-	Builtin.MustRegister("Class", 25, AttributeString)
-	Builtin.MustRegister("Vendor-Specific", 26, AttributeString)
+	Builtin.MustRegister("State", attributeTypeState, AttributeOctets)
+	Builtin.MustRegister("Class", attributeTypeClass, AttributeOctets)
+	Builtin.MustRegister("Vendor-Specific", 26, AttributeVendorSpecific)
 	Builtin.MustRegister("Session-Timeout", 27, AttributeInteger)
 	Builtin.MustRegister("Idle-Timeout", 28, AttributeInteger)
 	Builtin.MustRegister("Termination-Action", 29, AttributeInteger)
 	Builtin.MustRegister("Called-Station-Id", 30, AttributeString)
 	Builtin.MustRegister("Calling-Station-Id", 31, AttributeString)
 	Builtin.MustRegister("NAS-Identifier", 32, AttributeString)
-	Builtin.MustRegister("Proxy-State", 33, AttributeString)
+	Builtin.MustRegister("Proxy-State", attributeTypeProxyState, AttributeString)
 	Builtin.MustRegister("Login-LAT-Service", 34, AttributeString)
 	Builtin.MustRegister("Login-LAT-Node", 35, AttributeString)
 	Builtin.MustRegister("Login-LAT-Group", 36, AttributeString)
 	Builtin.MustRegister("Framed-AppleTalk-Link", 37, AttributeInteger)
 	Builtin.MustRegister("Framed-AppleTalk-Network", 38, AttributeInteger)
 	Builtin.MustRegister("Framed-AppleTalk-Zone", 39, AttributeString)
-	Builtin.MustRegister("CHAP-Challenge", 60, AttributeString)
+	Builtin.MustRegister("CHAP-Challenge", attributeTypeCHAPChallenge, AttributeString)
 	Builtin.MustRegister("NAS-Port-Type", 61, AttributeInteger)
 	Builtin.MustRegister("Port-Limit", 62, AttributeInteger)
 	Builtin.MustRegister("Login-LAT-Port", 63, AttributeString)
+
+	for i, name := range []string{
+		"Login-User",
+		"Framed-User",
+		"Callback-Login-User",
+		"Callback-Framed-User",
+		"Outbound-User",
+		"Administrative-User",
+		"NAS-Prompt-User",
+		"Authenticate-Only",
+		"Callback-NAS-Prompt",
+		"Call-Check",
+		"Callback-Administrative",
+	} {
+		Builtin.MustRegisterValue("Service-Type", name, uint32(i+1))
+	}
+	for i, name := range []string{
+		"Async",
+		"Sync",
+		"ISDN",
+		"ISDN-V120",
+		"ISDN-V110",
+		"Virtual",
+		"PIAFS",
+		"HDLC-Clear-Channel",
+		"X.25",
+		"X.75",
+		"G.3-Fax",
+		"SDSL",
+		"ADSL-CAP",
+		"ADSL-DMT",
+		"IDSL",
+		"Ethernet",
+		"xDSL",
+		"Cable",
+		"Wireless-Other",
+		"Wireless-802.11",
+	} {
+		Builtin.MustRegisterValue("NAS-Port-Type", name, uint32(i))
+	}
 }
 
-// TODO: support User-Password attributes longer than 16 bytes
-type rfc2865UserPassword struct{}
+// types of the CHAP-Password, Filter-Id, Reply-Message, State, Class,
+// Proxy-State and CHAP-Challenge attributes
+const (
+	attributeTypeCHAPPassword  = 3
+	attributeTypeFilterID      = 11
+	attributeTypeReplyMessage  = 18
+	attributeTypeState         = 24
+	attributeTypeClass         = 25
+	attributeTypeProxyState    = 33
+	attributeTypeCHAPChallenge = 60
+)
 
-func (rfc2865UserPassword) Decode(p *Packet, value []byte) (interface{}, error) {
-	if p.Secret == nil {
-		return nil, errors.New("radius: User-Password attribute requires Packet.Secret")
+// maximum length of a User-Password attribute value
+const maxUserPasswordLength = 128
+
+// EncryptUserPassword encrypts the given plaintext password as described in
+// RFC 2865 section 5.2. The plaintext is padded with NUL bytes to a multiple
+// of 16 bytes. An error is returned if the plaintext is longer than 128 bytes.
+func EncryptUserPassword(plaintext, secret, requestAuthenticator []byte) ([]byte, error) {
+	if len(plaintext) > maxUserPasswordLength {
+		return nil, errors.New("radius: User-Password is longer than 128 bytes")
 	}
-	if len(value) != 16 {
-		return nil, errors.New("radius: invalid User-Password attribute length")
+	length := len(plaintext)
+	if rem := length % md5.Size; rem != 0 || length == 0 {
+		length += md5.Size - rem
 	}
-	v := make([]byte, len(value))
-	copy(v, value)
+	ciphertext := make([]byte, length)
+	copy(ciphertext, plaintext)
 
 	var mask [md5.Size]byte
-	hash := md5.New()
-	hash.Write(p.Secret)
-	hash.Write(p.Authenticator[:])
-	hash.Sum(mask[0:0])
+	hash := newMD5()
+	hash.Write(secret)
+	hash.Write(requestAuthenticator)
+	for i := 0; i < len(ciphertext); i += md5.Size {
+		hash.Sum(mask[0:0])
+		for j := range mask {
+			ciphertext[i+j] ^= mask[j]
+		}
+		hash.Reset()
+		hash.Write(secret)
+		hash.Write(ciphertext[i : i+md5.Size])
+	}
+	return ciphertext, nil
+}
 
-	for i, b := range v {
-		v[i] = b ^ mask[i]
+// DecryptUserPassword decrypts the given User-Password ciphertext as described
+// in RFC 2865 section 5.2. Trailing NUL padding is removed from the returned
+// plaintext. An error is returned if the ciphertext length is not a multiple
+// of 16 bytes between 16 and 128.
+func DecryptUserPassword(ciphertext, secret, requestAuthenticator []byte) ([]byte, error) {
+	if len(ciphertext) < md5.Size || len(ciphertext) > maxUserPasswordLength || len(ciphertext)%md5.Size != 0 {
+		return nil, errors.New("radius: invalid User-Password attribute length")
 	}
+	plaintext := make([]byte, len(ciphertext))
 
-	if i := bytes.IndexByte(v, 0); i > -1 {
-		return string(v[:i]), nil
+	var mask [md5.Size]byte
+	hash := newMD5()
+	hash.Write(secret)
+	hash.Write(requestAuthenticator)
+	for i := 0; i < len(ciphertext); i += md5.Size {
+		hash.Sum(mask[0:0])
+		for j := range mask {
+			plaintext[i+j] = ciphertext[i+j] ^ mask[j]
+		}
+		hash.Reset()
+		hash.Write(secret)
+		hash.Write(ciphertext[i : i+md5.Size])
 	}
-	return string(v), nil
+	return bytes.TrimRight(plaintext, "\x00"), nil
+}
+
+type rfc2865UserPassword struct{}
+
+func (rfc2865UserPassword) Decode(p *Packet, value []byte) (interface{}, error) {
+	if p.Secret == nil {
+		return nil, errors.New("radius: User-Password attribute requires Packet.Secret")
+	}
+	plaintext, err := DecryptUserPassword(value, p.Secret, p.Authenticator[:])
+	if err != nil {
+		return nil, err
+	}
+	return string(plaintext), nil
 }
 
 func (rfc2865UserPassword) Encode(p *Packet, value interface{}) ([]byte, error) {
@@ -95,20 +194,266 @@ func (rfc2865UserPassword) Encode(p *Packet, value interface{}) ([]byte, error)
 	} else {
 		password = bytePassword
 	}
+	return EncryptUserPassword(password, p.Secret, p.Authenticator[:])
+}
 
-	if len(password) > 16 {
-		return nil, errors.New("radius: invalid User-Password attribute length")
+// CHAPPassword returns the value of a CHAP-Password attribute (RFC 2865,
+// section 5.3): the CHAP identifier, followed by the CHAP response, which is
+// the MD5 hash of the identifier, the user's password, and the challenge.
+func CHAPPassword(identifier byte, plaintext string, challenge []byte) []byte {
+	hash := newMD5()
+	hash.Write([]byte{identifier})
+	hash.Write([]byte(plaintext))
+	hash.Write(challenge)
+	return hash.Sum([]byte{identifier})
+}
+
+// VerifyCHAP reports whether chapPassword, the value of a CHAP-Password
+// attribute, is the response to challenge for the given password. The
+// response is compared in constant time.
+func VerifyCHAP(chapPassword, challenge []byte, plaintext string) bool {
+	if len(chapPassword) != 1+md5.Size {
+		return false
 	}
+	expected := CHAPPassword(chapPassword[0], plaintext, challenge)
+	return subtle.ConstantTimeCompare(expected, chapPassword) == 1
+}
 
-	var mask [md5.Size]byte
-	hash := md5.New()
-	hash.Write(p.Secret)
-	hash.Write(p.Authenticator[:])
-	hash.Sum(mask[0:0])
+// CHAPChallenge returns the challenge of a CHAP authentication: the value of
+// the packet's CHAP-Challenge attribute, or, if it has none, its request
+// authenticator (RFC 2865, section 2.2).
+func (p *Packet) CHAPChallenge() []byte {
+	p.decodeLazy()
+	for _, attr := range p.Attributes {
+		if attr.Type == attributeTypeCHAPChallenge {
+			if challenge, ok := attr.Value.([]byte); ok {
+				return challenge
+			}
+		}
+	}
+	return append([]byte(nil), p.Authenticator[:]...)
+}
+
+// SetCHAPPassword replaces the packet's CHAP-Password attributes with the
+// response to the packet's challenge (see CHAPChallenge) for the given CHAP
+// identifier and password. If the challenge is the request authenticator,
+// the packet's Authenticator must not be changed afterwards.
+func (p *Packet) SetCHAPPassword(identifier byte, plaintext string) {
+	p.Remove(attributeTypeCHAPPassword)
+	p.AddAttr(&Attribute{
+		Type:  attributeTypeCHAPPassword,
+		Value: CHAPPassword(identifier, plaintext, p.CHAPChallenge()),
+	})
+}
+
+// VerifyCHAPPassword reports whether the packet's CHAP-Password attribute is
+// the response to its challenge (see CHAPChallenge) for the given password. It
+// is false if the packet has no CHAP-Password.
+func (p *Packet) VerifyCHAPPassword(plaintext string) bool {
+	p.decodeLazy()
+	for _, attr := range p.Attributes {
+		if attr.Type == attributeTypeCHAPPassword {
+			chapPassword, _ := attr.Value.([]byte)
+			return VerifyCHAP(chapPassword, p.CHAPChallenge(), plaintext)
+		}
+	}
+	return false
+}
+
+// NASPort returns the value of the packet's NAS-Port attribute, the physical
+// port number of the NAS that is authenticating the user. It is an unsigned
+// 32 bit integer, and every value up to 4294967295 is valid; it should not be
+// confused with NAS-Port-Id, which is a text identifier (see NASPortID). ok is
+// false if the packet has no NAS-Port attribute.
+func (p *Packet) NASPort() (port uint32, ok bool) {
+	return p.GetInt("NAS-Port")
+}
+
+// SetNASPort sets the value of the packet's NAS-Port attribute.
+func (p *Packet) SetNASPort(port uint32) error {
+	return p.Set("NAS-Port", port)
+}
+
+// CopyProxyState appends copies of the Proxy-State attributes of from to the
+// packet, in the order in which they appear in from. As required by RFC 2865,
+// a proxy should copy the Proxy-State attributes of a request into its
+// response unmodified; the values are copied byte for byte.
+func (p *Packet) CopyProxyState(from *Packet) {
+	p.copyAttributes(from, attributeTypeProxyState)
+}
+
+// CopyClass appends copies of the Class attributes of from to the packet, in
+// the order in which they appear in from. As required by RFC 2865, a NAS
+// should copy the Class attributes of an Access-Accept unmodified into the
+// Accounting-Requests of the session, so that the server can correlate them;
+// the values are copied byte for byte.
+func (p *Packet) CopyClass(from *Packet) {
+	p.copyAttributes(from, attributeTypeClass)
+}
+
+// copyAttributes appends copies of the attributes of from of type t to the
+// packet.
+func (p *Packet) copyAttributes(from *Packet, t byte) {
+	p.decodeLazy()
+	from.decodeLazy()
+	for _, attr := range from.Attributes {
+		if attr.Type != t {
+			continue
+		}
+		p.AddAttr(&Attribute{
+			Type:  attr.Type,
+			Value: cloneValue(attr.Value),
+		})
+	}
+}
+
+// Reject returns a new Access-Reject response to the request p, which carries
+// reason in Reply-Message attributes, so that it can be displayed to the user.
+// A reason that is longer than 253 bytes is split over several Reply-Message
+// attributes, without splitting UTF-8 characters; an empty reason adds no
+// Reply-Message. If errorCause is non-zero, an Error-Cause attribute with that
+// value (such as ErrorCauseAdministrativelyProhibited) is also added.
+//
+// As with Response, the response authenticator is calculated when the packet
+// is encoded. An error is returned if p is not an Access-Request.
+func (p *Packet) Reject(reason string, errorCause uint32) (*Packet, error) {
+	response, err := p.Response(CodeAccessReject)
+	if err != nil {
+		return nil, err
+	}
+	response.AddReplyMessage(reason)
+	if errorCause != 0 {
+		response.AddAttr(&Attribute{
+			Type:  attributeTypeErrorCause,
+			Value: errorCause,
+		})
+	}
+	return response, nil
+}
 
-	for i, b := range password {
-		mask[i] = b ^ mask[i]
+// Challenge returns a new Access-Challenge response to the request p, which
+// carries message in Reply-Message attributes (split as with Reject), and
+// state in a State attribute, if it is non-nil.
+//
+// In a challenge/response exchange, such as for a one-time password, the
+// server answers the first Access-Request with an Access-Challenge that
+// prompts the user, and carries a State that identifies the exchange:
+//
+//	challenge, err := request.Challenge("Enter your one-time password", sessionID)
+//
+// The client sends the user's answer in a new Access-Request, with the State
+// of the challenge copied into it unmodified (see State and SetState). The
+// server then uses the State of that request to find the exchange that it
+// continues.
+//
+// As with Response, the response authenticator is calculated when the packet
+// is encoded. An error is returned if p is not an Access-Request.
+func (p *Packet) Challenge(message string, state []byte) (*Packet, error) {
+	response, err := p.Response(CodeAccessChallenge)
+	if err != nil {
+		return nil, err
+	}
+	response.AddReplyMessage(message)
+	if state != nil {
+		response.SetState(state)
 	}
+	return response, nil
+}
+
+// ReplyMessages returns the values of the packet's Reply-Message attributes,
+// in the order in which they appear in the packet. Together, they are the text
+// to display to the user; as a message may be split over several attributes
+// (see AddReplyMessage), each value is not necessarily a complete line.
+func (p *Packet) ReplyMessages() []string {
+	return p.textValues(attributeTypeReplyMessage)
+}
+
+// AddReplyMessage adds Reply-Message attributes that carry message, after any
+// that the packet already has. A message that is longer than 253 bytes is
+// split over several attributes, without splitting UTF-8 characters; an empty
+// message adds no attribute.
+func (p *Packet) AddReplyMessage(message string) {
+	for len(message) > 0 {
+		chunk := message
+		if len(chunk) > maxAttributeValueLength {
+			chunk = chunk[:maxAttributeValueLength]
+			// back up to the start of a character
+			for len(chunk) > 0 && !utf8.RuneStart(message[len(chunk)]) {
+				chunk = chunk[:len(chunk)-1]
+			}
+			if len(chunk) == 0 {
+				chunk = message[:maxAttributeValueLength]
+			}
+		}
+		p.AddAttr(&Attribute{
+			Type:  attributeTypeReplyMessage,
+			Value: chunk,
+		})
+		message = message[len(chunk):]
+	}
+}
+
+// FilterIDs returns the values of the packet's Filter-Id attributes, the names
+// of the filter lists to apply to the user, in the order in which they appear
+// in the packet.
+func (p *Packet) FilterIDs() []string {
+	return p.textValues(attributeTypeFilterID)
+}
+
+// AddFilterID adds a Filter-Id attribute with the given filter list name. An
+// error is returned if id is empty or longer than 253 bytes, as a name cannot
+// be split over several attributes.
+func (p *Packet) AddFilterID(id string) error {
+	if len(id) == 0 || len(id) > maxAttributeValueLength {
+		return errors.New("radius: Filter-Id must be between 1 and 253 bytes long")
+	}
+	p.AddAttr(&Attribute{
+		Type:  attributeTypeFilterID,
+		Value: id,
+	})
+	return nil
+}
+
+// textValues returns the values of the packet's attributes of type t, which
+// are decoded with AttributeText. []byte values are converted to strings.
+func (p *Packet) textValues(t byte) []string {
+	p.decodeLazy()
+	var values []string
+	for _, attr := range p.Attributes {
+		if attr.Type != t {
+			continue
+		}
+		switch v := attr.Value.(type) {
+		case string:
+			values = append(values, v)
+		case []byte:
+			values = append(values, string(v))
+		}
+	}
+	return values
+}
+
+// State returns the value of the packet's State attribute, which is opaque
+// data that a client must copy unmodified from an Access-Challenge into its
+// next Access-Request (see Challenge). ok is false if the packet has no State
+// attribute.
+func (p *Packet) State() (state []byte, ok bool) {
+	p.decodeLazy()
+	for _, attr := range p.Attributes {
+		if attr.Type == attributeTypeState {
+			state, ok = attr.Value.([]byte)
+			return
+		}
+	}
+	return
+}
 
-	return mask[:], nil
+// SetState replaces the packet's State attributes with one that carries a
+// copy of state.
+func (p *Packet) SetState(state []byte) {
+	p.Remove(attributeTypeState)
+	p.AddAttr(&Attribute{
+		Type:  attributeTypeState,
+		Value: append([]byte{}, state...),
+	})
 }
@@ -1,5 +1,21 @@
 package radius
 
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Values of the Acct-Status-Type attribute that are defined in RFC 2866.
+const (
+	AcctStatusTypeStart         uint32 = 1
+	AcctStatusTypeStop          uint32 = 2
+	AcctStatusTypeInterimUpdate uint32 = 3
+	AcctStatusTypeAccountingOn  uint32 = 7
+	AcctStatusTypeAccountingOff uint32 = 8
+)
+
 func init() {
 	builtinOnce.Do(initDictionary)
 	Builtin.MustRegister("Acct-Status-Type", 40, AttributeInteger)
@@ -14,4 +30,246 @@ func init() {
 	Builtin.MustRegister("Acct-Terminate-Cause", 49, AttributeInteger)
 	Builtin.MustRegister("Acct-Multi-Session-Id", 50, AttributeText)
 	Builtin.MustRegister("Acct-Link-Count", 51, AttributeInteger)
+
+	Builtin.MustRegisterValue("Acct-Status-Type", "Start", AcctStatusTypeStart)
+	Builtin.MustRegisterValue("Acct-Status-Type", "Stop", AcctStatusTypeStop)
+	Builtin.MustRegisterValue("Acct-Status-Type", "Interim-Update", AcctStatusTypeInterimUpdate)
+	Builtin.MustRegisterValue("Acct-Status-Type", "Accounting-On", AcctStatusTypeAccountingOn)
+	Builtin.MustRegisterValue("Acct-Status-Type", "Accounting-Off", AcctStatusTypeAccountingOff)
+}
+
+// AccountingRequestAuthenticator calculates the request authenticator of an
+// encoded Accounting-Request packet. Unlike the random authenticator of an
+// Access-Request, it is the MD5 hash of the packet (with the authenticator
+// field set to zeros) followed by the shared secret.
+//
+// The authenticator field of wire is ignored and left unmodified.
+func AccountingRequestAuthenticator(wire, secret []byte) ([16]byte, error) {
+	var authenticator [16]byte
+	if len(wire) < 20 {
+		return authenticator, errors.New("radius: packet must be at least 20 bytes long")
+	}
+	var nul [16]byte
+	hash := newMD5()
+	hash.Write(wire[:4])
+	hash.Write(nul[:])
+	hash.Write(wire[20:])
+	hash.Write(secret)
+	hash.Sum(authenticator[0:0])
+	return authenticator, nil
+}
+
+// SetAccountingAuthenticator sets the packet's Authenticator to its request
+// authenticator, as calculated by AccountingRequestAuthenticator. It is only
+// valid for Accounting-Request, Disconnect-Request and CoA-Request packets.
+//
+// Encode always calculates the authenticator of such packets, so calling this
+// method is only needed when the authenticator must be known before the
+// packet is sent, such as to verify a response with IsAuthentic.
+func (p *Packet) SetAccountingAuthenticator() error {
+	switch p.Code {
+	case CodeAccountingRequest, CodeDisconnectRequest, CodeCoARequest:
+	default:
+		return fmt.Errorf("radius: request authenticator of %v cannot be calculated", p.Code)
+	}
+	wire, err := p.Encode()
+	if err != nil {
+		return err
+	}
+	copy(p.Authenticator[:], wire[4:20])
+	return nil
+}
+
+// VerifyAccountingAuthenticator verifies the request authenticator of a
+// received Accounting-Request, Disconnect-Request or CoA-Request packet. nil
+// is returned if it is valid for the packet's secret.
+func (p *Packet) VerifyAccountingAuthenticator() error {
+	switch p.Code {
+	case CodeAccountingRequest, CodeDisconnectRequest, CodeCoARequest:
+	default:
+		return fmt.Errorf("radius: request authenticator of %v cannot be verified", p.Code)
+	}
+	if !p.IsAuthenticRequest() {
+		return errors.New("radius: invalid request authenticator")
+	}
+	return nil
+}
+
+// checkAccountingRequest returns an error if p is not a valid
+// Accounting-Request packet.
+func checkAccountingRequest(p *Packet) error {
+	if p.Code != CodeAccountingRequest {
+		return errors.New("radius: packet is not an Accounting-Request")
+	}
+	value := p.Value("Acct-Status-Type")
+	if value == nil {
+		return fmt.Errorf("%w: Acct-Status-Type", ErrAttributeNotFound)
+	}
+	if _, ok := value.(uint32); !ok {
+		return errors.New("radius: invalid Acct-Status-Type")
+	}
+	return nil
+}
+
+// AccountingSessions tracks the Acct-Status-Type of the accounting sessions of
+// NASes, to detect Accounting-Requests that are out of order, such as a Stop
+// before the Start of a session, or an Interim-Update after its Stop. This can
+// be used by an accounting server to detect NASes that misbehave.
+//
+// Sessions are identified by their Acct-Session-Id, along with the
+// NAS-IP-Address, NAS-IPv6-Address, or NAS-Identifier of the NAS. An
+// Accounting-On or Accounting-Off from a NAS ends all of its sessions.
+//
+// The zero value is ready to use. An AccountingSessions is safe for
+// concurrent use.
+type AccountingSessions struct {
+	// How long stopped sessions are remembered, to detect requests that
+	// follow their Stop. If zero, it defaults to 5 minutes.
+	StopRetention time.Duration
+
+	mu       sync.Mutex
+	sessions map[accountingSessionKey]*accountingSession
+	// stopped sessions, in the order in which they stopped
+	stopped []stoppedAccountingSession
+}
+
+type accountingSessionKey struct {
+	nas string
+	id  string
+}
+
+type accountingSession struct {
+	status    uint32
+	stoppedAt time.Time
+}
+
+type stoppedAccountingSession struct {
+	key       accountingSessionKey
+	stoppedAt time.Time
+}
+
+// Observe updates the state of the session of the Accounting-Request p, and
+// returns a description of each problem that it has, or nil if there is none.
+// The problems are advisory: the state of the session is updated regardless.
+// Retransmissions of a request should not be observed more than once, as a
+// retransmitted Start or Stop is reported as a duplicate.
+func (s *AccountingSessions) Observe(p *Packet) []string {
+	if p.Code != CodeAccountingRequest {
+		return []string{fmt.Sprintf("%v is not an Accounting-Request", p.Code)}
+	}
+	status, ok := p.GetInt("Acct-Status-Type")
+	if !ok {
+		return []string{"Accounting-Request has no Acct-Status-Type"}
+	}
+	nas := p.String("NAS-IP-Address")
+	if nas == "" {
+		nas = p.String("NAS-IPv6-Address")
+	}
+	if nas == "" {
+		nas = p.String("NAS-Identifier")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.expireLocked(now)
+	if s.sessions == nil {
+		s.sessions = make(map[accountingSessionKey]*accountingSession)
+	}
+
+	switch status {
+	case AcctStatusTypeAccountingOn, AcctStatusTypeAccountingOff:
+		open := 0
+		for key, session := range s.sessions {
+			if key.nas != nas {
+				continue
+			}
+			if session.status != AcctStatusTypeStop {
+				open++
+			}
+			delete(s.sessions, key)
+		}
+		if status == AcctStatusTypeAccountingOn && open > 0 {
+			// the NAS restarted without sending Accounting-Off
+			return []string{fmt.Sprintf("Accounting-On while %d session(s) of the NAS were not stopped", open)}
+		}
+		return nil
+	case AcctStatusTypeStart, AcctStatusTypeInterimUpdate, AcctStatusTypeStop:
+	default:
+		return []string{fmt.Sprintf("unknown Acct-Status-Type %d", status)}
+	}
+
+	id, ok := p.GetString("Acct-Session-Id")
+	if !ok {
+		return []string{"Accounting-Request has no Acct-Session-Id"}
+	}
+	key := accountingSessionKey{
+		nas: nas,
+		id:  id,
+	}
+	session := s.sessions[key]
+	var warning string
+	switch {
+	case status == AcctStatusTypeStart && session != nil && session.status == AcctStatusTypeStop:
+		warning = "Start of a session that has stopped"
+	case status == AcctStatusTypeStart && session != nil:
+		warning = "duplicate Start"
+	case status == AcctStatusTypeInterimUpdate && session == nil:
+		warning = "Interim-Update before Start"
+	case status == AcctStatusTypeInterimUpdate && session.status == AcctStatusTypeStop:
+		// the session remains stopped
+		return []string{fmt.Sprintf("session %q: Interim-Update after Stop", id)}
+	case status == AcctStatusTypeStop && session == nil:
+		warning = "Stop before Start"
+	case status == AcctStatusTypeStop && session.status == AcctStatusTypeStop:
+		warning = "duplicate Stop"
+	}
+
+	if session == nil {
+		session = &accountingSession{}
+		s.sessions[key] = session
+	}
+	session.status = status
+	if status == AcctStatusTypeStop {
+		session.stoppedAt = now
+		s.stopped = append(s.stopped, stoppedAccountingSession{
+			key:       key,
+			stoppedAt: now,
+		})
+	}
+	if warning != "" {
+		return []string{fmt.Sprintf("session %q: %s", id, warning)}
+	}
+	return nil
+}
+
+// expireLocked forgets the sessions that stopped before the StopRetention.
+// s.mu must be held.
+func (s *AccountingSessions) expireLocked(now time.Time) {
+	retention := s.StopRetention
+	if retention == 0 {
+		retention = 5 * time.Minute
+	}
+	for len(s.stopped) > 0 && now.Sub(s.stopped[0].stoppedAt) >= retention {
+		stopped := s.stopped[0]
+		s.stopped = s.stopped[1:]
+		// the session may have been restarted, or stopped again, since
+		session := s.sessions[stopped.key]
+		if session != nil && session.status == AcctStatusTypeStop && session.stoppedAt.Equal(stopped.stoppedAt) {
+			delete(s.sessions, stopped.key)
+		}
+	}
+}
+
+// Open returns the number of sessions that have started and not stopped.
+func (s *AccountingSessions) Open() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	open := 0
+	for _, session := range s.sessions {
+		if session.status != AcctStatusTypeStop {
+			open++
+		}
+	}
+	return open
 }
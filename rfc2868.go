@@ -0,0 +1,285 @@
+package radius
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// The tagged attribute value formats that are defined in RFC 2868.
+var (
+	// string
+	AttributeTaggedText AttributeTaggedCodec = attributeTaggedString{text: true}
+	// []byte
+	AttributeTaggedString AttributeTaggedCodec = attributeTaggedString{}
+	// uint32 (only the lower 24 bits can be used)
+	AttributeTaggedInteger AttributeTaggedCodec = attributeTaggedInteger{}
+)
+
+// AttributeSaltEncrypted is the codec of untagged attributes, such as some
+// vendor-specific ones, that are encrypted as described in RFC 2868 section
+// 3.5. The packet's secret and request authenticator are used; values are
+// encoded from string or []byte, and decoded to []byte.
+var AttributeSaltEncrypted AttributeCodec = attributeSaltEncrypted{}
+
+// maximum value of an RFC 2868 tag
+const maxTag = 0x1F
+
+func init() {
+	builtinOnce.Do(initDictionary)
+	Builtin.MustRegisterTagged("Tunnel-Type", 64, AttributeTaggedInteger)
+	Builtin.MustRegisterTagged("Tunnel-Medium-Type", 65, AttributeTaggedInteger)
+	Builtin.MustRegisterTagged("Tunnel-Client-Endpoint", 66, AttributeTaggedText)
+	Builtin.MustRegisterTagged("Tunnel-Server-Endpoint", 67, AttributeTaggedText)
+	Builtin.MustRegisterTagged("Tunnel-Password", 69, rfc2868TunnelPassword{})
+	Builtin.SetSensitive("Tunnel-Password", true)
+	Builtin.MustRegisterTagged("Tunnel-Private-Group-ID", 81, AttributeTaggedText)
+	Builtin.MustRegisterTagged("Tunnel-Assignment-ID", 82, AttributeTaggedText)
+	Builtin.MustRegisterTagged("Tunnel-Preference", 83, AttributeTaggedInteger)
+	Builtin.MustRegisterTagged("Tunnel-Client-Auth-ID", 90, AttributeTaggedText)
+	Builtin.MustRegisterTagged("Tunnel-Server-Auth-ID", 91, AttributeTaggedText)
+}
+
+type attributeTaggedString struct {
+	// decode to string rather than []byte
+	text bool
+}
+
+func (c attributeTaggedString) Decode(packet *Packet, value []byte) (interface{}, error) {
+	_, decoded, err := c.DecodeTagged(packet, value)
+	return decoded, err
+}
+
+func (c attributeTaggedString) Encode(packet *Packet, value interface{}) ([]byte, error) {
+	return c.EncodeTagged(packet, 0, value)
+}
+
+func (c attributeTaggedString) DecodeTagged(packet *Packet, value []byte) (byte, interface{}, error) {
+	var tag byte
+	// A first byte greater than maxTag is part of the value.
+	if len(value) > 0 && value[0] <= maxTag {
+		tag = value[0]
+		value = value[1:]
+	}
+	if c.text {
+		decoded, err := AttributeText.Decode(packet, value)
+		return tag, decoded, err
+	}
+	decoded, err := AttributeString.Decode(packet, value)
+	return tag, decoded, err
+}
+
+func (c attributeTaggedString) EncodeTagged(packet *Packet, tag byte, value interface{}) ([]byte, error) {
+	if tag > maxTag {
+		return nil, errors.New("radius: invalid attribute tag")
+	}
+	var raw []byte
+	var err error
+	if c.text {
+		raw, err = AttributeText.Encode(packet, value)
+	} else {
+		raw, err = AttributeString.Encode(packet, value)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if tag == 0 && (len(raw) == 0 || raw[0] > maxTag) {
+		return raw, nil
+	}
+	wire := make([]byte, 1+len(raw))
+	wire[0] = tag
+	copy(wire[1:], raw)
+	return wire, nil
+}
+
+type attributeTaggedInteger struct{}
+
+func (c attributeTaggedInteger) Decode(packet *Packet, value []byte) (interface{}, error) {
+	_, decoded, err := c.DecodeTagged(packet, value)
+	return decoded, err
+}
+
+func (c attributeTaggedInteger) Encode(packet *Packet, value interface{}) ([]byte, error) {
+	return c.EncodeTagged(packet, 0, value)
+}
+
+func (attributeTaggedInteger) DecodeTagged(packet *Packet, value []byte) (byte, interface{}, error) {
+	if len(value) != 4 {
+		return 0, nil, errors.New("radius: integer attribute has invalid size")
+	}
+	return value[0], binary.BigEndian.Uint32(value) & 0xFFFFFF, nil
+}
+
+func (attributeTaggedInteger) EncodeTagged(packet *Packet, tag byte, value interface{}) ([]byte, error) {
+	if tag > maxTag {
+		return nil, errors.New("radius: invalid attribute tag")
+	}
+	integer, ok := value.(uint32)
+	if !ok {
+		return nil, errors.New("radius: integer attribute must be uint32")
+	}
+	if integer > 0xFFFFFF {
+		return nil, errors.New("radius: tagged integer attribute value is too large")
+	}
+	raw := make([]byte, 4)
+	binary.BigEndian.PutUint32(raw, integer)
+	raw[0] = tag
+	return raw, nil
+}
+
+// rfc2868TunnelPassword is the codec for the Tunnel-Password attribute. The
+// wire format is a tag, a two byte salt, and the encrypted password.
+type rfc2868TunnelPassword struct{}
+
+func (c rfc2868TunnelPassword) Decode(p *Packet, value []byte) (interface{}, error) {
+	_, decoded, err := c.DecodeTagged(p, value)
+	return decoded, err
+}
+
+func (c rfc2868TunnelPassword) Encode(p *Packet, value interface{}) ([]byte, error) {
+	return c.EncodeTagged(p, 0, value)
+}
+
+func (rfc2868TunnelPassword) DecodeTagged(p *Packet, value []byte) (byte, interface{}, error) {
+	if p.Secret == nil {
+		return 0, nil, errors.New("radius: Tunnel-Password attribute requires Packet.Secret")
+	}
+	if len(value) < 1 {
+		return 0, nil, errors.New("radius: invalid Tunnel-Password attribute length")
+	}
+	plaintext, err := DecryptSalt(value[1:], p.Secret, p.Authenticator[:])
+	if err != nil {
+		return 0, nil, err
+	}
+	return value[0], string(plaintext), nil
+}
+
+func (rfc2868TunnelPassword) EncodeTagged(p *Packet, tag byte, value interface{}) ([]byte, error) {
+	if p.Secret == nil {
+		return nil, errors.New("radius: Tunnel-Password attribute requires Packet.Secret")
+	}
+	if tag > maxTag {
+		return nil, errors.New("radius: invalid attribute tag")
+	}
+	password, err := saltPlaintext("Tunnel-Password", value)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := EncryptSalt(password, p.Secret, p.Authenticator[:])
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{tag}, ciphertext...), nil
+}
+
+// maximum length of the plaintext of a salt-encrypted attribute value
+const maxSaltPlaintextLength = 239
+
+// EncryptSalt encrypts the given plaintext as described in RFC 2868 section
+// 3.5. The returned ciphertext starts with a random two byte salt, which is
+// read from Rand, and whose most significant bit is set. The length of the
+// plaintext is prepended to it before it is encrypted, and it is padded with
+// NUL bytes to a multiple of 16 bytes. An error is returned if the plaintext
+// is longer than 239 bytes.
+func EncryptSalt(plaintext, secret, requestAuthenticator []byte) ([]byte, error) {
+	if len(plaintext) > maxSaltPlaintextLength {
+		return nil, errors.New("radius: salt-encrypted attribute is longer than 239 bytes")
+	}
+	length := 1 + len(plaintext)
+	if rem := length % md5.Size; rem != 0 {
+		length += md5.Size - rem
+	}
+	wire := make([]byte, 2+length)
+	if _, err := io.ReadFull(Rand, wire[0:2]); err != nil {
+		return nil, err
+	}
+	// The most significant bit of the salt must be set.
+	wire[0] |= 0x80
+	wire[2] = byte(len(plaintext))
+	copy(wire[3:], plaintext)
+
+	var mask [md5.Size]byte
+	hash := newMD5()
+	hash.Write(secret)
+	hash.Write(requestAuthenticator)
+	hash.Write(wire[0:2])
+	for i := 2; i < len(wire); i += md5.Size {
+		hash.Sum(mask[0:0])
+		for j := range mask {
+			wire[i+j] ^= mask[j]
+		}
+		hash.Reset()
+		hash.Write(secret)
+		hash.Write(wire[i : i+md5.Size])
+	}
+	return wire, nil
+}
+
+// DecryptSalt decrypts the given salt-encrypted ciphertext as described in
+// RFC 2868 section 3.5. The salt, the embedded length, and the padding are
+// removed from the returned plaintext.
+func DecryptSalt(ciphertext, secret, requestAuthenticator []byte) ([]byte, error) {
+	// salt, and at least one block
+	if len(ciphertext) < 2+md5.Size || (len(ciphertext)-2)%md5.Size != 0 {
+		return nil, errors.New("radius: invalid salt-encrypted attribute length")
+	}
+	salt := ciphertext[0:2]
+	ciphertext = ciphertext[2:]
+
+	plaintext := make([]byte, len(ciphertext))
+	var mask [md5.Size]byte
+	hash := newMD5()
+	hash.Write(secret)
+	hash.Write(requestAuthenticator)
+	hash.Write(salt)
+	for i := 0; i < len(ciphertext); i += md5.Size {
+		hash.Sum(mask[0:0])
+		for j := range mask {
+			plaintext[i+j] = ciphertext[i+j] ^ mask[j]
+		}
+		hash.Reset()
+		hash.Write(secret)
+		hash.Write(ciphertext[i : i+md5.Size])
+	}
+
+	length := int(plaintext[0])
+	if length > len(plaintext)-1 {
+		return nil, errors.New("radius: invalid salt-encrypted attribute length")
+	}
+	return plaintext[1 : 1+length], nil
+}
+
+// saltPlaintext returns the plaintext of a salt-encrypted attribute value,
+// which must be a string or []byte.
+func saltPlaintext(name string, value interface{}) ([]byte, error) {
+	switch v := value.(type) {
+	case []byte:
+		return v, nil
+	case string:
+		return []byte(v), nil
+	}
+	return nil, errors.New("radius: " + name + " attribute must be string or []byte")
+}
+
+// attributeSaltEncrypted is the codec of untagged attributes that are
+// encrypted in the same way as Tunnel-Password.
+type attributeSaltEncrypted struct{}
+
+func (attributeSaltEncrypted) Decode(p *Packet, value []byte) (interface{}, error) {
+	if p.Secret == nil {
+		return nil, errors.New("radius: salt-encrypted attribute requires Packet.Secret")
+	}
+	return DecryptSalt(value, p.Secret, p.Authenticator[:])
+}
+
+func (attributeSaltEncrypted) Encode(p *Packet, value interface{}) ([]byte, error) {
+	if p.Secret == nil {
+		return nil, errors.New("radius: salt-encrypted attribute requires Packet.Secret")
+	}
+	plaintext, err := saltPlaintext("salt-encrypted", value)
+	if err != nil {
+		return nil, err
+	}
+	return EncryptSalt(plaintext, p.Secret, p.Authenticator[:])
+}
@@ -0,0 +1,105 @@
+package radius
+
+// types of the Acct-Input-Gigawords and Acct-Output-Gigawords attributes
+const (
+	attributeTypeAcctInputGigawords  = 52
+	attributeTypeAcctOutputGigawords = 53
+)
+
+func init() {
+	builtinOnce.Do(initDictionary)
+	Builtin.MustRegister("Acct-Input-Gigawords", attributeTypeAcctInputGigawords, AttributeInteger)
+	Builtin.MustRegister("Acct-Output-Gigawords", attributeTypeAcctOutputGigawords, AttributeInteger)
+	Builtin.MustRegister("Event-Timestamp", 55, AttributeTime)
+	Builtin.MustRegister("ARAP-Password", 70, AttributeString)
+	Builtin.MustRegister("ARAP-Features", 71, AttributeString)
+	Builtin.MustRegister("ARAP-Zone-Access", 72, AttributeInteger)
+	Builtin.MustRegister("ARAP-Security", 73, AttributeInteger)
+	Builtin.MustRegister("ARAP-Security-Data", 74, AttributeText)
+	Builtin.MustRegister("Password-Retry", 75, AttributeInteger)
+	Builtin.MustRegister("Prompt", 76, AttributeInteger)
+	Builtin.MustRegister("Connect-Info", 77, AttributeText)
+	Builtin.MustRegister("Configuration-Token", 78, AttributeText)
+	Builtin.MustRegister("ARAP-Challenge-Response", 84, AttributeString)
+	Builtin.MustRegister("Acct-Interim-Interval", 85, AttributeInteger)
+	Builtin.MustRegister("NAS-Port-Id", 87, AttributeText)
+	Builtin.MustRegister("Framed-Pool", 88, AttributeText)
+}
+
+// AcctInputOctets returns the number of octets received, combining the
+// Acct-Input-Octets and Acct-Input-Gigawords attributes into a single 64-bit
+// value. ok is false if the packet does not have an Acct-Input-Octets
+// attribute.
+func (p *Packet) AcctInputOctets() (octets uint64, ok bool) {
+	return p.octets64("Acct-Input-Octets", "Acct-Input-Gigawords")
+}
+
+// AcctOutputOctets returns the number of octets sent, combining the
+// Acct-Output-Octets and Acct-Output-Gigawords attributes into a single 64-bit
+// value. ok is false if the packet does not have an Acct-Output-Octets
+// attribute.
+func (p *Packet) AcctOutputOctets() (octets uint64, ok bool) {
+	return p.octets64("Acct-Output-Octets", "Acct-Output-Gigawords")
+}
+
+// InputBytes is like AcctInputOctets, but returns zero if the packet does not
+// have an Acct-Input-Octets attribute.
+func (p *Packet) InputBytes() uint64 {
+	octets, _ := p.AcctInputOctets()
+	return octets
+}
+
+// OutputBytes is like AcctOutputOctets, but returns zero if the packet does
+// not have an Acct-Output-Octets attribute.
+func (p *Packet) OutputBytes() uint64 {
+	octets, _ := p.AcctOutputOctets()
+	return octets
+}
+
+// SetInputBytes sets the packet's Acct-Input-Octets attribute to the low 32
+// bits of n, and its Acct-Input-Gigawords attribute to the high 32 bits. If
+// the high bits are zero, the Acct-Input-Gigawords attribute is removed
+// instead.
+func (p *Packet) SetInputBytes(n uint64) error {
+	return p.setOctets64("Acct-Input-Octets", "Acct-Input-Gigawords", attributeTypeAcctInputGigawords, n)
+}
+
+// SetOutputBytes sets the packet's Acct-Output-Octets attribute to the low 32
+// bits of n, and its Acct-Output-Gigawords attribute to the high 32 bits. If
+// the high bits are zero, the Acct-Output-Gigawords attribute is removed
+// instead.
+func (p *Packet) SetOutputBytes(n uint64) error {
+	return p.setOctets64("Acct-Output-Octets", "Acct-Output-Gigawords", attributeTypeAcctOutputGigawords, n)
+}
+
+// NASPortID returns the value of the packet's NAS-Port-Id attribute, a text
+// identifier of the NAS port that is authenticating the user, such as
+// "eth0/1:100". ok is false if the packet has no NAS-Port-Id attribute.
+func (p *Packet) NASPortID() (id string, ok bool) {
+	return p.GetString("NAS-Port-Id")
+}
+
+// SetNASPortID sets the value of the packet's NAS-Port-Id attribute.
+func (p *Packet) SetNASPortID(id string) error {
+	return p.Set("NAS-Port-Id", id)
+}
+
+func (p *Packet) octets64(octetsName, gigawordsName string) (uint64, bool) {
+	octets, ok := p.Value(octetsName).(uint32)
+	if !ok {
+		return 0, false
+	}
+	gigawords, _ := p.Value(gigawordsName).(uint32)
+	return uint64(gigawords)<<32 | uint64(octets), true
+}
+
+func (p *Packet) setOctets64(octetsName, gigawordsName string, gigawordsType byte, n uint64) error {
+	if err := p.Set(octetsName, uint32(n)); err != nil {
+		return err
+	}
+	if n>>32 == 0 {
+		p.Remove(gigawordsType)
+		return nil
+	}
+	return p.Set(gigawordsName, uint32(n>>32))
+}
@@ -0,0 +1,139 @@
+package radius
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+)
+
+// The IPv6 attribute value formats that are defined in RFC 3162.
+var (
+	// net.IP
+	AttributeIPv6Address AttributeCodec = attributeIPv6Address{}
+	// netip.Prefix
+	AttributeIPv6Prefix AttributeCodec = attributeIPv6Prefix{}
+	// [8]byte
+	AttributeIfid AttributeCodec = attributeIfid{}
+)
+
+func init() {
+	builtinOnce.Do(initDictionary)
+	Builtin.MustRegister("NAS-IPv6-Address", 95, AttributeIPv6Address)
+	Builtin.MustRegister("Framed-Interface-Id", 96, AttributeIfid)
+	Builtin.MustRegister("Framed-IPv6-Prefix", 97, AttributeIPv6Prefix)
+	Builtin.MustRegister("Login-IPv6-Host", 98, AttributeIPv6Address)
+	Builtin.MustRegister("Framed-IPv6-Route", 99, AttributeText)
+	Builtin.MustRegister("Framed-IPv6-Pool", 100, AttributeText)
+}
+
+type attributeIPv6Address struct{}
+
+func (attributeIPv6Address) Decode(packet *Packet, value []byte) (interface{}, error) {
+	if len(value) != net.IPv6len {
+		return nil, errors.New("radius: IPv6 address attribute has invalid size")
+	}
+	return net.IP(packet.wireValue(value)), nil
+}
+
+func (attributeIPv6Address) Encode(packet *Packet, value interface{}) ([]byte, error) {
+	var ip net.IP
+	switch v := value.(type) {
+	case net.IP:
+		ip = v
+	case netip.Addr:
+		if !v.Is6() {
+			return nil, errors.New("radius: IPv6 address attribute must be an IPv6 address")
+		}
+		raw := v.As16()
+		return raw[:], nil
+	case []byte:
+		// an encoded value, such as from NewIPAttr
+		if len(v) != net.IPv6len {
+			return nil, errors.New("radius: IPv6 address attribute has invalid size")
+		}
+		return v, nil
+	default:
+		return nil, errors.New("radius: IPv6 address attribute must be net.IP or netip.Addr")
+	}
+	if len(ip) != net.IPv6len || ip.To4() != nil {
+		return nil, errors.New("radius: IPv6 address attribute must be an IPv6 net.IP")
+	}
+	raw := make([]byte, net.IPv6len)
+	copy(raw, ip)
+	return raw, nil
+}
+
+// attributeIfid is the codec for 64-bit IPv6 interface identifiers, such as
+// those derived from an EUI-64. Values can be encoded from a [8]byte, or from
+// a []byte or net.HardwareAddr that is 8 bytes long.
+type attributeIfid struct{}
+
+// length of an interface identifier
+const ifidLength = 8
+
+func (attributeIfid) Decode(packet *Packet, value []byte) (interface{}, error) {
+	if len(value) != ifidLength {
+		return nil, errors.New("radius: interface identifier attribute has invalid size")
+	}
+	var ifid [ifidLength]byte
+	copy(ifid[:], value)
+	return ifid, nil
+}
+
+func (attributeIfid) Encode(packet *Packet, value interface{}) ([]byte, error) {
+	var raw []byte
+	switch v := value.(type) {
+	case [ifidLength]byte:
+		return v[:], nil
+	case []byte:
+		raw = v
+	case net.HardwareAddr:
+		raw = v
+	default:
+		return nil, errors.New("radius: interface identifier attribute must be [8]byte, []byte, or net.HardwareAddr")
+	}
+	if len(raw) != ifidLength {
+		return nil, fmt.Errorf("radius: interface identifier attribute must be %d bytes long; got %d", ifidLength, len(raw))
+	}
+	return append([]byte(nil), raw...), nil
+}
+
+// attributeIPv6Prefix is the codec for IPv6 prefixes. The wire format is a
+// reserved byte, the prefix length, and only the significant bytes of the
+// prefix.
+type attributeIPv6Prefix struct{}
+
+func (attributeIPv6Prefix) Decode(packet *Packet, value []byte) (interface{}, error) {
+	if len(value) < 2 || len(value) > 2+net.IPv6len {
+		return nil, errors.New("radius: IPv6 prefix attribute has invalid size")
+	}
+	bits := int(value[1])
+	if bits > 128 || len(value)-2 < (bits+7)/8 {
+		return nil, errors.New("radius: IPv6 prefix attribute has invalid prefix length")
+	}
+	var addr [16]byte
+	copy(addr[:], value[2:])
+	// Bits past the prefix length are not part of the prefix.
+	prefix, err := netip.AddrFrom16(addr).Prefix(bits)
+	if err != nil {
+		return nil, err
+	}
+	return prefix, nil
+}
+
+func (attributeIPv6Prefix) Encode(packet *Packet, value interface{}) ([]byte, error) {
+	prefix, ok := value.(netip.Prefix)
+	if !ok {
+		return nil, errors.New("radius: IPv6 prefix attribute must be netip.Prefix")
+	}
+	if !prefix.IsValid() || !prefix.Addr().Is6() {
+		return nil, errors.New("radius: IPv6 prefix attribute must be a valid IPv6 prefix")
+	}
+	bits := prefix.Bits()
+	addr := prefix.Masked().Addr().As16()
+	raw := make([]byte, 2+(bits+7)/8)
+	raw[1] = byte(bits)
+	copy(raw[2:], addr[:])
+	return raw, nil
+}
@@ -0,0 +1,152 @@
+package radius
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// types of the EAP-Message and Message-Authenticator attributes
+const (
+	attributeTypeEAPMessage           = 79
+	attributeTypeMessageAuthenticator = 80
+)
+
+func init() {
+	builtinOnce.Do(initDictionary)
+	Builtin.MustRegister("EAP-Message", attributeTypeEAPMessage, AttributeString)
+	Builtin.MustRegister("Message-Authenticator", attributeTypeMessageAuthenticator, AttributeString)
+}
+
+// EAPMessage returns the EAP packet that is carried by the packet. EAP packets
+// that are longer than 253 bytes are split over several EAP-Message
+// attributes; their values are concatenated in the order in which they appear
+// in the packet.
+//
+// An error is returned if the packet has no EAP-Message attribute. An
+// EAP-Message attribute with no data (as used to signal EAP-Start) results in
+// an empty, non-nil slice.
+func (p *Packet) EAPMessage() ([]byte, error) {
+	p.decodeLazy()
+	var found bool
+	message := []byte{}
+	for _, attr := range p.Attributes {
+		if attr.Type != attributeTypeEAPMessage {
+			continue
+		}
+		value, ok := attr.Value.([]byte)
+		if !ok {
+			return nil, errors.New("radius: EAP-Message attribute must be []byte")
+		}
+		message = append(message, value...)
+		found = true
+	}
+	if !found {
+		return nil, fmt.Errorf("%w: EAP-Message", ErrAttributeNotFound)
+	}
+	return message, nil
+}
+
+// SetEAPMessage replaces the packet's EAP-Message attributes with ones that
+// carry data, split into chunks of at most 253 bytes. If data is empty, a
+// single EAP-Message attribute with no data is added.
+//
+// A Message-Authenticator attribute is also added if the packet does not have
+// one, as is required by RFC 3579.
+func (p *Packet) SetEAPMessage(data []byte) {
+	p.Remove(attributeTypeEAPMessage)
+	p.addEAPMessage(data)
+	p.AddMessageAuthenticator()
+}
+
+// addEAPMessage adds EAP-Message attributes that carry data, split into chunks
+// of at most 253 bytes.
+func (p *Packet) addEAPMessage(data []byte) {
+	for {
+		chunk := data
+		if len(chunk) > maxAttributeValueLength {
+			chunk = chunk[:maxAttributeValueLength]
+		}
+		value := make([]byte, len(chunk))
+		copy(value, chunk)
+		p.AddAttr(&Attribute{
+			Type:  attributeTypeEAPMessage,
+			Value: value,
+		})
+		data = data[len(chunk):]
+		if len(data) == 0 {
+			break
+		}
+	}
+}
+
+// AddMessageAuthenticator adds a Message-Authenticator attribute to the packet,
+// if it does not already have one. The attribute's value is calculated when
+// the packet is encoded, and it is encoded after all of the other attributes.
+func (p *Packet) AddMessageAuthenticator() {
+	p.decodeLazy()
+	for _, attr := range p.Attributes {
+		if attr.Type == attributeTypeMessageAuthenticator {
+			return
+		}
+	}
+	p.AddAttr(&Attribute{
+		Type:  attributeTypeMessageAuthenticator,
+		Value: make([]byte, md5.Size),
+	})
+}
+
+// VerifyMessageAuthenticator verifies the value of the packet's
+// Message-Authenticator attribute, as described in RFC 3579 section 3.2. nil
+// is returned if the value is valid.
+//
+// The HMAC of a response covers the authenticator of its request, so this
+// method can only be used to verify request packets.
+func (p *Packet) VerifyMessageAuthenticator() error {
+	var authenticator [16]byte
+	switch p.Code {
+	case CodeAccessRequest, CodeStatusServer:
+		authenticator = p.Authenticator
+	case CodeAccountingRequest, CodeDisconnectRequest, CodeCoARequest:
+		// The HMAC is calculated with the authenticator set to zeros.
+	default:
+		return errors.New("radius: Message-Authenticator can only be verified for requests")
+	}
+	return p.verifyMessageAuthenticator(authenticator)
+}
+
+// verifyMessageAuthenticator verifies the value of the packet's
+// Message-Authenticator attribute, calculating the HMAC using the given
+// authenticator.
+func (p *Packet) verifyMessageAuthenticator(authenticator [16]byte) error {
+	attrs, offset, err := p.authenticatedAttributes()
+	if err != nil {
+		return err
+	}
+	if offset < 0 {
+		return fmt.Errorf("%w: Message-Authenticator", ErrAttributeNotFound)
+	}
+
+	var received [md5.Size]byte
+	copy(received[:], attrs[offset:offset+md5.Size])
+	for i := offset; i < offset+md5.Size; i++ {
+		attrs[i] = 0
+	}
+
+	var header [4]byte
+	header[0] = byte(p.Code)
+	header[1] = p.Identifier
+	binary.BigEndian.PutUint16(header[2:4], uint16(20+len(attrs)))
+
+	hash := newHMACMD5(p.Secret)
+	hash.Write(header[:])
+	hash.Write(authenticator[:])
+	hash.Write(attrs)
+	var sum [md5.Size]byte
+	if !hmac.Equal(hash.Sum(sum[0:0]), received[:]) {
+		return errors.New("radius: invalid Message-Authenticator")
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package radius
+
+import (
+	"net"
+	"strings"
+)
+
+// StationMAC returns the MAC address, and the SSID if there is one, that is
+// carried by a Calling-Station-Id or Called-Station-Id value of an IEEE 802.1X
+// authentication: RFC 3580 (sections 3.20 and 3.21) formats the address as
+// "00-10-A4-23-19-C0", followed by ":" and the SSID in a Called-Station-Id.
+// Addresses separated by colons or dots, as sent by some NASes, are accepted
+// too; addresses without separators are not, as they cannot be told apart
+// from telephone numbers. ok is false if stationID does not start with a 48
+// bit MAC address.
+func StationMAC(stationID string) (mac net.HardwareAddr, ssid string, ok bool) {
+	var address string
+	// "00-10-A4-23-19-C0" and "0010.a423.19c0" are 17 and 14 characters
+	// long; "00:10:A4:23:19:C0" is followed by the SSID after a colon too.
+	switch {
+	case len(stationID) >= 17 && strings.Count(stationID[:17], "-") == 5:
+		address = strings.ReplaceAll(stationID[:17], "-", ":")
+		ssid = stationID[17:]
+	case len(stationID) >= 17 && strings.Count(stationID[:17], ":") == 5:
+		address = stationID[:17]
+		ssid = stationID[17:]
+	case len(stationID) >= 14 && strings.Count(stationID[:14], ".") == 2:
+		address = stationID[:14]
+		ssid = stationID[14:]
+	default:
+		return nil, "", false
+	}
+	if ssid != "" {
+		if ssid[0] != ':' {
+			return nil, "", false
+		}
+		ssid = ssid[1:]
+	}
+	mac, err := net.ParseMAC(address)
+	if err != nil || len(mac) != 6 {
+		return nil, "", false
+	}
+	return mac, ssid, true
+}
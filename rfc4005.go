@@ -0,0 +1,39 @@
+package radius
+
+import "strings"
+
+func init() {
+	builtinOnce.Do(initDictionary)
+	Builtin.MustRegister("Originating-Line-Info", 94, AttributeString)
+}
+
+// maximum number of digits of an E.164 number
+const maxE164Digits = 15
+
+// E164Number returns the telephone number that is carried by a
+// Calling-Station-Id or Called-Station-Id value, such as the ANI and DNIS of
+// dial-up and mobile calls (RFC 4005, section 9.3), without its formatting:
+// spaces, dashes, dots, and parentheses are removed, and a leading "+" is
+// kept. ok is false if stationID contains anything else, or does not have
+// between 1 and 15 digits, as is the case for the MAC addresses that are
+// carried by 802.1X station IDs (see StationMAC).
+func E164Number(stationID string) (number string, ok bool) {
+	var b strings.Builder
+	digits := 0
+	for i, r := range stationID {
+		switch {
+		case r >= '0' && r <= '9':
+			b.WriteRune(r)
+			digits++
+		case r == '+' && i == 0:
+			b.WriteRune(r)
+		case r == ' ' || r == '-' || r == '.' || r == '(' || r == ')':
+		default:
+			return "", false
+		}
+	}
+	if digits == 0 || digits > maxE164Digits {
+		return "", false
+	}
+	return b.String(), true
+}
@@ -0,0 +1,168 @@
+package radius
+
+import (
+	"context"
+	"errors"
+)
+
+// Codes which are defined in RFC 5176.
+const (
+	CodeDisconnectRequest Code = 40
+	CodeDisconnectACK     Code = 41
+	CodeDisconnectNAK     Code = 42
+	CodeCoARequest        Code = 43
+	CodeCoAACK            Code = 44
+	CodeCoANAK            Code = 45
+)
+
+// Values of the Error-Cause attribute that are defined in RFC 5176.
+const (
+	ErrorCauseResidualSessionContextRemoved       uint32 = 201
+	ErrorCauseInvalidEAPPacket                    uint32 = 202
+	ErrorCauseUnsupportedAttribute                uint32 = 401
+	ErrorCauseMissingAttribute                    uint32 = 402
+	ErrorCauseNASIdentificationMismatch           uint32 = 403
+	ErrorCauseInvalidRequest                      uint32 = 404
+	ErrorCauseUnsupportedService                  uint32 = 405
+	ErrorCauseUnsupportedExtension                uint32 = 406
+	ErrorCauseInvalidAttributeValue               uint32 = 407
+	ErrorCauseAdministrativelyProhibited          uint32 = 501
+	ErrorCauseRequestNotRoutable                  uint32 = 502
+	ErrorCauseSessionContextNotFound              uint32 = 503
+	ErrorCauseSessionContextNotRemovable          uint32 = 504
+	ErrorCauseOtherProxyProcessingError           uint32 = 505
+	ErrorCauseResourcesUnavailable                uint32 = 506
+	ErrorCauseRequestInitiated                    uint32 = 507
+	ErrorCauseMultipleSessionSelectionUnsupported uint32 = 508
+)
+
+// type of the Error-Cause attribute
+const attributeTypeErrorCause = 101
+
+func init() {
+	builtinOnce.Do(initDictionary)
+	Builtin.MustRegister("Error-Cause", attributeTypeErrorCause, AttributeInteger)
+}
+
+// IsAuthenticRequest returns if the packet is a request whose authenticator
+// is valid for the packet's secret. Calling this function is only valid if
+// p.Code is one of:
+//  CodeAccountingRequest
+//  CodeDisconnectRequest
+//  CodeCoARequest
+//
+// The authenticator of an Access-Request is random, and cannot be verified;
+// false is returned for all other codes.
+func (p *Packet) IsAuthenticRequest() bool {
+	switch p.Code {
+	case CodeAccountingRequest, CodeDisconnectRequest, CodeCoARequest:
+		return p.IsAuthentic(p)
+	}
+	return false
+}
+
+// Disconnect sends packet to the NAS at addr as a Disconnect-Request, and
+// waits for its Disconnect-ACK or Disconnect-NAK response. The code of packet
+// is ignored.
+//
+// The request authenticator is calculated when the request is encoded, in the
+// same way as that of an Accounting-Request.
+func (c *Client) Disconnect(ctx context.Context, packet *Packet, addr string) (*Packet, error) {
+	return c.exchangeDynamicAuthorization(ctx, CodeDisconnectRequest, packet, addr)
+}
+
+// ChangeOfAuthorization sends packet to the NAS at addr as a CoA-Request, and
+// waits for its CoA-ACK or CoA-NAK response. The code of packet is ignored.
+//
+// The request authenticator is calculated when the request is encoded, in the
+// same way as that of an Accounting-Request.
+func (c *Client) ChangeOfAuthorization(ctx context.Context, packet *Packet, addr string) (*Packet, error) {
+	return c.exchangeDynamicAuthorization(ctx, CodeCoARequest, packet, addr)
+}
+
+func (c *Client) exchangeDynamicAuthorization(ctx context.Context, code Code, packet *Packet, addr string) (*Packet, error) {
+	request := *packet
+	request.Code = code
+	response, err := c.ExchangeContext(ctx, &request, addr)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case code == CodeDisconnectRequest && (response.Code == CodeDisconnectACK || response.Code == CodeDisconnectNAK):
+	case code == CodeCoARequest && (response.Code == CodeCoAACK || response.Code == CodeCoANAK):
+	default:
+		return nil, errors.New("radius: unexpected response code")
+	}
+	return response, nil
+}
+
+// ACK returns a new Disconnect-ACK or CoA-ACK response to the Disconnect-Request
+// or CoA-Request p. As with Response, the response authenticator is
+// calculated when the packet is encoded.
+func (p *Packet) ACK() (*Packet, error) {
+	switch p.Code {
+	case CodeDisconnectRequest:
+		return p.Response(CodeDisconnectACK)
+	case CodeCoARequest:
+		return p.Response(CodeCoAACK)
+	}
+	return nil, errors.New("radius: packet is not a Disconnect-Request or CoA-Request")
+}
+
+// NAK returns a new Disconnect-NAK or CoA-NAK response to the
+// Disconnect-Request or CoA-Request p. If errorCause is non-zero, an
+// Error-Cause attribute with that value (such as
+// ErrorCauseSessionContextNotFound) is added.
+func (p *Packet) NAK(errorCause uint32) (*Packet, error) {
+	var response *Packet
+	var err error
+	switch p.Code {
+	case CodeDisconnectRequest:
+		response, err = p.Response(CodeDisconnectNAK)
+	case CodeCoARequest:
+		response, err = p.Response(CodeCoANAK)
+	default:
+		return nil, errors.New("radius: packet is not a Disconnect-Request or CoA-Request")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if errorCause != 0 {
+		response.AddAttr(&Attribute{
+			Type:  attributeTypeErrorCause,
+			Value: errorCause,
+		})
+	}
+	return response, nil
+}
+
+// DynamicAuthorizationHandler returns a Handler for a NAS that receives
+// Disconnect-Request and CoA-Request packets (RFC 5176), usually on port 3799.
+// The Server verifies their request authenticators before they are handled.
+//
+// apply is called with each request, and should disconnect the session, or
+// change its authorization, depending on the Code of the request. If it
+// returns zero, the request is acknowledged with a Disconnect-ACK or CoA-ACK;
+// otherwise, it is rejected with a Disconnect-NAK or CoA-NAK that carries the
+// returned Error-Cause. Packets with other codes are dropped.
+//
+// Retransmitted requests are answered with the first response, without calling
+// apply again, if the server's DuplicateWindow is set.
+func DynamicAuthorizationHandler(apply func(request *Packet) (errorCause uint32)) Handler {
+	return HandlerFunc(func(w ResponseWriter, p *Packet) {
+		if p.Code != CodeDisconnectRequest && p.Code != CodeCoARequest {
+			return
+		}
+		var response *Packet
+		var err error
+		if errorCause := apply(p); errorCause != 0 {
+			response, err = p.NAK(errorCause)
+		} else {
+			response, err = p.ACK()
+		}
+		if err != nil {
+			return
+		}
+		w.Write(response)
+	})
+}
@@ -0,0 +1,26 @@
+package radius
+
+import (
+	"context"
+	"errors"
+)
+
+// Ping checks that the RADIUS server at addr is alive, by sending it a
+// Status-Server packet, as described in RFC 5997. nil is returned if the
+// server responded with an Access-Accept or Accounting-Response.
+func (c *Client) Ping(ctx context.Context, secret []byte, addr string) error {
+	request, err := NewPacket(CodeStatusServer, secret)
+	if err != nil {
+		return err
+	}
+	request.AddMessageAuthenticator()
+
+	response, err := c.ExchangeContext(ctx, request, addr)
+	if err != nil {
+		return err
+	}
+	if response.Code != CodeAccessAccept && response.Code != CodeAccountingResponse {
+		return errors.New("radius: unexpected response to Status-Server")
+	}
+	return nil
+}
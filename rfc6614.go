@@ -0,0 +1,343 @@
+package radius
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// RadSecSecret is the shared secret that is used for RADIUS over TLS, as
+// defined in RFC 6614. The integrity and confidentiality of the packets are
+// provided by TLS instead.
+const RadSecSecret = "radsec"
+
+// readStreamPacket reads a RADIUS packet from a stream. Over TLS, packets are
+// not prefixed; each one is delimited by the Length field of its header.
+func readStreamPacket(r io.Reader) ([]byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	length := int(binary.BigEndian.Uint16(header[2:4]))
+	if length < 20 || length > maxPacketSize() {
+		return nil, errors.New("radius: invalid packet length")
+	}
+	wire := make([]byte, length)
+	copy(wire, header[:])
+	if _, err := io.ReadFull(r, wire[4:]); err != nil {
+		return nil, err
+	}
+	return wire, nil
+}
+
+// ListenAndServeTLS starts a RADIUS over TLS (RadSec) server on the address
+// given in s. If s.Addr is empty, the address defaults to ":2083". s.Network
+// is ignored; TLS connections are always accepted over TCP.
+//
+// Multiple packets can be exchanged over each connection. If neither Secret
+// nor SecretSource is set, RadSecSecret is used as the shared secret.
+func (s *Server) ListenAndServeTLS() error {
+	if s.Handler == nil {
+		return errors.New("radius: nil Handler")
+	}
+	if s.TLSConfig == nil {
+		return errors.New("radius: nil TLSConfig")
+	}
+
+	addr := ":2083"
+	if s.Addr != "" {
+		addr = s.Addr
+	}
+	listener, err := tls.Listen("tcp", addr, s.TLSConfig)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		listener.Close()
+		return ErrServerClosed
+	}
+	if s.tlsListener != nil {
+		s.mu.Unlock()
+		listener.Close()
+		return errors.New("radius: server already started")
+	}
+	s.tlsListener = listener
+	s.tlsConns = make(map[net.Conn]struct{})
+	s.mu.Unlock()
+
+	state := s.newState([]byte(RadSecSecret))
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if s.isClosed() {
+				return ErrServerClosed
+			}
+			if netErr, ok := err.(net.Error); ok && netErr.Temporary() {
+				continue
+			}
+			return err
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return ErrServerClosed
+		}
+		s.tlsConns[conn] = struct{}{}
+		s.mu.Unlock()
+
+		go s.serveTLSConn(state, conn)
+	}
+}
+
+// serveTLSConn handles the packets that are received on a TLS connection.
+func (s *Server) serveTLSConn(state *serverState, conn net.Conn) {
+	var (
+		writeLock sync.Mutex
+		handlers  sync.WaitGroup
+	)
+	defer func() {
+		// The connection stays open until its handlers have responded.
+		handlers.Wait()
+		s.mu.Lock()
+		delete(s.tlsConns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	for {
+		wire, err := readStreamPacket(conn)
+		if err != nil {
+			return
+		}
+		if !s.startHandler() {
+			return
+		}
+		handlers.Add(1)
+
+		go func(wire []byte) {
+			defer s.handlers.Done()
+			defer handlers.Done()
+			response := responseWriter{
+				localAddr:  conn.LocalAddr(),
+				remoteAddr: conn.RemoteAddr(),
+				write: func(wire []byte) error {
+					writeLock.Lock()
+					defer writeLock.Unlock()
+					_, err := conn.Write(wire)
+					return err
+				},
+			}
+			s.handle(state, wire, &response)
+		}(wire)
+	}
+}
+
+// closeTLSConns closes all of the server's TLS connections.
+func (s *Server) closeTLSConns() {
+	s.mu.Lock()
+	conns := s.tlsConns
+	s.tlsConns = nil
+	s.mu.Unlock()
+	for conn := range conns {
+		conn.Close()
+	}
+}
+
+// ClientTLS is a RADIUS over TLS (RadSec) client. A connection to each server
+// is kept open and reused for subsequent exchanges, until Close is called.
+type ClientTLS struct {
+	// TLS configuration of the connections, including the client's
+	// certificate.
+	Config *tls.Config
+
+	// Timeouts for various operations. Default values for each field is 10
+	// seconds.
+	DialTimeout  time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+
+	mu     sync.Mutex
+	conns  map[string]*tlsClientConn
+	closed bool
+}
+
+// tlsClientConn is a connection of ClientTLS. Exchanges over the connection
+// are made one at a time.
+type tlsClientConn struct {
+	mu   sync.Mutex
+	conn *tls.Conn
+}
+
+// Exchange sends the packet to the given server address and waits for a
+// response. nil and an error is returned upon failure. If addr has no port,
+// port 2083 is used.
+//
+// If the packet's Secret is nil, RadSecSecret is used.
+func (c *ClientTLS) Exchange(packet *Packet, addr string) (*Packet, error) {
+	return c.ExchangeContext(context.Background(), packet, addr)
+}
+
+// RoundTrip makes an exchange with ExchangeContext. It implements
+// PacketRoundTripper.
+func (c *ClientTLS) RoundTrip(ctx context.Context, req *Packet, addr string) (*Packet, error) {
+	return c.ExchangeContext(ctx, req, addr)
+}
+
+// ExchangeContext is like Exchange, but the exchange is aborted when ctx is
+// cancelled or its deadline is reached. In that case, nil and ctx.Err() are
+// returned.
+func (c *ClientTLS) ExchangeContext(ctx context.Context, packet *Packet, addr string) (*Packet, error) {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "2083")
+	}
+
+	request := *packet
+	if request.Secret == nil {
+		request.Secret = []byte(RadSecSecret)
+	}
+	wire, err := request.Encode()
+	if err != nil {
+		return nil, err
+	}
+	copy(request.Authenticator[:], wire[4:20])
+
+	conn, err := c.conn(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+
+	response, err := c.exchange(ctx, conn.conn, &request, wire)
+	if err != nil {
+		// The state of the stream is unknown; it cannot be reused.
+		c.discard(addr, conn)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, err
+	}
+	return response, nil
+}
+
+func (c *ClientTLS) exchange(ctx context.Context, conn *tls.Conn, request *Packet, wire []byte) (*Packet, error) {
+	if done := ctx.Done(); done != nil {
+		stop := make(chan struct{})
+		defer close(stop)
+		go func() {
+			select {
+			case <-done:
+				conn.SetDeadline(time.Unix(1, 0))
+			case <-stop:
+			}
+		}()
+	}
+
+	const defaultTimeout = 10 * time.Second
+	writeTimeout := c.WriteTimeout
+	if writeTimeout == 0 {
+		writeTimeout = defaultTimeout
+	}
+	readTimeout := c.ReadTimeout
+	if readTimeout == 0 {
+		readTimeout = defaultTimeout
+	}
+
+	conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(wire); err != nil {
+		return nil, err
+	}
+
+	readDeadline := time.Now().Add(readTimeout)
+	if deadline, ok := ctx.Deadline(); ok && deadline.Before(readDeadline) {
+		readDeadline = deadline
+	}
+	conn.SetReadDeadline(readDeadline)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	for {
+		incoming, err := readStreamPacket(conn)
+		if err != nil {
+			return nil, err
+		}
+		received, err := ParseResponse(incoming, request)
+		if err == nil && received.VerifyResponse(request) == nil {
+			return received, nil
+		}
+	}
+}
+
+// conn returns the open connection to addr, dialing it if needed.
+func (c *ClientTLS) conn(ctx context.Context, addr string) (*tlsClientConn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil, ErrClientClosed
+	}
+	if conn, ok := c.conns[addr]; ok {
+		return conn, nil
+	}
+
+	dialTimeout := c.DialTimeout
+	if dialTimeout == 0 {
+		dialTimeout = 10 * time.Second
+	}
+	dialer := tls.Dialer{
+		NetDialer: &net.Dialer{
+			Timeout: dialTimeout,
+		},
+		Config: c.Config,
+	}
+	netConn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	conn := &tlsClientConn{
+		conn: netConn.(*tls.Conn),
+	}
+	if c.conns == nil {
+		c.conns = make(map[string]*tlsClientConn)
+	}
+	c.conns[addr] = conn
+	return conn, nil
+}
+
+// discard closes a connection that can no longer be used.
+func (c *ClientTLS) discard(addr string, conn *tlsClientConn) {
+	c.mu.Lock()
+	if c.conns[addr] == conn {
+		delete(c.conns, addr)
+	}
+	c.mu.Unlock()
+	conn.conn.Close()
+}
+
+// Close closes all of the client's connections.
+func (c *ClientTLS) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	var err error
+	for addr, conn := range c.conns {
+		if closeErr := conn.conn.Close(); err == nil {
+			err = closeErr
+		}
+		delete(c.conns, addr)
+	}
+	return err
+}
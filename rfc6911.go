@@ -0,0 +1,10 @@
+package radius
+
+func init() {
+	builtinOnce.Do(initDictionary)
+	Builtin.MustRegister("Framed-IPv6-Address", 168, AttributeIPv6Address)
+	Builtin.MustRegister("DNS-Server-IPv6-Address", 169, AttributeIPv6Address)
+	Builtin.MustRegister("Route-IPv6-Information", 170, AttributeIPv6Prefix)
+	Builtin.MustRegister("Delegated-IPv6-Prefix-Pool", 171, AttributeText)
+	Builtin.MustRegister("Stateful-IPv6-Address-Pool", 172, AttributeText)
+}
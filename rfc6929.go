@@ -0,0 +1,336 @@
+package radius
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// The attribute value formats that are defined in RFC 6929.
+var (
+	// uint64
+	AttributeInteger64 AttributeCodec = attributeInteger64{}
+)
+
+// types of the attributes that carry RFC 6929 extended attributes
+const (
+	attributeTypeExtended1     = 241
+	attributeTypeExtended4     = 244
+	attributeTypeLongExtended1 = 245
+	attributeTypeLongExtended2 = 246
+)
+
+// flag of a long extended attribute that is continued in the next attribute
+const longExtendedMore = 0x80
+
+// maximum length of a long extended attribute's value in a single attribute
+// (extended type and flags bytes)
+const maxLongExtendedFragmentLength = maxAttributeValueLength - 2
+
+func init() {
+	builtinOnce.Do(initDictionary)
+	for t := byte(attributeTypeExtended1); t <= attributeTypeLongExtended2; t++ {
+		Builtin.MustRegister(extendedTypeName(t), t, attributeExtended{t})
+	}
+}
+
+// ExtendedAttribute is an RFC 6929 extended attribute. It is carried as the
+// value of an attribute of type 241 to 244 (Extended-Type-1 to
+// Extended-Type-4), or 245 and 246 (Long-Extended-Type-1 and
+// Long-Extended-Type-2).
+//
+// The value of a long extended attribute may be longer than a single
+// attribute can carry; it is split over consecutive attributes (with the More
+// flag set in all but the last) when the packet is encoded, and reassembled
+// when it is parsed.
+type ExtendedAttribute struct {
+	ExtendedType byte
+	Value        interface{}
+}
+
+// isExtendedType returns if t is the type of an attribute that carries
+// extended attributes.
+func isExtendedType(t byte) bool {
+	return t >= attributeTypeExtended1 && t <= attributeTypeLongExtended2
+}
+
+// isLongExtendedType returns if t is the type of an attribute that carries
+// long extended attributes.
+func isLongExtendedType(t byte) bool {
+	return t == attributeTypeLongExtended1 || t == attributeTypeLongExtended2
+}
+
+// extendedTypeName returns the RFC 6929 name of the attribute type t, which
+// carries extended attributes.
+func extendedTypeName(t byte) string {
+	if isLongExtendedType(t) {
+		return fmt.Sprintf("Long-Extended-Type-%d", t-attributeTypeExtended4)
+	}
+	return fmt.Sprintf("Extended-Type-%d", t-attributeTypeExtended1+1)
+}
+
+// extendedKey returns the key of an extended attribute in
+// Dictionary.extended.
+func extendedKey(t, extendedType byte) uint16 {
+	return uint16(t)<<8 | uint16(extendedType)
+}
+
+// RegisterExtended registers the AttributeCodec for the given RFC 6929
+// extended attribute name, the type of the attribute that carries it (241 to
+// 246), and its extended type.
+//
+// If the attribute type t has not been registered, it is registered with the
+// codec of extended attributes, under its RFC 6929 name (such as
+// "Extended-Type-1").
+func (d *Dictionary) RegisterExtended(name string, t byte, extendedType byte, codec AttributeCodec) error {
+	if !isExtendedType(t) {
+		return fmt.Errorf("radius: attribute type %d cannot carry extended attributes", t)
+	}
+	if extendedType == 0 {
+		return errors.New("radius: invalid extended type 0")
+	}
+	entry := &DictionaryEntry{
+		Type:         t,
+		ExtendedType: extendedType,
+		Name:         name,
+		Codec:        codec,
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.storedLocked(entry) != nil {
+		return ErrAttributeAlreadyRegistered
+	}
+	d.storeLocked(entry)
+	if d.attributesByName == nil {
+		d.attributesByName = make(map[string]*DictionaryEntry)
+	}
+	d.attributesByName[name] = entry
+
+	if d.attributesByType[t] == nil {
+		carrier := &DictionaryEntry{
+			Type:  t,
+			Name:  extendedTypeName(t),
+			Codec: attributeExtended{t},
+		}
+		d.attributesByType[t] = carrier
+		d.attributesByName[carrier.Name] = carrier
+	}
+	return nil
+}
+
+// MustRegisterExtended is a helper for RegisterExtended that panics if it
+// returns an error.
+func (d *Dictionary) MustRegisterExtended(name string, t byte, extendedType byte, codec AttributeCodec) {
+	if err := d.RegisterExtended(name, t, extendedType, codec); err != nil {
+		panic(err)
+	}
+}
+
+// ExtendedAttributeName returns the registered name for the given extended
+// attribute type. ok is false if the given extended type is not registered.
+func (d *Dictionary) ExtendedAttributeName(t byte, extendedType byte) (name string, ok bool) {
+	if d == nil {
+		d = &emptyDictionary
+	}
+	d.mu.RLock()
+	entry := d.extended[extendedKey(t, extendedType)]
+	d.mu.RUnlock()
+	if entry == nil {
+		return
+	}
+	name = entry.Name
+	ok = true
+	return
+}
+
+// RemoveExtended removes an extended attribute from the dictionary by type. It
+// returns an error only if the extended attribute type does not exist.
+func (d *Dictionary) RemoveExtended(t byte, extendedType byte) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	key := extendedKey(t, extendedType)
+	entry := d.extended[key]
+	if entry == nil {
+		return ErrAttributeNotRegistered
+	}
+	d.unnameLocked(entry.Name)
+	delete(d.extended, key)
+	return nil
+}
+
+// extendedCodec returns the AttributeCodec for the given extended attribute
+// type. AttributeUnknown is returned if the type is not registered.
+func (d *Dictionary) extendedCodec(t byte, extendedType byte) AttributeCodec {
+	if entry := d.extendedEntry(t, extendedType); entry != nil {
+		return entry.Codec
+	}
+	return AttributeUnknown
+}
+
+// extendedEntry returns the entry registered for the given extended attribute
+// type, or nil if there is none.
+func (d *Dictionary) extendedEntry(t byte, extendedType byte) *DictionaryEntry {
+	if d == nil {
+		d = &emptyDictionary
+	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.extended[extendedKey(t, extendedType)]
+}
+
+// attributeExtended is the codec of the attributes that carry extended
+// attributes. Values are decoded into *ExtendedAttribute, and
+// *ExtendedAttribute, []byte, and string values can be encoded. The codec of
+// a long extended type only handles values that fit in a single attribute;
+// longer ones are split by Packet.Encode and reassembled by Parse.
+type attributeExtended struct {
+	// type of the attribute that carries the extended attributes
+	t byte
+}
+
+// header returns the length of the header that precedes the value of an
+// extended attribute.
+func (a attributeExtended) header() int {
+	if isLongExtendedType(a.t) {
+		// extended type and flags
+		return 2
+	}
+	// extended type
+	return 1
+}
+
+func (a attributeExtended) Decode(packet *Packet, value []byte) (interface{}, error) {
+	if len(value) < a.header() {
+		return nil, errors.New("radius: extended attribute is too short")
+	}
+	if isLongExtendedType(a.t) && value[1]&longExtendedMore != 0 {
+		return nil, errors.New("radius: long extended attribute is not followed by its continuation")
+	}
+	_, decoded, err := packet.Dictionary.extendedEntry(a.t, value[0]).decode(packet, value[a.header():])
+	if err != nil {
+		return nil, err
+	}
+	return &ExtendedAttribute{
+		ExtendedType: value[0],
+		Value:        decoded,
+	}, nil
+}
+
+func (a attributeExtended) Encode(packet *Packet, value interface{}) ([]byte, error) {
+	switch v := value.(type) {
+	case []byte:
+		return v, nil
+	case string:
+		return []byte(v), nil
+	}
+	attr, ok := value.(*ExtendedAttribute)
+	if !ok {
+		return nil, errors.New("radius: extended attribute must be *ExtendedAttribute, []byte, or string")
+	}
+	wire, err := packet.Dictionary.extendedCodec(a.t, attr.ExtendedType).Encode(packet, attr.Value)
+	if err != nil {
+		return nil, err
+	}
+	if len(wire) > maxAttributeValueLength-a.header() {
+		return nil, errors.New("radius: encoded extended attribute is too long")
+	}
+	raw := make([]byte, a.header(), a.header()+len(wire))
+	raw[0] = attr.ExtendedType
+	return append(raw, wire...), nil
+}
+
+// isLongExtendedCodec returns if the attribute type t is registered in the
+// dictionary with the codec of long extended attributes.
+func (d *Dictionary) isLongExtendedCodec(t byte) bool {
+	codec, ok := d.Codec(t).(attributeExtended)
+	return ok && isLongExtendedType(codec.t)
+}
+
+// parseLongExtendedAttribute parses a long extended attribute that is
+// continued over consecutive attributes, at the start of attributes. ok is
+// false if the first attribute does not start such an attribute. Otherwise,
+// the reassembled attribute and the number of bytes of attributes that it
+// used are returned.
+func parseLongExtendedAttribute(packet *Packet, attributes []byte) (attr *Attribute, n int, ok bool, err error) {
+	// The attribute lengths have already been validated by Parse.
+	t := attributes[0]
+	if attributes[1] < 4 || attributes[3]&longExtendedMore == 0 || !packet.Dictionary.isLongExtendedCodec(t) {
+		return nil, 0, false, nil
+	}
+	extendedType := attributes[2]
+	var value []byte
+	for more := true; more; {
+		rest := attributes[n:]
+		if len(rest) < 4 || rest[0] != t || rest[1] < 4 || rest[2] != extendedType {
+			return nil, 0, true, errors.New("radius: long extended attribute is not followed by its continuation")
+		}
+		value = append(value, rest[4:rest[1]]...)
+		more = rest[3]&longExtendedMore != 0
+		n += int(rest[1])
+	}
+
+	_, decoded, err := packet.Dictionary.extendedEntry(t, extendedType).decode(packet, value)
+	if err != nil {
+		return nil, 0, true, err
+	}
+	attr = &Attribute{
+		Type: t,
+		Value: &ExtendedAttribute{
+			ExtendedType: extendedType,
+			Value:        decoded,
+		},
+	}
+	return attr, n, true, nil
+}
+
+// encodeLongExtendedAttribute encodes a long extended attribute into the
+// values of the attributes that carry it. ok is false if attr is not a long
+// extended attribute.
+func (p *Packet) encodeLongExtendedAttribute(attr *Attribute) (values [][]byte, ok bool, err error) {
+	extended, isExtended := attr.Value.(*ExtendedAttribute)
+	if !isExtended || !p.Dictionary.isLongExtendedCodec(attr.Type) {
+		return nil, false, nil
+	}
+	wire, err := p.Dictionary.extendedCodec(attr.Type, extended.ExtendedType).Encode(p, extended.Value)
+	if err != nil {
+		return nil, true, err
+	}
+	for {
+		fragment := wire
+		var flags byte
+		if len(fragment) > maxLongExtendedFragmentLength {
+			fragment = fragment[:maxLongExtendedFragmentLength]
+			flags = longExtendedMore
+		}
+		value := make([]byte, 0, 2+len(fragment))
+		value = append(value, extended.ExtendedType, flags)
+		value = append(value, fragment...)
+		values = append(values, value)
+		wire = wire[len(fragment):]
+		if len(wire) == 0 {
+			return values, true, nil
+		}
+	}
+}
+
+type attributeInteger64 struct{}
+
+func (attributeInteger64) Decode(packet *Packet, value []byte) (interface{}, error) {
+	if len(value) != 8 {
+		return nil, errors.New("radius: integer64 attribute has invalid size")
+	}
+	return binary.BigEndian.Uint64(value), nil
+}
+
+func (attributeInteger64) Encode(packet *Packet, value interface{}) ([]byte, error) {
+	integer, ok := value.(uint64)
+	if !ok {
+		return nil, errors.New("radius: integer64 attribute must be uint64")
+	}
+	raw := make([]byte, 8)
+	binary.BigEndian.PutUint64(raw, integer)
+	return raw, nil
+}
@@ -0,0 +1,90 @@
+package radius
+
+import (
+	"context"
+	"net"
+	"sync"
+)
+
+// SecretSource supplies the shared secret that is used with a RADIUS client.
+type SecretSource interface {
+	// RADIUSSecret returns the shared secret of the client at remoteAddr. A nil
+	// secret indicates that the client is unknown.
+	RADIUSSecret(ctx context.Context, remoteAddr net.Addr) ([]byte, error)
+}
+
+// StaticSecretSource returns a SecretSource that uses the same secret for all
+// clients.
+func StaticSecretSource(secret []byte) SecretSource {
+	return staticSecretSource(secret)
+}
+
+type staticSecretSource []byte
+
+func (s staticSecretSource) RADIUSSecret(ctx context.Context, remoteAddr net.Addr) ([]byte, error) {
+	return []byte(s), nil
+}
+
+// MultiSecretSource is a SecretSource that can supply several secrets that are
+// accepted from a client, such as while its secret is being changed. It is
+// used by Server in place of RADIUSSecret when a SecretSource implements it.
+type MultiSecretSource interface {
+	SecretSource
+	// RADIUSSecrets returns the secrets that are accepted from the client at
+	// remoteAddr, the current one first. No secrets indicate that the client
+	// is unknown.
+	RADIUSSecrets(ctx context.Context, remoteAddr net.Addr) ([][]byte, error)
+}
+
+// clientSecrets returns the secrets that are accepted from the client at
+// remoteAddr.
+func clientSecrets(ctx context.Context, source SecretSource, remoteAddr net.Addr) ([][]byte, error) {
+	if multi, ok := source.(MultiSecretSource); ok {
+		return multi.RADIUSSecrets(ctx, remoteAddr)
+	}
+	secret, err := source.RADIUSSecret(ctx, remoteAddr)
+	if err != nil || secret == nil {
+		return nil, err
+	}
+	return [][]byte{secret}, nil
+}
+
+// RotatingSecretSource is a MultiSecretSource that uses the same secrets for
+// all clients, and whose secrets can be changed while a server is using it.
+// It is safe for concurrent use.
+type RotatingSecretSource struct {
+	mu      sync.RWMutex
+	secrets [][]byte
+}
+
+// NewRotatingSecretSource returns a RotatingSecretSource whose current secret
+// is secret, and which also accepts the previous secrets.
+func NewRotatingSecretSource(secret []byte, previous ...[]byte) *RotatingSecretSource {
+	s := &RotatingSecretSource{}
+	s.Set(secret, previous...)
+	return s
+}
+
+// Set replaces the secrets of s. secret is the current secret; the previous
+// secrets are still accepted from clients that have not been updated yet.
+// Once all of them have been, Set should be called again without them.
+func (s *RotatingSecretSource) Set(secret []byte, previous ...[]byte) {
+	secrets := append([][]byte{secret}, previous...)
+	s.mu.Lock()
+	s.secrets = secrets
+	s.mu.Unlock()
+}
+
+// RADIUSSecret returns the current secret.
+func (s *RotatingSecretSource) RADIUSSecret(ctx context.Context, remoteAddr net.Addr) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.secrets[0], nil
+}
+
+// RADIUSSecrets returns the current secret followed by the previous ones.
+func (s *RotatingSecretSource) RADIUSSecrets(ctx context.Context, remoteAddr net.Addr) ([][]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.secrets, nil
+}
@@ -1,12 +1,31 @@
 package radius
 
 import (
+	"context"
+	"crypto/tls"
 	"errors"
 	"net"
 	"sync"
+	"time"
 )
 
+// ErrServerClosed is returned by Server.ListenAndServe after a call to
+// Shutdown or Close.
+var ErrServerClosed = errors.New("radius: server closed")
+
 // Handler is a value that can handle a server's RADIUS packet event.
+//
+// The packet p that is given to ServeRadius carries the shared secret that it
+// was authenticated with (which, during a secret rotation, may be a previous
+// secret of the client) and the server's Dictionary, so that a response can be
+// created with p.Response, or one of the methods that use it such as Reject,
+// without configuring them again:
+//
+//  response, err := p.Response(radius.CodeAccessAccept)
+//  if err == nil {
+//  	response.Add("Session-Timeout", uint32(3600))
+//  	w.Write(response)
+//  }
 type Handler interface {
 	ServeRadius(w ResponseWriter, p *Packet)
 }
@@ -43,20 +62,36 @@ type ResponseWriter interface {
 }
 
 type responseWriter struct {
-	// listener that received the packet
-	conn *net.UDPConn
-	// where the packet came from
-	addr *net.UDPAddr
+	localAddr  net.Addr
+	remoteAddr net.Addr
+	// sends an encoded packet to the sender
+	write func(wire []byte) error
 	// original packet
 	packet *Packet
+	// copy the Proxy-State attributes of packet into responses
+	echoProxyState bool
+	// add a Message-Authenticator attribute to responses
+	addMessageAuthenticator bool
+	// called with a copy of each response before it is encoded, if non-nil
+	middleware func(packet *Packet)
+
+	// called after a response is sent, if non-nil
+	onResponse func(code Code, duration time.Duration)
+	// when the request was received
+	start time.Time
+
+	// where the response is stored, if duplicate detection is enabled
+	cache    ResponseCache
+	cacheKey RequestKey
+	cacheTTL time.Duration
 }
 
 func (r *responseWriter) LocalAddr() net.Addr {
-	return r.conn.LocalAddr()
+	return r.localAddr
 }
 
 func (r *responseWriter) RemoteAddr() net.Addr {
-	return r.addr
+	return r.remoteAddr
 }
 
 func (r *responseWriter) accessRespond(code Code, attributes ...*Attribute) error {
@@ -90,13 +125,46 @@ func (r *responseWriter) AccessChallenge(attributes ...*Attribute) error {
 }
 
 func (r *responseWriter) Write(packet *Packet) error {
-	raw, err := packet.Encode()
+	if r.echoProxyState && r.packet != nil && packet.Len(attributeTypeProxyState) == 0 && r.packet.Len(attributeTypeProxyState) > 0 {
+		echo := *packet
+		echo.Attributes = append([]*Attribute(nil), packet.Attributes...)
+		echo.CopyProxyState(r.packet)
+		packet = &echo
+	}
+	if r.middleware != nil {
+		// a deep copy, as Set modifies attributes in place
+		packet = packet.Clone()
+		r.middleware(packet)
+	}
+	if r.addMessageAuthenticator && packet.Len(attributeTypeMessageAuthenticator) == 0 {
+		authenticated := *packet
+		authenticated.Attributes = append([]*Attribute(nil), packet.Attributes...)
+		authenticated.AddMessageAuthenticator()
+		packet = &authenticated
+	}
+	raw, buf, err := packet.encodePooled()
 	if err != nil {
 		return err
 	}
-	if _, err := r.conn.WriteToUDP(raw, r.addr); err != nil {
+	defer releaseEncodeBuffer(buf)
+	if err := r.writeRaw(raw); err != nil {
+		return err
+	}
+	if r.cache != nil {
+		// the cache keeps the response after the buffer is reused
+		r.cache.Put(r.cacheKey, append([]byte(nil), raw...), r.cacheTTL)
+	}
+	return nil
+}
+
+// writeRaw sends an encoded response.
+func (r *responseWriter) writeRaw(raw []byte) error {
+	if err := r.write(raw); err != nil {
 		return err
 	}
+	if r.onResponse != nil {
+		r.onResponse(Code(raw[0]), time.Since(r.start))
+	}
 	return nil
 }
 
@@ -104,37 +172,194 @@ func (r *responseWriter) Write(packet *Packet) error {
 type Server struct {
 	// Address to bind the server on. If empty, the address defaults to ":1812".
 	Addr string
+	// Addresses to bind the server on, such as the authentication and
+	// accounting ports, or IPv4 and IPv6 addresses. If non-empty, Addr is
+	// ignored by ListenAndServe, and a socket is opened for each address. The
+	// sockets share the server's Handler and secrets.
+	Addrs []string
 	// Network of the server. Valid values are "udp", "udp4", "udp6". If empty,
 	// the network defaults to "udp".
 	Network string
-	// The shared secret between the client and server.
+	// The shared secret between the client and server. It is ignored if
+	// SecretSource is set. It can be changed while the server is running
+	// with SetSecret.
 	Secret []byte
-
-	// TODO: allow a secret function to be defined, which returned the secret
-	// that should be used for the given client.
-
-	// Dictionary used when decoding incoming packets.
+	// Supplies the shared secret of each client, based on the address that a
+	// packet was received from. If nil, Secret is used for all clients.
+	//
+	// If it implements MultiSecretSource, a request is accepted if its
+	// authenticators are valid for any of the client's secrets (an
+	// Access-Request without a Message-Authenticator cannot be verified, and
+	// uses the first one). The packet that is passed to Handler has the
+	// secret that was accepted, so its responses use the same secret as the
+	// client.
+	SecretSource SecretSource
+	// Called when a packet is dropped because SecretSource did not return a
+	// secret for its sender. err is the error returned by SecretSource, if
+	// any. If nil, such packets are dropped silently.
+	UnknownClient func(remoteAddr net.Addr, err error)
+
+	// Dictionary used when decoding incoming packets, and given to the
+	// handler with them. If nil, Builtin is used.
 	Dictionary *Dictionary
 
 	// The packet handler that handles incoming, valid packets.
 	Handler Handler
 
-	listener *net.UDPConn
+	// If non-zero, responses are remembered for this duration. A duplicate of
+	// a request (same client address, Identifier, and authenticator) that is
+	// received within the window is answered with the remembered response,
+	// rather than being passed to Handler again.
+	DuplicateWindow time.Duration
+	// Where responses are remembered when DuplicateWindow is set. If nil, an
+	// in-memory cache is used.
+	ResponseCache ResponseCache
+
+	// If non-zero, Status-Server requests (RFC 5997) are answered
+	// automatically with a response of this code, rather than being passed to
+	// Handler. It should be CodeAccessAccept for an authentication server, and
+	// CodeAccountingResponse for an accounting server.
+	StatusServerResponse Code
+
+	// If true, the Proxy-State attributes of a request are copied into its
+	// response, unless the response already has Proxy-State attributes.
+	EchoProxyState bool
+
+	// If true, a Message-Authenticator attribute is added to every response
+	// that does not already have one, as is required for EAP (RFC 3579). Its
+	// value is calculated when the response is encoded. The packet passed to
+	// ResponseWriter.Write is not modified.
+	AddMessageAuthenticator bool
+
+	// If true, requests that have an EAP-Message attribute but no
+	// Message-Authenticator attribute are dropped, as RFC 3579 requires. This
+	// protects EAP exchanges from forged or modified requests, such as the
+	// attacks on the Access-Request authenticator that are known as
+	// Blast-RADIUS. A Message-Authenticator attribute whose value is invalid
+	// is always dropped.
+	RequireMessageAuthenticatorForEAP bool
+
+	// Called with each response that is written by a handler, before it is
+	// encoded, so that its attributes can be changed for every response. It
+	// is given a copy of the packet that is passed to ResponseWriter.Write,
+	// which is not modified. It is called after the Proxy-State attributes
+	// are copied (see EchoProxyState), and before the Message-Authenticator
+	// attribute is added (see AddMessageAuthenticator); the authenticators of
+	// the response are calculated after it returns. Remembered responses that
+	// are sent again to duplicate requests are not passed to it again. It may
+	// be called concurrently. If nil, responses are sent as they are written.
+	ResponseMiddleware func(packet *Packet)
+
+	// Called for each valid request that is received, including duplicates
+	// and Status-Server requests, with the request's code. If nil, it is not
+	// called.
+	OnRequest func(code Code)
+	// Called for each response that is sent, with the response's code and the
+	// time since its request was received. If nil, it is not called.
+	//
+	// OnRequest and OnResponse are called from the goroutines that handle
+	// requests, so they may be called concurrently.
+	OnResponse func(code Code, duration time.Duration)
+
+	// If positive, the size of the operating system's receive buffer of each
+	// UDP socket (SO_RCVBUF). Larger buffers help avoid dropped packets during
+	// bursts. If zero, the system default is used.
+	ReadBuffer int
+	// Number of goroutines that read from each UDP socket. If zero, a single
+	// goroutine reads packets, and each packet is handled in a new goroutine.
+	// If positive, each of the goroutines handles the packets that it reads
+	// itself, with its own buffer, so at most Readers packets are handled at
+	// once per socket, without the cost of starting a goroutine per packet.
+	//
+	// With several readers, duplicates of a request may be read at the same
+	// time; they are detected in the same way as with a single reader (see
+	// DuplicateWindow), and ResponseCache must be safe for concurrent use.
+	Readers int
+
+	// If true, each response is sent from the local address that its request
+	// was received on, rather than from the address that the system selects
+	// for the client, which may differ on a host with several addresses
+	// when a socket is bound to the unspecified address (such as ":1812").
+	// ResponseWriter.LocalAddr is then that address too. The address is read
+	// from the IP_PKTINFO and IPV6_PKTINFO control messages of each packet,
+	// which are only supported on Linux; ListenAndServe returns an error on
+	// other systems.
+	ReplyFromLocalAddr bool
+
+	// Receives messages about packets that are dropped, and about temporary
+	// errors reading from a socket. If nil, nothing is logged.
+	Logger Logger
+
+	// TLS configuration used by ListenAndServeTLS.
+	TLSConfig *tls.Config
+
+	mu        sync.Mutex
+	listeners []net.PacketConn
+	closed    bool
+	// handlers that are currently running
+	handlers sync.WaitGroup
+
+	// secrets used when SecretSource is nil; set by SetSecret, or when the
+	// server starts
+	secrets *RotatingSecretSource
+
+	// used by ListenAndServeTLS
+	tlsListener net.Listener
+	tlsConns    map[net.Conn]struct{}
 }
 
-// ListenAndServe starts a RADIUS server on the address given in s.
-func (s *Server) ListenAndServe() error {
-	if s.listener != nil {
-		return errors.New("radius: server already started")
+// serverState is the state that is shared by the handlers of a running
+// server.
+type serverState struct {
+	secretSource SecretSource
+	cache        ResponseCache
+
+	activeLock sync.Mutex
+	active     map[RequestKey]bool
+}
+
+// newState returns the state of a server that is starting. defaultSecret is
+// used if neither Secret nor SecretSource is set.
+func (s *Server) newState(defaultSecret []byte) *serverState {
+	state := &serverState{
+		secretSource: s.SecretSource,
+		cache:        s.ResponseCache,
+		active:       make(map[RequestKey]bool),
 	}
+	if state.secretSource == nil {
+		s.mu.Lock()
+		if s.secrets == nil {
+			secret := s.Secret
+			if secret == nil {
+				secret = defaultSecret
+			}
+			s.secrets = NewRotatingSecretSource(secret)
+		}
+		state.secretSource = s.secrets
+		s.mu.Unlock()
+	}
+	if s.DuplicateWindow > 0 && state.cache == nil {
+		state.cache = NewMemoryCache()
+	}
+	return state
+}
 
+// ListenAndServe starts a RADIUS server on the address given in s, or on
+// each of the addresses in s.Addrs. It returns once all of the sockets have
+// stopped; if one of them fails, the others are closed, and the failure is
+// returned.
+func (s *Server) ListenAndServe() error {
 	if s.Handler == nil {
 		return errors.New("radius: nil Handler")
 	}
 
-	addrStr := ":1812"
-	if s.Addr != "" {
-		addrStr = s.Addr
+	addrs := s.Addrs
+	if len(addrs) == 0 {
+		addr := ":1812"
+		if s.Addr != "" {
+			addr = s.Addr
+		}
+		addrs = []string{addr}
 	}
 
 	network := "udp"
@@ -142,76 +367,469 @@ func (s *Server) ListenAndServe() error {
 		network = s.Network
 	}
 
-	addr, err := net.ResolveUDPAddr(network, addrStr)
-	if err != nil {
-		return err
+	var listeners []net.PacketConn
+	closeListeners := func() {
+		for _, listener := range listeners {
+			listener.Close()
+		}
 	}
-	s.listener, err = net.ListenUDP(network, addr)
-	if err != nil {
-		return err
+	for _, addrStr := range addrs {
+		addr, err := net.ResolveUDPAddr(network, addrStr)
+		if err != nil {
+			closeListeners()
+			return err
+		}
+		listener, err := net.ListenUDP(network, addr)
+		if err != nil {
+			closeListeners()
+			return err
+		}
+		listeners = append(listeners, listener)
+		if err := s.configure(listener); err != nil {
+			closeListeners()
+			return err
+		}
+	}
+	return s.serveListeners(listeners)
+}
+
+// Serve handles the packets that are received on conn, until it fails or the
+// server is closed, as with ListenAndServe; the server's addresses are not
+// used. conn is closed when Serve returns. It is usually a *net.UDPConn, to
+// which ReadBuffer and ReplyFromLocalAddr are applied, but any packet
+// connection can be given, such as one of a MemoryNetwork.
+func (s *Server) Serve(conn net.PacketConn) error {
+	if s.Handler == nil {
+		conn.Close()
+		return errors.New("radius: nil Handler")
+	}
+	udp, ok := conn.(*net.UDPConn)
+	if ok {
+		if err := s.configure(udp); err != nil {
+			conn.Close()
+			return err
+		}
+	} else if s.ReplyFromLocalAddr {
+		conn.Close()
+		return errors.New("radius: ReplyFromLocalAddr requires a *net.UDPConn")
+	}
+	return s.serveListeners([]net.PacketConn{conn})
+}
+
+// configure applies the socket options of the server to a UDP socket.
+func (s *Server) configure(listener *net.UDPConn) error {
+	if s.ReadBuffer > 0 {
+		if err := listener.SetReadBuffer(s.ReadBuffer); err != nil {
+			return err
+		}
 	}
+	if s.ReplyFromLocalAddr {
+		if err := enablePacketInfo(listener); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	type activeKey struct {
-		IP         string
-		Identifier byte
+// serveListeners serves on the given sockets until they have all stopped.
+func (s *Server) serveListeners(listeners []net.PacketConn) error {
+	closeListeners := func() {
+		for _, listener := range listeners {
+			listener.Close()
+		}
+	}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		closeListeners()
+		return ErrServerClosed
+	}
+	if s.listeners != nil {
+		s.mu.Unlock()
+		closeListeners()
+		return errors.New("radius: server already started")
 	}
+	s.listeners = listeners
+	s.mu.Unlock()
 
-	var (
-		activeLock sync.Mutex
-		active     = map[activeKey]bool{}
-	)
+	state := s.newState(nil)
 
+	errs := make(chan error, len(listeners))
+	for _, listener := range listeners {
+		go func(listener net.PacketConn) {
+			errs <- s.serve(state, listener)
+		}(listener)
+	}
+	var err error
+	for range listeners {
+		if serveErr := <-errs; err == nil {
+			err = serveErr
+			if serveErr != ErrServerClosed {
+				// one of the sockets failed; stop the others
+				closeListeners()
+			}
+		}
+	}
+	return err
+}
+
+// serve handles the packets that are received on listener, using s.Readers
+// goroutines.
+func (s *Server) serve(state *serverState, listener net.PacketConn) error {
+	if s.Readers <= 0 {
+		return s.read(state, listener, false)
+	}
+	errs := make(chan error, s.Readers)
+	for i := 0; i < s.Readers; i++ {
+		go func() {
+			errs <- s.read(state, listener, true)
+		}()
+	}
+	var err error
+	for i := 0; i < s.Readers; i++ {
+		if readErr := <-errs; err == nil {
+			err = readErr
+			if readErr != ErrServerClosed {
+				// stop the other readers
+				listener.Close()
+			}
+		}
+	}
+	return err
+}
+
+// read reads packets from listener until it is closed. If inline is true,
+// each packet is handled before the next one is read, and the read buffer is
+// reused; otherwise, each packet is handled in a new goroutine.
+func (s *Server) read(state *serverState, listener net.PacketConn, inline bool) error {
+	var reused []byte
+	if inline {
+		reused = make([]byte, maxPacketSize())
+	}
+	udp, _ := listener.(*net.UDPConn)
+	// control messages of each packet, if ReplyFromLocalAddr is set
+	var oob []byte
+	if s.ReplyFromLocalAddr && udp != nil {
+		oob = make([]byte, packetInfoSize)
+	}
 	for {
-		buff := make([]byte, 4096)
-		n, remoteAddr, err := s.listener.ReadFromUDP(buff)
-		if err != nil && !err.(*net.OpError).Temporary() {
-			break
+		buff := reused
+		if !inline {
+			buff = make([]byte, maxPacketSize())
+		}
+		var n int
+		var remoteAddr net.Addr
+		// local address of the packet and the control message that sends
+		// its response from it, if ReplyFromLocalAddr is set
+		var local net.IP
+		var reply []byte
+		var err error
+		if oob != nil {
+			var udpAddr *net.UDPAddr
+			n, udpAddr, local, reply, err = readPacketInfo(udp, buff, oob)
+			remoteAddr = udpAddr
+		} else {
+			n, remoteAddr, err = listener.ReadFrom(buff)
+		}
+		if err != nil {
+			if s.isClosed() {
+				return ErrServerClosed
+			}
+			if netErr, ok := err.(net.Error); ok && netErr.Temporary() {
+				if s.Logger != nil {
+					s.Logger.Warnf("radius: temporary error reading from %s: %v", listener.LocalAddr(), err)
+				}
+				continue
+			}
+			return err
 		}
 		if n == 0 {
 			continue
 		}
 		buff = buff[:n]
-		//go s.handleUDP(s.listener, buff, remoteAddr)
-		go func(conn *net.UDPConn, buff []byte, remoteAddr *net.UDPAddr) {
-			packet, err := Parse(buff, s.Secret, s.Dictionary)
-			if err != nil {
-				return
+
+		if !s.startHandler() {
+			return ErrServerClosed
+		}
+
+		handle := func(conn net.PacketConn, buff []byte, remoteAddr net.Addr, local net.IP, reply []byte) {
+			defer s.handlers.Done()
+			response := responseWriter{
+				localAddr:  conn.LocalAddr(),
+				remoteAddr: remoteAddr,
+				write: func(wire []byte) error {
+					_, err := conn.WriteTo(wire, remoteAddr)
+					return err
+				},
 			}
-			key := activeKey{
-				IP:         remoteAddr.String(),
-				Identifier: packet.Identifier,
+			if reply != nil {
+				localAddr := *udp.LocalAddr().(*net.UDPAddr)
+				localAddr.IP = local
+				response.localAddr = &localAddr
+				response.write = func(wire []byte) error {
+					_, _, err := udp.WriteMsgUDP(wire, reply, remoteAddr.(*net.UDPAddr))
+					return err
+				}
 			}
-			activeLock.Lock()
-			if _, ok := active[key]; ok {
-				activeLock.Unlock()
-				return
+			s.handle(state, buff, &response)
+		}
+		if inline {
+			// Parse does not retain buff, so it can be reused.
+			handle(listener, buff, remoteAddr, local, reply)
+		} else {
+			go handle(listener, buff, remoteAddr, local, reply)
+		}
+	}
+}
+
+// isClosed returns if Shutdown or Close has been called.
+func (s *Server) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+// startHandler registers a handler that is about to start. false is returned
+// if the server is shutting down, in which case the handler must not run.
+func (s *Server) startHandler() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.handlers.Add(1)
+	return true
+}
+
+// handle decodes and validates a received packet, and passes it to the
+// server's Handler. The packet is dropped if it is invalid, or if it is a
+// duplicate of a packet that is currently being handled.
+func (s *Server) handle(state *serverState, buff []byte, response *responseWriter) {
+	if s.OnResponse != nil {
+		response.onResponse = s.OnResponse
+		response.start = time.Now()
+	}
+	secrets, err := clientSecrets(context.Background(), state.secretSource, response.remoteAddr)
+	if err != nil || len(secrets) == 0 {
+		if s.Logger != nil {
+			if err != nil {
+				s.Logger.Warnf("radius: dropping packet from %s: no secret: %v", response.remoteAddr, err)
+			} else {
+				s.Logger.Warnf("radius: dropping packet from %s: unknown client", response.remoteAddr)
 			}
-			active[key] = true
-			activeLock.Unlock()
+		}
+		if s.UnknownClient != nil {
+			s.UnknownClient(response.remoteAddr, err)
+		}
+		return
+	}
 
-			response := responseWriter{
-				conn:   conn,
-				addr:   remoteAddr,
-				packet: packet,
+	// The packet is parsed with each of the secrets, as attributes such as
+	// User-Password are decrypted with it, until its authenticators are valid.
+	dictionary := s.Dictionary
+	if dictionary == nil {
+		dictionary = Builtin
+	}
+	var packet, invalid *Packet
+	var parseErr error
+	for _, secret := range secrets {
+		candidate, err := Parse(buff, secret, dictionary)
+		if err != nil {
+			parseErr = err
+			continue
+		}
+		if isValidRequest(candidate) {
+			packet = candidate
+			break
+		}
+		invalid = candidate
+	}
+	if packet == nil {
+		if s.Logger != nil {
+			if invalid != nil {
+				s.Logger.Warnf("radius: dropping %v from %s: invalid authenticator (the shared secret may be wrong)", invalid.Code, response.remoteAddr)
+			} else {
+				s.Logger.Debugf("radius: dropping packet from %s: %v", response.remoteAddr, parseErr)
+			}
+		}
+		return
+	}
+	if s.RequireMessageAuthenticatorForEAP && packet.Len(attributeTypeEAPMessage) > 0 && packet.Len(attributeTypeMessageAuthenticator) == 0 {
+		if s.Logger != nil {
+			s.Logger.Warnf("radius: dropping %v from %s: EAP-Message without Message-Authenticator", packet.Code, response.remoteAddr)
+		}
+		return
+	}
+	if s.OnRequest != nil {
+		s.OnRequest(packet.Code)
+	}
+	if packet.Code == CodeStatusServer && s.StatusServerResponse != 0 {
+		if status, err := packet.Response(s.StatusServerResponse); err == nil {
+			status.AddMessageAuthenticator()
+			response.Write(status)
+		}
+		return
+	}
+	key := RequestKey{
+		Addr:          response.remoteAddr.String(),
+		Identifier:    packet.Identifier,
+		Authenticator: packet.Authenticator,
+	}
+	if s.DuplicateWindow > 0 {
+		if cached := state.cache.Get(key); cached != nil {
+			if s.Logger != nil {
+				s.Logger.Debugf("radius: answering duplicate %v from %s (identifier %d)", packet.Code, response.remoteAddr, packet.Identifier)
 			}
+			response.writeRaw(cached)
+			return
+		}
+	}
+	state.activeLock.Lock()
+	if _, ok := state.active[key]; ok {
+		state.activeLock.Unlock()
+		if s.Logger != nil {
+			s.Logger.Debugf("radius: dropping duplicate %v from %s (identifier %d): the request is being handled", packet.Code, response.remoteAddr, packet.Identifier)
+		}
+		return
+	}
+	state.active[key] = true
+	state.activeLock.Unlock()
+
+	response.packet = packet
+	response.echoProxyState = s.EchoProxyState
+	response.addMessageAuthenticator = s.AddMessageAuthenticator
+	response.middleware = s.ResponseMiddleware
+	if s.DuplicateWindow > 0 {
+		response.cache = state.cache
+		response.cacheKey = key
+		response.cacheTTL = s.DuplicateWindow
+	}
 
-			s.Handler.ServeRadius(&response, packet)
+	s.Handler.ServeRadius(response, packet)
 
-			activeLock.Lock()
-			delete(active, key)
-			activeLock.Unlock()
-		}(s.listener, buff, remoteAddr)
+	state.activeLock.Lock()
+	delete(state.active, key)
+	state.activeLock.Unlock()
+}
+
+// SetSecret changes the shared secret of a server that uses Secret, rather than
+// SecretSource, without restarting it. The previous secrets are still
+// accepted, as with RotatingSecretSource, so that clients can be updated one
+// at a time. Once all of them have been, SetSecret should be called again
+// without the previous secrets. Once SetSecret has been called, the Secret
+// field is no longer used. An error is returned if SecretSource is set.
+func (s *Server) SetSecret(secret []byte, previous ...[]byte) error {
+	if s.SecretSource != nil {
+		return errors.New("radius: SetSecret cannot be used with SecretSource")
 	}
-	// TODO: only return nil if s.Close was called
-	s.listener = nil
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.secrets == nil {
+		s.secrets = NewRotatingSecretSource(secret, previous...)
+		return nil
+	}
+	s.secrets.Set(secret, previous...)
 	return nil
 }
 
+// isValidRequest returns if the authenticators of a received request are
+// valid. The request authenticator can only be verified for some codes; the
+// Message-Authenticator is verified if it is present.
+func isValidRequest(packet *Packet) bool {
+	switch packet.Code {
+	case CodeAccountingRequest, CodeDisconnectRequest, CodeCoARequest:
+		if !packet.IsAuthenticRequest() {
+			return false
+		}
+	}
+	switch packet.Code {
+	case CodeStatusServer:
+		// RFC 5997 requires a Message-Authenticator.
+		return packet.VerifyMessageAuthenticator() == nil
+	case CodeAccessRequest, CodeAccountingRequest, CodeDisconnectRequest, CodeCoARequest:
+		if packet.Len(attributeTypeMessageAuthenticator) > 0 {
+			return packet.VerifyMessageAuthenticator() == nil
+		}
+	}
+	return true
+}
+
+// Shutdown gracefully stops the server. It stops accepting new packets (and
+// TLS connections), waits for the packets that are currently being handled,
+// and then closes the listening sockets and any TLS connections.
+//
+// If ctx is done before all of the handlers have returned, the sockets are
+// closed anyway and ctx.Err() is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.closed = true
+	listeners := s.listeners
+	s.listeners = nil
+	tlsListener := s.tlsListener
+	s.tlsListener = nil
+	var tlsConns []net.Conn
+	for conn := range s.tlsConns {
+		tlsConns = append(tlsConns, conn)
+	}
+	s.mu.Unlock()
+
+	if listeners == nil && tlsListener == nil {
+		return nil
+	}
+	// Unblock the read loops. The sockets are kept open so that the running
+	// handlers are still able to respond.
+	for _, listener := range listeners {
+		listener.SetReadDeadline(time.Unix(1, 0))
+	}
+	if tlsListener != nil {
+		tlsListener.Close()
+	}
+	for _, conn := range tlsConns {
+		conn.SetReadDeadline(time.Unix(1, 0))
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.handlers.Wait()
+		close(done)
+	}()
+
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	for _, listener := range listeners {
+		if closeErr := listener.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	s.closeTLSConns()
+	return err
+}
+
 // Close stops listening for packets. Any packet that is currently being
 // handled will not be able to respond to the sender.
 func (s *Server) Close() error {
-	if s.listener == nil {
-		return nil
+	s.mu.Lock()
+	s.closed = true
+	listeners := s.listeners
+	s.listeners = nil
+	tlsListener := s.tlsListener
+	s.tlsListener = nil
+	s.mu.Unlock()
+
+	var err error
+	if tlsListener != nil {
+		err = tlsListener.Close()
+	}
+	s.closeTLSConns()
+	for _, listener := range listeners {
+		if closeErr := listener.Close(); err == nil {
+			err = closeErr
+		}
 	}
-	return s.listener.Close()
+	return err
 }
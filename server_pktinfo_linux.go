@@ -0,0 +1,84 @@
+//go:build linux
+// +build linux
+
+package radius
+
+import (
+	"net"
+	"syscall"
+	"unsafe"
+)
+
+// size of the control messages that are read with each packet: an
+// IP_PKTINFO and an IPV6_PKTINFO message
+var packetInfoSize = syscall.CmsgSpace(syscall.SizeofInet4Pktinfo) + syscall.CmsgSpace(syscall.SizeofInet6Pktinfo)
+
+// enablePacketInfo asks the system to report the local address that each
+// packet is received on. On a socket that is bound to an IPv6 address, both
+// options are set, as IPv4 packets can be received on it too; it is an error
+// only if neither can be set.
+func enablePacketInfo(conn *net.UDPConn) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var err4, err6 error
+	if err := raw.Control(func(fd uintptr) {
+		err4 = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_PKTINFO, 1)
+		err6 = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_RECVPKTINFO, 1)
+	}); err != nil {
+		return err
+	}
+	if err4 != nil && err6 != nil {
+		return err4
+	}
+	return nil
+}
+
+// readPacketInfo reads a packet from conn into buff, along with its control
+// messages, which are read into oob. local is the address that the packet was
+// sent to, and reply is the control message that sends a response from it, or
+// nil if the system did not report the address.
+func readPacketInfo(conn *net.UDPConn, buff, oob []byte) (n int, remoteAddr *net.UDPAddr, local net.IP, reply []byte, err error) {
+	n, oobn, _, remoteAddr, err := conn.ReadMsgUDP(buff, oob)
+	if err != nil {
+		return n, remoteAddr, nil, nil, err
+	}
+	messages, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return n, remoteAddr, nil, nil, nil
+	}
+	// An IPv4 response must be sent with IP_PKTINFO, even on an IPv6 socket.
+	ipv4 := remoteAddr.IP.To4() != nil
+	for _, m := range messages {
+		switch {
+		case ipv4 && m.Header.Level == syscall.IPPROTO_IP && m.Header.Type == syscall.IP_PKTINFO && len(m.Data) >= syscall.SizeofInet4Pktinfo:
+			info := *(*syscall.Inet4Pktinfo)(unsafe.Pointer(&m.Data[0]))
+			local = net.IPv4(info.Addr[0], info.Addr[1], info.Addr[2], info.Addr[3])
+			reply = packetInfoMessage(syscall.IPPROTO_IP, syscall.IP_PKTINFO, syscall.SizeofInet4Pktinfo)
+			*(*syscall.Inet4Pktinfo)(unsafe.Pointer(&reply[syscall.CmsgLen(0)])) = syscall.Inet4Pktinfo{
+				Ifindex:  info.Ifindex,
+				Spec_dst: info.Addr,
+			}
+			return n, remoteAddr, local, reply, nil
+		case !ipv4 && m.Header.Level == syscall.IPPROTO_IPV6 && m.Header.Type == syscall.IPV6_PKTINFO && len(m.Data) >= syscall.SizeofInet6Pktinfo:
+			info := *(*syscall.Inet6Pktinfo)(unsafe.Pointer(&m.Data[0]))
+			local = append(net.IP(nil), info.Addr[:]...)
+			reply = packetInfoMessage(syscall.IPPROTO_IPV6, syscall.IPV6_PKTINFO, syscall.SizeofInet6Pktinfo)
+			*(*syscall.Inet6Pktinfo)(unsafe.Pointer(&reply[syscall.CmsgLen(0)])) = info
+			return n, remoteAddr, local, reply, nil
+		}
+	}
+	return n, remoteAddr, nil, nil, nil
+}
+
+// packetInfoMessage returns a control message of the given level and type,
+// with room for size bytes of data.
+func packetInfoMessage(level, t, size int) []byte {
+	b := make([]byte, syscall.CmsgSpace(size))
+	h := (*syscall.Cmsghdr)(unsafe.Pointer(&b[0]))
+	h.Level = int32(level)
+	h.Type = int32(t)
+	h.SetLen(syscall.CmsgLen(size))
+	return b
+}
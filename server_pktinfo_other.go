@@ -0,0 +1,20 @@
+//go:build !linux
+// +build !linux
+
+package radius
+
+import (
+	"errors"
+	"net"
+)
+
+const packetInfoSize = 0
+
+func enablePacketInfo(conn *net.UDPConn) error {
+	return errors.New("radius: ReplyFromLocalAddr is not supported on this platform")
+}
+
+func readPacketInfo(conn *net.UDPConn, buff, oob []byte) (n int, remoteAddr *net.UDPAddr, local net.IP, reply []byte, err error) {
+	n, remoteAddr, err = conn.ReadFromUDP(buff)
+	return n, remoteAddr, nil, nil, err
+}
@@ -0,0 +1,1182 @@
+package radius_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/PromonLogicalis/radius"
+)
+
+// freeAddr returns a loopback UDP address that is not currently in use.
+func freeAddr(t testing.TB) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	return conn.LocalAddr().String()
+}
+
+func TestServerShutdown(t *testing.T) {
+	secret := []byte("secret")
+	addr := freeAddr(t)
+
+	handling := make(chan struct{})
+	release := make(chan struct{})
+	server := radius.Server{
+		Addr:       addr,
+		Secret:     secret,
+		Dictionary: radius.Builtin,
+		Handler: radius.HandlerFunc(func(w radius.ResponseWriter, p *radius.Packet) {
+			if p.String("User-Name") == "probe" {
+				w.AccessAccept()
+				return
+			}
+			close(handling)
+			<-release
+			w.AccessAccept()
+		}),
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.ListenAndServe()
+	}()
+
+	// wait for the server to start listening
+	probe := radius.New(radius.CodeAccessRequest, secret)
+	probe.Add("User-Name", "probe")
+	for i := 0; ; i++ {
+		client := radius.Client{
+			ReadTimeout: 50 * time.Millisecond,
+		}
+		if _, err := client.Exchange(probe, addr); err == nil {
+			break
+		} else if i == 50 {
+			t.Fatal(err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	exchangeErr := make(chan error, 1)
+	go func() {
+		var client radius.Client
+		packet := radius.New(radius.CodeAccessRequest, secret)
+		_, err := client.Exchange(packet, addr)
+		exchangeErr <- err
+	}()
+
+	select {
+	case <-handling:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler was not called")
+	}
+
+	shutdownErr := make(chan error, 1)
+	go func() {
+		shutdownErr <- server.Shutdown(context.Background())
+	}()
+
+	select {
+	case err := <-serveErr:
+		if err != radius.ErrServerClosed {
+			t.Fatalf("expecting ErrServerClosed; got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ListenAndServe did not return")
+	}
+
+	select {
+	case <-shutdownErr:
+		t.Fatal("Shutdown returned before the handler finished")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	if err := <-shutdownErr; err != nil {
+		t.Fatal(err)
+	}
+	if err := <-exchangeErr; err != nil {
+		t.Fatalf("expecting in-flight request to be answered; got %v", err)
+	}
+}
+
+func TestServerDuplicateWindow(t *testing.T) {
+	secret := []byte("secret")
+	addr := freeAddr(t)
+
+	var handled int32
+	server := radius.Server{
+		Addr:            addr,
+		Secret:          secret,
+		Dictionary:      radius.Builtin,
+		DuplicateWindow: time.Minute,
+		Handler: radius.HandlerFunc(func(w radius.ResponseWriter, p *radius.Packet) {
+			atomic.AddInt32(&handled, 1)
+			w.AccessAccept()
+		}),
+	}
+	go server.ListenAndServe()
+	defer server.Close()
+
+	packet := radius.New(radius.CodeAccessRequest, secret)
+	wire, err := packet.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	var responses [][]byte
+	for len(responses) < 2 {
+		conn.SetDeadline(time.Now().Add(50 * time.Millisecond))
+		conn.Write(wire)
+		buff := make([]byte, 4096)
+		n, err := conn.Read(buff)
+		if err != nil {
+			if len(responses) == 0 {
+				// the server may not be listening yet
+				time.Sleep(20 * time.Millisecond)
+				continue
+			}
+			t.Fatal(err)
+		}
+		responses = append(responses, buff[:n])
+	}
+
+	if n := atomic.LoadInt32(&handled); n != 1 {
+		t.Fatalf("expecting handler to be called once; called %d times", n)
+	}
+	if !bytes.Equal(responses[0], responses[1]) {
+		t.Fatal("expecting duplicate request to receive the same response")
+	}
+
+	// the same Identifier from another client is not a duplicate
+	other, err := net.Dial("udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	other.SetDeadline(time.Now().Add(time.Second))
+	other.Write(wire)
+	if _, err := other.Read(make([]byte, 4096)); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&handled); n != 2 {
+		t.Fatalf("expecting handler to be called twice; called %d times", n)
+	}
+}
+
+type secretsByPort map[int][]byte
+
+func (s secretsByPort) RADIUSSecret(ctx context.Context, remoteAddr net.Addr) ([]byte, error) {
+	return s[remoteAddr.(*net.UDPAddr).Port], nil
+}
+
+func TestServerSecretSource(t *testing.T) {
+	addr := freeAddr(t)
+
+	known, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer known.Close()
+	unknown, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unknown.Close()
+
+	knownPort := known.LocalAddr().(*net.UDPAddr).Port
+	unknownClients := make(chan net.Addr, 10)
+	server := radius.Server{
+		Addr:         addr,
+		Dictionary:   radius.Builtin,
+		SecretSource: secretsByPort{knownPort: []byte("known")},
+		UnknownClient: func(remoteAddr net.Addr, err error) {
+			unknownClients <- remoteAddr
+		},
+		Handler: radius.HandlerFunc(func(w radius.ResponseWriter, p *radius.Packet) {
+			w.AccessAccept()
+		}),
+	}
+	go server.ListenAndServe()
+	defer server.Close()
+
+	serverAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exchange := func(conn net.PacketConn, secret []byte) error {
+		packet := radius.New(radius.CodeAccessRequest, secret)
+		packet.AddMessageAuthenticator()
+		wire, err := packet.Encode()
+		if err != nil {
+			return err
+		}
+		buff := make([]byte, 4096)
+		for i := 0; i < 10; i++ {
+			conn.WriteTo(wire, serverAddr)
+			conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+			if _, _, err = conn.ReadFrom(buff); err == nil {
+				return nil
+			}
+		}
+		return err
+	}
+
+	if err := exchange(known, []byte("known")); err != nil {
+		t.Fatal(err)
+	}
+	// wrong secret; the Message-Authenticator is invalid
+	if err := exchange(known, []byte("wrong")); err == nil {
+		t.Fatal("expecting request with the wrong secret to be dropped")
+	}
+	if err := exchange(unknown, []byte("known")); err == nil {
+		t.Fatal("expecting request from unknown client to be dropped")
+	}
+	select {
+	case remoteAddr := <-unknownClients:
+		if remoteAddr.String() != unknown.LocalAddr().String() {
+			t.Fatalf("unexpected unknown client %s", remoteAddr)
+		}
+	default:
+		t.Fatal("expecting UnknownClient to be called")
+	}
+}
+
+// selfSignedTLS returns a server and client TLS configuration that use a
+// self-signed certificate for 127.0.0.1.
+func selfSignedTLS(t *testing.T) (server, client *tls.Config) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	server = &tls.Config{
+		Certificates: []tls.Certificate{{
+			Certificate: [][]byte{der},
+			PrivateKey:  key,
+		}},
+	}
+	client = &tls.Config{
+		RootCAs: pool,
+	}
+	return
+}
+
+func TestServerTLS(t *testing.T) {
+	serverConfig, clientConfig := selfSignedTLS(t)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	server := radius.Server{
+		Addr:       addr,
+		Dictionary: radius.Builtin,
+		TLSConfig:  serverConfig,
+		Handler: radius.HandlerFunc(func(w radius.ResponseWriter, p *radius.Packet) {
+			response, _ := p.Response(radius.CodeAccessAccept)
+			response.Add("Reply-Message", p.String("User-Name"))
+			w.Write(response)
+		}),
+	}
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.ListenAndServeTLS()
+	}()
+
+	client := radius.ClientTLS{
+		Config: clientConfig,
+	}
+	defer client.Close()
+
+	for i := 0; i < 3; i++ {
+		packet := radius.New(radius.CodeAccessRequest, nil)
+		packet.Add("User-Name", "tim")
+		var response *radius.Packet
+		for attempt := 0; ; attempt++ {
+			// the server may not be listening yet
+			if response, err = client.Exchange(packet, addr); err == nil || attempt == 50 {
+				break
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if response.Code != radius.CodeAccessAccept || response.String("Reply-Message") != "tim" {
+			t.Fatal("unexpected response")
+		}
+	}
+
+	if err := server.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-serveErr; err != radius.ErrServerClosed {
+		t.Fatalf("expecting ErrServerClosed; got %v", err)
+	}
+}
+
+func TestServerEchoProxyState(t *testing.T) {
+	secret := []byte("secret")
+	addr := freeAddr(t)
+
+	server := radius.Server{
+		Addr:           addr,
+		Secret:         secret,
+		Dictionary:     radius.Builtin,
+		EchoProxyState: true,
+		Handler: radius.HandlerFunc(func(w radius.ResponseWriter, p *radius.Packet) {
+			w.AccessAccept()
+		}),
+	}
+	go server.ListenAndServe()
+	defer server.Close()
+
+	// the request as it is received by a proxy, and forwarded upstream
+	received := radius.New(radius.CodeAccessRequest, secret)
+	received.Add("User-Name", "tim")
+	received.Add("Proxy-State", []byte{0x00, 0x01, 0xff})
+	received.Add("Proxy-State", []byte("second"))
+	forwarded := radius.New(radius.CodeAccessRequest, secret)
+	forwarded.Add("User-Name", "tim")
+	forwarded.CopyProxyState(received)
+
+	var response *radius.Packet
+	for i := 0; ; i++ {
+		client := radius.Client{
+			ReadTimeout: 50 * time.Millisecond,
+		}
+		var err error
+		// the server may not be listening yet
+		if response, err = client.Exchange(forwarded, addr); err == nil {
+			break
+		} else if i == 50 {
+			t.Fatal(err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	expected := received.Attrs(33)
+	states := response.Attrs(33)
+	if len(states) != len(expected) {
+		t.Fatalf("expecting %d Proxy-State attributes; got %d", len(expected), len(states))
+	}
+	for i, state := range states {
+		if !bytes.Equal(state.Value.([]byte), expected[i].Value.([]byte)) {
+			t.Fatalf("Proxy-State %d: expecting %x; got %x", i, expected[i].Value, state.Value)
+		}
+	}
+}
+
+func TestServerAddMessageAuthenticator(t *testing.T) {
+	secret := []byte("secret")
+	addr := freeAddr(t)
+
+	server := radius.Server{
+		Addr:                    addr,
+		Secret:                  secret,
+		Dictionary:              radius.Builtin,
+		AddMessageAuthenticator: true,
+		Handler: radius.HandlerFunc(func(w radius.ResponseWriter, p *radius.Packet) {
+			w.AccessChallenge(radius.Builtin.MustAttr("Reply-Message", "challenge"))
+		}),
+	}
+	go server.ListenAndServe()
+	defer server.Close()
+
+	request := radius.New(radius.CodeAccessRequest, secret)
+	request.Add("User-Name", "tim")
+	request.SetEAPMessage([]byte{0x02, 0x01, 0x00, 0x08, 0x01, 't', 'i', 'm'})
+
+	var response *radius.Packet
+	for i := 0; ; i++ {
+		client := radius.Client{
+			ReadTimeout: 50 * time.Millisecond,
+		}
+		var err error
+		// the server may not be listening yet
+		if response, err = client.Exchange(request, addr); err == nil {
+			break
+		} else if i == 50 {
+			t.Fatal(err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	if response.Code != radius.CodeAccessChallenge {
+		t.Fatalf("expecting Access-Challenge; got %v", response.Code)
+	}
+	if response.Len(80) != 1 {
+		t.Fatalf("expecting one Message-Authenticator attribute; got %d", response.Len(80))
+	}
+	if value := response.Attrs(80)[0].Value.([]byte); bytes.Equal(value, make([]byte, 16)) {
+		t.Fatal("expecting Message-Authenticator to be calculated")
+	}
+	if !response.IsAuthentic(request) {
+		t.Fatal("expecting response to be authentic")
+	}
+}
+
+// testLogger is a radius.Logger that records the messages it receives.
+type testLogger struct {
+	mu       sync.Mutex
+	messages []string
+}
+
+func (l *testLogger) Debugf(format string, args ...interface{}) {
+	l.log("debug: "+format, args...)
+}
+
+func (l *testLogger) Warnf(format string, args ...interface{}) {
+	l.log("warn: "+format, args...)
+}
+
+func (l *testLogger) log(format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.messages = append(l.messages, fmt.Sprintf(format, args...))
+}
+
+func (l *testLogger) find(substr string) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, message := range l.messages {
+		if strings.Contains(message, substr) {
+			return message
+		}
+	}
+	return ""
+}
+
+func TestServerLogger(t *testing.T) {
+	addr := freeAddr(t)
+	logger := &testLogger{}
+
+	server := radius.Server{
+		Addr:       addr,
+		Secret:     []byte("secret"),
+		Dictionary: radius.Builtin,
+		Logger:     logger,
+		Handler: radius.HandlerFunc(func(w radius.ResponseWriter, p *radius.Packet) {
+			t.Error("expecting the request not to be handled")
+		}),
+	}
+	go server.ListenAndServe()
+	defer server.Close()
+
+	request := radius.New(radius.CodeAccountingRequest, []byte("wrong"))
+	request.Add("Acct-Status-Type", uint32(1))
+	var message string
+	for i := 0; message == ""; i++ {
+		if i == 50 {
+			t.Fatal("expecting the dropped request to be logged")
+		}
+		client := radius.Client{
+			ReadTimeout: 20 * time.Millisecond,
+		}
+		if _, err := client.Exchange(request, addr); err == nil {
+			t.Fatal("expecting request with the wrong secret to be dropped")
+		}
+		// the server may not be listening yet
+		time.Sleep(20 * time.Millisecond)
+		message = logger.find("invalid authenticator")
+	}
+	if !strings.HasPrefix(message, "warn: ") || !strings.Contains(message, "Accounting-Request") {
+		t.Fatalf("unexpected message %q", message)
+	}
+}
+
+func TestServerRequestResponse(t *testing.T) {
+	secret := []byte("secret")
+	addr := freeAddr(t)
+
+	handled := make(chan error, 1)
+	server := radius.Server{
+		Addr:   addr,
+		Secret: secret,
+		// Builtin is used when no dictionary is set
+		Handler: radius.HandlerFunc(func(w radius.ResponseWriter, p *radius.Packet) {
+			var err error
+			if !bytes.Equal(p.Secret, secret) || p.Dictionary != radius.Builtin {
+				err = errors.New("expecting request to carry the secret and dictionary")
+			}
+			response, _ := p.Response(radius.CodeAccessAccept)
+			response.Add("Session-Timeout", uint32(3600))
+			w.Write(response)
+			select {
+			case handled <- err:
+			default:
+			}
+		}),
+	}
+	go server.ListenAndServe()
+	defer server.Close()
+
+	request := radius.New(radius.CodeAccessRequest, secret)
+	request.Add("User-Name", "tim")
+	var response *radius.Packet
+	for i := 0; ; i++ {
+		client := radius.Client{
+			ReadTimeout: 50 * time.Millisecond,
+		}
+		var err error
+		// the server may not be listening yet
+		if response, err = client.Exchange(request, addr); err == nil {
+			break
+		} else if i == 50 {
+			t.Fatal(err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err := <-handled; err != nil {
+		t.Fatal(err)
+	}
+	// the client has verified the response authenticator
+	if response.Identifier != request.Identifier || response.Value("Session-Timeout") != uint32(3600) {
+		t.Fatal("expecting the handler's Access-Accept")
+	}
+}
+
+func TestServerSetSecret(t *testing.T) {
+	addr := freeAddr(t)
+
+	server := radius.Server{
+		Addr:       addr,
+		Secret:     []byte("old"),
+		Dictionary: radius.Builtin,
+		Handler: radius.HandlerFunc(func(w radius.ResponseWriter, p *radius.Packet) {
+			response, _ := p.Response(radius.CodeAccountingResponse)
+			w.Write(response)
+		}),
+	}
+	go server.ListenAndServe()
+	defer server.Close()
+
+	exchange := func(secret string) error {
+		request := radius.New(radius.CodeAccountingRequest, []byte(secret))
+		request.Add("Acct-Status-Type", radius.AcctStatusTypeStart)
+		client := radius.Client{
+			ReadTimeout: 50 * time.Millisecond,
+		}
+		_, err := client.Exchange(request, addr)
+		return err
+	}
+	for i := 0; ; i++ {
+		// the server may not be listening yet
+		if err := exchange("old"); err == nil {
+			break
+		} else if i == 50 {
+			t.Fatal(err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// during the rotation, both secrets are accepted, and responses are
+	// authenticated with the secret of the request
+	if err := server.SetSecret([]byte("new"), []byte("old")); err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"new", "old"} {
+		if err := exchange(secret); err != nil {
+			t.Fatalf("%s: %v", secret, err)
+		}
+	}
+
+	if err := server.SetSecret([]byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	if err := exchange("new"); err != nil {
+		t.Fatal(err)
+	}
+	if err := exchange("old"); err == nil {
+		t.Fatal("expecting the old secret to be rejected after the rotation")
+	}
+
+	withSource := radius.Server{
+		SecretSource: radius.StaticSecretSource([]byte("secret")),
+	}
+	if err := withSource.SetSecret([]byte("new")); err == nil {
+		t.Fatal("expecting SetSecret to fail with a SecretSource")
+	}
+}
+
+func TestServerDynamicAuthorization(t *testing.T) {
+	secret := []byte("secret")
+	addr := freeAddr(t)
+
+	var applied int32
+	server := radius.Server{
+		Addr:            addr,
+		Secret:          secret,
+		Dictionary:      radius.Builtin,
+		DuplicateWindow: time.Minute,
+		Handler: radius.DynamicAuthorizationHandler(func(request *radius.Packet) uint32 {
+			atomic.AddInt32(&applied, 1)
+			if request.Code == radius.CodeCoARequest {
+				return radius.ErrorCauseUnsupportedService
+			}
+			return 0
+		}),
+	}
+	go server.ListenAndServe()
+	defer server.Close()
+
+	request := radius.New(radius.CodeDisconnectRequest, secret)
+	request.Add("User-Name", "tim")
+	// A persistent client sends a retransmission from the same address and
+	// with the same identifier.
+	client := radius.Client{
+		ReadTimeout: 50 * time.Millisecond,
+		Persistent:  true,
+	}
+	defer client.Close()
+	var response *radius.Packet
+	for i := 0; ; i++ {
+		var err error
+		// the server may not be listening yet
+		if response, err = client.Disconnect(context.Background(), request, addr); err == nil {
+			break
+		} else if i == 50 {
+			t.Fatal(err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if response.Code != radius.CodeDisconnectACK {
+		t.Fatalf("expecting Disconnect-ACK; got %v", response.Code)
+	}
+
+	// a retransmission is answered from the cache
+	if response, err := client.Disconnect(context.Background(), request, addr); err != nil || response.Code != radius.CodeDisconnectACK {
+		t.Fatalf("expecting Disconnect-ACK; got %v", err)
+	}
+	if n := atomic.LoadInt32(&applied); n != 1 {
+		t.Fatalf("expecting the request to be applied once; got %d", n)
+	}
+
+	response, err := client.ChangeOfAuthorization(context.Background(), request, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Code != radius.CodeCoANAK {
+		t.Fatalf("expecting CoA-NAK; got %v", response.Code)
+	}
+	if cause, _ := response.GetInt("Error-Cause"); cause != radius.ErrorCauseUnsupportedService {
+		t.Fatalf("expecting Error-Cause = 405; got %v", response.Value("Error-Cause"))
+	}
+
+	if _, err := radius.New(radius.CodeAccessRequest, secret).NAK(0); err == nil {
+		t.Fatal("expecting NAK of an Access-Request to fail")
+	}
+}
+
+func TestServerAddrs(t *testing.T) {
+	secret := []byte("secret")
+	addrs := []string{freeAddr(t), freeAddr(t)}
+
+	server := radius.Server{
+		Addrs:      addrs,
+		Secret:     secret,
+		Dictionary: radius.Builtin,
+		Handler: radius.HandlerFunc(func(w radius.ResponseWriter, p *radius.Packet) {
+			w.AccessAccept(radius.Builtin.MustAttr("Reply-Message", w.LocalAddr().String()))
+		}),
+	}
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.ListenAndServe()
+	}()
+
+	for _, addr := range addrs {
+		packet := radius.New(radius.CodeAccessRequest, secret)
+		for i := 0; ; i++ {
+			client := radius.Client{
+				ReadTimeout: 50 * time.Millisecond,
+			}
+			response, err := client.Exchange(packet, addr)
+			if err == nil {
+				if message := response.String("Reply-Message"); message != addr {
+					t.Fatalf("expecting response from %s; got %s", addr, message)
+				}
+				break
+			} else if i == 50 {
+				t.Fatal(err)
+			}
+			// the server may not be listening yet
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+
+	if err := server.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-serveErr:
+		if err != radius.ErrServerClosed {
+			t.Fatalf("expecting ErrServerClosed; got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expecting ListenAndServe to return after Shutdown")
+	}
+}
+
+func TestServerHooks(t *testing.T) {
+	secret := []byte("secret")
+	addr := freeAddr(t)
+
+	requests := make(chan radius.Code, 100)
+	responses := make(chan radius.Code, 100)
+	server := radius.Server{
+		Addr:       addr,
+		Secret:     secret,
+		Dictionary: radius.Builtin,
+		Handler: radius.HandlerFunc(func(w radius.ResponseWriter, p *radius.Packet) {
+			w.AccessReject()
+		}),
+		OnRequest: func(code radius.Code) {
+			requests <- code
+		},
+		OnResponse: func(code radius.Code, duration time.Duration) {
+			if duration < 0 {
+				t.Errorf("unexpected duration %s", duration)
+			}
+			responses <- code
+		},
+	}
+	go server.ListenAndServe()
+	defer server.Close()
+
+	type exchange struct {
+		code radius.Code
+		err  error
+	}
+	exchanges := make(chan exchange, 100)
+	client := radius.Client{
+		ReadTimeout: 50 * time.Millisecond,
+		OnExchange: func(code radius.Code, duration time.Duration, err error) {
+			exchanges <- exchange{code, err}
+		},
+	}
+	packet := radius.New(radius.CodeAccessRequest, secret)
+	packet.Add("User-Name", "tim")
+	for i := 0; ; i++ {
+		// the server may not be listening yet
+		if _, err := client.Exchange(packet, addr); err == nil {
+			break
+		} else if i == 50 {
+			t.Fatal(err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	var last exchange
+	for len(exchanges) > 0 {
+		last = <-exchanges
+	}
+	if last.err != nil || last.code != radius.CodeAccessReject {
+		t.Fatalf("unexpected OnExchange call %v", last)
+	}
+	if code := <-requests; code != radius.CodeAccessRequest {
+		t.Fatalf("expecting OnRequest with Access-Request; got %d", code)
+	}
+	if code := <-responses; code != radius.CodeAccessReject {
+		t.Fatalf("expecting OnResponse with Access-Reject; got %d", code)
+	}
+}
+
+func TestServerReaders(t *testing.T) {
+	secret := []byte("secret")
+	addr := freeAddr(t)
+
+	server := radius.Server{
+		Addr:       addr,
+		Secret:     secret,
+		Dictionary: radius.Builtin,
+		ReadBuffer: 1 << 20,
+		Readers:    4,
+		Handler: radius.HandlerFunc(func(w radius.ResponseWriter, p *radius.Packet) {
+			response, _ := p.Response(radius.CodeAccessAccept)
+			response.Add("Reply-Message", p.String("User-Name"))
+			w.Write(response)
+		}),
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- server.ListenAndServe()
+	}()
+
+	client := radius.Client{
+		ReadTimeout: 50 * time.Millisecond,
+	}
+	for i := 0; ; i++ {
+		// the server may not be listening yet
+		packet := radius.New(radius.CodeAccessRequest, secret)
+		if _, err := client.Exchange(packet, addr); err == nil {
+			break
+		} else if i == 50 {
+			t.Fatal(err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	client = radius.Client{
+		Persistent: true,
+	}
+	defer client.Close()
+	const count = 50
+	errs := make(chan error, count)
+	for i := 0; i < count; i++ {
+		go func(i int) {
+			packet := radius.New(radius.CodeAccessRequest, secret)
+			name := strings.Repeat("x", i+1)
+			packet.Add("User-Name", name)
+			response, err := client.Exchange(packet, addr)
+			if err == nil && response.String("Reply-Message") != name {
+				err = errors.New("response does not match request")
+			}
+			errs <- err
+		}(i)
+	}
+	for i := 0; i < count; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := server.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != radius.ErrServerClosed {
+		t.Fatalf("expecting ErrServerClosed; got %v", err)
+	}
+}
+
+func BenchmarkServer(b *testing.B) {
+	for _, readers := range []int{0, 4} {
+		b.Run(fmt.Sprintf("readers=%d", readers), func(b *testing.B) {
+			secret := []byte("secret")
+			addr := freeAddr(b)
+			server := radius.Server{
+				Addr:       addr,
+				Secret:     secret,
+				Dictionary: radius.Builtin,
+				Readers:    readers,
+				Handler: radius.HandlerFunc(func(w radius.ResponseWriter, p *radius.Packet) {
+					w.AccessAccept()
+				}),
+			}
+			go server.ListenAndServe()
+			defer server.Close()
+
+			client := radius.Client{
+				Persistent: true,
+			}
+			defer client.Close()
+			packet := radius.New(radius.CodeAccessRequest, secret)
+			packet.Add("User-Name", "tim")
+			for i := 0; ; i++ {
+				// the server may not be listening yet
+				probe := radius.Client{
+					ReadTimeout: 50 * time.Millisecond,
+				}
+				if _, err := probe.Exchange(packet, addr); err == nil {
+					break
+				} else if i == 50 {
+					b.Fatal(err)
+				}
+				time.Sleep(20 * time.Millisecond)
+			}
+
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if _, err := client.Exchange(packet.Clone(), addr); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
+
+func TestServerReplyFromLocalAddr(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("IP_PKTINFO is only supported on Linux")
+	}
+	secret := []byte("secret")
+	_, port, _ := net.SplitHostPort(freeAddr(t))
+
+	localAddrs := make(chan net.Addr, 64)
+	server := radius.Server{
+		Addr:               net.JoinHostPort("0.0.0.0", port),
+		Secret:             secret,
+		ReplyFromLocalAddr: true,
+		Handler: radius.HandlerFunc(func(w radius.ResponseWriter, p *radius.Packet) {
+			localAddrs <- w.LocalAddr()
+			w.AccessAccept()
+		}),
+	}
+	go server.ListenAndServe()
+	defer server.Close()
+
+	// wait for the server to start listening
+	probe := radius.New(radius.CodeAccessRequest, secret)
+	for i := 0; ; i++ {
+		client := radius.Client{
+			ReadTimeout: 50 * time.Millisecond,
+		}
+		if _, err := client.Exchange(probe, net.JoinHostPort("127.0.0.1", port)); err == nil {
+			break
+		} else if i == 50 {
+			t.Fatal(err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	<-localAddrs
+
+	// The request is sent to 127.0.0.2 from 127.0.0.1, which the system
+	// would use as the source of the response.
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	request := radius.New(radius.CodeAccessRequest, secret)
+	wire, err := request.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverAddr, _ := net.ResolveUDPAddr("udp4", net.JoinHostPort("127.0.0.2", port))
+	if _, err := conn.WriteToUDP(wire, serverAddr); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buff := make([]byte, radius.MaxPacketSize)
+	n, from, err := conn.ReadFromUDP(buff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !from.IP.Equal(serverAddr.IP) {
+		t.Fatalf("expecting response from %s; got %s", serverAddr.IP, from.IP)
+	}
+	response, err := radius.ParseResponse(buff[:n], request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := response.VerifyResponse(request); err != nil {
+		t.Fatal(err)
+	}
+	if localAddr := <-localAddrs; localAddr.String() != serverAddr.String() {
+		t.Fatalf("expecting LocalAddr %s; got %s", serverAddr, localAddr)
+	}
+}
+
+func TestServerResponseMiddleware(t *testing.T) {
+	secret := []byte("secret")
+	addr := freeAddr(t)
+
+	written := make(chan *radius.Packet, 1)
+	server := radius.Server{
+		Addr:                    addr,
+		Secret:                  secret,
+		AddMessageAuthenticator: true,
+		ResponseMiddleware: func(p *radius.Packet) {
+			p.AddReplyMessage("policy")
+			p.Set("Session-Timeout", uint32(20))
+		},
+		Handler: radius.HandlerFunc(func(w radius.ResponseWriter, p *radius.Packet) {
+			response, _ := p.Response(radius.CodeAccessAccept)
+			response.Add("Session-Timeout", uint32(10))
+			w.Write(response)
+			written <- response
+		}),
+	}
+	go server.ListenAndServe()
+	defer server.Close()
+
+	var response *radius.Packet
+	request := radius.New(radius.CodeAccessRequest, secret)
+	for i := 0; ; i++ {
+		client := radius.Client{
+			ReadTimeout: 50 * time.Millisecond,
+		}
+		var err error
+		if response, err = client.Exchange(request, addr); err == nil {
+			break
+		} else if i == 50 {
+			t.Fatal(err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// Exchange verified the authenticators, which cover the added attribute.
+	if messages := response.ReplyMessages(); len(messages) != 1 || messages[0] != "policy" {
+		t.Fatalf("expecting the middleware's Reply-Message; got %q", messages)
+	}
+	if response.Len(80) != 1 {
+		t.Fatal("expecting a Message-Authenticator")
+	}
+	if timeout, _ := response.GetInt("Session-Timeout"); timeout != 20 {
+		t.Fatalf("expecting the middleware's Session-Timeout; got %d", timeout)
+	}
+	original := <-written
+	if timeout, _ := original.GetInt("Session-Timeout"); len(original.Attributes) != 1 || timeout != 10 {
+		t.Fatal("expecting the handler's packet not to be modified")
+	}
+}
+
+func TestServerMemoryNetwork(t *testing.T) {
+	secret := []byte("secret")
+	network := radius.NewMemoryNetwork()
+	conn, err := network.ListenPacket("udp", ":1812")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := network.ListenPacket("udp", ":1812"); err == nil {
+		t.Fatal("expecting the address to be in use")
+	}
+
+	server := radius.Server{
+		Secret: secret,
+		Handler: radius.HandlerFunc(func(w radius.ResponseWriter, p *radius.Packet) {
+			response, _ := p.Response(radius.CodeAccessAccept)
+			response.AddReplyMessage("hello " + p.String("User-Name"))
+			w.Write(response)
+		}),
+	}
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.Serve(conn)
+	}()
+
+	for _, persistent := range []bool{false, true} {
+		client := radius.Client{
+			ReadTimeout:  time.Second,
+			Persistent:   persistent,
+			DialContext:  network.DialContext,
+			ListenPacket: network.ListenPacket,
+		}
+		for i := 0; i < 100; i++ {
+			request := radius.New(radius.CodeAccessRequest, secret)
+			request.Add("User-Name", fmt.Sprint(i))
+			response, err := client.Exchange(request, "127.0.0.1:1812")
+			if err != nil {
+				t.Fatalf("persistent: %v: %v", persistent, err)
+			}
+			if messages := response.ReplyMessages(); len(messages) != 1 || messages[0] != fmt.Sprintf("hello %d", i) {
+				t.Fatalf("unexpected response %v", response.Attributes)
+			}
+		}
+		client.Close()
+	}
+
+	// nothing listens on the address
+	client := radius.Client{
+		RetryInterval: 20 * time.Millisecond,
+		DialContext:   network.DialContext,
+	}
+	var timeout *radius.TimeoutError
+	if _, err := client.Exchange(radius.New(radius.CodeAccessRequest, secret), "127.0.0.1:1813"); !errors.As(err, &timeout) {
+		t.Fatalf("expecting *TimeoutError; got %v", err)
+	}
+
+	if err := server.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-serveErr; err != radius.ErrServerClosed {
+		t.Fatalf("expecting ErrServerClosed; got %v", err)
+	}
+}
+
+func TestServerRequireMessageAuthenticatorForEAP(t *testing.T) {
+	secret := []byte("secret")
+	network := radius.NewMemoryNetwork()
+	conn, err := network.ListenPacket("udp", "127.0.0.1:1812")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := radius.Server{
+		Secret:                            secret,
+		RequireMessageAuthenticatorForEAP: true,
+		Handler: radius.HandlerFunc(func(w radius.ResponseWriter, p *radius.Packet) {
+			response, _ := p.Response(radius.CodeAccessChallenge)
+			w.Write(response)
+		}),
+	}
+	go server.Serve(conn)
+	defer server.Close()
+
+	request := radius.New(radius.CodeAccessRequest, secret)
+	request.AddAttr(&radius.Attribute{Type: 79, Value: []byte{2, 0, 0, 5, 1}})
+
+	// dropped without a Message-Authenticator
+	client := radius.Client{
+		RetryInterval: 20 * time.Millisecond,
+		DialContext:   network.DialContext,
+	}
+	var timeout *radius.TimeoutError
+	if _, err := client.Exchange(request, "127.0.0.1:1812"); !errors.As(err, &timeout) {
+		t.Fatalf("expecting *TimeoutError; got %v", err)
+	}
+
+	client.RetryInterval = time.Second
+	client.AddMessageAuthenticatorForEAP = true
+	response, err := client.Exchange(request, "127.0.0.1:1812")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Code != radius.CodeAccessChallenge {
+		t.Fatalf("expecting Access-Challenge; got %v", response.Code)
+	}
+	if request.Len(80) != 0 {
+		t.Fatal("expecting the caller's packet not to be modified")
+	}
+
+	// requests without EAP-Message are not affected
+	client.AddMessageAuthenticatorForEAP = false
+	if _, err := client.Exchange(radius.New(radius.CodeAccessRequest, secret), "127.0.0.1:1812"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,562 @@
+package radius
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// type of the Vendor-Specific attribute
+const attributeTypeVendorSpecific = 26
+
+// AttributeVendorSpecific is the AttributeCodec of the Vendor-Specific
+// attribute.
+//
+// When decoding, a value whose vendor is registered in the packet's
+// dictionary is decoded into a *VendorAttribute, or into a []*VendorAttribute
+// if it carries more than one vendor attribute. Values of other vendors are
+// decoded into []byte.
+//
+// When encoding, *VendorAttribute, []*VendorAttribute, []byte, and string
+// values are accepted.
+var AttributeVendorSpecific AttributeCodec = attributeVendorSpecific{}
+
+// VendorFormat specifies how vendor attributes are laid out inside of a
+// Vendor-Specific attribute.
+type VendorFormat int
+
+// Vendor attribute formats.
+const (
+	// VendorFormatStandard is the format suggested by RFC 2865. Each vendor
+	// attribute consists of a one byte type, a one byte length (which
+	// includes the type and length bytes), and the value. A single
+	// Vendor-Specific attribute can carry multiple vendor attributes.
+	VendorFormatStandard VendorFormat = iota
+	// VendorFormatContinuous is a non-standard format where the one byte type
+	// is immediately followed by the value, which continues until the end of
+	// the Vendor-Specific attribute.
+	VendorFormatContinuous
+	// VendorFormatWiMAX is the format used by the WiMAX Forum (vendor ID
+	// 24757). Each vendor attribute consists of a one byte type, a one byte
+	// length (which includes the type, length, and continuation bytes), a
+	// continuation byte, and the value. A value that does not fit in a single
+	// Vendor-Specific attribute is split over consecutive ones; the most
+	// significant bit of the continuation byte is set in all but the last.
+	//
+	// Continued vendor attributes are reassembled when a packet is parsed,
+	// and split into as few Vendor-Specific attributes as possible when it is
+	// encoded.
+	VendorFormatWiMAX
+)
+
+// flag of a WiMAX vendor attribute that is continued in the next
+// Vendor-Specific attribute
+const wimaxContinued = 0x80
+
+// maximum length of a WiMAX vendor attribute's value in a single
+// Vendor-Specific attribute (vendor ID, type, length, and continuation bytes)
+const maxWiMAXFragmentLength = maxAttributeValueLength - 4 - 3
+
+// VendorDictionary stores the attributes of a single vendor. A Dictionary
+// keys its VendorDictionaries by vendor ID.
+type VendorDictionary struct {
+	ID     uint32
+	Format VendorFormat
+	// Name of the vendor, such as "Cisco"; empty if it has none. It is set
+	// using Dictionary.RegisterVendorName.
+	Name string
+
+	attributesByType [256]*DictionaryEntry
+}
+
+// VendorAttribute is an attribute defined by a vendor. It is carried as the
+// value of a Vendor-Specific attribute.
+type VendorAttribute struct {
+	VendorID uint32
+	Type     byte
+	Value    interface{}
+}
+
+// VSA is a vendor attribute in its wire format. The Value is not decoded,
+// so VSAs can be used for vendor attributes that are not in the dictionary.
+type VSA struct {
+	Type  byte
+	Value []byte
+}
+
+// VendorAttributes returns the attributes of the given vendor that are
+// carried by the packet's Vendor-Specific attributes, in the order in which
+// they appear. Attributes of vendors that are registered in the packet's
+// dictionary are re-encoded to get their wire format.
+func (p *Packet) VendorAttributes(vendorID uint32) []VSA {
+	p.decodeLazy()
+	var format VendorFormat
+	if p.Dictionary != nil {
+		format, _ = p.Dictionary.vendorFormat(vendorID)
+	}
+	var vsas []VSA
+	for _, attr := range p.Attributes {
+		if attr.Type != attributeTypeVendorSpecific {
+			continue
+		}
+		switch value := attr.Value.(type) {
+		case []byte:
+			vsas = appendVSAs(vsas, vendorID, format, value)
+		case string:
+			vsas = appendVSAs(vsas, vendorID, format, []byte(value))
+		default:
+			for _, vendorAttr := range vendorAttributes(value) {
+				if vendorAttr.VendorID != vendorID {
+					continue
+				}
+				wire, err := p.Dictionary.vendorCodec(vendorID, vendorAttr.Type).Encode(p, vendorAttr.Value)
+				if err != nil {
+					continue
+				}
+				vsas = append(vsas, VSA{
+					Type:  vendorAttr.Type,
+					Value: wire,
+				})
+			}
+		}
+	}
+	return vsas
+}
+
+// appendVSAs appends the vendor attributes in the given raw Vendor-Specific
+// attribute value to vsas, if it belongs to vendorID. A malformed vendor
+// attribute, and those following it, are skipped.
+func appendVSAs(vsas []VSA, vendorID uint32, format VendorFormat, value []byte) []VSA {
+	if len(value) < 5 || binary.BigEndian.Uint32(value) != vendorID {
+		return vsas
+	}
+	data := value[4:]
+	if format == VendorFormatContinuous {
+		return append(vsas, VSA{
+			Type:  data[0],
+			Value: append([]byte(nil), data[1:]...),
+		})
+	}
+	// length of the header of each vendor attribute
+	header := 2
+	if format == VendorFormatWiMAX {
+		header = 3
+	}
+	for len(data) > 0 {
+		if len(data) < header || int(data[1]) < header || int(data[1]) > len(data) {
+			break
+		}
+		vsas = append(vsas, VSA{
+			Type:  data[0],
+			Value: append([]byte(nil), data[header:data[1]]...),
+		})
+		data = data[data[1]:]
+	}
+	return vsas
+}
+
+// AddVSA adds a Vendor-Specific attribute to the packet that carries a single
+// attribute of the given vendor, with the given raw value. The value is not
+// encoded by the dictionary.
+func (p *Packet) AddVSA(vendorID uint32, vendorType byte, value []byte) {
+	var format VendorFormat
+	if p.Dictionary != nil {
+		format, _ = p.Dictionary.vendorFormat(vendorID)
+	}
+	wire := make([]byte, 4, 6+len(value))
+	binary.BigEndian.PutUint32(wire, vendorID)
+	wire = append(wire, vendorType)
+	switch format {
+	case VendorFormatContinuous:
+	case VendorFormatWiMAX:
+		wire = append(wire, byte(len(value)+3), 0)
+	default:
+		wire = append(wire, byte(len(value)+2))
+	}
+	wire = append(wire, value...)
+	p.AddAttr(&Attribute{
+		Type:  attributeTypeVendorSpecific,
+		Value: wire,
+	})
+}
+
+// vendorAttributes returns the vendor attributes carried by the given
+// Vendor-Specific attribute value.
+func vendorAttributes(value interface{}) []*VendorAttribute {
+	switch v := value.(type) {
+	case *VendorAttribute:
+		return []*VendorAttribute{v}
+	case []*VendorAttribute:
+		return v
+	}
+	return nil
+}
+
+// RegisterVendor registers the AttributeCodec for the given vendor attribute
+// name and type. The vendor is added to the dictionary, using
+// VendorFormatStandard, if it has not been already.
+//
+// If the Vendor-Specific attribute (26) has not been registered, it is
+// registered with AttributeVendorSpecific.
+func (d *Dictionary) RegisterVendor(vendorID uint32, name string, t byte, codec AttributeCodec) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	vendor := d.vendorLocked(vendorID)
+	if vendor.attributesByType[t] != nil {
+		return ErrAttributeAlreadyRegistered
+	}
+	entry := &DictionaryEntry{
+		Vendor: vendorID,
+		Type:   t,
+		Name:   name,
+		Codec:  codec,
+	}
+	vendor.attributesByType[t] = entry
+	if d.attributesByName == nil {
+		d.attributesByName = make(map[string]*DictionaryEntry)
+	}
+	d.attributesByName[name] = entry
+
+	if d.attributesByType[attributeTypeVendorSpecific] == nil {
+		vsa := &DictionaryEntry{
+			Type:  attributeTypeVendorSpecific,
+			Name:  "Vendor-Specific",
+			Codec: AttributeVendorSpecific,
+		}
+		d.attributesByType[attributeTypeVendorSpecific] = vsa
+		d.attributesByName[vsa.Name] = vsa
+	}
+	return nil
+}
+
+// MustRegisterVendor is a helper for RegisterVendor that panics if it returns
+// an error.
+func (d *Dictionary) MustRegisterVendor(vendorID uint32, name string, t byte, codec AttributeCodec) {
+	if err := d.RegisterVendor(vendorID, name, t, codec); err != nil {
+		panic(err)
+	}
+}
+
+// SetVendorFormat sets the format used for encoding and decoding the
+// attributes of the given vendor. The vendor is added to the dictionary if it
+// has not been already.
+func (d *Dictionary) SetVendorFormat(vendorID uint32, format VendorFormat) {
+	d.mu.Lock()
+	d.vendorLocked(vendorID).Format = format
+	d.mu.Unlock()
+}
+
+// RegisterVendorName registers name as the name of the vendor with the given
+// ID, as the VENDOR entries of dictionary files do. The vendor is added to the
+// dictionary, using VendorFormatStandard, if it has not been already. An error
+// is returned if another vendor has the name.
+func (d *Dictionary) RegisterVendorName(vendorID uint32, name string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if id, ok := d.vendorIDLocked(name); ok && id != vendorID {
+		return fmt.Errorf("radius: vendor name %s is already registered for vendor %d", name, id)
+	}
+	d.vendorLocked(vendorID).Name = name
+	return nil
+}
+
+// VendorID returns the ID of the vendor whose name is registered as name. ok
+// is false if no vendor has the name.
+func (d *Dictionary) VendorID(name string) (vendorID uint32, ok bool) {
+	if d == nil {
+		d = &emptyDictionary
+	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.vendorIDLocked(name)
+}
+
+func (d *Dictionary) vendorIDLocked(name string) (vendorID uint32, ok bool) {
+	for id, vendor := range d.vendors {
+		if vendor.Name == name && name != "" {
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+// VendorName returns the registered name of the vendor with the given ID. ok
+// is false if the vendor has no name.
+func (d *Dictionary) VendorName(vendorID uint32) (name string, ok bool) {
+	if d == nil {
+		d = &emptyDictionary
+	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if vendor := d.vendors[vendorID]; vendor != nil && vendor.Name != "" {
+		return vendor.Name, true
+	}
+	return "", false
+}
+
+// VendorAttributeName returns the registered name for the given vendor
+// attribute type. ok is false if the given vendor type is not registered.
+func (d *Dictionary) VendorAttributeName(vendorID uint32, t byte) (name string, ok bool) {
+	entry := d.vendorEntry(vendorID, t)
+	if entry == nil {
+		return
+	}
+	name = entry.Name
+	ok = true
+	return
+}
+
+// vendorLocked returns the VendorDictionary for the given vendor ID, creating
+// it if needed. d.mu must be held for writing.
+func (d *Dictionary) vendorLocked(vendorID uint32) *VendorDictionary {
+	vendor := d.vendors[vendorID]
+	if vendor == nil {
+		vendor = &VendorDictionary{
+			ID: vendorID,
+		}
+		if d.vendors == nil {
+			d.vendors = make(map[uint32]*VendorDictionary)
+		}
+		d.vendors[vendorID] = vendor
+	}
+	return vendor
+}
+
+func (d *Dictionary) vendorFormat(vendorID uint32) (format VendorFormat, ok bool) {
+	if d == nil {
+		d = &emptyDictionary
+	}
+	d.mu.RLock()
+	vendor := d.vendors[vendorID]
+	d.mu.RUnlock()
+	if vendor == nil {
+		return
+	}
+	format = vendor.Format
+	ok = true
+	return
+}
+
+func (d *Dictionary) vendorEntry(vendorID uint32, t byte) *DictionaryEntry {
+	if d == nil {
+		d = &emptyDictionary
+	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	vendor := d.vendors[vendorID]
+	if vendor == nil {
+		return nil
+	}
+	return vendor.attributesByType[t]
+}
+
+// vendorCodec returns the AttributeCodec for the given vendor attribute
+// type. AttributeUnknown is returned if the type is not registered.
+func (d *Dictionary) vendorCodec(vendorID uint32, t byte) AttributeCodec {
+	if entry := d.vendorEntry(vendorID, t); entry != nil {
+		return entry.Codec
+	}
+	return AttributeUnknown
+}
+
+type attributeVendorSpecific struct{}
+
+func (attributeVendorSpecific) Decode(packet *Packet, value []byte) (interface{}, error) {
+	var vendorID uint32
+	var format VendorFormat
+	ok := false
+	if len(value) >= 5 {
+		vendorID = binary.BigEndian.Uint32(value)
+		format, ok = packet.Dictionary.vendorFormat(vendorID)
+	}
+	if !ok {
+		return packet.wireValue(value), nil
+	}
+
+	var attrs []*VendorAttribute
+	data := value[4:]
+	for len(data) > 0 {
+		var t byte
+		var attrValue []byte
+		switch format {
+		case VendorFormatContinuous:
+			t = data[0]
+			attrValue = data[1:]
+			data = nil
+		case VendorFormatWiMAX:
+			if len(data) < 3 || data[1] < 3 || int(data[1]) > len(data) {
+				return nil, errors.New("radius: invalid vendor attribute length")
+			}
+			if data[2]&wimaxContinued != 0 {
+				return nil, errors.New("radius: continued vendor attribute is not followed by its continuation")
+			}
+			t = data[0]
+			attrValue = data[3:data[1]]
+			data = data[data[1]:]
+		default:
+			if len(data) < 2 || data[1] < 2 || int(data[1]) > len(data) {
+				return nil, errors.New("radius: invalid vendor attribute length")
+			}
+			t = data[0]
+			attrValue = data[2:data[1]]
+			data = data[data[1]:]
+		}
+
+		_, decoded, err := packet.Dictionary.vendorEntry(vendorID, t).decode(packet, attrValue)
+		if err != nil {
+			return nil, err
+		}
+		attrs = append(attrs, &VendorAttribute{
+			VendorID: vendorID,
+			Type:     t,
+			Value:    decoded,
+		})
+	}
+
+	if len(attrs) == 1 {
+		return attrs[0], nil
+	}
+	return attrs, nil
+}
+
+func (attributeVendorSpecific) Encode(packet *Packet, value interface{}) ([]byte, error) {
+	switch v := value.(type) {
+	case []byte:
+		return v, nil
+	case string:
+		return []byte(v), nil
+	}
+	attrs := vendorAttributes(value)
+	if len(attrs) == 0 {
+		return nil, errors.New("radius: Vendor-Specific attribute must be *VendorAttribute, []*VendorAttribute, []byte, or string")
+	}
+
+	vendorID := attrs[0].VendorID
+	format, _ := packet.Dictionary.vendorFormat(vendorID)
+	if format == VendorFormatContinuous && len(attrs) > 1 {
+		return nil, errors.New("radius: vendor format allows a single attribute per Vendor-Specific attribute")
+	}
+
+	var buffer bytes.Buffer
+	binary.Write(&buffer, binary.BigEndian, vendorID)
+	for _, attr := range attrs {
+		if attr.VendorID != vendorID {
+			return nil, errors.New("radius: Vendor-Specific attribute cannot carry attributes of multiple vendors")
+		}
+		codec := packet.Dictionary.vendorCodec(vendorID, attr.Type)
+		wire, err := codec.Encode(packet, attr.Value)
+		if err != nil {
+			return nil, err
+		}
+		buffer.WriteByte(attr.Type)
+		switch format {
+		case VendorFormatContinuous:
+		case VendorFormatWiMAX:
+			if len(wire) > maxWiMAXFragmentLength {
+				return nil, errors.New("radius: encoded vendor attribute is too long")
+			}
+			buffer.WriteByte(byte(len(wire) + 3))
+			buffer.WriteByte(0)
+		default:
+			if len(wire) > 253-4-2 {
+				return nil, errors.New("radius: encoded vendor attribute is too long")
+			}
+			buffer.WriteByte(byte(len(wire) + 2))
+		}
+		buffer.Write(wire)
+	}
+	return buffer.Bytes(), nil
+}
+
+// parseContinuedVendorAttribute parses a WiMAX vendor attribute that is
+// continued over consecutive Vendor-Specific attributes, at the start of
+// attributes. ok is false if the first attribute does not start such a vendor
+// attribute. Otherwise, the reassembled attribute and the number of bytes of
+// attributes that it used are returned.
+func parseContinuedVendorAttribute(packet *Packet, attributes []byte) (attr *Attribute, n int, ok bool, err error) {
+	vendorID, t, fragment, continued := wimaxFragment(packet.Dictionary, attributes)
+	if !continued {
+		return nil, 0, false, nil
+	}
+	value := append([]byte(nil), fragment...)
+	n = int(attributes[1])
+	for continued {
+		var next []byte
+		var nextVendorID uint32
+		var nextType byte
+		nextVendorID, nextType, next, continued = wimaxFragment(packet.Dictionary, attributes[n:])
+		if next == nil || nextVendorID != vendorID || nextType != t {
+			return nil, 0, true, errors.New("radius: continued vendor attribute is not followed by its continuation")
+		}
+		value = append(value, next...)
+		n += int(attributes[n+1])
+	}
+
+	_, decoded, err := packet.Dictionary.vendorEntry(vendorID, t).decode(packet, value)
+	if err != nil {
+		return nil, 0, true, err
+	}
+	attr = &Attribute{
+		Type: attributeTypeVendorSpecific,
+		Value: &VendorAttribute{
+			VendorID: vendorID,
+			Type:     t,
+			Value:    decoded,
+		},
+	}
+	return attr, n, true, nil
+}
+
+// wimaxFragment returns the vendor attribute that is carried by the first
+// attribute in attributes, if it is a Vendor-Specific attribute that carries
+// a single WiMAX vendor attribute. fragment is nil otherwise. continued is
+// true if the vendor attribute continues in the next attribute.
+func wimaxFragment(dictionary *Dictionary, attributes []byte) (vendorID uint32, t byte, fragment []byte, continued bool) {
+	// The attribute lengths have already been validated by Parse.
+	if len(attributes) < 2 || attributes[0] != attributeTypeVendorSpecific || attributes[1] < 2+4+3 {
+		return
+	}
+	value := attributes[2:attributes[1]]
+	vendorID = binary.BigEndian.Uint32(value)
+	if format, _ := dictionary.vendorFormat(vendorID); format != VendorFormatWiMAX {
+		return
+	}
+	data := value[4:]
+	if int(data[1]) != len(data) {
+		// not a single vendor attribute
+		return
+	}
+	return vendorID, data[0], data[3:], data[2]&wimaxContinued != 0
+}
+
+// encodeContinuedVendorAttribute encodes a WiMAX vendor attribute into the
+// values of the Vendor-Specific attributes that carry it. ok is false if the
+// attribute's vendor does not use VendorFormatWiMAX.
+func (p *Packet) encodeContinuedVendorAttribute(attr *VendorAttribute) (values [][]byte, ok bool, err error) {
+	if format, _ := p.Dictionary.vendorFormat(attr.VendorID); format != VendorFormatWiMAX {
+		return nil, false, nil
+	}
+	wire, err := p.Dictionary.vendorCodec(attr.VendorID, attr.Type).Encode(p, attr.Value)
+	if err != nil {
+		return nil, true, err
+	}
+	for {
+		fragment := wire
+		var flags byte
+		if len(fragment) > maxWiMAXFragmentLength {
+			fragment = fragment[:maxWiMAXFragmentLength]
+			flags = wimaxContinued
+		}
+		value := make([]byte, 4, 4+3+len(fragment))
+		binary.BigEndian.PutUint32(value, attr.VendorID)
+		value = append(value, attr.Type, byte(len(fragment)+3), flags)
+		value = append(value, fragment...)
+		values = append(values, value)
+		wire = wire[len(fragment):]
+		if len(wire) == 0 {
+			return values, true, nil
+		}
+	}
+}
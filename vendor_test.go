@@ -0,0 +1,285 @@
+package radius_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"testing"
+
+	"github.com/PromonLogicalis/radius"
+)
+
+func TestVendorSpecific(t *testing.T) {
+	var d radius.Dictionary
+	d.MustRegister("User-Name", 1, radius.AttributeText)
+	d.MustRegisterVendor(9, "Cisco-AVPair", 1, radius.AttributeText)
+	d.MustRegisterVendor(9, "Cisco-NAS-Port", 2, radius.AttributeText)
+
+	p := radius.New(radius.CodeAccessRequest, []byte("secret"))
+	p.Dictionary = &d
+	p.Add("User-Name", "tim")
+	if err := p.Add("Cisco-AVPair", "shell:priv-lvl=15"); err != nil {
+		t.Fatal(err)
+	}
+
+	wire, err := p.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []byte{
+		0x1a, 0x19, 0x00, 0x00, 0x00, 0x09, 0x01, 0x13,
+		's', 'h', 'e', 'l', 'l', ':', 'p', 'r', 'i', 'v', '-', 'l', 'v', 'l', '=', '1', '5',
+	}
+	if !bytes.Equal(wire[len(wire)-len(expected):], expected) {
+		t.Fatalf("unexpected Vendor-Specific encoding: %x", wire)
+	}
+
+	q, err := radius.Parse(wire, p.Secret, &d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value := q.String("Cisco-AVPair"); value != "shell:priv-lvl=15" {
+		t.Fatalf("expecting Cisco-AVPair = shell:priv-lvl=15; got %q", value)
+	}
+	if q.Value("Cisco-NAS-Port") != nil {
+		t.Fatal("expecting Cisco-NAS-Port to be absent")
+	}
+	if name, ok := d.VendorAttributeName(9, 1); !ok || name != "Cisco-AVPair" {
+		t.Fatal("expecting vendor attribute 9:1 = Cisco-AVPair")
+	}
+}
+
+func TestVendorSpecificMultiple(t *testing.T) {
+	var d radius.Dictionary
+	d.MustRegisterVendor(9, "Cisco-AVPair", 1, radius.AttributeText)
+	d.MustRegisterVendor(9, "Cisco-NAS-Port", 2, radius.AttributeText)
+
+	// Vendor-Specific attribute carrying two vendor attributes
+	wire := []byte{
+		0x02, 0x01, 0x00, 0x28, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x1a, 0x14, 0x00, 0x00, 0x00, 0x09,
+		0x01, 0x05, 'a', '=', 'b',
+		0x02, 0x09, 'E', 't', 'h', '0', '/', '0', '1',
+	}
+
+	p, err := radius.Parse(wire, []byte("secret"), &d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Attributes) != 1 {
+		t.Fatal("expecting 1 attribute")
+	}
+	if value := p.String("Cisco-AVPair"); value != "a=b" {
+		t.Fatalf("expecting Cisco-AVPair = a=b; got %q", value)
+	}
+	if value := p.String("Cisco-NAS-Port"); value != "Eth0/01" {
+		t.Fatalf("expecting Cisco-NAS-Port = Eth0/01; got %q", value)
+	}
+}
+
+func TestVendorSpecificContinuous(t *testing.T) {
+	var d radius.Dictionary
+	d.MustRegisterVendor(429, "USR-Thing", 5, radius.AttributeString)
+	d.SetVendorFormat(429, radius.VendorFormatContinuous)
+
+	p := radius.New(radius.CodeAccessRequest, []byte("secret"))
+	p.Dictionary = &d
+	p.Add("USR-Thing", []byte{0xde, 0xad})
+
+	wire, err := p.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []byte{0x1a, 0x09, 0x00, 0x00, 0x01, 0xad, 0x05, 0xde, 0xad}
+	if !bytes.Equal(wire[20:], expected) {
+		t.Fatalf("unexpected Vendor-Specific encoding: %x", wire[20:])
+	}
+
+	q, err := radius.Parse(wire, p.Secret, &d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := q.Value("USR-Thing").([]byte); !bytes.Equal(value, []byte{0xde, 0xad}) {
+		t.Fatalf("unexpected USR-Thing value %x", value)
+	}
+}
+
+func TestVendorSpecificGets(t *testing.T) {
+	var d radius.Dictionary
+	d.MustRegisterVendor(9, "Cisco-AVPair", 1, radius.AttributeText)
+
+	p := radius.New(radius.CodeAccessRequest, []byte("secret"))
+	p.Dictionary = &d
+	p.Add("Cisco-AVPair", "a=1")
+	p.Add("Cisco-AVPair", "b=2")
+
+	values, err := p.Gets("Cisco-AVPair")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 2 || values[0] != "a=1" || values[1] != "b=2" {
+		t.Fatalf("unexpected Cisco-AVPair values %v", values)
+	}
+}
+
+func TestVendorAttributes(t *testing.T) {
+	var d radius.Dictionary
+	d.MustRegisterVendor(9, "Cisco-AVPair", 1, radius.AttributeText)
+
+	p := radius.New(radius.CodeAccessRequest, []byte("secret"))
+	p.Dictionary = &d
+	p.AddVSA(311, 7, []byte{0x01, 0x02})
+	p.Add("Cisco-AVPair", "a=b")
+	p.AddVSA(311, 8, []byte("value"))
+	// a single Vendor-Specific attribute carrying two vendor attributes
+	p.Add("Vendor-Specific", []byte{0x00, 0x00, 0x01, 0x37, 0x09, 0x03, 'x', 0x0a, 0x02})
+
+	wire, err := p.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, err := radius.Parse(wire, p.Secret, &d)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vsas := q.VendorAttributes(311)
+	expected := []radius.VSA{
+		{Type: 7, Value: []byte{0x01, 0x02}},
+		{Type: 8, Value: []byte("value")},
+		{Type: 9, Value: []byte("x")},
+		{Type: 10, Value: []byte{}},
+	}
+	if len(vsas) != len(expected) {
+		t.Fatalf("expecting %d vendor attributes; got %d", len(expected), len(vsas))
+	}
+	for i, vsa := range vsas {
+		if vsa.Type != expected[i].Type || !bytes.Equal(vsa.Value, expected[i].Value) {
+			t.Fatalf("vendor attribute %d: expecting %d %x; got %d %x", i, expected[i].Type, expected[i].Value, vsa.Type, vsa.Value)
+		}
+	}
+
+	// registered vendors are available in their wire format too
+	vsas = q.VendorAttributes(9)
+	if len(vsas) != 1 || vsas[0].Type != 1 || string(vsas[0].Value) != "a=b" {
+		t.Fatalf("unexpected Cisco vendor attributes %v", vsas)
+	}
+	if vsas := q.VendorAttributes(14823); len(vsas) != 0 {
+		t.Fatalf("expecting no vendor attributes; got %v", vsas)
+	}
+}
+
+func TestVendorSpecificWiMAX(t *testing.T) {
+	var d radius.Dictionary
+	d.MustRegisterVendor(24757, "WiMAX-Capability", 1, radius.AttributeString)
+	d.SetVendorFormat(24757, radius.VendorFormatWiMAX)
+
+	p := radius.New(radius.CodeAccessRequest, []byte("secret"))
+	p.Dictionary = &d
+	p.Add("WiMAX-Capability", []byte{0xde, 0xad})
+
+	wire, err := p.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []byte{0x1a, 0x0b, 0x00, 0x00, 0x60, 0xb5, 0x01, 0x05, 0x00, 0xde, 0xad}
+	if !bytes.Equal(wire[20:], expected) {
+		t.Fatalf("unexpected Vendor-Specific encoding: %x", wire[20:])
+	}
+
+	long := bytes.Repeat([]byte{0x5a}, 600)
+	p = radius.New(radius.CodeAccessRequest, []byte("secret"))
+	p.Dictionary = &d
+	p.Add("WiMAX-Capability", long)
+	wire, err = p.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 600 bytes are split into fragments of 246, 246, and 108 bytes
+	if len(wire) != 20+3*9+600 {
+		t.Fatalf("unexpected encoded length %d", len(wire))
+	}
+	for i, offset := range []int{20, 20 + 255, 20 + 2*255} {
+		continued := wire[offset+8]&0x80 != 0
+		if continued != (i < 2) {
+			t.Fatalf("fragment %d: unexpected continuation byte %x", i, wire[offset+8])
+		}
+	}
+
+	q, err := radius.Parse(wire, p.Secret, &d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(q.Attributes) != 1 {
+		t.Fatalf("expecting continued attribute to be reassembled; got %d attributes", len(q.Attributes))
+	}
+	if value, _ := q.Value("WiMAX-Capability").([]byte); !bytes.Equal(value, long) {
+		t.Fatalf("unexpected WiMAX-Capability value %x", value)
+	}
+	if rewire, err := q.Encode(); err != nil || !bytes.Equal(rewire, wire) {
+		t.Fatal("expecting reassembled packet to encode as it was received")
+	}
+
+	// the last fragment is missing
+	truncated := append([]byte(nil), wire[:20+2*255]...)
+	binary.BigEndian.PutUint16(truncated[2:4], uint16(len(truncated)))
+	if _, err := radius.Parse(truncated, p.Secret, &d); err == nil {
+		t.Fatal("expecting truncated continued attribute to be rejected")
+	}
+}
+
+func TestMicrosoftMPPEKeys(t *testing.T) {
+	defer func(r io.Reader) {
+		radius.Rand = r
+	}(radius.Rand)
+
+	d := radius.Builtin.Clone()
+	if err := d.Merge(radius.MicrosoftDictionary(), false); err != nil {
+		t.Fatal(err)
+	}
+	secret := []byte("secret")
+	request := radius.New(radius.CodeAccessRequest, secret)
+	for i := range request.Authenticator {
+		request.Authenticator[i] = byte(i)
+	}
+	request.Dictionary = d
+	response, err := request.Response(radius.CodeAccessAccept)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := make([]byte, 32)
+	for i := range key {
+		key[i] = byte(100 + i)
+	}
+	radius.Rand = bytes.NewReader([]byte{0x00, 0x01})
+	if err := response.Add("MS-MPPE-Send-Key", key); err != nil {
+		t.Fatal(err)
+	}
+	response.Add("MS-MPPE-Encryption-Policy", "Encryption-Required")
+	wire, err := response.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// RFC 2548 section 2.4.2, with the salt 0x8001
+	expected, _ := hex.DecodeString("800158248e2288d2ccfdfc0960cdc1d371ae4293b4b1b1fa2642419d75df921996f8e2c6d115d2b42208f0e0c66b2dd47943")
+	if !bytes.Contains(wire, append([]byte{0x00, 0x00, 0x01, 0x37, 16, byte(len(expected) + 2)}, expected...)) {
+		t.Fatalf("expecting encrypted MS-MPPE-Send-Key %x; got %x", expected, wire)
+	}
+
+	// responses are decrypted with the request authenticator
+	received, err := radius.ParseResponse(wire, request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := received.VerifyResponse(request); err != nil {
+		t.Fatal(err)
+	}
+	if value := received.Value("MS-MPPE-Send-Key"); !bytes.Equal(value.([]byte), key) {
+		t.Fatalf("unexpected MS-MPPE-Send-Key %x", value)
+	}
+	if value := received.Value("MS-MPPE-Encryption-Policy"); value != radius.MSMPPEEncryptionPolicyRequired {
+		t.Fatalf("unexpected MS-MPPE-Encryption-Policy %v", value)
+	}
+}